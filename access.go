@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+const (
+	metricsReadAction               = "Microsoft.Insights/metrics/read"
+	subscriptionResourcesReadAction = "Microsoft.Resources/subscriptions/resources/read"
+	groupResourcesReadAction        = "Microsoft.Resources/subscriptions/resourceGroups/resources/read"
+)
+
+// PermissionsResponse represents the permissions of the caller on a scope.
+type PermissionsResponse struct {
+	Value []struct {
+		Actions    []string `json:"actions"`
+		NotActions []string `json:"notActions"`
+	} `json:"value"`
+}
+
+// allows reports whether any of the permissions grants the action.
+func (p *PermissionsResponse) allows(action string) bool {
+	for _, permission := range p.Value {
+		granted := false
+		for _, a := range permission.Actions {
+			if actionMatches(a, action) {
+				granted = true
+				break
+			}
+		}
+		for _, a := range permission.NotActions {
+			if actionMatches(a, action) {
+				granted = false
+				break
+			}
+		}
+		if granted {
+			return true
+		}
+	}
+	return false
+}
+
+// actionMatches matches an action against a role definition action, which
+// may hold * wildcards. Actions are case insensitive.
+func actionMatches(pattern string, action string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	re, err := regexp.Compile("(?i)^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(action)
+}
+
+// accessCheck is the result of the permission check of a scope.
+type accessCheck struct {
+	Scope   string
+	Missing []string
+	Err     error
+}
+
+// requiredActions returns the actions needed by the configuration per scope.
+func requiredActions(c *config.Config) map[string][]string {
+	subscription := fmt.Sprintf("/subscriptions/%s", c.Credentials.SubscriptionID)
+	scopes := map[string]map[string]bool{}
+	require := func(scope string, actions ...string) {
+		if scopes[scope] == nil {
+			scopes[scope] = map[string]bool{}
+		}
+		for _, a := range actions {
+			scopes[scope][a] = true
+		}
+	}
+
+	for _, t := range expandTargets(c.Targets) {
+		require(subscription+t.Resource, metricsReadAction)
+	}
+	for _, rg := range c.ResourceGroups {
+		require(fmt.Sprintf("%s/resourceGroups/%s", subscription, rg.ResourceGroup), groupResourcesReadAction, metricsReadAction)
+	}
+	if len(c.ResourceTags) > 0 {
+		require(subscription, subscriptionResourcesReadAction, metricsReadAction)
+	}
+
+	required := map[string][]string{}
+	for scope, actions := range scopes {
+		for a := range actions {
+			required[scope] = append(required[scope], a)
+		}
+		sort.Strings(required[scope])
+	}
+	return required
+}
+
+// checkAccess verifies the credentials are granted the actions needed by the
+// configuration on each configured scope.
+func (ac *AzureClient) checkAccess() []accessCheck {
+	required := requiredActions(sc.Get())
+	var scopes []string
+	for scope := range required {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+
+	var checks []accessCheck
+	for _, scope := range scopes {
+		check := accessCheck{Scope: scope}
+		permissions, err := ac.getPermissions(scope)
+		if err != nil {
+			check.Err = err
+		} else {
+			for _, action := range required[scope] {
+				if !permissions.allows(action) {
+					check.Missing = append(check.Missing, action)
+				}
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// Returns the permissions of the credentials on the given scope
+func (ac *AzureClient) getPermissions(scope string) (*PermissionsResponse, error) {
+	apiVersion := "2015-07-01"
+	permissionsEndpoint := fmt.Sprintf("%s%s/providers/Microsoft.Authorization/permissions?api-version=%s",
+		strings.TrimSuffix(sc.Get().ResourceManagerURL, "/"), scope, apiVersion)
+
+	body, err := getAzureMonitorResponse(permissionsEndpoint, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions PermissionsResponse
+	if err := json.Unmarshal(body, &permissions); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling response body: %v", err)
+	}
+	return &permissions, nil
+}
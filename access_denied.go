@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var resourceAccessDeniedDesc = prometheus.NewDesc("azure_resource_access_denied", "Resource discovered by tag that the credentials of the exporter can't read", []string{"resource"}, nil)
+
+// accessDeniedSet collects the resources discovered by tag that the
+// credentials can't read, typically resources of other tenants.
+type accessDeniedSet map[string]bool
+
+// deny records the resource if it was discovered by tag and the status code
+// denies the access to it.
+func (s *accessDeniedSet) deny(rm resourceMeta, httpStatusCode int) bool {
+	if !rm.discoveredByTag || httpStatusCode != http.StatusForbidden {
+		return false
+	}
+	if *s == nil {
+		*s = accessDeniedSet{}
+	}
+	(*s)[rm.resourceID] = true
+	return true
+}
+
+// collect sends the resources denied during the scrape and logs a summary
+// of them by subscription.
+func (s accessDeniedSet) collect(ch chan<- prometheus.Metric) {
+	if len(s) == 0 {
+		return
+	}
+	bySubscription := map[string]int{}
+	for id := range s {
+		ch <- prometheus.MustNewConstMetric(resourceAccessDeniedDesc, prometheus.GaugeValue, 1, id)
+		bySubscription[resourceSubscription(id)]++
+	}
+	var counts []string
+	for subscription, n := range bySubscription {
+		counts = append(counts, fmt.Sprintf("%s: %d", subscription, n))
+	}
+	sort.Strings(counts)
+	log.Printf("Access denied to %d resources discovered by tag, by subscription: %s", len(s), strings.Join(counts, ", "))
+}
+
+// resourceSubscription returns the subscription of a resource ID.
+func resourceSubscription(id string) string {
+	parts := strings.Split(id, "/")
+	if len(parts) > 2 && strings.EqualFold(parts[1], "subscriptions") {
+		return parts[2]
+	}
+	return "unknown"
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAccessDeniedSet(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{}
+
+	c := &Collector{cfg: sc.C}
+	ch := make(chan prometheus.Metric, 10)
+	for _, rm := range []resourceMeta{
+		{resourceID: "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app1", discoveredByTag: true},
+		{resourceID: "/subscriptions/def/resourceGroups/rg/providers/Microsoft.Web/sites/app2", discoveredByTag: true},
+		{resourceID: "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app3"},
+	} {
+		c.extractMetrics(ch, rm, 403, AzureMetricValueResponse{}, map[string]bool{}, apiErrorSet{})
+	}
+	if len(ch) != 0 {
+		t.Fatalf("sent metrics for denied resources: %d", len(ch))
+	}
+	c.accessDenied.collect(ch)
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`azure_resource_access_denied{/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app1}`: 1,
+		`azure_resource_access_denied{/subscriptions/def/resourceGroups/rg/providers/Microsoft.Web/sites/app2}`: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't report the resources discovered by tag as denied\ngot: %v\nwant: %v", got, want)
+	}
+}
+
+func TestResourceSubscription(t *testing.T) {
+	for id, want := range map[string]string{
+		"/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app": "abc",
+		"/resourceGroups/rg/providers/Microsoft.Web/sites/app":                   "unknown",
+	} {
+		if got := resourceSubscription(id); got != want {
+			t.Errorf("resourceSubscription(%q)\ngot: %v\nwant: %v", id, got, want)
+		}
+	}
+}
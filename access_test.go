@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPermissionsAllows(t *testing.T) {
+	var cases = []struct {
+		actions    []string
+		notActions []string
+		action     string
+		want       bool
+	}{
+		{[]string{"*/read"}, nil, metricsReadAction, true},
+		{[]string{"Microsoft.Insights/Metrics/Read"}, nil, metricsReadAction, true},
+		{[]string{"Microsoft.Insights/*"}, []string{"Microsoft.Insights/metrics/*"}, metricsReadAction, false},
+		{[]string{"*"}, nil, groupResourcesReadAction, true},
+		{[]string{"Microsoft.Compute/*/read"}, nil, metricsReadAction, false},
+	}
+
+	for _, c := range cases {
+		var permissions PermissionsResponse
+		permissions.Value = append(permissions.Value, struct {
+			Actions    []string `json:"actions"`
+			NotActions []string `json:"notActions"`
+		}{c.actions, c.notActions})
+
+		got := permissions.allows(c.action)
+		if got != c.want {
+			t.Errorf("doesn't check %s against %v (not %v) as expected\ngot: %v\nwant: %v", c.action, c.actions, c.notActions, got, c.want)
+		}
+	}
+}
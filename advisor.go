@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var advisorRecommendationsDesc = prometheus.NewDesc("azure_advisor_recommendations", "Number of active Azure Advisor recommendations",
+	[]string{"category", "impact", "resource_group"}, nil)
+
+// AzureAdvisorRecommendationListResponse is a page of the Azure Advisor
+// recommendations API.
+type AzureAdvisorRecommendationListResponse struct {
+	Value []struct {
+		Properties struct {
+			Category         string `json:"category"`
+			Impact           string `json:"impact"`
+			ResourceMetadata struct {
+				ResourceID string `json:"resourceId"`
+			} `json:"resourceMetadata"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+type advisorKey struct {
+	category      string
+	impact        string
+	resourceGroup string
+}
+
+// countAdvisorRecommendations counts the recommendations of the subscription
+// by category, impact and resource group, following the pages of the
+// response.
+func (ac *AzureClient) countAdvisorRecommendations(scrapeID string) (map[advisorKey]int, error) {
+	apiVersion := "2020-01-01"
+	cfg := sc.Get()
+	endpoint := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.Advisor/recommendations?api-version=%s",
+		strings.TrimSuffix(cfg.ResourceManagerURL, "/"), cfg.Credentials.SubscriptionID, apiVersion)
+
+	counts := map[advisorKey]int{}
+	err := forEachPage(endpoint, scrapeID, func(body []byte) (string, error) {
+		var page AzureAdvisorRecommendationListResponse
+		if err := decodeLenient("advisor", body, &page); err != nil {
+			return "", err
+		}
+		for _, r := range page.Value {
+			key := advisorKey{
+				category:      r.Properties.Category,
+				impact:        r.Properties.Impact,
+				resourceGroup: resourceGroupOf(r.Properties.ResourceMetadata.ResourceID),
+			}
+			counts[key]++
+		}
+		return page.NextLink, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// collectAdvisorRecommendations exposes the number of Azure Advisor
+// recommendations of the subscription. Recommendations of the subscription
+// itself have an empty resource_group label.
+func (c *Collector) collectAdvisorRecommendations(ch chan<- prometheus.Metric, apiErrors apiErrorSet) {
+	counts, err := ac.countAdvisorRecommendations(c.scrapeID)
+	if err != nil {
+		c.logf("Failed to get Advisor recommendations: %v", err)
+		apiErrors.add(errorCode(err), "advisor")
+		return
+	}
+
+	for k, count := range counts {
+		ch <- prometheus.MustNewConstMetric(advisorRecommendationsDesc, prometheus.GaugeValue, float64(count), k.category, k.impact, k.resourceGroup)
+	}
+}
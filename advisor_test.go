@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectAdvisorRecommendations(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "" {
+			fmt.Fprintf(w, `{"value": [
+				{"properties": {"category": "Cost", "impact": "High", "resourceMetadata": {"resourceId": "/subscriptions/abc/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachines/vm1"}}},
+				{"properties": {"category": "Cost", "impact": "High", "resourceMetadata": {"resourceId": "/subscriptions/abc/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachines/vm2"}}}
+			], "nextLink": "%s/next?page=2"}`, server.URL)
+			return
+		}
+		fmt.Fprint(w, `{"value": [
+			{"properties": {"category": "Security", "impact": "Medium", "resourceMetadata": {"resourceId": "/subscriptions/abc"}}}
+		]}`)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL}
+	ac = NewAzureClient()
+
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).collectAdvisorRecommendations(ch, apiErrorSet{})
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`azure_advisor_recommendations{Cost,High,rg1}`:    2,
+		`azure_advisor_recommendations{Security,Medium,}`: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't count recommendations\ngot: %v\nwant: %v", got, want)
+	}
+}
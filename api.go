@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+// maxConfigSize limits the size of configuration documents posted to the API.
+const maxConfigSize = 10 << 20
+
+type validationIssue struct {
+	Type     string `json:"type"`
+	Message  string `json:"message"`
+	Resource string `json:"resource,omitempty"`
+	Metric   string `json:"metric,omitempty"`
+}
+
+type validationResult struct {
+	Valid  bool              `json:"valid"`
+	Issues []validationIssue `json:"issues"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+// validateConfigHandler validates a candidate configuration posted as YAML
+// without applying it. Resources and metrics are resolved against Azure with
+// the credentials of the running configuration.
+func validateConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	result := validationResult{Issues: []validationIssue{}}
+	result.Issues = append(result.Issues, validateConfig(body)...)
+	result.Valid = len(result.Issues) == 0
+	writeJSON(w, http.StatusOK, result)
+}
+
+func validateConfig(yamlData []byte) []validationIssue {
+	c, err := config.Parse(yamlData)
+	if err != nil {
+		if unknown, ok := err.(*config.UnknownFieldsError); ok {
+			var issues []validationIssue
+			for _, f := range unknown.Fields {
+				issues = append(issues, validationIssue{
+					Type:    "unknown_field",
+					Message: fmt.Sprintf("unknown field %q in %s", f, unknown.Context),
+				})
+			}
+			return issues
+		}
+		return []validationIssue{{Type: "parse_error", Message: err.Error()}}
+	}
+
+	c.ApplyDefaults()
+	if err := c.Validate(); err != nil {
+		return []validationIssue{{Type: "invalid_config", Message: err.Error()}}
+	}
+
+	// The resources are resolved with the credentials of the running
+	// configuration.
+	if err := ac.refreshAccessToken(); err != nil {
+		return []validationIssue{{Type: "azure_error", Message: err.Error()}}
+	}
+	return validateResources(c)
+}
+
+// validateResources resolves the resources of the configuration and checks
+// the configured metrics against their metric definitions, in the
+// subscription of the running configuration.
+func validateResources(c *config.Config) []validationIssue {
+	var issues []validationIssue
+	subscription := sc.Get().Credentials.SubscriptionID
+
+	for _, t := range expandTargets(c.Targets) {
+		issues = append(issues, validateMetrics(t.Resource, t.MetricNamespace, t.Metrics)...)
+	}
+
+	for _, rg := range c.ResourceGroups {
+		resources, err := ac.filteredListFromResourceGroup(subscription, rg, "")
+		if err != nil {
+			issues = append(issues, validationIssue{
+				Type:     "unresolvable_resource",
+				Message:  err.Error(),
+				Resource: rg.ResourceGroup,
+			})
+			continue
+		}
+		issues = append(issues, validateResourceList(resources, rg.MetricNamespace, rg.Metrics)...)
+	}
+
+	resourcesCache := make(map[string][]byte)
+	for _, tag := range c.ResourceTags {
+		resources, err := ac.filteredListByTag(subscription, tag, resourcesCache, "")
+		if err != nil {
+			issues = append(issues, validationIssue{
+				Type:     "unresolvable_resource",
+				Message:  err.Error(),
+				Resource: fmt.Sprintf("%s=%s", tag.ResourceTagName, tag.ResourceTagValue),
+			})
+			continue
+		}
+		issues = append(issues, validateResourceList(resources, tag.MetricNamespace, tag.Metrics)...)
+	}
+	return issues
+}
+
+// validateResourceList checks the metrics against one resource of each type.
+func validateResourceList(resources []AzureResource, metricNamespace string, metrics []config.Metric) []validationIssue {
+	var issues []validationIssue
+	checkedTypes := map[string]bool{}
+	for _, resource := range resources {
+		if checkedTypes[resource.Type] {
+			continue
+		}
+		checkedTypes[resource.Type] = true
+		issues = append(issues, validateMetrics(resource.ID, metricNamespace, metrics)...)
+	}
+	return issues
+}
+
+func validateMetrics(resource string, metricNamespace string, metrics []config.Metric) []validationIssue {
+	def, err := ac.getAzureMetricDefinitionResponse(resource, metricNamespace)
+	if err != nil {
+		return []validationIssue{{
+			Type:     "unresolvable_resource",
+			Message:  err.Error(),
+			Resource: resource,
+		}}
+	}
+
+	available := map[string]bool{}
+	for _, d := range def.MetricDefinitionResponses {
+		available[strings.ToLower(d.Name.Value)] = true
+	}
+
+	var issues []validationIssue
+	for _, m := range metrics {
+		if !available[strings.ToLower(m.Name)] {
+			issues = append(issues, validationIssue{
+				Type:     "invalid_metric",
+				Message:  fmt.Sprintf("metric %q is not defined for the resource", m.Name),
+				Resource: resource,
+				Metric:   m.Name,
+			})
+		}
+	}
+	return issues
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestValidateConfigHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/virtualMachines/vm1/") {
+			fmt.Fprint(w, `{"value": [{"name": {"value": "Percentage CPU"}}]}`)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error": {"code": "ResourceNotFound", "message": "The resource was not found"}}`)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL, Credentials: config.Credentials{SubscriptionID: "abc"}}
+	ac = NewAzureClient()
+	ac.tokens[server.URL] = accessToken{token: "token", expiresOn: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		name   string
+		body   string
+		valid  bool
+		issues []string
+	}{
+		{
+			name:  "valid",
+			body:  "targets:\n  - resource: /resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1\n    metrics: [{name: Percentage CPU}]\n",
+			valid: true,
+		},
+		{
+			name:   "invalid YAML",
+			body:   "targets: [\n",
+			issues: []string{"parse_error "},
+		},
+		{
+			name: "missing resource",
+			body: "targets:\n  - resource: /resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1\n    metrics: [{name: Percentage CPU}, {name: Disk Read Bytes}]\n" +
+				"  - resource: /resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm2\n    metrics: [{name: Percentage CPU}]\n" +
+				"resource_groups:\n  - resource_group: missing\n    resource_types: [Microsoft.Compute/virtualMachines]\n    metrics: [{name: Percentage CPU}]\n",
+			issues: []string{
+				"invalid_metric /resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+				"unresolvable_resource /resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm2",
+				"unresolvable_resource missing",
+			},
+		},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		validateConfigHandler(rec, httptest.NewRequest("POST", "/api/validate-config", strings.NewReader(test.body)))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: unexpected status\ngot: %d\nwant: %d", test.name, rec.Code, http.StatusOK)
+			continue
+		}
+		var result validationResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: Error decoding response: %v", test.name, err)
+		}
+		var issues []string
+		for _, issue := range result.Issues {
+			issues = append(issues, issue.Type+" "+issue.Resource)
+		}
+		if result.Valid != test.valid || !reflect.DeepEqual(issues, test.issues) {
+			t.Errorf("%s: unexpected result\ngot: %v %q\nwant: %v %q", test.name, result.Valid, issues, test.valid, test.issues)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	validateConfigHandler(rec, httptest.NewRequest("GET", "/api/validate-config", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status for GET\ngot: %d\nwant: %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
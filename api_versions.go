@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// apiVersionsStatus is the response of a reload of the API versions.
+type apiVersionsStatus struct {
+	ResourceTypes int `json:"resource_types"`
+}
+
+// reloadAPIVersions lists the API versions again, so that the resource types
+// registered since the last listing are recognized.
+func reloadAPIVersions() error {
+	cfg := sc.Get()
+	if err := ac.refreshAccessTokenFor(cfg.ResourceManagerAudience()); err != nil {
+		return err
+	}
+	return ac.listAPIVersions(cfg)
+}
+
+// refreshAPIVersions reloads the API versions every
+// api_versions_refresh_interval, zero disabling the refreshes.
+func refreshAPIVersions() {
+	for {
+		sc.RLock()
+		interval := sc.C.APIVersionsRefreshInterval
+		sc.RUnlock()
+		if interval <= 0 {
+			time.Sleep(time.Minute)
+			continue
+		}
+		time.Sleep(interval)
+		if err := reloadAPIVersions(); err != nil {
+			log.Printf("Failed to refresh the API versions: %v", err)
+		}
+	}
+}
+
+// apiVersionsReloadHandler reloads the API versions and returns the number of
+// resource types.
+func apiVersionsReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "Only POST or PUT requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := reloadAPIVersions(); err != nil {
+		log.Printf("Error reloading the API versions: %v", err)
+		http.Error(w, fmt.Sprintf("Error reloading the API versions: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	ac.apiVersionsMtx.RLock()
+	status := apiVersionsStatus{ResourceTypes: len(ac.APIVersions)}
+	ac.apiVersionsMtx.RUnlock()
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestAPIVersionsReloadHandler(t *testing.T) {
+	providers := `{"value": [{"namespace": "Microsoft.Compute", "resourceTypes": [{"resourceType": "virtualMachines", "apiVersions": ["2021-03-01"]}]}]}`
+	var ifNoneMatch []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/oauth2/token") {
+			fmt.Fprintf(w, `{"access_token":"token","expires_on":"%d"}`, time.Now().Add(time.Hour).Unix())
+			return
+		}
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == `"v2"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v2"`)
+		fmt.Fprint(w, providers)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{
+		ResourceManagerURL:          server.URL,
+		ActiveDirectoryAuthorityURL: server.URL,
+		Credentials:                 config.Credentials{SubscriptionID: "abc", ClientID: "client", ClientSecret: "secret", TenantID: "tenant"},
+	}
+	ac = NewAzureClient()
+	ac.apiVersionsETag = `"v1"`
+
+	sampleCount := func(status string) uint64 {
+		var metric dto.Metric
+		if err := apiRequestDuration.WithLabelValues(listingEndpoints, status).(prometheus.Histogram).Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		return metric.GetHistogram().GetSampleCount()
+	}
+	updated, notModified := sampleCount("200"), sampleCount("304")
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		apiVersionsReloadHandler(rec, httptest.NewRequest("POST", "/-/reload-api-versions", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status\ngot: %d\nwant: %d\n%s", rec.Code, http.StatusOK, rec.Body)
+		}
+		var got apiVersionsStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+		if got.ResourceTypes != 1 {
+			t.Errorf("unexpected resource types\ngot: %d\nwant: 1", got.ResourceTypes)
+		}
+	}
+	if want := []string{`"v1"`, `"v2"`}; strings.Join(ifNoneMatch, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected If-None-Match headers\ngot: %v\nwant: %v", ifNoneMatch, want)
+	}
+	if got := sampleCount("200") - updated; got != 1 {
+		t.Errorf("unexpected observed listings with status 200\ngot: %d\nwant: 1", got)
+	}
+	if got := sampleCount("304") - notModified; got != 1 {
+		t.Errorf("unexpected observed listings with status 304\ngot: %d\nwant: 1", got)
+	}
+	if got := ac.findAPIVersion("Microsoft.Compute/virtualMachines"); got != "2021-03-01" {
+		t.Errorf("unexpected API version\ngot: %s\nwant: 2021-03-01", got)
+	}
+
+	rec := httptest.NewRecorder()
+	apiVersionsReloadHandler(rec, httptest.NewRequest("GET", "/-/reload-api-versions", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status for GET\ngot: %d\nwant: %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// autoscaleAPIVersion is the API version of the autoscale settings.
+const autoscaleAPIVersion = "2022-10-01"
+
+var (
+	autoscaleLabels            = []string{"autoscale_setting", "resource_group", "target_resource"}
+	autoscaleEnabledDesc       = prometheus.NewDesc("azure_autoscale_enabled", "Whether an autoscale setting is enabled (1) or not (0)", autoscaleLabels, nil)
+	autoscaleCapacityDesc      = prometheus.NewDesc("azure_autoscale_capacity", "Instance count bound of a profile of an autoscale setting", append(append([]string{}, autoscaleLabels...), "profile", "bound"), nil)
+	autoscaleObservedDesc      = prometheus.NewDesc("azure_autoscale_observed_capacity", "Instance count of the target resource observed by autoscale", autoscaleLabels, nil)
+	autoscaleActionsDesc       = prometheus.NewDesc("azure_autoscale_scale_actions_initiated", "Scale actions initiated by autoscale during the last minute", autoscaleLabels, nil)
+	autoscaleRuleValueDesc     = prometheus.NewDesc("azure_autoscale_rule_observed_value", "Value of the metric of an autoscale rule as evaluated by autoscale", append(append([]string{}, autoscaleLabels...), "metric"), nil)
+	autoscaleRuleThresholdDesc = prometheus.NewDesc("azure_autoscale_rule_threshold", "Threshold of the metric of an autoscale rule as evaluated by autoscale", append(append([]string{}, autoscaleLabels...), "metric"), nil)
+)
+
+// AzureAutoscaleSettingListResponse is a page of the autoscale settings API.
+type AzureAutoscaleSettingListResponse struct {
+	Value []struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		Properties struct {
+			Enabled           bool   `json:"enabled"`
+			TargetResourceURI string `json:"targetResourceUri"`
+			Profiles          []struct {
+				Name     string `json:"name"`
+				Capacity struct {
+					Minimum string `json:"minimum"`
+					Maximum string `json:"maximum"`
+					Default string `json:"default"`
+				} `json:"capacity"`
+			} `json:"profiles"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+// collectAutoscale exposes the capacity bounds of the autoscale settings of
+// the subscription, along with the capacity observed by autoscale and the
+// evaluations of its rules, from the metrics of the autoscale settings.
+func (c *Collector) collectAutoscale(ch chan<- prometheus.Metric, apiErrors apiErrorSet) {
+	resourceManagerURL := strings.TrimSuffix(c.cfg.ResourceManagerURL, "/")
+	endpoint := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.Insights/autoscalesettings?api-version=%s",
+		resourceManagerURL, c.cfg.Credentials.SubscriptionID, autoscaleAPIVersion)
+
+	var settings AzureAutoscaleSettingListResponse
+	err := forEachPage(endpoint, c.scrapeID, func(body []byte) (string, error) {
+		var page AzureAutoscaleSettingListResponse
+		if err := decodeLenient("autoscale", body, &page); err != nil {
+			return "", err
+		}
+		settings.Value = append(settings.Value, page.Value...)
+		return page.NextLink, nil
+	})
+	if err != nil {
+		c.logf("Failed to get autoscale settings: %v", err)
+		apiErrors.add(errorCode(err), "autoscale_settings")
+		return
+	}
+
+	for _, s := range settings.Value {
+		labels := []string{s.Name, resourceGroupOf(s.ID), s.Properties.TargetResourceURI}
+		ch <- prometheus.MustNewConstMetric(autoscaleEnabledDesc, prometheus.GaugeValue, boolToFloat64(s.Properties.Enabled), labels...)
+		for _, p := range s.Properties.Profiles {
+			for _, bound := range [][2]string{{"minimum", p.Capacity.Minimum}, {"maximum", p.Capacity.Maximum}, {"default", p.Capacity.Default}} {
+				v, err := strconv.ParseFloat(bound[1], 64)
+				if err != nil {
+					continue
+				}
+				ch <- prometheus.MustNewConstMetric(autoscaleCapacityDesc, prometheus.GaugeValue, v, append(labels, p.Name, bound[0])...)
+			}
+		}
+
+		capacity, err := autoscaleMetrics(resourceManagerURL+s.ID, "ObservedCapacity,ScaleActionsInitiated", "", c.scrapeID)
+		if err != nil {
+			c.logf("Failed to get the metrics of autoscale setting %s: %v", s.Name, err)
+			apiErrors.add(errorCode(err), s.ID)
+			continue
+		}
+		for _, v := range capacity.Value {
+			if len(v.Timeseries) == 0 || len(v.Timeseries[0].Data) == 0 {
+				continue
+			}
+			data := v.Timeseries[0].Data[len(v.Timeseries[0].Data)-1]
+			switch v.Name.Value {
+			case "ObservedCapacity":
+				ch <- prometheus.MustNewConstMetric(autoscaleObservedDesc, prometheus.GaugeValue, float64(data.Average), labels...)
+			case "ScaleActionsInitiated":
+				ch <- prometheus.MustNewConstMetric(autoscaleActionsDesc, prometheus.GaugeValue, float64(data.Total), labels...)
+			}
+		}
+
+		rules, err := autoscaleMetrics(resourceManagerURL+s.ID, "ObservedMetricValue,MetricThreshold", "MetricTriggerSource eq '*'", c.scrapeID)
+		if err != nil {
+			c.logf("Failed to get the rule evaluations of autoscale setting %s: %v", s.Name, err)
+			apiErrors.add(errorCode(err), s.ID)
+			continue
+		}
+		for _, v := range rules.Value {
+			desc := autoscaleRuleValueDesc
+			if v.Name.Value == "MetricThreshold" {
+				desc = autoscaleRuleThresholdDesc
+			}
+			for _, ts := range v.Timeseries {
+				if len(ts.Data) == 0 || len(ts.MetadataValues) == 0 {
+					continue
+				}
+				data := ts.Data[len(ts.Data)-1]
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(data.Average), append(labels, ts.MetadataValues[0].Value)...)
+			}
+		}
+	}
+}
+
+// autoscaleMetrics returns the latest datapoints of metrics of an autoscale
+// setting.
+func autoscaleMetrics(setting string, metrics string, filter string, scrapeID string) (*AzureMetricValueResponse, error) {
+	endTime, startTime := GetTimes(time.Minute)
+	values := url.Values{}
+	values.Add("metricnames", metrics)
+	values.Add("aggregation", "Average,Total")
+	if filter != "" {
+		values.Add("$filter", filter)
+	}
+	values.Add("timespan", fmt.Sprintf("%s/%s", startTime, endTime))
+	values.Add("api-version", "2018-01-01")
+
+	body, err := getAzureMonitorResponse(fmt.Sprintf("%s/providers/microsoft.insights/metrics?%s", setting, values.Encode()), scrapeID)
+	if err != nil {
+		return nil, err
+	}
+	var data AzureMetricValueResponse
+	if err := decodeLenient("autoscale", body, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
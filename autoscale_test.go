@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectAutoscale(t *testing.T) {
+	setting := "/subscriptions/abc/resourceGroups/rg/providers/microsoft.insights/autoscalesettings/web-autoscale"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/autoscalesettings"):
+			fmt.Fprintf(w, `{"value": [{"id": %q, "name": "web-autoscale", "properties": {
+				"enabled": true, "targetResourceUri": "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/serverfarms/plan",
+				"profiles": [{"name": "default", "capacity": {"minimum": "1", "maximum": "10", "default": "2"}}]
+			}}]}`, setting)
+		case r.URL.Path == setting+"/providers/microsoft.insights/metrics" && r.URL.Query().Get("$filter") == "":
+			fmt.Fprint(w, `{"value": [
+				{"name": {"value": "ObservedCapacity"}, "timeseries": [{"data": [{"timeStamp": "2020-01-01T00:00:00Z", "average": 3}]}]},
+				{"name": {"value": "ScaleActionsInitiated"}, "timeseries": [{"data": [{"timeStamp": "2020-01-01T00:00:00Z", "total": 1}]}]}
+			]}`)
+		case r.URL.Path == setting+"/providers/microsoft.insights/metrics":
+			fmt.Fprint(w, `{"value": [
+				{"name": {"value": "ObservedMetricValue"}, "timeseries": [{"metadatavalues": [{"name": {"value": "metrictriggersource"}, "value": "CpuPercentage"}], "data": [{"timeStamp": "2020-01-01T00:00:00Z", "average": 85}]}]},
+				{"name": {"value": "MetricThreshold"}, "timeseries": [{"metadatavalues": [{"name": {"value": "metrictriggersource"}, "value": "CpuPercentage"}], "data": [{"timeStamp": "2020-01-01T00:00:00Z", "average": 70}]}]}
+			]}`)
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL, Credentials: config.Credentials{SubscriptionID: "abc"}}
+	ac = NewAzureClient()
+
+	ch := make(chan prometheus.Metric, 20)
+	(&Collector{cfg: sc.C}).collectAutoscale(ch, apiErrorSet{})
+	close(ch)
+
+	target := "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/serverfarms/plan"
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`azure_autoscale_enabled{web-autoscale,rg,` + target + `}`:                           1,
+		`azure_autoscale_capacity{web-autoscale,minimum,default,rg,` + target + `}`:          1,
+		`azure_autoscale_capacity{web-autoscale,maximum,default,rg,` + target + `}`:          10,
+		`azure_autoscale_capacity{web-autoscale,default,default,rg,` + target + `}`:          2,
+		`azure_autoscale_observed_capacity{web-autoscale,rg,` + target + `}`:                 3,
+		`azure_autoscale_scale_actions_initiated{web-autoscale,rg,` + target + `}`:           1,
+		`azure_autoscale_rule_observed_value{web-autoscale,CpuPercentage,rg,` + target + `}`: 85,
+		`azure_autoscale_rule_threshold{web-autoscale,CpuPercentage,rg,` + target + `}`:      70,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't collect the autoscale settings\ngot: %v\nwant: %v", got, want)
+	}
+}
@@ -3,14 +3,18 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/percona/azure_metrics_exporter/config"
@@ -30,6 +34,7 @@ type metricDefinitionResponse struct {
 		LocalizedValue string `json:"localizedValue"`
 		Value          string `json:"value"`
 	} `json:"dimensions"`
+	DisplayDescription   string `json:"displayDescription"`
 	ID                   string `json:"id"`
 	IsDimensionRequired  bool   `json:"isDimensionRequired"`
 	MetricAvailabilities []struct {
@@ -40,9 +45,10 @@ type metricDefinitionResponse struct {
 		LocalizedValue string `json:"localizedValue"`
 		Value          string `json:"value"`
 	} `json:"name"`
-	PrimaryAggregationType string `json:"primaryAggregationType"`
-	ResourceID             string `json:"resourceId"`
-	Unit                   string `json:"unit"`
+	PrimaryAggregationType    string   `json:"primaryAggregationType"`
+	SupportedAggregationTypes []string `json:"supportedAggregationTypes"`
+	ResourceID                string   `json:"resourceId"`
+	Unit                      string   `json:"unit"`
 }
 
 // MetricNamespaceCollectionResponse represents metric namespace response for a given resource from Azure.
@@ -64,13 +70,13 @@ type metricNamespaceResponse struct {
 type AzureMetricValueResponse struct {
 	Value []struct {
 		Timeseries []struct {
-			Data []struct {
-				TimeStamp string  `json:"timeStamp"`
-				Total     float64 `json:"total"`
-				Average   float64 `json:"average"`
-				Minimum   float64 `json:"minimum"`
-				Maximum   float64 `json:"maximum"`
-			} `json:"data"`
+			MetadataValues []struct {
+				Name struct {
+					Value string `json:"value"`
+				} `json:"name"`
+				Value string `json:"value"`
+			} `json:"metadatavalues"`
+			Data []AzureMetricData `json:"data"`
 		} `json:"timeseries"`
 		ID   string `json:"id"`
 		Name struct {
@@ -86,18 +92,77 @@ type AzureMetricValueResponse struct {
 	} `json:"error"`
 }
 
-type AzureBatchMetricResponse struct {
-	Responses []struct {
-		HttpStatusCode int                      `json:"httpStatusCode"`
-		Content        AzureMetricValueResponse `json:"content"`
-	} `json:"responses"`
+// AzureMetricData represents a data point of a metric time series.
+type AzureMetricData struct {
+	TimeStamp string    `json:"timeStamp"`
+	Total     jsonFloat `json:"total"`
+	Average   jsonFloat `json:"average"`
+	Minimum   jsonFloat `json:"minimum"`
+	Maximum   jsonFloat `json:"maximum"`
 }
 
-type AzureBatchLookupResponse struct {
-	Responses []struct {
-		HttpStatusCode int           `json:"httpStatusCode"`
-		Content        AzureResource `json:"content"`
-	} `json:"responses"`
+// APIError represents an error response of the Azure API.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Body       []byte
+}
+
+// newAPIError parses the error code and message from an Azure Resource
+// Manager or Azure Active Directory error response body.
+func newAPIError(statusCode int, body []byte) *APIError {
+	e := &APIError{StatusCode: statusCode, Body: body}
+
+	var armError struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	var aadError struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &armError); err == nil && armError.Error.Code != "" {
+		e.Code, e.Message = armError.Error.Code, armError.Error.Message
+	} else if err := json.Unmarshal(body, &aadError); err == nil && aadError.Error != "" {
+		e.Code, e.Message = aadError.Error, aadError.Description
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Unable to query API with status code: %d and with body: %s", e.StatusCode, e.Body)
+}
+
+// errorCode returns the Azure error code of err, falling back to the HTTP
+// status for unparsable error responses.
+func errorCode(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Code != "" {
+			return apiErr.Code
+		}
+		return strings.Replace(http.StatusText(apiErr.StatusCode), " ", "", -1)
+	}
+	return "RequestFailed"
+}
+
+// AzureBatchMetricSubResponse represents the response to a metric request of a batch.
+type AzureBatchMetricSubResponse struct {
+	Name           string                   `json:"name"`
+	HttpStatusCode int                      `json:"httpStatusCode"`
+	Headers        map[string]string        `json:"headers"`
+	Content        AzureMetricValueResponse `json:"content"`
+}
+
+// AzureBatchLookupSubResponse represents the response to a resource request of a batch.
+type AzureBatchLookupSubResponse struct {
+	Name           string            `json:"name"`
+	HttpStatusCode int               `json:"httpStatusCode"`
+	Headers        map[string]string `json:"headers"`
+	Content        AzureResource     `json:"content"`
 }
 
 type AzureResourceListResponse struct {
@@ -105,13 +170,19 @@ type AzureResourceListResponse struct {
 }
 
 type AzureResource struct {
-	ID           string            `json:"id" pretty:"id"`
-	Name         string            `json:"name" pretty:"resource_name"`
-	Location     string            `json:"location" pretty:"azure_location"`
-	Type         string            `json:"type" pretty:"resource_type"`
-	Tags         map[string]string `json:"tags" pretty:"tags"`
-	ManagedBy    string            `json:"managedBy" pretty:"managed_by"`
-	Subscription string            `pretty:"azure_subscription"`
+	ID        string            `json:"id" pretty:"id"`
+	Name      string            `json:"name" pretty:"resource_name"`
+	Location  string            `json:"location" pretty:"azure_location"`
+	Type      string            `json:"type" pretty:"resource_type"`
+	Tags      map[string]string `json:"tags" pretty:"tags"`
+	ManagedBy string            `json:"managedBy" pretty:"managed_by"`
+	// ProvisioningState is only returned at the top level by the listings,
+	// the lookups return it in the properties.
+	ProvisioningState string                 `json:"provisioningState" pretty:"provisioning_state"`
+	Properties        map[string]interface{} `json:"properties"`
+	Sku               map[string]interface{} `json:"sku"`
+	Subscription      string                 `pretty:"azure_subscription"`
+	SubscriptionName  string                 `pretty:"azure_subscription_name"`
 }
 
 type APIVersionResponse struct {
@@ -179,77 +250,157 @@ func (m *APIVersionMap) findBy(resourceType string) string {
 	return apiVersion
 }
 
-// AzureClient represents our client to talk to the Azure api
+// AzureClient represents our client to talk to the Azure api. It is shared
+// by concurrent scrapes.
 type AzureClient struct {
-	client               *http.Client
-	accessToken          string
-	accessTokenExpiresOn time.Time
-	APIVersions          APIVersionMap
+	client *http.Client
+
+	// tokenMtx protects the access tokens and the client secret state,
+	// tokenFlights coalesces the concurrent renewals of a token.
+	tokenMtx            sync.RWMutex
+	tokens              map[string]accessToken
+	clientSecretModTime time.Time
+	credentialMethod    string
+	tokenFlights        flightGroup
+
+	apiVersionsMtx  sync.RWMutex
+	APIVersions     APIVersionMap
+	apiVersionsETag string
+
+	// subscriptionNameFlights coalesces the requests of the name of a
+	// subscription, which are sent without subscriptionNamesMtx held.
+	subscriptionNamesMtx    sync.Mutex
+	subscriptionNames       map[string]subscriptionNameEntry
+	subscriptionNameFlights flightGroup
+
+	managementGroupsMtx sync.Mutex
+	managementGroups    managementGroupsEntry
+
+	credentialPoolMtx    sync.Mutex
+	credentialPoolNext   int
+	credentialPoolTokens map[string]pooledToken
+	// credentialPoolFlights coalesces the token requests of a credential of
+	// the pool, which are sent without credentialPoolMtx held.
+	credentialPoolFlights flightGroup
+
+	metricDescriptionsMtx sync.Mutex
+	metricDescriptions    map[string]metricDescriptionsEntry
+
+	// definitionFlights coalesces the concurrent requests of the metric
+	// definitions of a resource.
+	definitionFlights flightGroup
 }
 
 // NewAzureClient returns an Azure client to talk the Azure API
 func NewAzureClient() *AzureClient {
 	return &AzureClient{
-		client:               &http.Client{},
-		accessToken:          "",
-		accessTokenExpiresOn: time.Time{},
+		client:             &http.Client{Transport: chaosTransport{next: clockTransport{next: newARMTransport()}}},
+		tokens:             map[string]accessToken{},
+		subscriptionNames:  map[string]subscriptionNameEntry{},
+		metricDescriptions: map[string]metricDescriptionsEntry{},
+
+		credentialPoolTokens: map[string]pooledToken{},
 	}
 }
 
+// accessToken is an access token for a resource (audience).
+type accessToken struct {
+	token     string
+	expiresOn time.Time
+}
+
+// getAccessToken requests an access token for the Azure Resource Manager.
 func (ac *AzureClient) getAccessToken() error {
-	var resp *http.Response
+	return ac.fetchAccessToken(sc.Get().ResourceManagerAudience())
+}
+
+// fetchAccessToken requests a new access token for the resource with the
+// first credential method of the chain that succeeds.
+func (ac *AzureClient) fetchAccessToken(resource string) error {
+	credentials := sc.Get().Credentials
+	chain := credentialChain(credentials)
+	var errs []string
 	var err error
-	if len(sc.C.Credentials.ClientID) == 0 {
-		log.Printf("Using managed identity")
-		target := fmt.Sprintf("http://169.254.169.254/metadata/identity/oauth2/token?resource=%s&api-version=2018-02-01", sc.C.ResourceManagerURL)
-		req, err := http.NewRequest("GET", target, nil)
+	for _, method := range chain {
+		var token accessToken
+		token, err = ac.tokenFrom(method, resource)
+		if err == nil {
+			err = validateToken(token.token, resource, credentials.TenantID, time.Now().UTC())
+		}
 		if err != nil {
-			return fmt.Errorf("Error getting token against Azure MSI endpoint: %v", err)
+			errs = append(errs, fmt.Sprintf("%s: %v", method, err))
+			continue
 		}
-		req.Header.Add("Metadata", "true")
-		resp, err = ac.client.Do(req)
-	} else {
-		target := fmt.Sprintf("%s/%s/oauth2/token", sc.C.ActiveDirectoryAuthorityURL, sc.C.Credentials.TenantID)
-		form := url.Values{
-			"grant_type":    {"client_credentials"},
-			"resource":      {sc.C.ResourceManagerURL},
-			"client_id":     {sc.C.Credentials.ClientID},
-			"client_secret": {sc.C.Credentials.ClientSecret},
+		ac.tokenMtx.Lock()
+		if method != ac.credentialMethod {
+			log.Printf("Authenticated with %s credentials", method)
+			ac.credentialMethod = method
 		}
-		resp, err = ac.client.PostForm(target, form)
-	}
-	if err != nil {
-		return fmt.Errorf("Error authenticating against Azure API: %v", err)
+		ac.tokens[resource] = token
+		ac.tokenMtx.Unlock()
+		return nil
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		respBytest, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("Did not get status code 200, got: %d with body: %s", resp.StatusCode, string(respBytest))
+	if len(chain) == 1 {
+		return err
 	}
+	return fmt.Errorf("Error authenticating with the credentials chain (%s): %w", strings.Join(errs, "; "), err)
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("Error reading body of response: %v", err)
-	}
-	var data map[string]interface{}
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		return fmt.Errorf("Error unmarshalling response body: %v", err)
+// definitionFilter selects the resources whose metric definitions are
+// listed: the resources of resourceGroup or the given resources instead of
+// the configured ones, of resourceTypes when given.
+type definitionFilter struct {
+	resourceGroup string
+	resources     []string
+	resourceTypes []string
+}
+
+// matches tells whether the resource is of the resource types of the filter.
+func (f definitionFilter) matches(resourceID string) bool {
+	if len(f.resourceTypes) == 0 {
+		return true
 	}
-	ac.accessToken = data["access_token"].(string)
-	expiresOn, err := strconv.ParseInt(data["expires_on"].(string), 10, 64)
-	if err != nil {
-		return fmt.Errorf("Error ParseInt of expires_on failed: %v", err)
+	for _, t := range f.resourceTypes {
+		if strings.EqualFold(t, resourceTypeOf(resourceID)) {
+			return true
+		}
 	}
-	ac.accessTokenExpiresOn = time.Unix(expiresOn, 0).UTC()
-
-	return nil
+	return false
 }
 
-// Returns metric definitions for all configured target and resource groups
-func (ac *AzureClient) getMetricDefinitions() (map[string]AzureMetricDefinitionResponse, error) {
+// Returns metric definitions for all configured target and resource groups,
+// or for the resources of the filter.
+func (ac *AzureClient) getMetricDefinitions(filter definitionFilter) (map[string]AzureMetricDefinitionResponse, error) {
+	cfg := sc.Get()
 	definitions := make(map[string]AzureMetricDefinitionResponse)
-	for _, target := range sc.C.Targets {
+	if filter.resourceGroup != "" || len(filter.resources) > 0 {
+		resources := filter.resources
+		if filter.resourceGroup != "" {
+			listed, err := ac.listFromResourceGroup(cfg.Credentials.SubscriptionID, filter.resourceGroup, filter.resourceTypes, "")
+			if err != nil {
+				return nil, fmt.Errorf("Failed to get resources for resource group %s: %v", filter.resourceGroup, err)
+			}
+			for _, resource := range listed {
+				resources = append(resources, resource.ID)
+			}
+		}
+		for _, resource := range resources {
+			if !filter.matches(resource) {
+				continue
+			}
+			def, err := ac.getAzureMetricDefinitionResponse(resource, "")
+			if err != nil {
+				return nil, err
+			}
+			definitions[resource] = *def
+		}
+		return definitions, nil
+	}
+
+	for _, target := range expandTargets(cfg.Targets) {
+		if !filter.matches(target.Resource) {
+			continue
+		}
 		def, err := ac.getAzureMetricDefinitionResponse(target.Resource, target.MetricNamespace)
 		if err != nil {
 			return nil, err
@@ -261,13 +412,16 @@ func (ac *AzureClient) getMetricDefinitions() (map[string]AzureMetricDefinitionR
 		definitions[defKey] = *def
 	}
 
-	for _, resourceGroup := range sc.C.ResourceGroups {
-		resources, err := ac.filteredListFromResourceGroup(resourceGroup)
+	for _, resourceGroup := range cfg.ResourceGroups {
+		resources, err := ac.filteredListFromResourceGroup(cfg.Credentials.SubscriptionID, resourceGroup, "")
 		if err != nil {
 			return nil, fmt.Errorf("Failed to get resources for resource group %s and resource types %s: %v",
 				resourceGroup.ResourceGroup, resourceGroup.ResourceTypes, err)
 		}
 		for _, resource := range resources {
+			if !filter.matches(resource.ID) {
+				continue
+			}
 			def, err := ac.getAzureMetricDefinitionResponse(resource.ID, resourceGroup.MetricNamespace)
 			if err != nil {
 				return nil, err
@@ -284,8 +438,9 @@ func (ac *AzureClient) getMetricDefinitions() (map[string]AzureMetricDefinitionR
 
 // Returns metric namespaces for all configured target and resource groups.
 func (ac *AzureClient) getMetricNamespaces() (map[string]MetricNamespaceCollectionResponse, error) {
+	cfg := sc.Get()
 	namespaces := make(map[string]MetricNamespaceCollectionResponse)
-	for _, target := range sc.C.Targets {
+	for _, target := range expandTargets(cfg.Targets) {
 		namespaceCollection, err := ac.getMetricNamespaceCollectionResponse(target.Resource)
 		if err != nil {
 			return nil, err
@@ -293,8 +448,8 @@ func (ac *AzureClient) getMetricNamespaces() (map[string]MetricNamespaceCollecti
 		namespaces[target.Resource] = *namespaceCollection
 	}
 
-	for _, resourceGroup := range sc.C.ResourceGroups {
-		resources, err := ac.filteredListFromResourceGroup(resourceGroup)
+	for _, resourceGroup := range cfg.ResourceGroups {
+		resources, err := ac.filteredListFromResourceGroup(cfg.Credentials.SubscriptionID, resourceGroup, "")
 		if err != nil {
 			return nil, fmt.Errorf("Failed to get resources for resource group %s and resource types %s: %v",
 				resourceGroup.ResourceGroup, resourceGroup.ResourceTypes, err)
@@ -310,12 +465,74 @@ func (ac *AzureClient) getMetricNamespaces() (map[string]MetricNamespaceCollecti
 	return namespaces, nil
 }
 
-// Returns AzureMetricDefinitionResponse for a given resource
+// metricDescriptionsRetryDelay is the delay before retrying to get the metric
+// definitions of a resource type after a failure.
+const metricDescriptionsRetryDelay = 5 * time.Minute
+
+type metricDescriptionsEntry struct {
+	descriptions map[string]string
+	expires      time.Time
+}
+
+// metricDescription returns the description of a metric of the metric
+// namespace of a resource, or an empty string when it isn't known. The
+// descriptions of the metric definitions are cached per resource type and
+// metric namespace.
+func (ac *AzureClient) metricDescription(resource string, resourceType string, metricNamespace string, metricName string) string {
+	key := strings.ToLower(resourceType + "|" + metricNamespace)
+	ac.metricDescriptionsMtx.Lock()
+	entry, ok := ac.metricDescriptions[key]
+	ac.metricDescriptionsMtx.Unlock()
+
+	if !ok || (entry.descriptions == nil && time.Now().After(entry.expires)) {
+		// Resources of the same type share a single request.
+		v, _ := ac.definitionFlights.do("descriptions|"+key, func() (interface{}, error) {
+			ac.metricDescriptionsMtx.Lock()
+			entry, ok := ac.metricDescriptions[key]
+			ac.metricDescriptionsMtx.Unlock()
+			if ok && (entry.descriptions != nil || time.Now().Before(entry.expires)) {
+				return entry, nil
+			}
+
+			def, err := ac.getAzureMetricDefinitionResponse(resource, metricNamespace)
+			if err != nil {
+				log.Printf("Failed to get metric definitions of %s: %v", resource, err)
+				entry = metricDescriptionsEntry{expires: time.Now().Add(metricDescriptionsRetryDelay)}
+			} else {
+				entry = metricDescriptionsEntry{descriptions: map[string]string{}}
+				for _, d := range def.MetricDefinitionResponses {
+					entry.descriptions[strings.ToLower(d.Name.Value)] = d.DisplayDescription
+				}
+			}
+
+			ac.metricDescriptionsMtx.Lock()
+			ac.metricDescriptions[key] = entry
+			ac.metricDescriptionsMtx.Unlock()
+			return entry, nil
+		})
+		entry = v.(metricDescriptionsEntry)
+	}
+	return entry.descriptions[strings.ToLower(metricName)]
+}
+
+// Returns AzureMetricDefinitionResponse for a given resource, concurrent
+// requests for the same resource share a single request.
 func (ac *AzureClient) getAzureMetricDefinitionResponse(resource string, metricNamespace string) (*AzureMetricDefinitionResponse, error) {
+	v, err := ac.definitionFlights.do("definitions|"+resource+"|"+metricNamespace, func() (interface{}, error) {
+		return ac.fetchAzureMetricDefinitionResponse(resource, metricNamespace)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*AzureMetricDefinitionResponse), nil
+}
+
+func (ac *AzureClient) fetchAzureMetricDefinitionResponse(resource string, metricNamespace string) (*AzureMetricDefinitionResponse, error) {
 	apiVersion := "2018-01-01"
 
-	metricsResource := fmt.Sprintf("subscriptions/%s%s", sc.C.Credentials.SubscriptionID, resource)
-	metricsTarget := fmt.Sprintf("%s/%s/providers/microsoft.insights/metricDefinitions?api-version=%s", sc.C.ResourceManagerURL, metricsResource, apiVersion)
+	cfg := sc.Get()
+	metricsResource := fmt.Sprintf("subscriptions/%s%s", cfg.Credentials.SubscriptionID, escapeResourceID(resource))
+	metricsTarget := fmt.Sprintf("%s/%s/providers/microsoft.insights/metricDefinitions?api-version=%s", cfg.ResourceManagerURL, metricsResource, apiVersion)
 	if metricNamespace != "" {
 		metricsTarget = fmt.Sprintf("%s&metricnamespace=%s", metricsTarget, url.QueryEscape(metricNamespace))
 	}
@@ -324,18 +541,21 @@ func (ac *AzureClient) getAzureMetricDefinitionResponse(resource string, metricN
 	if err != nil {
 		return nil, fmt.Errorf("Error creating HTTP request: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+ac.accessToken)
-	resp, err := ac.client.Do(req)
+	req.Header.Set("Authorization", ac.authorization())
+	resp, err := ac.clientFor(listingEndpoints).Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("Error: %v", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		recordThrottling("resources", resp.Header.Get("Retry-After"))
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("Error reading body of response: %v", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Error: %v", string(body))
+		return nil, newAPIError(resp.StatusCode, body)
 	}
 
 	def := &AzureMetricDefinitionResponse{}
@@ -350,24 +570,28 @@ func (ac *AzureClient) getAzureMetricDefinitionResponse(resource string, metricN
 func (ac *AzureClient) getMetricNamespaceCollectionResponse(resource string) (*MetricNamespaceCollectionResponse, error) {
 	apiVersion := "2017-12-01-preview"
 
-	nsResource := fmt.Sprintf("subscriptions/%s%s", sc.C.Credentials.SubscriptionID, resource)
-	nsTarget := fmt.Sprintf("%s/%s/providers/microsoft.insights/metricNamespaces?api-version=%s", sc.C.ResourceManagerURL, nsResource, apiVersion)
+	cfg := sc.Get()
+	nsResource := fmt.Sprintf("subscriptions/%s%s", cfg.Credentials.SubscriptionID, escapeResourceID(resource))
+	nsTarget := fmt.Sprintf("%s/%s/providers/microsoft.insights/metricNamespaces?api-version=%s", cfg.ResourceManagerURL, nsResource, apiVersion)
 	req, err := http.NewRequest("GET", nsTarget, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Error creating HTTP request: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+ac.accessToken)
-	resp, err := ac.client.Do(req)
+	req.Header.Set("Authorization", ac.authorization())
+	resp, err := ac.clientFor(listingEndpoints).Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("Error: %v", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		recordThrottling("resources", resp.Header.Get("Retry-After"))
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("Error reading body of response: %v", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Error: %v", string(body))
+		return nil, newAPIError(resp.StatusCode, body)
 	}
 
 	namespaceCollection := &MetricNamespaceCollectionResponse{}
@@ -379,8 +603,8 @@ func (ac *AzureClient) getMetricNamespaceCollectionResponse(resource string) (*M
 }
 
 // Returns resource list resolved and filtered from resource_groups configuration
-func (ac *AzureClient) filteredListFromResourceGroup(resourceGroup config.ResourceGroup) ([]AzureResource, error) {
-	resources, err := ac.listFromResourceGroup(resourceGroup.ResourceGroup, resourceGroup.ResourceTypes)
+func (ac *AzureClient) filteredListFromResourceGroup(subscriptionID string, resourceGroup config.ResourceGroup, scrapeID string) ([]AzureResource, error) {
+	resources, err := ac.listFromResourceGroup(subscriptionID, resourceGroup.ResourceGroup, resourceGroup.ResourceTypes, scrapeID)
 	if err != nil {
 		return nil, err
 	}
@@ -390,8 +614,8 @@ func (ac *AzureClient) filteredListFromResourceGroup(resourceGroup config.Resour
 }
 
 // Returns resource list filtered by tag name and tag value
-func (ac *AzureClient) filteredListByTag(resourceTag config.ResourceTag, resourcesMap map[string][]byte) ([]AzureResource, error) {
-	resources, err := ac.listByTag(resourceTag.ResourceTagName, resourceTag.ResourceTagValue, resourceTag.ResourceTypes, resourcesMap)
+func (ac *AzureClient) filteredListByTag(subscriptionID string, resourceTag config.ResourceTag, resourcesMap map[string][]byte, scrapeID string) ([]AzureResource, error) {
+	resources, err := ac.listByTag(subscriptionID, resourceTag.ResourceTagName, resourceTag.ResourceTagValue, resourceTag.ResourceTypes, resourcesMap, scrapeID)
 	if err != nil {
 		return nil, err
 	}
@@ -399,7 +623,7 @@ func (ac *AzureClient) filteredListByTag(resourceTag config.ResourceTag, resourc
 }
 
 // Returns all resources for given resource group and types
-func (ac *AzureClient) listFromResourceGroup(resourceGroup string, resourceTypes []string) ([]AzureResource, error) {
+func (ac *AzureClient) listFromResourceGroup(subscriptionID string, resourceGroup string, resourceTypes []string, scrapeID string) ([]AzureResource, error) {
 	apiVersion := "2018-02-01"
 
 	var filterTypesElements []string
@@ -407,35 +631,34 @@ func (ac *AzureClient) listFromResourceGroup(resourceGroup string, resourceTypes
 		filterTypesElements = append(filterTypesElements, fmt.Sprintf("resourcetype eq '%s'", filterType))
 	}
 	filterTypes := url.QueryEscape(strings.Join(filterTypesElements, " or "))
-	subscription := fmt.Sprintf("subscriptions/%s", sc.C.Credentials.SubscriptionID)
-	resourcesEndpoint := fmt.Sprintf("%s/%s/resourceGroups/%s/resources?api-version=%s&$filter=%s", sc.C.ResourceManagerURL, subscription, resourceGroup, apiVersion, filterTypes)
+	subscription := fmt.Sprintf("subscriptions/%s", subscriptionID)
+	resourcesEndpoint := fmt.Sprintf("%s/%s/resourceGroups/%s/resources?api-version=%s&$filter=%s&$expand=provisioningState", sc.Get().ResourceManagerURL, subscription, resourceGroup, apiVersion, filterTypes)
 
-	body, err := getAzureMonitorResponse(resourcesEndpoint)
+	body, err := getAzureMonitorResponse(resourcesEndpoint, scrapeID)
 	if err != nil {
 		return nil, err
 	}
 
 	var data AzureResourceListResponse
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		return nil, fmt.Errorf("Error unmarshalling response body: %v", err)
+	if err := decodeLenient("resources", body, &data); err != nil {
+		return nil, err
 	}
-	return data.extendResources(), nil
+	return data.extendResources(subscriptionID), nil
 }
 
 // Returns all resource with the given couple tagname, tagvalue
-func (ac *AzureClient) listByTag(tagName string, tagValue string, types []string, resourcesMap map[string][]byte) ([]AzureResource, error) {
+func (ac *AzureClient) listByTag(subscriptionID string, tagName string, tagValue string, types []string, resourcesMap map[string][]byte, scrapeID string) ([]AzureResource, error) {
 	apiVersion := "2018-05-01"
 	securedTagName := secureString(tagName)
 	securedTagValue := secureString(tagValue)
 	filterTypes := url.QueryEscape(fmt.Sprintf("tagName eq '%s' and tagValue eq '%s'", securedTagName, securedTagValue))
-	subscription := fmt.Sprintf("subscriptions/%s", sc.C.Credentials.SubscriptionID)
-	resourcesEndpoint := fmt.Sprintf("%s/%s/resources?api-version=%s&$filter=%s", sc.C.ResourceManagerURL, subscription, apiVersion, filterTypes)
+	subscription := fmt.Sprintf("subscriptions/%s", subscriptionID)
+	resourcesEndpoint := fmt.Sprintf("%s/%s/resources?api-version=%s&$filter=%s&$expand=provisioningState", sc.Get().ResourceManagerURL, subscription, apiVersion, filterTypes)
 
 	body, ok := resourcesMap[resourcesEndpoint]
 	if !ok {
 		var err error
-		body, err = getAzureMonitorResponse(resourcesEndpoint)
+		body, err = getAzureMonitorResponse(resourcesEndpoint, scrapeID)
 		if err != nil {
 			return nil, err
 		}
@@ -443,35 +666,140 @@ func (ac *AzureClient) listByTag(tagName string, tagValue string, types []string
 	}
 
 	var data AzureResourceListResponse
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		return nil, fmt.Errorf("Error unmarshalling response body: %v", err)
+	if err := decodeLenient("resources", body, &data); err != nil {
+		return nil, err
 	}
 
 	if len(types) > 0 {
 		data.Value = data.filterTypesInResourceList(types)
 	}
-	return data.extendResources(), nil
+	return data.extendResources(subscriptionID), nil
+}
+
+// subscriptionNameRetryDelay is the delay before retrying to resolve the
+// name of a subscription after a failure.
+const subscriptionNameRetryDelay = 5 * time.Minute
+
+type subscriptionNameEntry struct {
+	name    string
+	expires time.Time
+}
+
+// subscriptionNamesEnabled tells whether the display names of the
+// subscriptions are resolved, which is only the case when subscription_name
+// is one of global_labels_from_identity.
+func subscriptionNamesEnabled() bool {
+	for _, label := range sc.Get().GlobalLabelsFromIdentity {
+		if label == "subscription_name" {
+			return true
+		}
+	}
+	return false
+}
+
+// cachedSubscriptionName returns the cached name of the subscription, or its
+// ID when it isn't known yet, and whether the name is still fresh.
+func (ac *AzureClient) cachedSubscriptionName(subscriptionID string, now time.Time) (subscriptionNameEntry, bool) {
+	ac.subscriptionNamesMtx.Lock()
+	defer ac.subscriptionNamesMtx.Unlock()
+	entry, ok := ac.subscriptionNames[subscriptionID]
+	if !ok {
+		entry.name = subscriptionID
+	}
+	return entry, ok && now.Before(entry.expires)
+}
+
+// subscriptionName returns the display name of the subscription. Names are
+// cached for subscription_name_refresh_interval, the last known name or the
+// subscription ID are used when the name can't be retrieved.
+func (ac *AzureClient) subscriptionName(subscriptionID string) string {
+	now := time.Now()
+	if entry, fresh := ac.cachedSubscriptionName(subscriptionID, now); fresh {
+		return entry.name
+	}
+
+	v, _ := ac.subscriptionNameFlights.do(subscriptionID, func() (interface{}, error) {
+		// The name may have been resolved by a request completed meanwhile.
+		entry, fresh := ac.cachedSubscriptionName(subscriptionID, now)
+		if fresh {
+			return entry.name, nil
+		}
+		name, err := ac.getSubscriptionName(subscriptionID)
+		if err != nil {
+			log.Printf("Failed to get name of subscription %s, using %s: %v", subscriptionID, entry.name, err)
+			entry.expires = now.Add(subscriptionNameRetryDelay)
+		} else {
+			entry.name = name
+			entry.expires = now.Add(sc.Get().SubscriptionNameRefreshInterval)
+		}
+		ac.subscriptionNamesMtx.Lock()
+		ac.subscriptionNames[subscriptionID] = entry
+		ac.subscriptionNamesMtx.Unlock()
+		return entry.name, nil
+	})
+	return v.(string)
+}
+
+// Returns the display name of a subscription from the Subscriptions API
+func (ac *AzureClient) getSubscriptionName(subscriptionID string) (string, error) {
+	apiVersion := "2020-01-01"
+	subscriptionEndpoint := fmt.Sprintf("%s/subscriptions/%s?api-version=%s", strings.TrimSuffix(sc.Get().ResourceManagerURL, "/"), subscriptionID, apiVersion)
+	body, err := getAzureMonitorResponse(subscriptionEndpoint, "")
+	if err != nil {
+		return "", err
+	}
+
+	var subscription struct {
+		DisplayName string `json:"displayName"`
+	}
+	if err := json.Unmarshal(body, &subscription); err != nil {
+		return "", fmt.Errorf("Error unmarshalling response body: %v", err)
+	}
+	if subscription.DisplayName == "" {
+		return "", fmt.Errorf("No display name in response: %s", body)
+	}
+	return subscription.DisplayName, nil
 }
 
-func (ac *AzureClient) listAPIVersions() error {
+// listAPIVersions lists the API versions of the resource types of the
+// resource providers. The listing is requested with the ETag of the previous
+// one, which Azure Resource Manager answers with 304 Not Modified when the
+// resource providers didn't change.
+func (ac *AzureClient) listAPIVersions(cfg *config.Config) error {
 	apiVersion := "2019-05-10"
 	var versionResponse APIVersionResponse
 
-	subscription := fmt.Sprintf("subscriptions/%s", sc.C.Credentials.SubscriptionID)
-	resourcesEndpoint := fmt.Sprintf("%s/%s/providers?api-version=%s", sc.C.ResourceManagerURL, subscription, apiVersion)
+	subscription := fmt.Sprintf("subscriptions/%s", cfg.Credentials.SubscriptionID)
+	resourcesEndpoint := fmt.Sprintf("%s/%s/providers?api-version=%s", cfg.ResourceManagerURL, subscription, apiVersion)
 
-	body, err := getAzureMonitorResponse(resourcesEndpoint)
+	header := http.Header{}
+	ac.apiVersionsMtx.RLock()
+	if ac.apiVersionsETag != "" {
+		header.Set("If-None-Match", ac.apiVersionsETag)
+	}
+	ac.apiVersionsMtx.RUnlock()
+	body, respHeader, err := sendAzureRequest("GET", resourcesEndpoint, ac.authorization(), "", header)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotModified {
+		apiVersionsRefreshesTotal.WithLabelValues("not_modified").Inc()
+		return nil
+	}
 	if err != nil {
+		apiVersionsRefreshesTotal.WithLabelValues("error").Inc()
 		return err
 	}
 
 	err = json.Unmarshal(body, &versionResponse)
 	if err != nil {
+		apiVersionsRefreshesTotal.WithLabelValues("error").Inc()
 		return fmt.Errorf("Error unmarshalling response body: %v", err)
 	}
 
+	ac.apiVersionsMtx.Lock()
 	ac.APIVersions = versionResponse.extractAPIVersions()
+	ac.apiVersionsETag = respHeader.Get("ETag")
+	ac.apiVersionsMtx.Unlock()
+	apiVersionsRefreshesTotal.WithLabelValues("updated").Inc()
 	return nil
 }
 
@@ -494,36 +822,93 @@ func secureString(value string) string {
 	return securedValue
 }
 
-func getAzureMonitorResponse(azureManagementEndpoint string) ([]byte, error) {
-	req, err := http.NewRequest("GET", azureManagementEndpoint, nil)
+// getAzureMonitorResponse sends a GET request to Azure Resource Manager with
+// the next credential of the credential pool, on behalf of the scrape
+// scrapeID if any.
+func getAzureMonitorResponse(azureManagementEndpoint string, scrapeID string) ([]byte, error) {
+	credential, authorization := ac.pooledAuthorization()
+	credentialRequestsTotal.WithLabelValues(credential).Inc()
+	body, err := azureRequest("GET", azureManagementEndpoint, authorization, scrapeID)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests {
+		credentialThrottledTotal.WithLabelValues(credential).Inc()
+	}
+	return body, err
+}
+
+// postAzureMonitorRequest sends a POST request without body, as used by the
+// query APIs of Azure Resource Manager.
+func postAzureMonitorRequest(azureManagementEndpoint string, scrapeID string) ([]byte, error) {
+	return azureRequest("POST", azureManagementEndpoint, ac.authorization(), scrapeID)
+}
+
+// azureRequest sends a request without body to an Azure API with the given
+// Authorization header value. The requests of a scrape carry its ID in the
+// correlation header.
+func azureRequest(method string, azureManagementEndpoint string, authorization string, scrapeID string) ([]byte, error) {
+	body, _, err := sendAzureRequest(method, azureManagementEndpoint, authorization, scrapeID, nil)
+	return body, err
+}
+
+// sendAzureRequest is azureRequest with the extra request headers, e.g. the
+// If-None-Match of a conditional request, also returning the response
+// headers. Any status but 200 OK, 304 Not Modified included, is returned as
+// an *APIError.
+func sendAzureRequest(method string, azureManagementEndpoint string, authorization string, scrapeID string, header http.Header) ([]byte, http.Header, error) {
+	req, err := http.NewRequest(method, azureManagementEndpoint, nil)
 	if err != nil {
-		return nil, fmt.Errorf("Error creating HTTP request: %v", err)
+		return nil, nil, fmt.Errorf("Error creating HTTP request: %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
 	}
-	req.Header.Set("Authorization", "Bearer "+ac.accessToken)
-	resp, err := ac.client.Do(req)
+	req.Header.Set("Authorization", authorization)
+	setCorrelationHeader(req, scrapeID)
+	resp, err := ac.clientFor(listingEndpoints).Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("Error: %v", err)
+		return nil, nil, fmt.Errorf("Error: %v", err)
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading body of response: %v", err)
+	}
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		recordThrottling("resources", resp.Header.Get("Retry-After"))
+	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("Unable to query API with status code: %d and with body: %s", resp.StatusCode, body)
+		return nil, nil, newAPIError(resp.StatusCode, body)
 	}
+	return body, resp.Header, nil
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("Error reading body of response: %v", err)
+// forEachPage requests the pages of an Azure list API, starting at endpoint.
+// The page callback handles the body of each page and returns the link to
+// the next page.
+func forEachPage(endpoint string, scrapeID string, page func(body []byte) (string, error)) error {
+	for endpoint != "" {
+		body, err := getAzureMonitorResponse(endpoint, scrapeID)
+		if err != nil {
+			return err
+		}
+		if endpoint, err = page(body); err != nil {
+			return err
+		}
 	}
-	return body, err
+	return nil
 }
 
-func (ar *AzureResourceListResponse) extendResources() []AzureResource {
-	subscription := fmt.Sprintf("subscriptions/%s", sc.C.Credentials.SubscriptionID)
+func (ar *AzureResourceListResponse) extendResources(subscriptionID string) []AzureResource {
+	subscription := fmt.Sprintf("subscriptions/%s", subscriptionID)
 	var subscriptionPrefixLen = len(subscription) + 1
 
 	for i, val := range ar.Value {
 		ar.Value[i].ID = val.ID[subscriptionPrefixLen:]
-		ar.Value[i].Subscription = sc.C.Credentials.SubscriptionID
+		ar.Value[i].Subscription = subscriptionID
+		if subscriptionNamesEnabled() {
+			ar.Value[i].SubscriptionName = ac.subscriptionName(subscriptionID)
+		}
 	}
 	return ar.Value
 }
@@ -562,38 +947,129 @@ func (ac *AzureClient) filterResources(resources []AzureResource, resourceGroup
 	return filteredResources
 }
 
+// refreshAccessToken renews the Azure Resource Manager access token before it
+// expires.
 func (ac *AzureClient) refreshAccessToken() error {
-	now := time.Now().UTC()
-	refreshAt := ac.accessTokenExpiresOn.Add(-10 * time.Minute)
+	return ac.refreshAccessTokenFor(sc.Get().ResourceManagerAudience())
+}
 
-	if now.After(refreshAt) {
-		err := ac.getAccessToken()
-		if err != nil {
-			return fmt.Errorf("Error refreshing access token: %v", err)
+// refreshAccessTokenFor renews the access token of the resource before it
+// expires. Concurrent scrapes wait for a single renewal, while the renewals
+// of the tokens of other resources proceed.
+func (ac *AzureClient) refreshAccessTokenFor(resource string) error {
+	if !ac.tokenExpiring(resource) {
+		return nil
+	}
+	_, err := ac.tokenFlights.do(resource, func() (interface{}, error) {
+		// The token may have been renewed by a renewal completed meanwhile.
+		if !ac.tokenExpiring(resource) {
+			return nil, nil
 		}
+		return nil, ac.fetchAccessToken(resource)
+	})
+	if err != nil {
+		return fmt.Errorf("Error refreshing access token: %w", err)
 	}
 	return nil
 }
 
+// resetTokens drops the access tokens, e.g. after the credentials changed.
+func (ac *AzureClient) resetTokens() {
+	ac.tokenMtx.Lock()
+	defer ac.tokenMtx.Unlock()
+	ac.tokens = map[string]accessToken{}
+}
+
+// tokenExpiring reports whether the access token of the resource needs to be
+// renewed.
+func (ac *AzureClient) tokenExpiring(resource string) bool {
+	ac.tokenMtx.Lock()
+	defer ac.tokenMtx.Unlock()
+
+	refreshAt := ac.tokens[resource].expiresOn.Add(-10 * time.Minute)
+	return time.Now().UTC().After(refreshAt) || ac.clientSecretChanged()
+}
+
+// authorization returns the Authorization header value for Azure Resource
+// Manager requests.
+func (ac *AzureClient) authorization() string {
+	return ac.authorizationFor(sc.Get().ResourceManagerAudience())
+}
+
+// authorizationFor returns the Authorization header value for requests to
+// the resource.
+func (ac *AzureClient) authorizationFor(resource string) string {
+	ac.tokenMtx.RLock()
+	defer ac.tokenMtx.RUnlock()
+	return "Bearer " + ac.tokens[resource].token
+}
+
+// findAPIVersion returns the latest API version of the resource type.
+func (ac *AzureClient) findAPIVersion(resourceType string) string {
+	ac.apiVersionsMtx.RLock()
+	defer ac.apiVersionsMtx.RUnlock()
+	return ac.APIVersions.findBy(resourceType)
+}
+
+// clientSecret returns the client secret, read from the client secret file
+// when one is configured.
+func (ac *AzureClient) clientSecret() (string, error) {
+	credentials := sc.Get().Credentials
+	secretFile := credentials.ClientSecretFile
+	if secretFile == "" {
+		return credentials.ClientSecret, nil
+	}
+
+	info, err := os.Stat(secretFile)
+	if err != nil {
+		return "", fmt.Errorf("Error reading client secret file: %v", err)
+	}
+	secret, err := ioutil.ReadFile(secretFile)
+	if err != nil {
+		return "", fmt.Errorf("Error reading client secret file: %v", err)
+	}
+	ac.tokenMtx.Lock()
+	ac.clientSecretModTime = info.ModTime()
+	ac.tokenMtx.Unlock()
+	return strings.TrimSpace(string(secret)), nil
+}
+
+// clientSecretChanged reports whether the client secret file was modified
+// since the secret was last read, e.g. by a credential rotation.
+func (ac *AzureClient) clientSecretChanged() bool {
+	secretFile := sc.Get().Credentials.ClientSecretFile
+	if secretFile == "" || ac.clientSecretModTime.IsZero() {
+		return false
+	}
+
+	info, err := os.Stat(secretFile)
+	if err != nil || info.ModTime().Equal(ac.clientSecretModTime) {
+		return false
+	}
+	log.Printf("Client secret file %s changed, authenticating again", secretFile)
+	return true
+}
+
 type batchBody struct {
 	Requests []batchRequest `json:"requests"`
 }
 
 type batchRequest struct {
+	Name        string `json:"name"`
 	RelativeURL string `json:"relativeUrl"`
 	Method      string `json:"httpMethod"`
 }
 
-func resourceURLFrom(resource string, metricNamespace string, metricNames string, aggregations []string) string {
+func resourceURLFrom(subscriptionID string, resource string, metricNamespace string, metricNames string, aggregations []string, dimensions []config.Dimension, queryParameters map[string]string, interval time.Duration, timespan time.Duration) string {
 	apiVersion := "2018-01-01"
 
 	path := fmt.Sprintf(
 		"/subscriptions/%s%s/providers/microsoft.insights/metrics",
-		sc.C.Credentials.SubscriptionID,
-		resource,
+		url.PathEscape(subscriptionID),
+		escapeResourceID(resource),
 	)
 
-	endTime, startTime := GetTimes()
+	endTime, startTime := GetTimes(timespan)
 
 	values := url.Values{}
 	if metricNames != "" {
@@ -604,28 +1080,47 @@ func resourceURLFrom(resource string, metricNamespace string, metricNames string
 	}
 	filtered := filterAggregations(aggregations)
 	values.Add("aggregation", strings.Join(filtered, ","))
+	if filter := dimensionFilter(dimensions); filter != "" {
+		values.Add("$filter", filter)
+	}
 	values.Add("timespan", fmt.Sprintf("%s/%s", startTime, endTime))
+	if interval != 0 {
+		values.Add("interval", isoDuration(interval))
+	}
 	values.Add("api-version", apiVersion)
+	for k, v := range queryParameters {
+		values.Set(k, v)
+	}
 
-	url := url.URL{
-		Path:     path,
-		RawQuery: values.Encode(),
+	return path + "?" + values.Encode()
+}
+
+// dimensionFilter returns the filter splitting the metrics by all the values
+// of the dimensions.
+func dimensionFilter(dimensions []config.Dimension) string {
+	var filters []string
+	for _, d := range dimensions {
+		filters = append(filters, fmt.Sprintf("%s eq '*'", d.Name))
 	}
-	return url.String()
+	return strings.Join(filters, " and ")
 }
 
-func (ac *AzureClient) getBatchResponseBody(urls []string) ([]byte, error) {
+// getBatchResponse sends the requests as a batch and returns the batch
+// response body, which must be closed by the caller. The timeout of the
+// endpoint class covers reading the body.
+func (ac *AzureClient) getBatchResponse(class string, urls []string, scrapeID string) (io.ReadCloser, error) {
 
-	rmBaseURL := sc.C.ResourceManagerURL
-	if !strings.HasSuffix(sc.C.ResourceManagerURL, "/") {
+	rmBaseURL := sc.Get().ResourceManagerURL
+	if !strings.HasSuffix(rmBaseURL, "/") {
 		rmBaseURL += "/"
 	}
 
 	apiURL := fmt.Sprintf("%sbatch?api-version=2017-03-01", rmBaseURL)
 
 	batch := batchBody{}
-	for _, u := range urls {
+	for k, u := range urls {
 		batch.Requests = append(batch.Requests, batchRequest{
+			Name:        strconv.Itoa(k),
 			RelativeURL: u,
 			Method:      "GET",
 		})
@@ -641,17 +1136,140 @@ func (ac *AzureClient) getBatchResponseBody(urls []string) ([]byte, error) {
 		return nil, fmt.Errorf("Error creating HTTP request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+ac.accessToken)
+	req.Header.Set("Authorization", ac.authorization())
+	setCorrelationHeader(req, scrapeID)
 
-	resp, err := ac.client.Do(req)
+	resp, err := ac.clientFor(class).Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("Error: %v", err)
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		recordThrottling("batch", resp.Header.Get("Retry-After"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading body of response: %v", err)
+		}
+		return nil, newAPIError(resp.StatusCode, body)
+	}
+	return resp.Body, nil
+}
+
+// batchMatcher matches the sub-responses of a batch to its requests by the
+// name of the requests echoed by Azure, as the sub-responses aren't
+// guaranteed to be in the order of the requests.
+type batchMatcher struct {
+	answered []bool
+}
+
+func newBatchMatcher(requests int) *batchMatcher {
+	return &batchMatcher{answered: make([]bool, requests)}
+}
+
+// match returns the index of the request answered by the k-th sub-response,
+// given the name of the sub-response. Sub-responses without a name are
+// matched by position.
+func (m *batchMatcher) match(k int, name string) (int, error) {
+	i := k
+	if name != "" {
+		n, err := strconv.Atoi(name)
+		if err != nil || n < 0 || n >= len(m.answered) {
+			batchMismatchesTotal.WithLabelValues("unknown").Inc()
+			return 0, fmt.Errorf("Unexpected batch sub-response %q for %d requests", name, len(m.answered))
+		}
+		if n != k {
+			batchMismatchesTotal.WithLabelValues("out_of_order").Inc()
+		}
+		i = n
+	}
+	if i >= len(m.answered) || m.answered[i] {
+		batchMismatchesTotal.WithLabelValues("unknown").Inc()
+		return 0, fmt.Errorf("Unexpected batch sub-response %d for %d requests", k, len(m.answered))
+	}
+	m.answered[i] = true
+	return i, nil
+}
+
+// missing returns the indexes of the requests without sub-response.
+func (m *batchMatcher) missing() []int {
+	var missing []int
+	for i, answered := range m.answered {
+		if !answered {
+			missing = append(missing, i)
+		}
+	}
+	batchMismatchesTotal.WithLabelValues("missing").Add(float64(len(missing)))
+	return missing
+}
+
+// decodeBatchResponses streams the sub-responses of a batch response to
+// decode one at a time, so that large batch responses are never held in
+// memory as a whole. decode is given the index of the sub-response.
+func decodeBatchResponses(r io.Reader, decode func(k int, dec *json.Decoder) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("Error decoding batch response: %v", err)
+		}
+		if key != "responses" {
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return fmt.Errorf("Error decoding batch response: %v", err)
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		for k := 0; dec.More(); k++ {
+			if err := decode(k, dec); err != nil {
+				return err
+			}
+		}
+		return expectDelim(dec, ']')
+	}
+	return nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("Error decoding batch response: %v", err)
+	}
+	if token != delim {
+		return fmt.Errorf("Error decoding batch response: expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+// formatMetricDefinition returns the lines describing a metric definition,
+// with its supported dimensions, aggregations and time grains.
+func formatMetricDefinition(r metricDefinitionResponse) []string {
+	lines := []string{fmt.Sprintf("- %s (%s)", r.Name.Value, r.Unit)}
+	if len(r.SupportedAggregationTypes) > 0 {
+		lines = append(lines, fmt.Sprintf("    Aggregations: %s (primary: %s)", strings.Join(r.SupportedAggregationTypes, ", "), r.PrimaryAggregationType))
+	}
+	var timeGrains []string
+	for _, a := range r.MetricAvailabilities {
+		timeGrains = append(timeGrains, a.TimeGrain)
+	}
+	if len(timeGrains) > 0 {
+		lines = append(lines, fmt.Sprintf("    Time grains: %s", strings.Join(timeGrains, ", ")))
+	}
+	var dimensions []string
+	for _, d := range r.Dimensions {
+		dimensions = append(dimensions, d.Value)
+	}
+	if len(dimensions) > 0 {
+		lines = append(lines, fmt.Sprintf("    Dimensions: %s", strings.Join(dimensions, ", ")))
 	}
-	return body, nil
+	return lines
 }
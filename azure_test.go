@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestErrorCode(t *testing.T) {
+	var cases = []struct {
+		err  error
+		want string
+	}{
+		{
+			newAPIError(404, []byte(`{"error":{"code":"ResourceNotFound","message":"The Resource was not found."}}`)),
+			"ResourceNotFound",
+		},
+		{
+			newAPIError(401, []byte(`{"error":"invalid_client","error_description":"AADSTS7000215: Invalid client secret is provided."}`)),
+			"invalid_client",
+		},
+		{
+			fmt.Errorf("Error refreshing access token: %w", newAPIError(503, []byte("<html>Service Unavailable</html>"))),
+			"ServiceUnavailable",
+		},
+		{
+			fmt.Errorf("Error: dial tcp: connection refused"),
+			"RequestFailed",
+		},
+	}
+
+	for _, c := range cases {
+		got := errorCode(c.err)
+
+		if got != c.want {
+			t.Errorf("doesn't extract expected error code from %v\ngot: %v\nwant: %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestRefreshAccessTokenConcurrent(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		expiresOn := time.Now().Add(time.Hour).Unix()
+		fmt.Fprintf(w, `{"access_token":"token","expires_on":"%d"}`, expiresOn)
+	}))
+	defer server.Close()
+
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{
+		ActiveDirectoryAuthorityURL: server.URL,
+		Credentials:                 config.Credentials{ClientID: "client", TenantID: "tenant"},
+	}
+
+	client := NewAzureClient()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.refreshAccessToken(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if got := client.authorization(); got != "Bearer token" {
+				t.Errorf("unexpected authorization: %s", got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if requests != 1 {
+		t.Errorf("concurrent refreshes didn't share the token\ngot: %d token requests\nwant: 1", requests)
+	}
+}
+
+func TestDecodeBatchResponses(t *testing.T) {
+	body := `{
+		"nextLink": {"ignored": [1, 2]},
+		"responses": [
+			{"httpStatusCode": 200, "content": {"id": "/a", "name": "a"}},
+			{"httpStatusCode": 404, "content": {"error": {"code": "ResourceNotFound"}}}
+		]
+	}`
+
+	var got []AzureBatchLookupSubResponse
+	err := decodeBatchResponses(strings.NewReader(body), func(k int, dec *json.Decoder) error {
+		if k != len(got) {
+			t.Errorf("unexpected sub-response index %d", k)
+		}
+		var resp AzureBatchLookupSubResponse
+		if err := dec.Decode(&resp); err != nil {
+			return err
+		}
+		got = append(got, resp)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(got) != 2 || got[0].Content.ID != "/a" || got[1].HttpStatusCode != 404 {
+		t.Errorf("doesn't decode expected sub-responses\ngot: %+v", got)
+	}
+
+	if err := decodeBatchResponses(strings.NewReader(`[]`), nil); err == nil {
+		t.Errorf("expected an error for a malformed batch response")
+	}
+}
+
+func TestBatchMatcher(t *testing.T) {
+	m := newBatchMatcher(3)
+
+	if i, err := m.match(0, "2"); i != 2 || err != nil {
+		t.Errorf("doesn't match sub-response by name\ngot: %d, %v\nwant: 2", i, err)
+	}
+	if i, err := m.match(1, ""); i != 1 || err != nil {
+		t.Errorf("doesn't match unnamed sub-response by position\ngot: %d, %v\nwant: 1", i, err)
+	}
+	for _, name := range []string{"2", "3", "x"} {
+		if _, err := m.match(2, name); err == nil {
+			t.Errorf("expected an error for sub-response %q", name)
+		}
+	}
+
+	if got := m.missing(); len(got) != 1 || got[0] != 0 {
+		t.Errorf("unexpected missing requests\ngot: %v\nwant: [0]", got)
+	}
+}
+
+func TestGetMetricDefinitionsOfResourceGroup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/resourceGroups/rg/resources"):
+			if !strings.Contains(r.URL.Query().Get("$filter"), "Microsoft.Compute/virtualMachines") {
+				t.Errorf("resources aren't filtered by type: %s", r.URL)
+			}
+			fmt.Fprint(w, `{"value": [{"id": "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm", "type": "Microsoft.Compute/virtualMachines"}]}`)
+		case strings.HasSuffix(r.URL.Path, "/metricDefinitions"):
+			fmt.Fprint(w, `{"value": [{"name": {"value": "Percentage CPU"}, "unit": "Percent"}]}`)
+		case r.URL.Path == "/subscriptions/abc":
+			fmt.Fprint(w, `{"displayName": "sub"}`)
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL, Credentials: config.Credentials{SubscriptionID: "abc"}}
+	ac = NewAzureClient()
+
+	got, err := ac.getMetricDefinitions(definitionFilter{resourceGroup: "rg", resourceTypes: []string{"Microsoft.Compute/virtualMachines"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, ok := got["/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm"]
+	if len(got) != 1 || !ok || def.MetricDefinitionResponses[0].Name.Value != "Percentage CPU" {
+		t.Errorf("unexpected metric definitions\ngot: %+v", got)
+	}
+}
+
+func TestDefinitionFilter(t *testing.T) {
+	f := definitionFilter{resourceTypes: []string{"microsoft.compute/virtualmachines"}}
+	if !f.matches("/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm") {
+		t.Errorf("doesn't match a resource of the type")
+	}
+	if f.matches("/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Sql/servers/db") {
+		t.Errorf("matches a resource of another type")
+	}
+	if !(definitionFilter{}).matches("/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Sql/servers/db") {
+		t.Errorf("doesn't match resources without resource types")
+	}
+}
+
+func TestFormatMetricDefinition(t *testing.T) {
+	var r metricDefinitionResponse
+	err := json.Unmarshal([]byte(`{
+		"name": {"value": "Percentage CPU"}, "unit": "Percent",
+		"primaryAggregationType": "Average", "supportedAggregationTypes": ["Average", "Maximum"],
+		"metricAvailabilities": [{"timeGrain": "PT1M"}, {"timeGrain": "PT5M"}],
+		"dimensions": [{"value": "VMName"}]
+	}`), &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"- Percentage CPU (Percent)",
+		"    Aggregations: Average, Maximum (primary: Average)",
+		"    Time grains: PT1M, PT5M",
+		"    Dimensions: VMName",
+	}
+	if got := formatMetricDefinition(r); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected metric definition\ngot: %q\nwant: %q", got, want)
+	}
+}
+
+func TestMetricDescription(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, `{"value": [{"name": {"value": "Percentage CPU"}, "displayDescription": "The percentage of allocated compute units in use."}]}`)
+	}))
+	defer server.Close()
+
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL}
+
+	client := NewAzureClient()
+	for _, resource := range []string{"/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1", "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm2"} {
+		got := client.metricDescription(resource, "Microsoft.Compute/virtualMachines", "", "percentage cpu")
+		if want := "The percentage of allocated compute units in use."; got != want {
+			t.Errorf("doesn't return metric description\ngot: %v\nwant: %v", got, want)
+		}
+	}
+	if got := client.metricDescription("/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1", "Microsoft.Compute/virtualMachines", "", "Unknown"); got != "" {
+		t.Errorf("unexpected description of unknown metric: %v", got)
+	}
+	if requests != 1 {
+		t.Errorf("metric definitions not cached per resource type\ngot: %d requests\nwant: 1", requests)
+	}
+}
+
+func TestResourceURLFromQueryParameters(t *testing.T) {
+	dimensions := []config.Dimension{{Name: "Queue"}}
+	parameters := map[string]string{"validatedimensions": "false", "rollupby": "Queue"}
+	u, err := url.Parse(resourceURLFrom("abc", "/resourceGroups/rg/providers/Microsoft.ServiceBus/namespaces/ns", "", "ActiveMessages", []string{"Average"}, dimensions, parameters, 0, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	q := u.Query()
+	for k, want := range map[string]string{
+		"metricnames":        "ActiveMessages",
+		"$filter":            "Queue eq '*'",
+		"validatedimensions": "false",
+		"rollupby":           "Queue",
+	} {
+		if got := q.Get(k); got != want {
+			t.Errorf("unexpected %s\ngot: %q\nwant: %q", k, got, want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// backupAPIVersion is the API version of the Recovery Services vaults and
+// of their backup jobs and protected items.
+const backupAPIVersion = "2023-04-01"
+
+var (
+	backupLastJobStatusDesc = prometheus.NewDesc("azure_backup_last_job_status", "Whether the last finished backup job of an item completed (1) or not (0)",
+		[]string{"vault", "resource_group", "item", "operation", "status"}, nil)
+	backupProtectedItemHealthDesc = prometheus.NewDesc("azure_backup_protected_item_health", "Whether the health of a protected item of a Recovery Services vault is passed (1) or not (0)",
+		[]string{"vault", "resource_group", "item", "source_resource", "health_status"}, nil)
+)
+
+// AzureRecoveryServicesVaultListResponse is a page of the Recovery Services
+// vaults API.
+type AzureRecoveryServicesVaultListResponse struct {
+	Value []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+// AzureBackupJobListResponse is a page of the backup jobs of a vault.
+type AzureBackupJobListResponse struct {
+	Value []struct {
+		Properties struct {
+			EntityFriendlyName string    `json:"entityFriendlyName"`
+			Operation          string    `json:"operation"`
+			Status             string    `json:"status"`
+			StartTime          time.Time `json:"startTime"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+// AzureBackupProtectedItemListResponse is a page of the protected items of a
+// vault.
+type AzureBackupProtectedItemListResponse struct {
+	Value []struct {
+		Properties struct {
+			FriendlyName     string `json:"friendlyName"`
+			SourceResourceID string `json:"sourceResourceId"`
+			HealthStatus     string `json:"healthStatus"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+type backupJob struct {
+	status    string
+	startTime time.Time
+}
+
+// collectBackups exposes the status of the last backup jobs and the health of
+// the protected items of the Recovery Services vaults of the subscription.
+func (c *Collector) collectBackups(ch chan<- prometheus.Metric, apiErrors apiErrorSet) {
+	resourceManagerURL := strings.TrimSuffix(c.cfg.ResourceManagerURL, "/")
+	vaultsEndpoint := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.RecoveryServices/vaults?api-version=%s",
+		resourceManagerURL, c.cfg.Credentials.SubscriptionID, backupAPIVersion)
+
+	var vaults AzureRecoveryServicesVaultListResponse
+	err := forEachPage(vaultsEndpoint, c.scrapeID, func(body []byte) (string, error) {
+		var page AzureRecoveryServicesVaultListResponse
+		if err := decodeLenient("backup", body, &page); err != nil {
+			return "", err
+		}
+		vaults.Value = append(vaults.Value, page.Value...)
+		return page.NextLink, nil
+	})
+	if err != nil {
+		c.logf("Failed to get Recovery Services vaults: %v", err)
+		apiErrors.add(errorCode(err), "recovery_services_vaults")
+		return
+	}
+
+	for _, vault := range vaults.Value {
+		resourceGroup := resourceGroupOf(vault.ID)
+
+		jobs, err := lastBackupJobs(fmt.Sprintf("%s%s/backupJobs?api-version=%s", resourceManagerURL, vault.ID, backupAPIVersion), c.scrapeID)
+		if err != nil {
+			c.logf("Failed to get backup jobs of vault %s: %v", vault.Name, err)
+			apiErrors.add(errorCode(err), vault.ID)
+		}
+		for k, job := range jobs {
+			ch <- prometheus.MustNewConstMetric(backupLastJobStatusDesc, prometheus.GaugeValue, boolToFloat64(job.status == "Completed"),
+				vault.Name, resourceGroup, k[0], k[1], job.status)
+		}
+
+		itemsEndpoint := fmt.Sprintf("%s%s/backupProtectedItems?api-version=%s", resourceManagerURL, vault.ID, backupAPIVersion)
+		err = forEachPage(itemsEndpoint, c.scrapeID, func(body []byte) (string, error) {
+			var page AzureBackupProtectedItemListResponse
+			if err := decodeLenient("backup", body, &page); err != nil {
+				return "", err
+			}
+			for _, item := range page.Value {
+				p := item.Properties
+				ch <- prometheus.MustNewConstMetric(backupProtectedItemHealthDesc, prometheus.GaugeValue, boolToFloat64(p.HealthStatus == "Passed"),
+					vault.Name, resourceGroup, p.FriendlyName, p.SourceResourceID, p.HealthStatus)
+			}
+			return page.NextLink, nil
+		})
+		if err != nil {
+			c.logf("Failed to get protected items of vault %s: %v", vault.Name, err)
+			apiErrors.add(errorCode(err), vault.ID)
+		}
+	}
+}
+
+// lastBackupJobs returns the last finished job of each item and operation of
+// a vault. Jobs in progress are ignored.
+func lastBackupJobs(endpoint string, scrapeID string) (map[[2]string]backupJob, error) {
+	jobs := map[[2]string]backupJob{}
+	err := forEachPage(endpoint, scrapeID, func(body []byte) (string, error) {
+		var page AzureBackupJobListResponse
+		if err := decodeLenient("backup", body, &page); err != nil {
+			return "", err
+		}
+		for _, job := range page.Value {
+			p := job.Properties
+			if p.Status == "InProgress" || p.Status == "Cancelling" {
+				continue
+			}
+			key := [2]string{p.EntityFriendlyName, p.Operation}
+			if last, ok := jobs[key]; !ok || p.StartTime.After(last.startTime) {
+				jobs[key] = backupJob{status: p.Status, startTime: p.StartTime}
+			}
+		}
+		return page.NextLink, nil
+	})
+	return jobs, err
+}
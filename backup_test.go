@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectBackups(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/vaults"):
+			fmt.Fprint(w, `{"value": [{"id": "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.RecoveryServices/vaults/vault1", "name": "vault1"}]}`)
+		case strings.HasSuffix(r.URL.Path, "/backupJobs"):
+			fmt.Fprint(w, `{"value": [
+				{"properties": {"entityFriendlyName": "vm1", "operation": "Backup", "status": "Completed", "startTime": "2020-01-01T00:00:00Z"}},
+				{"properties": {"entityFriendlyName": "vm1", "operation": "Backup", "status": "Failed", "startTime": "2020-01-02T00:00:00Z"}},
+				{"properties": {"entityFriendlyName": "vm1", "operation": "Backup", "status": "InProgress", "startTime": "2020-01-03T00:00:00Z"}},
+				{"properties": {"entityFriendlyName": "vm2", "operation": "Backup", "status": "Completed", "startTime": "2020-01-02T00:00:00Z"}}
+			]}`)
+		case strings.HasSuffix(r.URL.Path, "/backupProtectedItems"):
+			fmt.Fprint(w, `{"value": [{"properties": {"friendlyName": "vm1", "sourceResourceId": "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1", "healthStatus": "ActionRequired"}}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL}
+	ac = NewAzureClient()
+
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).collectBackups(ch, apiErrorSet{})
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`azure_backup_last_job_status{vm1,Backup,rg,Failed,vault1}`:                                                                                             0,
+		`azure_backup_last_job_status{vm2,Backup,rg,Completed,vault1}`:                                                                                          1,
+		`azure_backup_protected_item_health{ActionRequired,vm1,rg,/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1,vault1}`: 0,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't collect backups\ngot: %v\nwant: %v", got, want)
+	}
+}
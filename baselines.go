@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// baselinesAPIVersion is the API version of the metric baselines.
+	baselinesAPIVersion = "2019-03-01"
+	// baselinesConcurrency is the number of baseline requests in flight.
+	baselinesConcurrency = 8
+)
+
+// AzureMetricBaselinesResponse is the response of the metric baselines API
+// for the metrics of a resource.
+type AzureMetricBaselinesResponse struct {
+	Value []struct {
+		Name       string `json:"name"`
+		Properties struct {
+			Baselines []struct {
+				Aggregation string `json:"aggregation"`
+				Data        []struct {
+					Sensitivity    string    `json:"sensitivity"`
+					LowThresholds  []float64 `json:"lowThresholds"`
+					HighThresholds []float64 `json:"highThresholds"`
+				} `json:"data"`
+			} `json:"baselines"`
+		} `json:"properties"`
+	} `json:"value"`
+}
+
+// collectBaselines exposes the latest low and high bands of the baselines
+// computed by Azure Monitor for the metrics of the resources, as
+// azure_baseline_low and azure_baseline_high labeled with the resource
+// labels and the metric, aggregation and sensitivity.
+func (c *Collector) collectBaselines(ch chan<- prometheus.Metric, resources []resourceMeta, apiErrors apiErrorSet) {
+	responses := make([]*AzureMetricBaselinesResponse, len(resources))
+	errs := make([]error, len(resources))
+	slots := make(chan struct{}, baselinesConcurrency)
+	var wg sync.WaitGroup
+	for i, rm := range resources {
+		if rm.metrics == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, rm resourceMeta) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			responses[i], errs[i] = metricBaselines(rm, c.scrapeID)
+		}(i, rm)
+	}
+	wg.Wait()
+
+	for i, rm := range resources {
+		if rm.metrics == "" {
+			continue
+		}
+		c.stats.add(rm.block, subscriptionOf(rm), entryStats{APICalls: 1})
+		if errs[i] != nil {
+			c.logf("Failed to get the metric baselines of resource %s: %v", rm.resourceID, errs[i])
+			apiErrors.add(errorCode(errs[i]), rm.resourceID)
+			continue
+		}
+
+		for _, v := range responses[i].Value {
+			for _, b := range v.Properties.Baselines {
+				for _, d := range b.Data {
+					labels := resourceLabels(rm)
+					for name, value := range rm.labels {
+						if _, ok := labels[name]; !ok {
+							labels[name] = value
+						}
+					}
+					labels["metric"] = v.Name
+					labels["aggregation"] = strings.ToLower(b.Aggregation)
+					labels["sensitivity"] = strings.ToLower(d.Sensitivity)
+					for _, band := range []struct {
+						name       string
+						thresholds []float64
+					}{{"azure_baseline_low", d.LowThresholds}, {"azure_baseline_high", d.HighThresholds}} {
+						if len(band.thresholds) == 0 {
+							continue
+						}
+						desc := prometheus.NewDesc(band.name, "Band of the Azure Monitor baseline of a metric, the values outside of it being anomalous", nil, labels)
+						ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, band.thresholds[len(band.thresholds)-1])
+						c.stats.add(rm.block, subscriptionOf(rm), entryStats{Series: 1})
+					}
+				}
+			}
+		}
+	}
+}
+
+// metricBaselines requests the baselines of the metrics of a resource over
+// the timespan of its metrics.
+func metricBaselines(rm resourceMeta, scrapeID string) (*AzureMetricBaselinesResponse, error) {
+	cfg := sc.Get()
+	endTime, startTime := GetTimes(rm.timespan)
+	values := url.Values{}
+	values.Add("metricnames", rm.metrics)
+	if rm.metricNamespace != "" {
+		values.Add("metricnamespace", rm.metricNamespace)
+	}
+	values.Add("aggregation", strings.Join(filterAggregations(rm.aggregations), ","))
+	values.Add("sensitivities", cfg.Baselines.Sensitivity)
+	values.Add("timespan", fmt.Sprintf("%s/%s", startTime, endTime))
+	if rm.interval != 0 {
+		values.Add("interval", isoDuration(rm.interval))
+	}
+	values.Add("resultType", "Data")
+	values.Add("api-version", baselinesAPIVersion)
+
+	endpoint := fmt.Sprintf("%s/subscriptions/%s%s/providers/Microsoft.Insights/metricBaselines?%s",
+		strings.TrimSuffix(cfg.ResourceManagerURL, "/"), subscriptionOf(rm), escapeResourceID(rm.resourceID), values.Encode())
+	body, err := getAzureMonitorResponse(endpoint, scrapeID)
+	if err != nil {
+		return nil, err
+	}
+	var data AzureMetricBaselinesResponse
+	if err := decodeLenient("baselines", body, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectBaselines(t *testing.T) {
+	vm := "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/subscriptions/abc"+vm+"/providers/Microsoft.Insights/metricBaselines" {
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("metricnames") != "Percentage CPU" || q.Get("sensitivities") != "Medium" || q.Get("aggregation") != "Average" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		fmt.Fprint(w, `{"value": [{"name": "Percentage CPU", "properties": {"baselines": [{
+			"aggregation": "Average",
+			"data": [{"sensitivity": "Medium", "lowThresholds": [5, 10], "highThresholds": [60, 70]}]
+		}]}}]}`)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL, Credentials: config.Credentials{SubscriptionID: "abc"}, Baselines: config.Baselines{Enabled: true, Sensitivity: "Medium"}}
+	ac = NewAzureClient()
+
+	resources := []resourceMeta{
+		{
+			resourceID:   vm,
+			resourceURL:  resourceURLFrom("abc", vm, "", "Percentage CPU", []string{"Average"}, nil, nil, 0, 0),
+			metrics:      "Percentage CPU",
+			aggregations: []string{"Average"},
+		},
+		// Resources without metrics have no baselines.
+		{resourceID: "/resourceGroups/rg/providers/Microsoft.Web/sites/app"},
+	}
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).collectBaselines(ch, resources, apiErrorSet{})
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`azure_baseline_low{average,Percentage CPU,rg,vm1,medium}`:  10,
+		`azure_baseline_high{average,Percentage CPU,rg,vm1,medium}`: 70,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected baselines\ngot: %v\nwant: %v", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	budgetLabels            = []string{"budget", "time_grain", "currency"}
+	budgetLimitDesc         = prometheus.NewDesc("azure_budget_limit", "Amount of the Cost Management budget", budgetLabels, nil)
+	budgetCurrentSpendDesc  = prometheus.NewDesc("azure_budget_current_spend", "Spend of the current period of the Cost Management budget", budgetLabels, nil)
+	budgetForecastSpendDesc = prometheus.NewDesc("azure_budget_forecast_spend", "Forecasted spend of the current period of the Cost Management budget", budgetLabels, nil)
+)
+
+// AzureBudgetListResponse is the response of the Cost Management budgets API.
+type AzureBudgetListResponse struct {
+	Value []AzureBudget `json:"value"`
+}
+
+type AzureBudget struct {
+	Name       string `json:"name"`
+	Properties struct {
+		Amount       jsonFloat `json:"amount"`
+		TimeGrain    string    `json:"timeGrain"`
+		CurrentSpend *struct {
+			Amount jsonFloat `json:"amount"`
+			Unit   string    `json:"unit"`
+		} `json:"currentSpend"`
+		ForecastSpend *struct {
+			Amount jsonFloat `json:"amount"`
+			Unit   string    `json:"unit"`
+		} `json:"forecastSpend"`
+	} `json:"properties"`
+}
+
+// listBudgets returns the budgets of the subscription.
+func (ac *AzureClient) listBudgets(scrapeID string) ([]AzureBudget, error) {
+	apiVersion := "2021-10-01"
+	cfg := sc.Get()
+	budgetsEndpoint := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.Consumption/budgets?api-version=%s",
+		strings.TrimSuffix(cfg.ResourceManagerURL, "/"), cfg.Credentials.SubscriptionID, apiVersion)
+	body, err := getAzureMonitorResponse(budgetsEndpoint, scrapeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var data AzureBudgetListResponse
+	if err := decodeLenient("budgets", body, &data); err != nil {
+		return nil, err
+	}
+	return data.Value, nil
+}
+
+// collectBudgets exposes the amount and the current and forecasted spend of
+// the budgets of the subscription. Spends are only exposed once computed by
+// Cost Management.
+func (c *Collector) collectBudgets(ch chan<- prometheus.Metric, apiErrors apiErrorSet) {
+	budgets, err := ac.listBudgets(c.scrapeID)
+	if err != nil {
+		c.logf("Failed to get budgets: %v", err)
+		apiErrors.add(errorCode(err), "budgets")
+		return
+	}
+
+	for _, b := range budgets {
+		p := b.Properties
+		currency := ""
+		if p.CurrentSpend != nil {
+			currency = p.CurrentSpend.Unit
+		}
+		ch <- prometheus.MustNewConstMetric(budgetLimitDesc, prometheus.GaugeValue, float64(p.Amount), b.Name, p.TimeGrain, currency)
+		if p.CurrentSpend != nil {
+			ch <- prometheus.MustNewConstMetric(budgetCurrentSpendDesc, prometheus.GaugeValue, float64(p.CurrentSpend.Amount), b.Name, p.TimeGrain, currency)
+		}
+		if p.ForecastSpend != nil {
+			ch <- prometheus.MustNewConstMetric(budgetForecastSpendDesc, prometheus.GaugeValue, float64(p.ForecastSpend.Amount), b.Name, p.TimeGrain, currency)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectBudgets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"value": [
+			{"name": "monthly", "properties": {"amount": 1000, "timeGrain": "Monthly",
+				"currentSpend": {"amount": 412.5, "unit": "USD"}, "forecastSpend": {"amount": 980, "unit": "USD"}}},
+			{"name": "new", "properties": {"amount": "50", "timeGrain": "Annually"}}
+		]}`)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL}
+	ac = NewAzureClient()
+
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).collectBudgets(ch, apiErrorSet{})
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`azure_budget_limit{monthly,USD,Monthly}`:          1000,
+		`azure_budget_current_spend{monthly,USD,Monthly}`:  412.5,
+		`azure_budget_forecast_spend{monthly,USD,Monthly}`: 980,
+		`azure_budget_limit{new,,Annually}`:                50,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't collect budgets\ngot: %v\nwant: %v", got, want)
+	}
+}
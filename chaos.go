@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// errSimulated is the error of the Azure requests failed by
+// --debug.simulate-error-rate.
+var errSimulated = errors.New("Simulated Azure API error")
+
+// chaosTransport delays and fails the Azure requests as configured by the
+// hidden --debug.simulate-latency and --debug.simulate-error-rate flags, so
+// that the alerting on azure_error and on the scrape duration can be tested.
+type chaosTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if *simulateLatency > 0 {
+		timer := time.NewTimer(*simulateLatency)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+	if *simulateErrorRate > 0 && rand.Float64() < *simulateErrorRate {
+		return nil, errSimulated
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChaosTransport(t *testing.T) {
+	previousLatency, previousErrorRate := *simulateLatency, *simulateErrorRate
+	defer func() { *simulateLatency, *simulateErrorRate = previousLatency, previousErrorRate }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	client := &http.Client{Transport: chaosTransport{next: http.DefaultTransport}}
+
+	*simulateLatency = 50 * time.Millisecond
+	start := time.Now()
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if d := time.Since(start); d < *simulateLatency {
+		t.Errorf("doesn't delay the request\ngot: %v", d)
+	}
+
+	*simulateLatency = 0
+	*simulateErrorRate = 1
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Error("doesn't fail the request")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Exit codes of the exporter, so that the wrappers of the CLI modes can tell
+// the failures apart.
+const (
+	exitOK            = 0
+	exitFailed        = 1
+	exitConfigInvalid = 2
+	exitAuthFailed    = 3
+	exitAPIError      = 4
+)
+
+// exitStatuses are the statuses reported by --output=json per exit code.
+var exitStatuses = map[int]string{
+	exitOK:            "ok",
+	exitFailed:        "failed",
+	exitConfigInvalid: "config_invalid",
+	exitAuthFailed:    "auth_failed",
+	exitAPIError:      "api_error",
+}
+
+// cliOutput is the document written by the CLI modes with --output=json.
+type cliOutput struct {
+	Status   string      `json:"status"`
+	ExitCode int         `json:"exit_code"`
+	Error    string      `json:"error,omitempty"`
+	Warnings []string    `json:"warnings,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+}
+
+// writeCLIOutput writes the JSON document of the outcome of a CLI mode.
+func writeCLIOutput(w io.Writer, code int, err error, warnings []string, result interface{}) error {
+	out := cliOutput{
+		Status:   exitStatuses[code],
+		ExitCode: code,
+		Warnings: warnings,
+		Result:   result,
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// cliExit reports the outcome of a CLI mode, as JSON on the standard output
+// with --output=json and otherwise by logging err, and exits with code.
+func cliExit(code int, err error, warnings []string, result interface{}) {
+	if *output == "json" {
+		if werr := writeCLIOutput(os.Stdout, code, err, warnings, result); werr != nil {
+			log.Printf("Error writing output: %v", werr)
+		}
+	} else if err != nil {
+		log.Print(err)
+	}
+	os.Exit(code)
+}
+
+// accessCheckResult is the JSON form of an accessCheck.
+type accessCheckResult struct {
+	Scope   string   `json:"scope"`
+	Missing []string `json:"missing,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// accessChecksOutcome returns the JSON form of the checks and the exit code
+// of check-access: failed when permissions are missing, API error when a
+// scope couldn't be checked.
+func accessChecksOutcome(checks []accessCheck) ([]accessCheckResult, int) {
+	results := []accessCheckResult{}
+	code := exitOK
+	for _, check := range checks {
+		result := accessCheckResult{Scope: check.Scope, Missing: check.Missing}
+		if check.Err != nil {
+			result.Error = check.Err.Error()
+			code = exitAPIError
+		} else if len(check.Missing) > 0 && code == exitOK {
+			code = exitFailed
+		}
+		results = append(results, result)
+	}
+	return results, code
+}
+
+// jsonSettings converts the effective settings of lint-config, whose keys
+// are strings, to maps which can be encoded as JSON.
+func jsonSettings(entries []yaml.MapSlice) []map[string]interface{} {
+	settings := []map[string]interface{}{}
+	for _, entry := range entries {
+		m := make(map[string]interface{}, len(entry))
+		for _, item := range entry {
+			m[fmt.Sprint(item.Key)] = item.Value
+		}
+		settings = append(settings, m)
+	}
+	return settings
+}
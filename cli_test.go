@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestWriteCLIOutput(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeCLIOutput(&out, exitAuthFailed, errors.New("Failed to get token: invalid client secret"), nil, nil); err != nil {
+		t.Fatalf("Error writing output: %v", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("Output isn't JSON: %v\n%s", err, out.String())
+	}
+	want := map[string]interface{}{
+		"status":    "auth_failed",
+		"exit_code": float64(3),
+		"error":     "Failed to get token: invalid client secret",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected output\ngot: %v\nwant: %v", got, want)
+	}
+}
+
+func TestAccessChecksOutcome(t *testing.T) {
+	tests := []struct {
+		checks []accessCheck
+		code   int
+	}{
+		{[]accessCheck{{Scope: "/subscriptions/abc"}}, exitOK},
+		{[]accessCheck{{Scope: "/subscriptions/abc", Missing: []string{"Microsoft.Insights/Metrics/Read"}}}, exitFailed},
+		{[]accessCheck{
+			{Scope: "/subscriptions/abc", Err: errors.New("timeout")},
+			{Scope: "/subscriptions/abc/resourceGroups/rg", Missing: []string{"Microsoft.Insights/Metrics/Read"}},
+		}, exitAPIError},
+	}
+	for _, test := range tests {
+		results, code := accessChecksOutcome(test.checks)
+		if code != test.code {
+			t.Errorf("unexpected exit code for %v\ngot: %d\nwant: %d", test.checks, code, test.code)
+		}
+		if len(results) != len(test.checks) {
+			t.Errorf("unexpected results for %v: %v", test.checks, results)
+		}
+	}
+}
+
+func TestJSONSettings(t *testing.T) {
+	entries := []yaml.MapSlice{{
+		{Key: "block", Value: "targets[0]"},
+		{Key: "metrics", Value: []string{"Percentage CPU"}},
+	}}
+	got := jsonSettings(entries)
+	want := []map[string]interface{}{{"block": "targets[0]", "metrics": []string{"Percentage CPU"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected settings\ngot: %v\nwant: %v", got, want)
+	}
+	if _, err := json.Marshal(got); err != nil {
+		t.Errorf("Settings can't be encoded as JSON: %v", err)
+	}
+}
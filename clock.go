@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// azureClock estimates the offset of the clock of Azure from the local
+// clock, from the Date header of the Azure responses, so that the query
+// timespans follow the clock of Azure when the clock of the host drifts.
+type azureClock struct {
+	mtx      sync.Mutex
+	offset   time.Duration
+	observed bool
+}
+
+// observe records the Date header of a response to a request sent at sent
+// and received at received. The header has a resolution of a second, the
+// date is assumed to be in the middle of its second and of the request.
+func (c *azureClock) observe(date string, sent time.Time, received time.Time) {
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return
+	}
+	offset := t.Add(500 * time.Millisecond).Sub(sent.Add(received.Sub(sent) / 2))
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.observed {
+		// Smooth the jitter of the network and of the header resolution.
+		offset = c.offset + (offset-c.offset)/4
+	}
+	c.offset = offset
+	c.observed = true
+	clockOffsetSeconds.Set(offset.Seconds())
+}
+
+// now returns the current time on the clock of Azure.
+func (c *azureClock) now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+// clockTransport records the Date header of the Azure responses.
+type clockTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t clockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		azureTime.observe(resp.Header.Get("Date"), sent, time.Now())
+	}
+	return resp, err
+}
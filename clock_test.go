@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestAzureClock(t *testing.T) {
+	var c azureClock
+	sent := time.Date(2020, 3, 29, 1, 59, 0, 0, time.UTC)
+	received := sent.Add(200 * time.Millisecond)
+
+	// Azure is 90 seconds ahead of the host.
+	c.observe(sent.Add(90*time.Second).Format(http.TimeFormat), sent, received)
+	if c.offset < 90*time.Second || c.offset > 91*time.Second {
+		t.Errorf("unexpected offset\ngot: %v\nwant: about 90s", c.offset)
+	}
+	if now := c.now(); now.Sub(time.Now()) < 89*time.Second {
+		t.Errorf("time isn't on the clock of Azure: %v", now)
+	}
+
+	// Later observations are smoothed.
+	c.observe(sent.Format(http.TimeFormat), sent, received)
+	if c.offset < 67*time.Second || c.offset > 69*time.Second {
+		t.Errorf("unexpected smoothed offset\ngot: %v\nwant: about 68s", c.offset)
+	}
+
+	// Invalid dates are ignored.
+	previous := c.offset
+	c.observe("", sent, received)
+	if c.offset != previous {
+		t.Errorf("offset changed by an invalid date\ngot: %v\nwant: %v", c.offset, previous)
+	}
+}
+
+func TestGetTimesClockSkew(t *testing.T) {
+	previous, previousTime := sc.C, azureTime
+	defer func() { sc.C, azureTime = previous, previousTime }()
+	sc.C = &config.Config{QueryDelay: 3 * time.Minute, ClockSkewAllowance: 2 * time.Minute}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+	azureTime = &azureClock{}
+	resp, err := (&http.Client{Transport: clockTransport{next: http.DefaultTransport}}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	endTime, startTime := GetTimes(5 * time.Minute)
+	end, _ := time.Parse(time.RFC3339, endTime)
+	start, _ := time.Parse(time.RFC3339, startTime)
+	if want := time.Now().Add(-time.Hour - 3*time.Minute); end.Sub(want) > 5*time.Second || want.Sub(end) > 5*time.Second {
+		t.Errorf("window doesn't end query_delay before the time of Azure\ngot: %v\nwant: %v", end, want)
+	}
+	if got := end.Sub(start); got != 7*time.Minute {
+		t.Errorf("window isn't widened by clock_skew_allowance\ngot: %v\nwant: 7m0s", got)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// collectorNames are the parts of the configuration that can be selected by
+// the collect[] parameters of /metrics.
+var collectorNames = []string{"targets", "resource_groups", "resource_tags", "budgets", "advisor", "secure_score", "backup", "policy", "autoscale", "log_analytics", "credential_expiry", "scheduled_events"}
+
+// collectorSet is the selection of the parts of the configuration collected
+// by a scrape, nil selecting all of them.
+type collectorSet map[string]bool
+
+// parseCollectorSet returns the selection of the collect[] parameters.
+func parseCollectorSet(names []string) (collectorSet, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	s := collectorSet{}
+	for _, name := range names {
+		if !containsFold(collectorNames, name) {
+			return nil, fmt.Errorf("Unknown collector %q, must be one of %s", name, strings.Join(collectorNames, ", "))
+		}
+		s[strings.ToLower(name)] = true
+	}
+	return s, nil
+}
+
+// enabled tells whether the part of the configuration is collected.
+func (s collectorSet) enabled(name string) bool {
+	return s == nil || s[name]
+}
+
+// key identifies the selection, the empty string selecting all the parts.
+func (s collectorSet) key() string {
+	var names []string
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
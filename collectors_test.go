@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCollectorSet(t *testing.T) {
+	all, err := parseCollectorSet(nil)
+	if err != nil || !all.enabled("targets") || all.key() != "" {
+		t.Errorf("doesn't select all the collectors by default\ngot: %v, %v", all, err)
+	}
+
+	s, err := parseCollectorSet([]string{"resource_tags", "Targets"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.enabled("targets") || !s.enabled("resource_tags") || s.enabled("resource_groups") {
+		t.Errorf("doesn't select the collectors\ngot: %v", s)
+	}
+	if got := s.key(); got != "resource_tags,targets" {
+		t.Errorf("unexpected key\ngot: %v", got)
+	}
+
+	if _, err := parseCollectorSet([]string{"virtual_machines"}); err == nil {
+		t.Error("accepts an unknown collector")
+	}
+}
+
+func TestHandlerUnknownCollector(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/metrics?collect[]=unknown", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("doesn't reject an unknown collector\ngot: %d", rec.Code)
+	}
+}
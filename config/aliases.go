@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MetricAlias is a well-known name of an Azure metric, always computed from
+// the same aggregation of the metric.
+type MetricAlias struct {
+	// Metric is the name of the Azure metric, e.g. cpu_percent.
+	Metric string
+	// Unit is the unit of the Azure metric, e.g. percent.
+	Unit        string
+	Aggregation string
+	Alias       string
+}
+
+// MetricName returns the name of the metric with the suffixes naming, before
+// its aggregation suffix.
+func (a MetricAlias) MetricName() string {
+	return a.Metric + "_" + a.Unit
+}
+
+// MetricAliases are the well-known names of the Azure metrics.
+var MetricAliases = []MetricAlias{
+	// Our common metrics for nodes.
+	{"cpu_percent", "percent", "Average", "node_cpu_average"},
+	{"network_bytes_egress", "bytes", "Average", "node_network_transmit_bytes_total"},
+	{"network_bytes_ingress", "bytes", "Average", "node_network_receive_bytes_total"},
+	{"storage_limit", "bytes", "Average", "node_filesystem_size_bytes"},
+	// Unique metrics for Azure.
+	{"storage_used", "bytes", "Average", "azure_storage_used_bytes_average"},
+	{"storage_percent", "percent", "Average", "azure_storage_percent_average"},
+	{"memory_percent", "percent", "Average", "azure_memory_percent_average"},
+}
+
+// CounterAliases are the aliases accumulated into counters when
+// alias_counters is enabled. They replace the metric aliases of the same name.
+var CounterAliases = []MetricAlias{
+	{"network_bytes_egress", "bytes", "Total", "node_network_transmit_bytes_total"},
+	{"network_bytes_ingress", "bytes", "Total", "node_network_receive_bytes_total"},
+}
+
+// DimensionAlias maps the series of a metric split by Dimension onto the
+// per-service naming of PMM: they are published as the alias, with the name
+// of the resource identified by the dimension value as the Label label.
+type DimensionAlias struct {
+	MetricAlias
+	Dimension string
+	Label     string
+}
+
+// DimensionAliases are the well-known names of the Azure metrics split by a
+// dimension.
+var DimensionAliases = []DimensionAlias{
+	// Per-database metrics of the Azure SQL elastic pools.
+	{MetricAlias{"cpu_percent", "percent", "Average", "node_cpu_average"}, "DatabaseResourceId", "service_name"},
+	{MetricAlias{"storage_used", "bytes", "Average", "azure_storage_used_bytes_average"}, "DatabaseResourceId", "service_name"},
+	{MetricAlias{"storage_percent", "percent", "Average", "azure_storage_percent_average"}, "DatabaseResourceId", "service_name"},
+}
+
+// HasDimension tells whether the dimensions include the one of the alias.
+func (a DimensionAlias) HasDimension(dimensions []Dimension) bool {
+	for _, d := range dimensions {
+		if strings.EqualFold(d.Name, a.Dimension) {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasesOf returns the aliases in effect for the metric of a block.
+func (c *Config) aliasesOf(metric string) []MetricAlias {
+	name := strings.ToLower(strings.Replace(metric, " ", "_", -1))
+	var aliases []MetricAlias
+	for _, a := range CounterAliases {
+		if c.AliasCounters && a.Metric == name {
+			aliases = append(aliases, a)
+		}
+	}
+	for _, a := range MetricAliases {
+		replaced := false
+		for _, counter := range aliases {
+			replaced = replaced || counter.Alias == a.Alias
+		}
+		if a.Metric == name && !replaced {
+			aliases = append(aliases, a)
+		}
+	}
+	return aliases
+}
+
+// validateAliases rejects the blocks requesting aliased metrics without the
+// aggregation their alias is computed from, which would otherwise silently
+// publish the metric without its alias.
+func (c *Config) validateAliases(metricNamespace string, metrics []Metric, aggregations []string, dimensions []Dimension) error {
+	// Aliases only apply to the metrics of the default namespace named with
+	// suffixes.
+	if c.MetricNaming == "labels" || c.MetricNaming == "aggregation_label" || metricNamespace != "" || len(aggregations) == 0 {
+		return nil
+	}
+	for _, m := range metrics {
+		aliases := c.aliasesOf(m.Name)
+		for _, a := range DimensionAliases {
+			if a.Metric == strings.ToLower(strings.Replace(m.Name, " ", "_", -1)) && a.HasDimension(dimensions) {
+				aliases = append(aliases, a.MetricAlias)
+			}
+		}
+		for _, a := range aliases {
+			if !contains(aggregations, a.Aggregation) {
+				return fmt.Errorf("Metric %s is published as %s from its %s aggregation, which is missing from aggregations %v", m.Name, a.Alias, a.Aggregation, aggregations)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// validBlockName matches the names of the blocks, which are used as label
+// values and URL parameters.
+var validBlockName = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Block kinds, as in the configuration.
+const (
+	TargetsBlock        = "targets"
+	ResourceGroupsBlock = "resource_groups"
+	ResourceTagsBlock   = "resource_tags"
+)
+
+// BlockName returns the name of a block of the configuration, which is its
+// name field when set and otherwise its position, e.g. resource_tags[3].
+func BlockName(kind string, i int, name string) string {
+	if name != "" {
+		return name
+	}
+	return fmt.Sprintf("%s[%d]", kind, i)
+}
+
+// FindBlock returns the kind and the index of the block of the given name,
+// see BlockName.
+func (c *Config) FindBlock(block string) (string, int, bool) {
+	for i, t := range c.Targets {
+		if BlockName(TargetsBlock, i, t.Name) == block {
+			return TargetsBlock, i, true
+		}
+	}
+	for i, g := range c.ResourceGroups {
+		if BlockName(ResourceGroupsBlock, i, g.Name) == block {
+			return ResourceGroupsBlock, i, true
+		}
+	}
+	for i, t := range c.ResourceTags {
+		if BlockName(ResourceTagsBlock, i, t.Name) == block {
+			return ResourceTagsBlock, i, true
+		}
+	}
+	return "", 0, false
+}
+
+// validateBlockNames checks that the names of the blocks are unique.
+func (c *Config) validateBlockNames() error {
+	var names []string
+	for _, t := range c.Targets {
+		names = append(names, t.Name)
+	}
+	for _, g := range c.ResourceGroups {
+		names = append(names, g.Name)
+	}
+	for _, t := range c.ResourceTags {
+		names = append(names, t.Name)
+	}
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if !validBlockName.MatchString(name) {
+			return fmt.Errorf("block name %q must only contain letters, digits, '_', '.' and '-'", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("block name %q is used more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// withBlock returns a copy of the configuration where the named block is
+// replaced by the block of the same name of from.
+func (c *Config) withBlock(from *Config, name string) (*Config, error) {
+	// The positions of the unnamed blocks, e.g. targets[0], aren't valid
+	// names.
+	if !validBlockName.MatchString(name) {
+		return nil, fmt.Errorf("Only named blocks can be reloaded, not %q", name)
+	}
+	kind, i, ok := c.FindBlock(name)
+	if !ok {
+		return nil, fmt.Errorf("Unknown block %q", name)
+	}
+	fromKind, j, ok := from.FindBlock(name)
+	if !ok {
+		return nil, fmt.Errorf("Block %q isn't in the config files", name)
+	}
+	if fromKind != kind {
+		return nil, fmt.Errorf("Block %q moved from %s to %s", name, kind, fromKind)
+	}
+
+	updated := *c
+	switch kind {
+	case TargetsBlock:
+		updated.Targets = append([]Target{}, c.Targets...)
+		updated.Targets[i] = from.Targets[j]
+	case ResourceGroupsBlock:
+		updated.ResourceGroups = append([]ResourceGroup{}, c.ResourceGroups...)
+		updated.ResourceGroups[i] = from.ResourceGroups[j]
+	case ResourceTagsBlock:
+		updated.ResourceTags = append([]ResourceTag{}, c.ResourceTags...)
+		updated.ResourceTags[i] = from.ResourceTags[j]
+	}
+	return &updated, nil
+}
+
+// LoadBlock loads the configuration from its sources and applies only the
+// named block, the other blocks and settings of the running configuration
+// being kept. Unnamed blocks can't be reloaded, as their positions may
+// change. The hash is kept, as it still identifies the last full load.
+func (sc *SafeConfig) LoadBlock(sources Sources, name string) error {
+	loaded, _, err := loadSources(sources, "")
+	if err != nil {
+		return err
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+	c, err := sc.C.withBlock(loaded, name)
+	if err != nil {
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("Error validating config file: %s", err)
+	}
+	sc.C = c
+	return nil
+}
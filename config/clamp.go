@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"math"
+)
+
+// Reasons of the clamped samples.
+const (
+	ClampNaNValue = "nan_value"
+	ClampBelowMin = "below_min"
+	ClampAboveMax = "above_max"
+)
+
+// Clamp overrides the values of a metric before they are published: the
+// NaNValues, e.g. the -1 sentinel of some Service Bus metrics, are replaced
+// by NaN, and the other values are bounded by Min and Max when set.
+type Clamp struct {
+	Min       *float64  `yaml:"min,omitempty"`
+	Max       *float64  `yaml:"max,omitempty"`
+	NaNValues []float64 `yaml:"nan_values,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Clamp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Clamp
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Apply returns the overridden value and the reason of the override, empty
+// when the value is kept.
+func (s *Clamp) Apply(val float64) (float64, string) {
+	for _, v := range s.NaNValues {
+		if val == v {
+			return math.NaN(), ClampNaNValue
+		}
+	}
+	if s.Min != nil && val < *s.Min {
+		return *s.Min, ClampBelowMin
+	}
+	if s.Max != nil && val > *s.Max {
+		return *s.Max, ClampAboveMax
+	}
+	return val, ""
+}
+
+// validateClamps checks the clamps of the metrics of a block.
+func (c *Config) validateClamps(metrics []Metric) error {
+	for _, m := range metrics {
+		s := m.Clamp
+		if s == nil {
+			continue
+		}
+		if s.Min == nil && s.Max == nil && len(s.NaNValues) == 0 {
+			return fmt.Errorf("Clamp of metric %s needs a min, a max or nan_values", m.Name)
+		}
+		if s.Min != nil && s.Max != nil && *s.Min > *s.Max {
+			return fmt.Errorf("Clamp of metric %s has a min %v above its max %v", m.Name, *s.Min, *s.Max)
+		}
+	}
+	return nil
+}
@@ -1,23 +1,68 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
 // Config - Azure exporter configuration
 type Config struct {
-	ActiveDirectoryAuthorityURL string          `yaml:"active_directory_authority_url"`
-	ResourceManagerURL          string          `yaml:"resource_manager_url"`
-	Credentials                 Credentials     `yaml:"credentials"`
-	Targets                     []Target        `yaml:"targets"`
-	ResourceGroups              []ResourceGroup `yaml:"resource_groups"`
-	ResourceTags                []ResourceTag   `yaml:"resource_tags"`
+	ActiveDirectoryAuthorityURL     string            `yaml:"active_directory_authority_url"`
+	ResourceManagerURL              string            `yaml:"resource_manager_url"`
+	ResourceManagerHost             string            `yaml:"resource_manager_host"`
+	ResourceManagerTLSServerName    string            `yaml:"resource_manager_tls_server_name"`
+	ResourceManagerSecondaryURL     string            `yaml:"resource_manager_secondary_url"`
+	TokenAudience                   string            `yaml:"token_audience"`
+	LookupFallbackAPIVersion        string            `yaml:"lookup_fallback_api_version"`
+	Credentials                     Credentials       `yaml:"credentials"`
+	CredentialPool                  []PoolCredential  `yaml:"credential_pool"`
+	Targets                         []Target          `yaml:"targets"`
+	ResourceGroups                  []ResourceGroup   `yaml:"resource_groups"`
+	ResourceTags                    []ResourceTag     `yaml:"resource_tags"`
+	ManagementGroups                []string          `yaml:"management_groups"`
+	DeletedResourceScrapes          int               `yaml:"deleted_resource_scrapes"`
+	Include                         []string          `yaml:"include"`
+	MetricPrefix                    string            `yaml:"metric_prefix"`
+	GlobalLabelsFromIdentity        []string          `yaml:"global_labels_from_identity"`
+	SubscriptionNameRefreshInterval time.Duration     `yaml:"subscription_name_refresh_interval"`
+	APIVersionsRefreshInterval      time.Duration     `yaml:"api_versions_refresh_interval"`
+	QueryDelay                      time.Duration     `yaml:"query_delay"`
+	ClockSkewAllowance              time.Duration     `yaml:"clock_skew_allowance"`
+	MetricsDataPlane                MetricsDataPlane  `yaml:"metrics_data_plane"`
+	ManagedPrometheus               ManagedPrometheus `yaml:"managed_prometheus"`
+	AliasCounters                   bool              `yaml:"alias_counters"`
+	MetricNaming                    string            `yaml:"metric_naming"`
+	Rules                           []Rule            `yaml:"rules"`
+	MaxMetricNameLength             int               `yaml:"max_metric_name_length"`
+	GroupByNamespace                bool              `yaml:"group_by_namespace"`
+	DisableCompression              bool              `yaml:"disable_compression"`
+	ClampSuspectSamples             bool              `yaml:"clamp_suspect_samples"`
+	Budgets                         Budgets           `yaml:"budgets"`
+	Advisor                         Advisor           `yaml:"advisor"`
+	SecureScore                     SecureScore       `yaml:"secure_score"`
+	Backup                          Backup            `yaml:"backup"`
+	Policy                          Policy            `yaml:"policy"`
+	Autoscale                       Autoscale         `yaml:"autoscale"`
+	LogAnalytics                    LogAnalytics      `yaml:"log_analytics"`
+	Baselines                       Baselines         `yaml:"baselines"`
+	ScheduledEvents                 ScheduledEvents   `yaml:"scheduled_events"`
+	CredentialExpiry                CredentialExpiry  `yaml:"credential_expiry"`
+	Timeouts                        Timeouts          `yaml:"timeouts"`
+	Defaults                        Defaults          `yaml:"defaults"`
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]interface{} `yaml:",inline"`
@@ -27,52 +72,524 @@ type Config struct {
 type SafeConfig struct {
 	sync.RWMutex
 	C *Config
+	// Hash is the SHA-256 of the contents of the configuration files, in
+	// the order they were loaded.
+	Hash string
 }
 
-// ReloadConfig - allows for live reloads of the configuration file.
-func (sc *SafeConfig) ReloadConfig(confFile string) (err error) {
-	var c = &Config{
-		ActiveDirectoryAuthorityURL: "https://login.microsoftonline.com/",
-		ResourceManagerURL:          "https://management.azure.com/",
+// Get returns the running configuration. Reloads replace it rather than
+// modify it, so it can be used without the lock, which must not be held by
+// the caller.
+func (sc *SafeConfig) Get() *Config {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.C
+}
+
+// ErrHashMismatch is returned when the configuration files don't have the
+// expected hash.
+var ErrHashMismatch = errors.New("config hash mismatch")
+
+func newDefaultConfig() *Config {
+	return &Config{
+		ActiveDirectoryAuthorityURL:     "https://login.microsoftonline.com/",
+		ResourceManagerURL:              "https://management.azure.com/",
+		DeletedResourceScrapes:          5,
+		MetricNaming:                    "suffixes",
+		SubscriptionNameRefreshInterval: time.Hour,
+		APIVersionsRefreshInterval:      time.Hour,
+		QueryDelay:                      3 * time.Minute,
+		MetricsDataPlane: MetricsDataPlane{
+			URL:      "https://{region}.metrics.monitor.azure.com",
+			Audience: "https://metrics.monitor.azure.com/",
+		},
+		CredentialExpiry: CredentialExpiry{
+			GraphURL: "https://graph.microsoft.com/",
+		},
+		LogAnalytics: LogAnalytics{
+			QueryURL: "https://api.loganalytics.io/",
+		},
+		Baselines: Baselines{
+			Sensitivity: "Medium",
+		},
+		ScheduledEvents: ScheduledEvents{
+			URL:          "http://169.254.169.254/metadata/scheduledevents",
+			PollInterval: 10 * time.Second,
+		},
 	}
+}
 
-	yamlFile, err := ioutil.ReadFile(confFile)
+// Sources are the files the configuration is loaded from.
+type Sources struct {
+	Files []string
+	// The *.yml files found in Dir are loaded after Files.
+	Dir string
+	// CredentialsFile holds only the credentials, e.g. from a Kubernetes
+	// secret, when the configuration files don't define them.
+	CredentialsFile string
+	// TargetsFile is loaded after the other files and, like them, may only
+	// define targets, resource groups and resource tags, e.g. from a
+	// Kubernetes config map.
+	TargetsFile string
+}
+
+// ReloadConfig - allows for live reloads of the configuration files.
+// The *.yml files found in confDir are loaded after confFiles.
+func (sc *SafeConfig) ReloadConfig(confFiles []string, confDir string) (err error) {
+	return sc.ReloadConfigIfMatch(confFiles, confDir, "")
+}
+
+// ReloadConfigIfMatch reloads the configuration files only when their hash
+// is expectedHash, any hash is accepted when it's empty. The running
+// configuration is kept on ErrHashMismatch.
+func (sc *SafeConfig) ReloadConfigIfMatch(confFiles []string, confDir string, expectedHash string) (err error) {
+	return sc.Load(Sources{Files: confFiles, Dir: confDir}, expectedHash)
+}
+
+// Load loads the configuration from its sources only when their hash is
+// expectedHash, see ReloadConfigIfMatch.
+func (sc *SafeConfig) Load(sources Sources, expectedHash string) error {
+	return sc.LoadPrepared(sources, expectedHash, nil)
+}
+
+// LoadPrepared loads the configuration like Load, completing it with prepare
+// before it's published. The running configuration is kept when prepare
+// fails.
+func (sc *SafeConfig) LoadPrepared(sources Sources, expectedHash string, prepare func(*Config) error) error {
+	c, loadedHash, err := loadSources(sources, expectedHash)
 	if err != nil {
-		return fmt.Errorf("Error reading config file: %s", err)
+		return err
+	}
+	if prepare != nil {
+		if err := prepare(c); err != nil {
+			return err
+		}
 	}
 
-	if err := yaml.Unmarshal(yamlFile, c); err != nil {
-		return fmt.Errorf("Error parsing config file: %s", err)
+	sc.Lock()
+	sc.C = c
+	sc.Hash = loadedHash
+	sc.Unlock()
+
+	return nil
+}
+
+// loadSources loads and validates the configuration of the sources, and
+// returns it with its hash.
+func loadSources(sources Sources, expectedHash string) (*Config, string, error) {
+	files := append([]string{}, sources.Files...)
+	if sources.Dir != "" {
+		dirFiles, err := filepath.Glob(filepath.Join(sources.Dir, "*.yml"))
+		if err != nil {
+			return nil, "", fmt.Errorf("Error listing config directory: %s", err)
+		}
+		files = append(files, dirFiles...)
+	}
+	if sources.TargetsFile != "" {
+		files = append(files, sources.TargetsFile)
+	}
+	if len(files) == 0 && (!EnvConfigured() || sources.CredentialsFile != "") {
+		return nil, "", fmt.Errorf("No config file found")
+	}
+
+	l := &configLoader{visited: map[string]bool{}, hash: sha256.New()}
+	for _, f := range files {
+		if err := l.load(f, nil); err != nil {
+			return nil, "", err
+		}
+	}
+	if len(files) == 0 {
+		if err := l.loadEnv(); err != nil {
+			return nil, "", err
+		}
+	}
+	var credentials Credentials
+	if sources.CredentialsFile != "" {
+		if err := l.loadCredentials(sources.CredentialsFile, &credentials); err != nil {
+			return nil, "", err
+		}
+	}
+	loadedHash := hex.EncodeToString(l.hash.Sum(nil))
+	if expectedHash != "" && expectedHash != loadedHash {
+		return nil, "", fmt.Errorf("%w: expected %s, loaded %s", ErrHashMismatch, expectedHash, loadedHash)
+	}
+
+	c, err := mergeConfigs(l.files, l.configs)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error merging config files: %s", err)
+	}
+	if sources.CredentialsFile != "" {
+		c.Credentials = credentials
 	}
 
+	c.ApplyDefaults()
 	if err := c.Validate(); err != nil {
-		return fmt.Errorf("Error validating config file: %s", err)
+		return nil, "", fmt.Errorf("Error validating config file: %s", err)
 	}
+	return c, loadedHash, nil
+}
 
-	sc.Lock()
-	sc.C = c
-	sc.Unlock()
+// loadCredentials loads the credentials of a credentials file, which the
+// configuration files must not define.
+func (l *configLoader) loadCredentials(credentialsFile string, credentials *Credentials) error {
+	for i, c := range l.configs {
+		if !c.Credentials.isEmpty() {
+			return fmt.Errorf("credentials are defined in both %s and %s", l.files[i], credentialsFile)
+		}
+	}
 
+	data, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return fmt.Errorf("Error reading credentials file %s: %s", credentialsFile, err)
+	}
+	if err := yaml.Unmarshal(data, credentials); err != nil {
+		return fmt.Errorf("Error parsing credentials file %s: %s", credentialsFile, err)
+	}
+	l.hash.Write(data)
 	return nil
 }
 
-var validAggregations = []string{"Total", "Average", "Minimum", "Maximum"}
+func loadConfigFile(confFile string) (*Config, []byte, error) {
+	yamlFile, err := ioutil.ReadFile(confFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading config file %s: %s", confFile, err)
+	}
+
+	c, err := Parse(yamlFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error parsing config file %s: %s", confFile, err)
+	}
+	return c, yamlFile, nil
+}
+
+// Parse parses a single YAML configuration document on top of the defaults.
+// Includes are not processed.
+func Parse(yamlData []byte) (*Config, error) {
+	c := newDefaultConfig()
+	if err := yaml.Unmarshal(yamlData, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// configLoader loads configuration files along with the files they include.
+type configLoader struct {
+	files   []string
+	configs []*Config
+	visited map[string]bool
+	hash    hash.Hash
+}
+
+// load loads confFile and then its includes, which are relative to the
+// directory of confFile like targets files. chain holds the files including confFile.
+func (l *configLoader) load(confFile string, chain []string) error {
+	path, err := filepath.Abs(confFile)
+	if err != nil {
+		return fmt.Errorf("Error resolving config file %s: %s", confFile, err)
+	}
+	for _, f := range chain {
+		if f == path {
+			return fmt.Errorf("Error including config file: cycle detected: %s -> %s", strings.Join(chain, " -> "), path)
+		}
+	}
+	if l.visited[path] {
+		return nil
+	}
+	l.visited[path] = true
+
+	c, data, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	l.hash.Write(data)
+	for i, t := range c.Targets {
+		if t.TargetsFile != "" && !filepath.IsAbs(t.TargetsFile) {
+			c.Targets[i].TargetsFile = filepath.Join(filepath.Dir(path), t.TargetsFile)
+		}
+	}
+	l.files = append(l.files, path)
+	l.configs = append(l.configs, c)
+
+	chain = append(chain, path)
+	for _, pattern := range c.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("Error including config files %s: %s", pattern, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			matches = []string{pattern}
+		}
+		for _, m := range matches {
+			if err := l.load(m, chain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeConfigs merges configurations loaded from several files. Targets,
+// resource groups and resource tags are appended, while the credentials and
+// the remaining settings are taken from the single file defining credentials.
+func mergeConfigs(files []string, configs []*Config) (*Config, error) {
+	main := 0
+	found := false
+	for i, c := range configs {
+		if c.Credentials.isEmpty() {
+			continue
+		}
+		if found {
+			return nil, fmt.Errorf("credentials are defined in both %s and %s", files[main], files[i])
+		}
+		main, found = i, true
+	}
+
+	merged := *configs[main]
+	merged.Targets, merged.ResourceGroups, merged.ResourceTags = nil, nil, nil
+
+	defaults := newDefaultConfig()
+	for i, c := range configs {
+		if i != main && !settingsEqual(c, defaults) {
+			return nil, fmt.Errorf("%s may only define targets, resource_groups, resource_tags and include, other settings belong in %s", files[i], files[main])
+		}
+		merged.Targets = append(merged.Targets, c.Targets...)
+		merged.ResourceGroups = append(merged.ResourceGroups, c.ResourceGroups...)
+		merged.ResourceTags = append(merged.ResourceTags, c.ResourceTags...)
+	}
+	return &merged, nil
+}
+
+// settingsEqual reports whether both configurations have the same settings,
+// ignoring the credentials and the lists merged across files.
+func settingsEqual(a, b *Config) bool {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < va.NumField(); i++ {
+		switch va.Type().Field(i).Name {
+		case "Credentials", "Targets", "ResourceGroups", "ResourceTags", "Include", "XXX":
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			return false
+		}
+	}
+	return true
+}
+
+// ResourceManagerAudience returns the resource of the Azure Resource Manager
+// access tokens. When the requests are sent to a private endpoint, e.g. by IP
+// address, the tokens are still requested for the resource_manager_host, as
+// Azure AD only issues them for the public endpoint.
+func (c *Config) ResourceManagerAudience() string {
+	if c.TokenAudience != "" {
+		return c.TokenAudience
+	}
+	if c.ResourceManagerHost != "" {
+		u, err := url.Parse(c.ResourceManagerURL)
+		if err != nil || u.Scheme == "" {
+			return "https://" + c.ResourceManagerHost + "/"
+		}
+		return u.Scheme + "://" + c.ResourceManagerHost + "/"
+	}
+	return c.ResourceManagerURL
+}
+
+var (
+	validAggregations        = []string{"Total", "Average", "Minimum", "Maximum"}
+	validMetricPrefix        = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*$")
+	validIdentityLabels      = []string{"subscription_id", "subscription_name", "tenant_id"}
+	validLabelName           = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+	validCredentials         = []string{"client_secret", "workload_identity", "managed_identity", "cli"}
+	validDimensionTransforms = []string{"lowercase", "strip_domain", "replace"}
+	validMetricNamings       = []string{"suffixes", "labels", "aggregation_label"}
+	validDeallocatedVMs      = []string{"skip", "label"}
+	validSensitivities       = []string{"Low", "Medium", "High"}
+	validIntervals           = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour}
+)
 
 func (c *Config) Validate() (err error) {
+	if c.Credentials.ClientSecret != "" && c.Credentials.ClientSecretFile != "" {
+		return fmt.Errorf("At most one of client_secret and client_secret_file must be specified")
+	}
+
+	if c.Credentials.AuthType != "" && len(c.Credentials.Chain) > 0 {
+		return fmt.Errorf("At most one of auth_type and chain must be specified")
+	}
+
+	if c.Credentials.AuthType != "" && !contains(validCredentials, c.Credentials.AuthType) {
+		return fmt.Errorf("%s is not one of the valid credentials (%v)", c.Credentials.AuthType, validCredentials)
+	}
+
+	for _, method := range c.Credentials.Chain {
+		if !contains(validCredentials, method) {
+			return fmt.Errorf("%s is not one of the valid credentials (%v)", method, validCredentials)
+		}
+	}
+
+	if err := c.validateCredentialPool(); err != nil {
+		return err
+	}
+
+	if c.ResourceManagerSecondaryURL != "" {
+		if u, err := url.Parse(c.ResourceManagerSecondaryURL); err != nil || u.Host == "" {
+			return fmt.Errorf("resource_manager_secondary_url %q is not a valid URL", c.ResourceManagerSecondaryURL)
+		}
+	}
+
+	if c.TokenAudience != "" {
+		if u, err := url.Parse(c.TokenAudience); err != nil || u.Host == "" {
+			return fmt.Errorf("token_audience %q is not a valid URL", c.TokenAudience)
+		}
+	}
+
+	if c.MetricPrefix != "" && !validMetricPrefix.MatchString(c.MetricPrefix) {
+		return fmt.Errorf("metric_prefix %q is not a valid metric name prefix", c.MetricPrefix)
+	}
+
+	if !contains(validMetricNamings, c.MetricNaming) {
+		return fmt.Errorf("%s is not one of the valid metric namings (%v)", c.MetricNaming, validMetricNamings)
+	}
+
+	if c.QueryDelay < 0 || c.ClockSkewAllowance < 0 {
+		return fmt.Errorf("query_delay and clock_skew_allowance can't be negative")
+	}
+
+	if c.MaxMetricNameLength != 0 && c.MaxMetricNameLength < 32 {
+		return fmt.Errorf("max_metric_name_length must be 0 or at least 32")
+	}
+
+	if (c.MetricNaming == "labels" || c.MetricNaming == "aggregation_label") && c.AliasCounters {
+		return fmt.Errorf("alias_counters can't be used with the %s metric_naming", c.MetricNaming)
+	}
+
+	if err := c.validateRules(); err != nil {
+		return err
+	}
+
+	if err := c.validateBlockNames(); err != nil {
+		return err
+	}
+
+	for _, g := range c.ManagementGroups {
+		if g == "" || strings.ContainsAny(g, "/?#") {
+			return fmt.Errorf("management group %q is not a valid management group ID", g)
+		}
+	}
+
+	if err := validateIdentityLabels(c.GlobalLabelsFromIdentity, c.ManagementGroups); err != nil {
+		return err
+	}
+
+	if c.MetricsDataPlane.Enabled && c.MetricsDataPlane.URL == "" {
+		return fmt.Errorf("metrics_data_plane needs a url when enabled")
+	}
+
+	if c.Baselines.Enabled && !contains(validSensitivities, c.Baselines.Sensitivity) {
+		return fmt.Errorf("%s is not one of the valid baseline sensitivities (%v)", c.Baselines.Sensitivity, validSensitivities)
+	}
+
+	if c.ScheduledEvents.PollInterval <= 0 {
+		return fmt.Errorf("scheduled_events poll_interval must be positive")
+	}
+
+	if c.ScheduledEvents.Enabled && c.ScheduledEvents.URL == "" {
+		return fmt.Errorf("scheduled_events needs a url when enabled")
+	}
+
+	if c.LogAnalytics.TableUsage && c.LogAnalytics.QueryURL == "" {
+		return fmt.Errorf("log_analytics needs a query_url to query the table usage")
+	}
+
+	if c.CredentialExpiry.Graph && c.Credentials.ClientID == "" {
+		return fmt.Errorf("credential_expiry needs a client_id to read the credentials from Microsoft Graph")
+	}
+
+	if c.Timeouts.Token < 0 || c.Timeouts.Listing < 0 || c.Timeouts.Lookup < 0 || c.Timeouts.Metrics < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+
+	if c.DeletedResourceScrapes < 0 {
+		return fmt.Errorf("deleted_resource_scrapes must not be negative")
+	}
+
+	for _, i := range c.ManagedPrometheus.Ingested {
+		if len(i.ResourceTypes) == 0 {
+			return fmt.Errorf("At least one resource type needs to be specified in each managed_prometheus ingested entry")
+		}
+	}
+
 	for _, t := range c.Targets {
 		if err := c.validateAggregations(t.Aggregations); err != nil {
 			return err
 		}
 
-		if len(t.Resource) == 0 {
+		if err := c.validateDimensions(t.Dimensions); err != nil {
+			return err
+		}
+
+		if err := c.validateJoins(t.Join); err != nil {
+			return err
+		}
+
+		if err := c.validatePropertyMetrics(t.PropertyMetrics); err != nil {
+			return err
+		}
+
+		if err := c.validateAliases(t.MetricNamespace, t.Metrics, t.Aggregations, t.Dimensions); err != nil {
+			return err
+		}
+
+		if err := c.validatePercentiles(t.Metrics); err != nil {
+			return err
+		}
+
+		if err := c.validateQueryParameters(t.Metrics); err != nil {
+			return err
+		}
+
+		if err := c.validateClamps(t.Metrics); err != nil {
+			return err
+		}
+
+		if _, ok := Presets[t.Preset]; t.Preset != "" && !ok {
+			return fmt.Errorf("%s is not one of the valid presets (%v)", t.Preset, presetNames())
+		}
+
+		if t.DeallocatedVMs != "" && !contains(validDeallocatedVMs, t.DeallocatedVMs) {
+			return fmt.Errorf("%s is not one of the valid deallocated_vms (%v)", t.DeallocatedVMs, validDeallocatedVMs)
+		}
+
+		if t.MaxDatapointAge < 0 {
+			return fmt.Errorf("max_datapoint_age must not be negative")
+		}
+
+		if err := validateWindow(t.Interval, t.Timespan); err != nil {
+			return err
+		}
+		if err := validateTimegrains(t.Timegrains, t.Interval, t.Labels); err != nil {
+			return err
+		}
+
+		if len(t.Resource) == 0 && len(t.TargetsFile) == 0 {
 			return fmt.Errorf("name needs to be specified in each resource")
 		}
 
-		if !strings.HasPrefix(t.Resource, "/") {
+		if len(t.Resource) != 0 && len(t.TargetsFile) != 0 {
+			return fmt.Errorf("At most one of resource and targets_file must be specified in each resource")
+		}
+
+		if len(t.Resource) != 0 && !strings.HasPrefix(t.Resource, "/") {
 			return fmt.Errorf("Resource path %q must start with a /", t.Resource)
 		}
 
-		if len(t.Metrics) == 0 {
+		if err := validateLabelNames(t.Labels); err != nil {
+			return err
+		}
+
+		// The metrics of the presets may only be given by resource type.
+		if len(t.Metrics) == 0 && len(Presets[t.Preset].TypeMetrics) == 0 {
 			return fmt.Errorf("At least one metric needs to be specified in each resource")
 		}
 	}
@@ -82,6 +599,65 @@ func (c *Config) Validate() (err error) {
 			return err
 		}
 
+		if err := c.validateDimensions(t.Dimensions); err != nil {
+			return err
+		}
+
+		if err := c.validateJoins(t.Join); err != nil {
+			return err
+		}
+
+		if err := c.validatePropertyMetrics(t.PropertyMetrics); err != nil {
+			return err
+		}
+
+		if err := c.validateAliases(t.MetricNamespace, t.Metrics, t.Aggregations, t.Dimensions); err != nil {
+			return err
+		}
+
+		if err := c.validatePercentiles(t.Metrics); err != nil {
+			return err
+		}
+
+		if err := c.validateQueryParameters(t.Metrics); err != nil {
+			return err
+		}
+
+		if err := c.validateClamps(t.Metrics); err != nil {
+			return err
+		}
+
+		if _, ok := Presets[t.Preset]; t.Preset != "" && !ok {
+			return fmt.Errorf("%s is not one of the valid presets (%v)", t.Preset, presetNames())
+		}
+
+		if t.MaxInFlight < 0 || t.RequestsPerSecond < 0 {
+			return fmt.Errorf("max_in_flight and requests_per_second must not be negative")
+		}
+
+		if t.DeallocatedVMs != "" && !contains(validDeallocatedVMs, t.DeallocatedVMs) {
+			return fmt.Errorf("%s is not one of the valid deallocated_vms (%v)", t.DeallocatedVMs, validDeallocatedVMs)
+		}
+
+		if t.MaxDatapointAge < 0 {
+			return fmt.Errorf("max_datapoint_age must not be negative")
+		}
+
+		if t.SuspendEmptyAfter < 0 || t.SuspendEmptyFor < 0 {
+			return fmt.Errorf("suspend_empty_after and suspend_empty_for must not be negative")
+		}
+
+		if err := validateWindow(t.Interval, t.Timespan); err != nil {
+			return err
+		}
+		if err := validateTimegrains(t.Timegrains, t.Interval, t.Labels); err != nil {
+			return err
+		}
+
+		if err := validateLabelNames(t.Labels); err != nil {
+			return err
+		}
+
 		if len(t.ResourceGroup) == 0 {
 			return fmt.Errorf("resource_group needs to be specified in each resource group")
 		}
@@ -90,7 +666,7 @@ func (c *Config) Validate() (err error) {
 			return fmt.Errorf("At lease one resource type needs to be specified in each resource group")
 		}
 
-		if len(t.Metrics) == 0 {
+		if len(t.Metrics) == 0 && len(Presets[t.Preset].TypeMetrics) == 0 {
 			return fmt.Errorf("At least one metric needs to be specified in each resource group")
 		}
 	}
@@ -100,6 +676,65 @@ func (c *Config) Validate() (err error) {
 			return err
 		}
 
+		if err := c.validateDimensions(t.Dimensions); err != nil {
+			return err
+		}
+
+		if err := c.validateJoins(t.Join); err != nil {
+			return err
+		}
+
+		if err := c.validatePropertyMetrics(t.PropertyMetrics); err != nil {
+			return err
+		}
+
+		if err := c.validateAliases(t.MetricNamespace, t.Metrics, t.Aggregations, t.Dimensions); err != nil {
+			return err
+		}
+
+		if err := c.validatePercentiles(t.Metrics); err != nil {
+			return err
+		}
+
+		if err := c.validateQueryParameters(t.Metrics); err != nil {
+			return err
+		}
+
+		if err := c.validateClamps(t.Metrics); err != nil {
+			return err
+		}
+
+		if _, ok := Presets[t.Preset]; t.Preset != "" && !ok {
+			return fmt.Errorf("%s is not one of the valid presets (%v)", t.Preset, presetNames())
+		}
+
+		if t.MaxInFlight < 0 || t.RequestsPerSecond < 0 {
+			return fmt.Errorf("max_in_flight and requests_per_second must not be negative")
+		}
+
+		if t.DeallocatedVMs != "" && !contains(validDeallocatedVMs, t.DeallocatedVMs) {
+			return fmt.Errorf("%s is not one of the valid deallocated_vms (%v)", t.DeallocatedVMs, validDeallocatedVMs)
+		}
+
+		if t.MaxDatapointAge < 0 {
+			return fmt.Errorf("max_datapoint_age must not be negative")
+		}
+
+		if t.SuspendEmptyAfter < 0 || t.SuspendEmptyFor < 0 {
+			return fmt.Errorf("suspend_empty_after and suspend_empty_for must not be negative")
+		}
+
+		if err := validateWindow(t.Interval, t.Timespan); err != nil {
+			return err
+		}
+		if err := validateTimegrains(t.Timegrains, t.Interval, t.Labels); err != nil {
+			return err
+		}
+
+		if err := validateLabelNames(t.Labels); err != nil {
+			return err
+		}
+
 		if len(t.ResourceTagName) == 0 {
 			return fmt.Errorf("resource_tag_name needs to be specified in each resource tag")
 		}
@@ -108,79 +743,446 @@ func (c *Config) Validate() (err error) {
 			return fmt.Errorf("resource_tag_value needs to be specified in each resource tag")
 		}
 
-		if len(t.Metrics) == 0 {
+		if len(t.Metrics) == 0 && len(Presets[t.Preset].TypeMetrics) == 0 {
 			return fmt.Errorf("At least one metric needs to be specified in each resource tag")
 		}
 	}
 
-	return nil
+	return nil
+}
+
+// validateWindow validates the interval and the timespan of the metrics of a
+// block.
+func validateWindow(interval time.Duration, timespan time.Duration) error {
+	if interval != 0 {
+		valid := false
+		for _, i := range validIntervals {
+			valid = valid || interval == i
+		}
+		if !valid {
+			return fmt.Errorf("%v is not one of the valid intervals (%v)", interval, validIntervals)
+		}
+	}
+	if timespan < 0 {
+		return fmt.Errorf("timespan must not be negative")
+	}
+	if timespan != 0 && timespan < interval {
+		return fmt.Errorf("timespan %v must not be shorter than interval %v", timespan, interval)
+	}
+	return nil
+}
+
+func validateLabelNames(labels map[string]string) error {
+	for name := range labels {
+		if !validLabelName.MatchString(name) {
+			return fmt.Errorf("%q is not a valid label name", name)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateAggregations(aggregations []string) error {
+	for _, a := range aggregations {
+		if !contains(validAggregations, a) {
+			return fmt.Errorf("%s is not one of the valid aggregations (%v)", a, validAggregations)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateDimensions(dimensions []Dimension) error {
+	for _, d := range dimensions {
+		if len(d.Name) == 0 {
+			return fmt.Errorf("name needs to be specified in each dimension")
+		}
+		for _, t := range d.Transforms {
+			if !contains(validDimensionTransforms, t.Type) {
+				return fmt.Errorf("%s is not one of the valid dimension transforms (%v)", t.Type, validDimensionTransforms)
+			}
+			if t.Type == "replace" && t.Regex.Regexp == nil {
+				return fmt.Errorf("regex needs to be specified in each replace transform of dimension %s", d.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateJoins(joins []Join) error {
+	for _, j := range joins {
+		if len(j.Property) == 0 {
+			return fmt.Errorf("property needs to be specified in each join")
+		}
+		if !validLabelName.MatchString(j.Label) {
+			return fmt.Errorf("%q is not a valid label name for join of %s", j.Label, j.Property)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validatePropertyMetrics(metrics []PropertyMetric) error {
+	for _, m := range metrics {
+		path := strings.Split(m.Property, ".")
+		if len(path) < 2 || !(strings.EqualFold(path[0], "properties") || strings.EqualFold(path[0], "sku")) {
+			return fmt.Errorf("property %q of property metric %s must be below properties or sku", m.Property, m.Name)
+		}
+		if !validMetricPrefix.MatchString(m.Name) {
+			return fmt.Errorf("%q is not a valid metric name for property %s", m.Name, m.Property)
+		}
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Credentials - Azure credentials
+type Credentials struct {
+	SubscriptionID   string   `yaml:"subscription_id"`
+	ClientID         string   `yaml:"client_id"`
+	ClientSecret     string   `yaml:"client_secret"`
+	ClientSecretFile string   `yaml:"client_secret_file"`
+	TenantID         string   `yaml:"tenant_id"`
+	AuthType         string   `yaml:"auth_type"`
+	Chain            []string `yaml:"chain"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+func (c Credentials) isEmpty() bool {
+	return c.SubscriptionID == "" && c.ClientID == "" && c.ClientSecret == "" && c.ClientSecretFile == "" && c.TenantID == "" && c.AuthType == "" && len(c.Chain) == 0
+}
+
+// MetricsDataPlane configures the Azure Monitor metrics data plane, whose
+// batch API queries the metrics of many resources of the same type at once.
+type MetricsDataPlane struct {
+	Enabled               bool     `yaml:"enabled"`
+	URL                   string   `yaml:"url"`
+	Audience              string   `yaml:"audience"`
+	FallbackResourceTypes []string `yaml:"fallback_resource_types"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Budgets configures the collection of the Cost Management budgets of the
+// subscription.
+type Budgets struct {
+	Enabled bool `yaml:"enabled"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Advisor configures the collection of the Azure Advisor recommendations of
+// the subscription.
+type Advisor struct {
+	Enabled bool `yaml:"enabled"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// SecureScore configures the collection of the Defender for Cloud secure
+// scores of the subscription.
+type SecureScore struct {
+	Enabled bool `yaml:"enabled"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Backup configures the collection of the backup jobs and protected items of
+// the Recovery Services vaults of the subscription.
+type Backup struct {
+	Enabled bool `yaml:"enabled"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Autoscale configures the collection of the autoscale settings of the
+// subscription and of their metrics.
+type Autoscale struct {
+	Enabled bool `yaml:"enabled"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// LogAnalytics configures the collection of the data ingestion and daily
+// caps of the Log Analytics workspaces of the subscription. TableUsage
+// queries the billable volume of each table from the query API at QueryURL.
+type LogAnalytics struct {
+	Enabled    bool   `yaml:"enabled"`
+	TableUsage bool   `yaml:"table_usage"`
+	QueryURL   string `yaml:"query_url"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Baselines configures the collection of the Azure Monitor dynamic
+// thresholds (baselines) of the metrics of the collected resources, with
+// the Low, Medium or High Sensitivity.
+type Baselines struct {
+	Enabled     bool   `yaml:"enabled"`
+	Sensitivity string `yaml:"sensitivity"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// ScheduledEvents configures the polling of the scheduled events of the
+// virtual machine running the exporter, e.g. the evictions of Spot virtual
+// machines, from the Azure Instance Metadata Service.
+type ScheduledEvents struct {
+	Enabled      bool          `yaml:"enabled"`
+	URL          string        `yaml:"url"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Policy configures the collection of the Azure Policy compliance of the
+// subscription.
+type Policy struct {
+	Enabled bool `yaml:"enabled"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// CredentialExpiry configures the exposure of the expiry of the credentials
+// of the exporter, either configured or read from Microsoft Graph.
+type CredentialExpiry struct {
+	ExpiresAt time.Time `yaml:"expires_at"`
+	Graph     bool      `yaml:"graph"`
+	GraphURL  string    `yaml:"graph_url"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Timeouts configures the timeouts of the requests by Azure endpoint class,
+// the exporter flags are used for the ones that aren't set.
+type Timeouts struct {
+	Token   time.Duration `yaml:"token"`
+	Listing time.Duration `yaml:"listing"`
+	Lookup  time.Duration `yaml:"lookup"`
+	Metrics time.Duration `yaml:"metrics"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Defaults are the settings inherited by the targets, resource groups and
+// resource tags which don't set them.
+type Defaults struct {
+	Aggregations []string          `yaml:"aggregations"`
+	Interval     time.Duration     `yaml:"interval"`
+	Timespan     time.Duration     `yaml:"timespan"`
+	Dimensions   []Dimension       `yaml:"dimensions"`
+	Labels       map[string]string `yaml:"labels"`
+
+	XXX map[string]interface{} `yaml:",inline"`
 }
 
-func (c *Config) validateAggregations(aggregations []string) error {
-	for _, a := range aggregations {
-		ok := false
-		for _, valid := range validAggregations {
-			if a == valid {
-				ok = true
-				break
-			}
-		}
-		if !ok {
-			return fmt.Errorf("%s is not one of the valid aggregations (%v)", a, validAggregations)
-		}
-	}
+// ManagedPrometheus lists the metrics already ingested by Azure Managed
+// Prometheus, which the exporter doesn't collect.
+type ManagedPrometheus struct {
+	Ingested []IngestedMetrics `yaml:"ingested"`
 
-	return nil
+	XXX map[string]interface{} `yaml:",inline"`
 }
 
-// Credentials - Azure credentials
-type Credentials struct {
-	SubscriptionID string `yaml:"subscription_id"`
-	ClientID       string `yaml:"client_id"`
-	ClientSecret   string `yaml:"client_secret"`
-	TenantID       string `yaml:"tenant_id"`
+// IngestedMetrics selects the metric namespaces of resource types ingested by
+// Azure Managed Prometheus. All the metric namespaces are selected when none
+// is given.
+type IngestedMetrics struct {
+	ResourceTypes    []string `yaml:"resource_types"`
+	MetricNamespaces []string `yaml:"metric_namespaces"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
 
+// Ingests reports whether the metrics of the metric namespace of a resource
+// type are ingested by Azure Managed Prometheus.
+func (m ManagedPrometheus) Ingests(resourceType string, metricNamespace string) bool {
+	for _, i := range m.Ingested {
+		if !containsFold(i.ResourceTypes, resourceType) {
+			continue
+		}
+		if len(i.MetricNamespaces) == 0 || containsFold(i.MetricNamespaces, metricNamespace) {
+			return true
+		}
+	}
+	return false
+}
+
 // Target represents Azure target resource and its associated metric definitions
 type Target struct {
-	Resource        string   `yaml:"resource"`
-	MetricNamespace string   `yaml:"metric_namespace"`
-	Metrics         []Metric `yaml:"metrics"`
-	Aggregations    []string `yaml:"aggregations"`
+	Name               string            `yaml:"name"`
+	Resource           string            `yaml:"resource"`
+	MetricNamespace    string            `yaml:"metric_namespace"`
+	Metrics            []Metric          `yaml:"metrics"`
+	Aggregations       []string          `yaml:"aggregations"`
+	ResourceInfo       ResourceInfo      `yaml:"resource_info"`
+	SkipResourceLookup bool              `yaml:"skip_resource_lookup"`
+	TargetsFile        string            `yaml:"targets_file"`
+	Labels             map[string]string `yaml:"labels"`
+	Dimensions         []Dimension       `yaml:"dimensions"`
+	Join               []Join            `yaml:"join"`
+	PropertyMetrics    []PropertyMetric  `yaml:"property_metrics"`
+	EmitAbsentAsZero   bool              `yaml:"emit_absent_as_zero"`
+	MaxDatapointAge    time.Duration     `yaml:"max_datapoint_age"`
+	DeallocatedVMs     string            `yaml:"deallocated_vms"`
+	Interval           time.Duration     `yaml:"interval"`
+	Timegrains         []time.Duration   `yaml:"timegrains"`
+	Timespan           time.Duration     `yaml:"timespan"`
+	Preset             string            `yaml:"preset"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
 
 // ResourceGroup represents Azure target resource group and its associated metric definitions
 type ResourceGroup struct {
-	ResourceGroup         string   `yaml:"resource_group"`
-	MetricNamespace       string   `yaml:"metric_namespace"`
-	ResourceTypes         []string `yaml:"resource_types"`
-	ResourceNameIncludeRe []Regexp `yaml:"resource_name_include_re"`
-	ResourceNameExcludeRe []Regexp `yaml:"resource_name_exclude_re"`
-	Metrics               []Metric `yaml:"metrics"`
-	Aggregations          []string `yaml:"aggregations"`
+	Name                  string            `yaml:"name"`
+	ResourceGroup         string            `yaml:"resource_group"`
+	MetricNamespace       string            `yaml:"metric_namespace"`
+	ResourceTypes         []string          `yaml:"resource_types"`
+	ResourceNameIncludeRe []Regexp          `yaml:"resource_name_include_re"`
+	ResourceNameExcludeRe []Regexp          `yaml:"resource_name_exclude_re"`
+	Metrics               []Metric          `yaml:"metrics"`
+	Aggregations          []string          `yaml:"aggregations"`
+	ResourceInfo          ResourceInfo      `yaml:"resource_info"`
+	Dimensions            []Dimension       `yaml:"dimensions"`
+	Join                  []Join            `yaml:"join"`
+	PropertyMetrics       []PropertyMetric  `yaml:"property_metrics"`
+	EmitAbsentAsZero      bool              `yaml:"emit_absent_as_zero"`
+	MaxInFlight           int               `yaml:"max_in_flight"`
+	RequestsPerSecond     float64           `yaml:"requests_per_second"`
+	MaxDatapointAge       time.Duration     `yaml:"max_datapoint_age"`
+	DeallocatedVMs        string            `yaml:"deallocated_vms"`
+	Interval              time.Duration     `yaml:"interval"`
+	Timegrains            []time.Duration   `yaml:"timegrains"`
+	Timespan              time.Duration     `yaml:"timespan"`
+	Labels                map[string]string `yaml:"labels"`
+	Preset                string            `yaml:"preset"`
+	SuspendEmptyAfter     int               `yaml:"suspend_empty_after"`
+	SuspendEmptyFor       time.Duration     `yaml:"suspend_empty_for"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
 
 // ResourceTag selects resources with tag name and tag value
 type ResourceTag struct {
-	ResourceTagName  string   `yaml:"resource_tag_name"`
-	ResourceTagValue string   `yaml:"resource_tag_value"`
-	MetricNamespace  string   `yaml:"metric_namespace"`
-	ResourceTypes    []string `yaml:"resource_types"`
-	Metrics          []Metric `yaml:"metrics"`
-	Aggregations     []string `yaml:"aggregations"`
+	Name              string            `yaml:"name"`
+	ResourceTagName   string            `yaml:"resource_tag_name"`
+	ResourceTagValue  string            `yaml:"resource_tag_value"`
+	MetricNamespace   string            `yaml:"metric_namespace"`
+	ResourceTypes     []string          `yaml:"resource_types"`
+	Metrics           []Metric          `yaml:"metrics"`
+	Aggregations      []string          `yaml:"aggregations"`
+	ResourceInfo      ResourceInfo      `yaml:"resource_info"`
+	Dimensions        []Dimension       `yaml:"dimensions"`
+	Join              []Join            `yaml:"join"`
+	PropertyMetrics   []PropertyMetric  `yaml:"property_metrics"`
+	EmitAbsentAsZero  bool              `yaml:"emit_absent_as_zero"`
+	MaxInFlight       int               `yaml:"max_in_flight"`
+	RequestsPerSecond float64           `yaml:"requests_per_second"`
+	MaxDatapointAge   time.Duration     `yaml:"max_datapoint_age"`
+	DeallocatedVMs    string            `yaml:"deallocated_vms"`
+	Interval          time.Duration     `yaml:"interval"`
+	Timegrains        []time.Duration   `yaml:"timegrains"`
+	Timespan          time.Duration     `yaml:"timespan"`
+	Labels            map[string]string `yaml:"labels"`
+	Preset            string            `yaml:"preset"`
+	SuspendEmptyAfter int               `yaml:"suspend_empty_after"`
+	SuspendEmptyFor   time.Duration     `yaml:"suspend_empty_for"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// ResourceInfo configures the azure_resource_info series of the resources
+// of a block. Labels restricts its label set when not empty.
+type ResourceInfo struct {
+	Skip   bool     `yaml:"skip"`
+	Labels []string `yaml:"labels"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Join adds the name of a related resource, referenced by a property of the
+// resource such as managedBy or properties.serverFarmId, as a label.
+type Join struct {
+	Property string `yaml:"property"`
+	Label    string `yaml:"label"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// PropertyMetric exports a numeric property of the looked up resources as a
+// gauge, e.g. properties.diskSizeGB or sku.capacity.
+type PropertyMetric struct {
+	Property string `yaml:"property"`
+	Name     string `yaml:"name"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Dimension splits the metrics of a block by the values of a dimension,
+// which are exposed as a label after the transforms are applied in order.
+type Dimension struct {
+	Name       string               `yaml:"name"`
+	Transforms []DimensionTransform `yaml:"transforms"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// DimensionTransform transforms dimension values. Type is lowercase,
+// strip_domain, which removes everything after the first dot, or replace,
+// which replaces the matches of Regex with Replacement.
+type DimensionTransform struct {
+	Type        string `yaml:"type"`
+	Regex       Regexp `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
 
+// Normalize applies the transforms of the dimension to a value.
+func (d Dimension) Normalize(value string) string {
+	for _, t := range d.Transforms {
+		switch t.Type {
+		case "lowercase":
+			value = strings.ToLower(value)
+		case "strip_domain":
+			if i := strings.Index(value, "."); i > 0 {
+				value = value[:i]
+			}
+		case "replace":
+			value = t.Regex.ReplaceAllString(value, t.Replacement)
+		}
+	}
+	return value
+}
+
 // Metric defines metric name
 type Metric struct {
-	Name string `yaml:"name"`
+	Name        string       `yaml:"name"`
+	Percentiles *Percentiles `yaml:"percentiles,omitempty"`
+	// QueryParameters are passed verbatim to the metrics API, e.g.
+	// validatedimensions or rollupby.
+	QueryParameters map[string]string `yaml:"query_parameters,omitempty"`
+	Clamp           *Clamp            `yaml:"clamp,omitempty"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
@@ -190,13 +1192,25 @@ type Regexp struct {
 	*regexp.Regexp
 }
 
+// UnknownFieldsError is returned when a configuration section holds fields
+// that are not part of the configuration format.
+type UnknownFieldsError struct {
+	Context string
+	Fields  []string
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return fmt.Sprintf("unknown fields in %s: %s", e.Context, strings.Join(e.Fields, ", "))
+}
+
 func checkOverflow(m map[string]interface{}, ctx string) error {
 	if len(m) > 0 {
 		var keys []string
 		for k := range m {
 			keys = append(keys, k)
 		}
-		return fmt.Errorf("unknown fields in %s: %s", ctx, strings.Join(keys, ", "))
+		sort.Strings(keys)
+		return &UnknownFieldsError{Context: ctx, Fields: keys}
 	}
 	return nil
 }
@@ -225,6 +1239,246 @@ func (s *Credentials) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Budgets) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Budgets
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Advisor) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Advisor
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *SecureScore) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain SecureScore
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Backup) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Backup
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Autoscale) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Autoscale
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *LogAnalytics) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain LogAnalytics
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Baselines) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Baselines
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *ScheduledEvents) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ScheduledEvents
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Policy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Policy
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *CredentialExpiry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain CredentialExpiry
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Timeouts) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Timeouts
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Defaults) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Defaults
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *MetricsDataPlane) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain MetricsDataPlane
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *ManagedPrometheus) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ManagedPrometheus
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *IngestedMetrics) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain IngestedMetrics
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Join) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Join
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *PropertyMetric) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain PropertyMetric
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Dimension) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Dimension
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *DimensionTransform) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain DimensionTransform
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *ResourceInfo) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ResourceInfo
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
 func (s *Metric) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type plain Metric
@@ -274,3 +1528,19 @@ func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	re.Regexp = regex
 	return nil
 }
+
+// validateIdentityLabels checks global_labels_from_identity. The subscription
+// of the credentials doesn't identify the resources discovered in the
+// subscriptions of management groups, whose series are labelled with their
+// own subscription instead.
+func validateIdentityLabels(labels []string, managementGroups []string) error {
+	for _, l := range labels {
+		if !contains(validIdentityLabels, l) {
+			return fmt.Errorf("%s is not one of the valid identity labels (%v)", l, validIdentityLabels)
+		}
+		if len(managementGroups) > 0 && (l == "subscription_id" || l == "subscription_name") {
+			return fmt.Errorf("identity label %s can't be used with management_groups, the series are labelled with azure_subscription instead", l)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,718 @@
+package config
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestMergeConfigs(t *testing.T) {
+	files := []string{"targets.yml", "azure.yml"}
+
+	targets := newDefaultConfig()
+	targets.Targets = []Target{{Resource: "/a"}}
+	main := newDefaultConfig()
+	main.Credentials.SubscriptionID = "abc"
+	main.Targets = []Target{{Resource: "/b"}}
+	main.ResourceGroups = []ResourceGroup{{ResourceGroup: "rg"}}
+
+	merged, err := mergeConfigs(files, []*Config{targets, main})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if merged.Credentials.SubscriptionID != "abc" {
+		t.Errorf("credentials not taken from %s", files[1])
+	}
+	if len(merged.Targets) != 2 || merged.Targets[0].Resource != "/a" || merged.Targets[1].Resource != "/b" {
+		t.Errorf("targets not merged in file order: %v", merged.Targets)
+	}
+	if len(merged.ResourceGroups) != 1 {
+		t.Errorf("resource groups not merged: %v", merged.ResourceGroups)
+	}
+
+	targets.ResourceManagerURL = "https://management.example.com/"
+	if _, err := mergeConfigs(files, []*Config{targets, main}); err == nil {
+		t.Errorf("expected an error for settings defined outside of %s", files[1])
+	}
+
+	targets = newDefaultConfig()
+	targets.Credentials.SubscriptionID = "def"
+	if _, err := mergeConfigs(files, []*Config{targets, main}); err == nil {
+		t.Errorf("expected an error for credentials defined twice")
+	}
+}
+
+func TestReloadConfigInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azure_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"azure.yml":      "credentials:\n  subscription_id: abc\ninclude: [teams/*.yml]\n",
+		"teams/a.yml":    "targets:\n  - resource: /a\n    metrics: [{name: m}]\n",
+		"teams/b.yml":    "targets:\n  - resource: /b\n    metrics: [{name: m}]\ninclude: [a.yml]\n",
+		"cycle.yml":      "include: [loop/cycle.yml]\n",
+		"loop/cycle.yml": "include: [../cycle.yml]\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sc := &SafeConfig{}
+	if err := sc.ReloadConfig([]string{filepath.Join(dir, "azure.yml")}, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sc.C.Targets) != 2 {
+		t.Errorf("expected each included file to be loaded once, got targets: %v", sc.C.Targets)
+	}
+
+	err = sc.ReloadConfig([]string{filepath.Join(dir, "cycle.yml")}, "")
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected an include cycle error, got: %v", err)
+	}
+}
+
+func TestLoadCredentialsAndTargetsFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azure_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"azure.yml":       "metric_prefix: azure_\n",
+		"credentials.yml": "subscription_id: abc\nclient_id: client\nclient_secret: secret\n",
+		"targets.yml":     "targets:\n  - resource: /a\n    metrics: [{name: m}]\n",
+		"main.yml":        "credentials:\n  subscription_id: def\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sc := &SafeConfig{}
+	sources := Sources{
+		Files:           []string{filepath.Join(dir, "azure.yml")},
+		CredentialsFile: filepath.Join(dir, "credentials.yml"),
+		TargetsFile:     filepath.Join(dir, "targets.yml"),
+	}
+	if err := sc.Load(sources, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Credentials{SubscriptionID: "abc", ClientID: "client", ClientSecret: "secret"}
+	if !reflect.DeepEqual(sc.C.Credentials, want) {
+		t.Errorf("doesn't load the credentials file\ngot: %+v\nwant: %+v", sc.C.Credentials, want)
+	}
+	if sc.C.MetricPrefix != "azure_" || len(sc.C.Targets) != 1 {
+		t.Errorf("doesn't merge the config and targets files, got: %+v", sc.C)
+	}
+
+	sources.Files = []string{filepath.Join(dir, "main.yml")}
+	if err := sc.Load(sources, ""); err == nil || !strings.Contains(err.Error(), "credentials are defined in both") {
+		t.Errorf("expected an error for credentials defined twice, got: %v", err)
+	}
+}
+
+func TestReloadConfigEnv(t *testing.T) {
+	env := map[string]string{
+		"AZURE_SUBSCRIPTION_ID": "abc",
+		"AZURE_CLIENT_ID":       "client",
+		"AZURE_CLIENT_SECRET":   "secret",
+		"AZURE_TARGETS_JSON":    `[{"resource": "/a", "metrics": [{"name": "m"}], "aggregations": ["Total"]}]`,
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	sc := &SafeConfig{}
+	if err := sc.ReloadConfig(nil, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Credentials{SubscriptionID: "abc", ClientID: "client", ClientSecret: "secret"}
+	if !reflect.DeepEqual(sc.C.Credentials, want) {
+		t.Errorf("doesn't configure the credentials\ngot: %+v\nwant: %+v", sc.C.Credentials, want)
+	}
+	if len(sc.C.Targets) != 1 || sc.C.Targets[0].Resource != "/a" {
+		t.Errorf("doesn't configure the targets, got: %+v", sc.C.Targets)
+	}
+
+	os.Setenv("AZURE_TARGETS_JSON", `[{"resource": "/a", "unknown": true}]`)
+	if err := sc.ReloadConfig(nil, ""); err == nil {
+		t.Errorf("expected an error for an invalid target")
+	}
+}
+
+func TestManagedPrometheusIngests(t *testing.T) {
+	m := ManagedPrometheus{Ingested: []IngestedMetrics{
+		{ResourceTypes: []string{"Microsoft.ContainerService/managedClusters"}},
+		{ResourceTypes: []string{"Microsoft.Compute/virtualMachines"}, MetricNamespaces: []string{"Microsoft.Compute/virtualMachines"}},
+	}}
+
+	tests := []struct {
+		resourceType    string
+		metricNamespace string
+		want            bool
+	}{
+		{"microsoft.containerservice/managedclusters", "insights.container/nodes", true},
+		{"Microsoft.Compute/virtualMachines", "Microsoft.Compute/virtualMachines", true},
+		{"Microsoft.Compute/virtualMachines", "Azure.VM.Windows.GuestMetrics", false},
+		{"Microsoft.Web/sites", "Microsoft.Web/sites", false},
+	}
+	for _, test := range tests {
+		if got := m.Ingests(test.resourceType, test.metricNamespace); got != test.want {
+			t.Errorf("doesn't match %s %s\ngot: %v\nwant: %v", test.resourceType, test.metricNamespace, got, test.want)
+		}
+	}
+}
+
+func TestDimensionNormalize(t *testing.T) {
+	d := Dimension{Name: "Instance", Transforms: []DimensionTransform{
+		{Type: "strip_domain"},
+		{Type: "lowercase"},
+		{Type: "replace", Regex: Regexp{regexp.MustCompile("^rd([0-9a-f]+)$")}, Replacement: "instance-$1"},
+	}}
+
+	tests := map[string]string{
+		"RD0003FF5D1C2A":              "instance-0003ff5d1c2a",
+		"Web01.internal.cloudapp.net": "web01",
+		"RD0003FF5D1C2A.cloudapp.net": "instance-0003ff5d1c2a",
+		".hidden":                     ".hidden",
+	}
+	for value, want := range tests {
+		if got := d.Normalize(value); got != want {
+			t.Errorf("doesn't normalize %s\ngot: %v\nwant: %v", value, got, want)
+		}
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	c, err := Parse([]byte(`
+defaults:
+  aggregations: [Average]
+  interval: 5m
+  timespan: 15m
+  dimensions: [{name: Instance}]
+  labels: {env: prod, team: web}
+targets:
+  - resource: /resourceGroups/rg/providers/Microsoft.Web/sites/app1
+    metrics: [{name: Requests}]
+  - resource: /resourceGroups/rg/providers/Microsoft.Web/sites/app2
+    metrics: [{name: Requests}]
+    aggregations: [Total]
+    dimensions: []
+    labels: {team: api}
+resource_groups:
+  - resource_group: rg
+    resource_types: [Microsoft.Web/sites]
+    metrics: [{name: Requests}]
+    interval: 1m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ApplyDefaults()
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	inherited := c.Targets[0]
+	if !reflect.DeepEqual(inherited.Aggregations, []string{"Average"}) || inherited.Interval != 5*time.Minute || inherited.Timespan != 15*time.Minute ||
+		len(inherited.Dimensions) != 1 || !reflect.DeepEqual(inherited.Labels, map[string]string{"env": "prod", "team": "web"}) {
+		t.Errorf("doesn't inherit the defaults\ngot: %+v", inherited)
+	}
+	overridden := c.Targets[1]
+	if !reflect.DeepEqual(overridden.Aggregations, []string{"Total"}) || len(overridden.Dimensions) != 0 ||
+		!reflect.DeepEqual(overridden.Labels, map[string]string{"env": "prod", "team": "api"}) {
+		t.Errorf("doesn't override the defaults\ngot: %+v", overridden)
+	}
+	if rg := c.ResourceGroups[0]; rg.Interval != time.Minute || rg.Timespan != 15*time.Minute {
+		t.Errorf("doesn't override the default interval of resource groups\ngot: %+v", rg)
+	}
+
+	c.Defaults.Interval = 10 * time.Minute
+	c.Targets[0].Interval = 0
+	c.ApplyDefaults()
+	if err := c.Validate(); err == nil {
+		t.Error("accepts an invalid interval")
+	}
+}
+
+func TestValidateAliases(t *testing.T) {
+	tests := []struct {
+		aliasCounters bool
+		aggregations  []string
+		valid         bool
+	}{
+		{false, nil, true},
+		{false, []string{"Average"}, true},
+		{false, []string{"Total"}, false},
+		{true, []string{"Average"}, false},
+		{true, []string{"Total"}, true},
+	}
+	for _, test := range tests {
+		c := &Config{AliasCounters: test.aliasCounters}
+		err := c.validateAliases("", []Metric{{Name: "network_bytes_egress"}}, test.aggregations, nil)
+		if (err == nil) != test.valid {
+			t.Errorf("alias_counters %v, aggregations %v\ngot: %v\nwant valid: %v", test.aliasCounters, test.aggregations, err, test.valid)
+		}
+	}
+
+	// Blocks splitting an aliased metric by the dimension of a dimension alias
+	// need the aggregation of the alias.
+	c := &Config{}
+	metrics := []Metric{{Name: "storage_used"}}
+	dimensions := []Dimension{{Name: "DatabaseResourceId"}}
+	if err := c.validateAliases("", metrics, []string{"Maximum"}, dimensions); err == nil {
+		t.Errorf("expected an error for storage_used split by DatabaseResourceId without the Average aggregation")
+	}
+	if err := c.validateAliases("", metrics, []string{"Average"}, dimensions); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestApplyPresets(t *testing.T) {
+	c, err := Parse([]byte(`
+resource_groups:
+  - resource_group: sql
+    preset: sql_elastic_pool
+  - resource_group: sql
+    preset: sql_managed_instance
+    metrics: [{name: avg_cpu_percent}]
+    aggregations: [Average]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ApplyDefaults()
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := c.ResourceGroups[0]
+	if !reflect.DeepEqual(pool.ResourceTypes, []string{"Microsoft.Sql/servers/elasticPools"}) || len(pool.Metrics) != len(Presets["sql_elastic_pool"].Metrics) ||
+		!reflect.DeepEqual(pool.Aggregations, []string{"Average", "Maximum"}) {
+		t.Errorf("doesn't apply the preset\ngot: %+v", pool)
+	}
+	instance := c.ResourceGroups[1]
+	if !reflect.DeepEqual(instance.ResourceTypes, []string{"Microsoft.Sql/managedInstances"}) || len(instance.Metrics) != 1 ||
+		!reflect.DeepEqual(instance.Aggregations, []string{"Average"}) {
+		t.Errorf("doesn't override the preset\ngot: %+v", instance)
+	}
+
+	c.ResourceGroups[1].Preset = "sql_pool"
+	if err := c.Validate(); err == nil {
+		t.Error("accepts an unknown preset")
+	}
+}
+
+func TestValidatePercentiles(t *testing.T) {
+	c := newDefaultConfig()
+	valid := Percentiles{WorkspaceID: "0000-1111", Table: "AppServiceHTTPLogs", Column: "TimeTaken", Values: []float64{95, 99}}
+	if err := c.validatePercentiles([]Metric{{Name: "HttpResponseTime", Percentiles: &valid}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	invalid := []Percentiles{
+		{Table: "AppServiceHTTPLogs", Column: "TimeTaken", Values: []float64{95}},
+		{WorkspaceID: "0000-1111", Table: "AppServiceHTTPLogs | take 1", Column: "TimeTaken", Values: []float64{95}},
+		{WorkspaceID: "0000-1111", Table: "AppServiceHTTPLogs", Column: "TimeTaken"},
+		{WorkspaceID: "0000-1111", Table: "AppServiceHTTPLogs", Column: "TimeTaken", Values: []float64{100}},
+	}
+	for _, p := range invalid {
+		p := p
+		if err := c.validatePercentiles([]Metric{{Name: "HttpResponseTime", Percentiles: &p}}); err == nil {
+			t.Errorf("expected an error for percentiles %+v", p)
+		}
+	}
+}
+
+func TestValidateQueryParameters(t *testing.T) {
+	c := newDefaultConfig()
+	valid := []Metric{
+		{Name: "ActiveMessages", QueryParameters: map[string]string{"validatedimensions": "false"}},
+		{Name: "DeadletteredMessages", QueryParameters: map[string]string{"validatedimensions": "false", "rollupby": "EntityName"}},
+	}
+	if err := c.validateQueryParameters(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	invalid := [][]Metric{
+		{{Name: "ActiveMessages", QueryParameters: map[string]string{"": "false"}}},
+		{{Name: "ActiveMessages", QueryParameters: map[string]string{"Timespan": "PT1H"}}},
+		{{Name: "ActiveMessages", QueryParameters: map[string]string{"$filter": "EntityName eq 'q'"}}},
+		{
+			{Name: "ActiveMessages", QueryParameters: map[string]string{"validatedimensions": "false"}},
+			{Name: "DeadletteredMessages", QueryParameters: map[string]string{"validatedimensions": "true"}},
+		},
+	}
+	for _, metrics := range invalid {
+		if err := c.validateQueryParameters(metrics); err == nil {
+			t.Errorf("expected an error for metrics %+v", metrics)
+		}
+	}
+}
+
+func TestValidatePropertyMetrics(t *testing.T) {
+	c := newDefaultConfig()
+	if err := c.validatePropertyMetrics([]PropertyMetric{{Property: "properties.diskSizeGB", Name: "disk_size_gigabytes"}, {Property: "sku.capacity", Name: "plan_capacity"}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, m := range []PropertyMetric{
+		{Property: "managedBy", Name: "managed_by"},
+		{Property: "properties", Name: "properties"},
+		{Property: "tags.size", Name: "size"},
+		{Property: "properties.diskSizeGB", Name: "disk-size"},
+		{Property: "properties.diskSizeGB"},
+	} {
+		if err := c.validatePropertyMetrics([]PropertyMetric{m}); err == nil {
+			t.Errorf("expected an error for property metric %+v", m)
+		}
+	}
+}
+
+func TestValidateTimegrains(t *testing.T) {
+	if err := validateTimegrains([]time.Duration{time.Minute, time.Hour}, 0, map[string]string{"env": "prod"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, c := range []struct {
+		timegrains []time.Duration
+		interval   time.Duration
+		labels     map[string]string
+	}{
+		{timegrains: []time.Duration{2 * time.Minute}},
+		{timegrains: []time.Duration{time.Minute, time.Minute}},
+		{timegrains: []time.Duration{time.Minute, time.Hour}, interval: time.Minute},
+		{timegrains: []time.Duration{time.Minute}, labels: map[string]string{"timegrain": "fine"}},
+	} {
+		if err := validateTimegrains(c.timegrains, c.interval, c.labels); err == nil {
+			t.Errorf("expected an error for %+v", c)
+		}
+	}
+
+	c := newDefaultConfig()
+	c.Defaults.Interval = 5 * time.Minute
+	c.ResourceTags = []ResourceTag{{Timegrains: []time.Duration{time.Minute, time.Hour}}, {}}
+	c.ApplyDefaults()
+	if c.ResourceTags[0].Interval != 0 || c.ResourceTags[1].Interval != 5*time.Minute {
+		t.Errorf("unexpected intervals %v and %v", c.ResourceTags[0].Interval, c.ResourceTags[1].Interval)
+	}
+}
+
+func TestValidateCredentialPool(t *testing.T) {
+	c := newDefaultConfig()
+	c.CredentialPool = []PoolCredential{
+		{Name: "reader-2", ClientID: "client2", ClientSecret: "secret"},
+		{Name: "reader-3", ClientID: "client3", ClientSecretFile: "/etc/azure/secret"},
+	}
+	if err := c.validateCredentialPool(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, pool := range [][]PoolCredential{
+		{{Name: "primary", ClientID: "client2", ClientSecret: "secret"}},
+		{{Name: "reader 2", ClientID: "client2", ClientSecret: "secret"}},
+		{{Name: "reader-2", ClientID: "client2", ClientSecret: "secret"}, {Name: "reader-2", ClientID: "client3", ClientSecret: "secret"}},
+		{{Name: "reader-2", ClientSecret: "secret"}},
+		{{Name: "reader-2", ClientID: "client2"}},
+		{{Name: "reader-2", ClientID: "client2", ClientSecret: "secret", ClientSecretFile: "/etc/azure/secret"}},
+	} {
+		c.CredentialPool = pool
+		if err := c.validateCredentialPool(); err == nil {
+			t.Errorf("expected an error for credential pool %+v", pool)
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	min, max := 0.0, 100.0
+	clamp := Clamp{Min: &min, Max: &max, NaNValues: []float64{-1}}
+	for _, test := range []struct {
+		val    float64
+		want   float64
+		reason string
+	}{
+		{50, 50, ""},
+		{-3, 0, ClampBelowMin},
+		{120, 100, ClampAboveMax},
+	} {
+		if got, reason := clamp.Apply(test.val); got != test.want || reason != test.reason {
+			t.Errorf("unexpected clamp of %v\ngot: %v, %q\nwant: %v, %q", test.val, got, reason, test.want, test.reason)
+		}
+	}
+	if got, reason := clamp.Apply(-1); !math.IsNaN(got) || reason != ClampNaNValue {
+		t.Errorf("unexpected clamp of -1\ngot: %v, %q\nwant: NaN, %q", got, reason, ClampNaNValue)
+	}
+
+	c := newDefaultConfig()
+	if err := c.validateClamps([]Metric{{Name: "ActiveMessages", Clamp: &clamp}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, invalid := range []Clamp{{}, {Min: &max, Max: &min}} {
+		invalid := invalid
+		if err := c.validateClamps([]Metric{{Name: "ActiveMessages", Clamp: &invalid}}); err == nil {
+			t.Errorf("expected an error for clamp %+v", invalid)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	var c Config
+	err := yaml.Unmarshal([]byte(`
+rules:
+- metric: azure_sql_.*
+  name: '{{ .Name | replace "azure_sql_" "mysql_" }}'
+  labels:
+    service_name: '{{ index .Labels "resource_name" | lower }}'
+`), &c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.validateRules(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	r := c.Rules[0]
+	if !r.Matches("azure_sql_cpu_percent_percent_average") || r.Matches("node_cpu_average") {
+		t.Errorf("rule doesn't match the metrics of %s", r.Metric)
+	}
+	data := RuleData{Name: "azure_sql_cpu_percent_percent_average", Labels: map[string]string{"resource_name": "DB1"}}
+	if name, err := r.Name.Execute(data); err != nil || name != "mysql_cpu_percent_percent_average" {
+		t.Errorf("unexpected name\ngot: %q, %v\nwant: mysql_cpu_percent_percent_average", name, err)
+	}
+	if v, err := r.Labels["service_name"].Execute(data); err != nil || v != "db1" {
+		t.Errorf("unexpected label\ngot: %q, %v\nwant: db1", v, err)
+	}
+
+	if err := yaml.Unmarshal([]byte("rules:\n- name: '{{ .Name'\n"), &Config{}); err == nil {
+		t.Errorf("expected an error for an invalid template")
+	}
+	invalid := []string{
+		"rules:\n- metric: azure_.*\n",
+		"rules:\n- labels:\n    service-name: '{{ .Name }}'\n",
+	}
+	for _, s := range invalid {
+		var c Config
+		if err := yaml.Unmarshal([]byte(s), &c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := c.validateRules(); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestApplyPresetNamespaceAndDimensions(t *testing.T) {
+	c, err := Parse([]byte(`
+defaults:
+  dimensions: [{name: Instance}]
+resource_groups:
+  - resource_group: storage
+    preset: netapp_volume
+  - resource_group: vms
+    preset: vm_disk_bursting
+  - resource_group: vms
+    preset: vm_disk_bursting
+    dimensions: []
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ApplyDefaults()
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.ResourceGroups[0].MetricNamespace; got != "microsoft.netapp/netappaccounts/capacitypools/volumes" {
+		t.Errorf("doesn't apply the metric namespace of the preset\ngot: %q", got)
+	}
+	if got := c.ResourceGroups[0].Dimensions; !reflect.DeepEqual(got, []Dimension{{Name: "Instance"}}) {
+		t.Errorf("doesn't apply the default dimensions without preset dimensions\ngot: %+v", got)
+	}
+	if got := c.ResourceGroups[1].Dimensions; !reflect.DeepEqual(got, []Dimension{{Name: "LUN"}}) {
+		t.Errorf("doesn't apply the dimensions of the preset\ngot: %+v", got)
+	}
+	if got := c.ResourceGroups[2].Dimensions; len(got) != 0 {
+		t.Errorf("doesn't override the dimensions of the preset\ngot: %+v", got)
+	}
+}
+
+func TestPresetMetrics(t *testing.T) {
+	var metrics []Metric
+	for _, m := range Presets["postgresql"].Metrics {
+		metrics = append(metrics, Metric{Name: m})
+	}
+
+	got := PresetMetrics("postgresql", "microsoft.dbforpostgresql/flexibleservers", metrics)
+	if got[len(got)-1] != "disk_iops_consumed_percentage" || len(got) != len(metrics)+1 {
+		t.Errorf("doesn't add the metrics of the resource type\ngot: %v", got)
+	}
+	got = PresetMetrics("postgresql", "Microsoft.DBforPostgreSQL/servers", []Metric{{Name: "cpu_percent"}})
+	if !reflect.DeepEqual(got, []string{"cpu_percent"}) {
+		t.Errorf("adds the metrics of the resource type to explicit metrics\ngot: %v", got)
+	}
+
+	if got := PresetMetricName("postgresql", "disk_iops_consumed_percentage"); got != "io_consumption_percent" {
+		t.Errorf("doesn't rename the metric\ngot: %v", got)
+	}
+	if got := PresetMetricName("", "disk_iops_consumed_percentage"); got != "disk_iops_consumed_percentage" {
+		t.Errorf("renames the metric without preset\ngot: %v", got)
+	}
+}
+
+func TestCPUCreditsPreset(t *testing.T) {
+	c, err := Parse([]byte(`
+resource_groups:
+  - resource_group: rg
+    preset: cpu_credits
+    labels: {team: a}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ApplyDefaults()
+	if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rg := c.ResourceGroups[0]
+
+	got := PresetMetrics(rg.Preset, "Microsoft.Compute/virtualMachines", rg.Metrics)
+	if want := []string{"CPU Credits Consumed", "CPU Credits Remaining"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected metrics of the virtual machines\ngot: %v\nwant: %v", got, want)
+	}
+	if got := PresetMetricName(rg.Preset, "CPU Credits Remaining"); got != "cpu_credits_remaining" {
+		t.Errorf("unexpected name of the metric\ngot: %v", got)
+	}
+
+	labels := PresetLabels(rg.Preset, "microsoft.dbforpostgresql/flexibleservers", rg.Labels)
+	if want := map[string]string{"team": "a", "credit_model": "burstable_database"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("unexpected labels\ngot: %v\nwant: %v", labels, want)
+	}
+	if labels := PresetLabels("", "Microsoft.Compute/virtualMachines", rg.Labels); !reflect.DeepEqual(labels, rg.Labels) {
+		t.Errorf("adds labels without preset\ngot: %v", labels)
+	}
+}
+
+func TestLoadBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azure_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "azure.yml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte("credentials:\n  subscription_id: abc\n"+content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+targets:
+  - resource: /a
+    metrics: [{name: m}]
+resource_tags:
+  - name: db-prod
+    resource_tag_name: team
+    resource_tag_value: db
+    metrics: [{name: m}]
+`)
+	sc := &SafeConfig{}
+	sources := Sources{Files: []string{path}}
+	if err := sc.Load(sources, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hash := sc.Hash
+	if kind, i, ok := sc.C.FindBlock("targets[0]"); !ok || kind != TargetsBlock || i != 0 {
+		t.Errorf("unexpected block targets[0]: %s, %d, %v", kind, i, ok)
+	}
+	if kind, i, ok := sc.C.FindBlock("db-prod"); !ok || kind != ResourceTagsBlock || i != 0 {
+		t.Errorf("unexpected block db-prod: %s, %d, %v", kind, i, ok)
+	}
+
+	write(`
+targets:
+  - resource: /b
+    metrics: [{name: m}]
+resource_tags:
+  - name: db-prod
+    resource_tag_name: team
+    resource_tag_value: database
+    metrics: [{name: m}]
+`)
+	if err := sc.LoadBlock(sources, "db-prod"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := sc.C.ResourceTags[0].ResourceTagValue; got != "database" {
+		t.Errorf("block wasn't reloaded\ngot: %s\nwant: database", got)
+	}
+	if got := sc.C.Targets[0].Resource; got != "/a" {
+		t.Errorf("other block was reloaded\ngot: %s\nwant: /a", got)
+	}
+	if sc.Hash != hash {
+		t.Errorf("block reload changed the hash")
+	}
+
+	for _, block := range []string{"targets[0]", "unknown"} {
+		if err := sc.LoadBlock(sources, block); err == nil {
+			t.Errorf("expected an error reloading block %s", block)
+		}
+	}
+}
+
+func TestValidateBlockNames(t *testing.T) {
+	tests := []struct {
+		names []string
+		valid bool
+	}{
+		{[]string{"", ""}, true},
+		{[]string{"db-prod", "db.staging"}, true},
+		{[]string{"db-prod", "db-prod"}, false},
+		{[]string{"targets[0]", ""}, false},
+	}
+	for _, test := range tests {
+		c := &Config{
+			Targets:      []Target{{Name: test.names[0]}},
+			ResourceTags: []ResourceTag{{Name: test.names[1]}},
+		}
+		if err := c.validateBlockNames(); (err == nil) != test.valid {
+			t.Errorf("names %q\ngot: %v\nwant valid: %v", test.names, err, test.valid)
+		}
+	}
+}
+
+func TestValidateIdentityLabels(t *testing.T) {
+	tests := []struct {
+		labels           []string
+		managementGroups []string
+		valid            bool
+	}{
+		{[]string{"subscription_id", "tenant_id"}, nil, true},
+		{[]string{"resource_group"}, nil, false},
+		{[]string{"tenant_id"}, []string{"contoso"}, true},
+		{[]string{"subscription_id"}, []string{"contoso"}, false},
+		{[]string{"subscription_name"}, []string{"contoso"}, false},
+	}
+	for _, test := range tests {
+		if err := validateIdentityLabels(test.labels, test.managementGroups); (err == nil) != test.valid {
+			t.Errorf("labels %q with management groups %q\ngot: %v\nwant valid: %v", test.labels, test.managementGroups, err, test.valid)
+		}
+	}
+}
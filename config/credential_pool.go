@@ -0,0 +1,54 @@
+package config
+
+import "fmt"
+
+// PrimaryCredential is the name of the credentials in the credential pool.
+const PrimaryCredential = "primary"
+
+// PoolCredential is a service principal of the credential pool, across which
+// the direct GET requests to Azure Resource Manager are spread, as its read
+// limits apply to each principal. TenantID defaults to the tenant of the
+// credentials.
+type PoolCredential struct {
+	Name             string `yaml:"name"`
+	ClientID         string `yaml:"client_id"`
+	ClientSecret     string `yaml:"client_secret"`
+	ClientSecretFile string `yaml:"client_secret_file"`
+	TenantID         string `yaml:"tenant_id"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *PoolCredential) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain PoolCredential
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateCredentialPool checks that the credentials of the pool are named
+// uniquely and have a client ID and a single client secret.
+func (c *Config) validateCredentialPool() error {
+	seen := map[string]bool{PrimaryCredential: true}
+	for _, p := range c.CredentialPool {
+		if !validBlockName.MatchString(p.Name) {
+			return fmt.Errorf("credential_pool name %q must only contain letters, digits, '_', '.' and '-'", p.Name)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("credential_pool name %q is used more than once or reserved", p.Name)
+		}
+		seen[p.Name] = true
+		if p.ClientID == "" {
+			return fmt.Errorf("credential_pool %s needs a client_id", p.Name)
+		}
+		if (p.ClientSecret == "") == (p.ClientSecretFile == "") {
+			return fmt.Errorf("credential_pool %s needs exactly one of client_secret and client_secret_file", p.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import "time"
+
+// ApplyDefaults sets the settings of their preset, then of the defaults
+// section, on the targets, resource groups and resource tags which don't set
+// them. Labels are merged, the labels of an entry overriding the default
+// labels of the same name.
+func (c *Config) ApplyDefaults() {
+	d := c.Defaults
+	for i := range c.Targets {
+		t := &c.Targets[i]
+		applyPreset(t.Preset, nil, &t.MetricNamespace, &t.Metrics, &t.Aggregations, &t.Dimensions)
+		d.apply(&t.Aggregations, &t.Interval, t.Timegrains, &t.Timespan, &t.Dimensions, &t.Labels)
+	}
+	for i := range c.ResourceGroups {
+		t := &c.ResourceGroups[i]
+		applyPreset(t.Preset, &t.ResourceTypes, &t.MetricNamespace, &t.Metrics, &t.Aggregations, &t.Dimensions)
+		d.apply(&t.Aggregations, &t.Interval, t.Timegrains, &t.Timespan, &t.Dimensions, &t.Labels)
+	}
+	for i := range c.ResourceTags {
+		t := &c.ResourceTags[i]
+		applyPreset(t.Preset, &t.ResourceTypes, &t.MetricNamespace, &t.Metrics, &t.Aggregations, &t.Dimensions)
+		d.apply(&t.Aggregations, &t.Interval, t.Timegrains, &t.Timespan, &t.Dimensions, &t.Labels)
+	}
+}
+
+func (d Defaults) apply(aggregations *[]string, interval *time.Duration, timegrains []time.Duration, timespan *time.Duration, dimensions *[]Dimension, labels *map[string]string) {
+	if len(*aggregations) == 0 {
+		*aggregations = d.Aggregations
+	}
+	// The timegrains of an entry replace its interval.
+	if *interval == 0 && len(timegrains) == 0 {
+		*interval = d.Interval
+	}
+	if *timespan == 0 {
+		*timespan = d.Timespan
+	}
+	// An explicitly empty list of dimensions overrides the defaults.
+	if *dimensions == nil {
+		*dimensions = d.Dimensions
+	}
+	if len(d.Labels) > 0 {
+		merged := map[string]string{}
+		for name, value := range d.Labels {
+			merged[name] = value
+		}
+		for name, value := range *labels {
+			merged[name] = value
+		}
+		*labels = merged
+	}
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// credentialsEnv maps the credentials settings to the environment variables
+// setting them when the exporter is configured without configuration files.
+var credentialsEnv = map[string]string{
+	"subscription_id":    "AZURE_SUBSCRIPTION_ID",
+	"tenant_id":          "AZURE_TENANT_ID",
+	"client_id":          "AZURE_CLIENT_ID",
+	"client_secret":      "AZURE_CLIENT_SECRET",
+	"client_secret_file": "AZURE_CLIENT_SECRET_FILE",
+	"auth_type":          "AZURE_AUTH_TYPE",
+}
+
+// EnvConfigured reports whether the exporter can be configured by environment
+// variables, which requires AZURE_SUBSCRIPTION_ID.
+func EnvConfigured() bool {
+	return os.Getenv("AZURE_SUBSCRIPTION_ID") != ""
+}
+
+// envConfig returns the configuration document built from the environment
+// variables. AZURE_TARGETS_JSON holds the targets as a JSON list.
+func envConfig() ([]byte, error) {
+	credentials := map[string]string{}
+	for setting, env := range credentialsEnv {
+		if value := os.Getenv(env); value != "" {
+			credentials[setting] = value
+		}
+	}
+	doc := map[string]interface{}{"credentials": credentials}
+
+	if targets := os.Getenv("AZURE_TARGETS_JSON"); targets != "" {
+		var t []interface{}
+		if err := yaml.Unmarshal([]byte(targets), &t); err != nil {
+			return nil, fmt.Errorf("Error parsing AZURE_TARGETS_JSON: %s", err)
+		}
+		doc["targets"] = t
+	}
+	return yaml.Marshal(doc)
+}
+
+// loadEnv loads the configuration from the environment variables.
+func (l *configLoader) loadEnv() error {
+	data, err := envConfig()
+	if err != nil {
+		return err
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf("Error parsing config from environment variables: %s", err)
+	}
+	l.hash.Write(data)
+	l.files = append(l.files, "environment variables")
+	l.configs = append(l.configs, c)
+	return nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// kqlIdentifier matches the table and column names usable in the queries of
+// the percentiles, which are not quoted.
+var kqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Percentiles computes percentiles of a metric from the Column of the log
+// Table of a Log Analytics workspace, for the metrics Azure Monitor only
+// aggregates as average, minimum and maximum, e.g. the response time of the
+// App Service apps from AppServiceHTTPLogs.
+type Percentiles struct {
+	// WorkspaceID is the workspace (customer) ID of the workspace.
+	WorkspaceID string    `yaml:"workspace_id"`
+	Table       string    `yaml:"table"`
+	Column      string    `yaml:"column"`
+	Values      []float64 `yaml:"values"`
+	// Scale converts the values of the column to the unit of the metric,
+	// e.g. 0.001 for milliseconds to seconds.
+	Scale float64 `yaml:"scale"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Percentiles) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Percentiles
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validatePercentiles checks the percentiles of the metrics of a block.
+func (c *Config) validatePercentiles(metrics []Metric) error {
+	for _, m := range metrics {
+		p := m.Percentiles
+		if p == nil {
+			continue
+		}
+		if p.WorkspaceID == "" {
+			return fmt.Errorf("Percentiles of metric %s need a workspace_id", m.Name)
+		}
+		if !kqlIdentifier.MatchString(p.Table) || !kqlIdentifier.MatchString(p.Column) {
+			return fmt.Errorf("Percentiles of metric %s need a valid table and column, got %q and %q", m.Name, p.Table, p.Column)
+		}
+		if len(p.Values) == 0 {
+			return fmt.Errorf("Percentiles of metric %s need at least one value", m.Name)
+		}
+		for _, v := range p.Values {
+			if v <= 0 || v >= 100 {
+				return fmt.Errorf("Percentile %v of metric %s must be between 0 and 100", v, m.Name)
+			}
+		}
+		if p.Scale < 0 {
+			return fmt.Errorf("Percentiles of metric %s must not have a negative scale", m.Name)
+		}
+		if c.LogAnalytics.QueryURL == "" {
+			return fmt.Errorf("Percentiles of metric %s need a log_analytics query_url", m.Name)
+		}
+	}
+	return nil
+}
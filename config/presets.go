@@ -0,0 +1,219 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Preset is a built-in selection of the metrics of resource types, set with
+// the preset of a target, resource group or resource tag.
+type Preset struct {
+	ResourceTypes   []string
+	MetricNamespace string
+	// Metrics are the metrics common to the resource types.
+	Metrics      []string
+	Aggregations []string
+	// Dimensions split the metrics of the preset, e.g. by disk.
+	Dimensions []Dimension
+	// TypeMetrics are the metrics requested in addition to the metrics of the
+	// preset for the resources of a type.
+	TypeMetrics map[string][]string
+	// Renames publish Azure metrics under the name of their equivalent in
+	// the other resource types of the preset.
+	Renames map[string]string
+	// TypeLabels are added to the labels of the resources of a type.
+	TypeLabels map[string]map[string]string
+}
+
+// Presets are the built-in presets by name.
+var Presets = map[string]Preset{
+	// Elastic pools are child resources of the SQL servers, their metrics are
+	// labeled with the server as resource_name and the pool as
+	// sub_resource_name.
+	"sql_elastic_pool": {
+		ResourceTypes: []string{"Microsoft.Sql/servers/elasticPools"},
+		Metrics: []string{
+			"cpu_percent", "dtu_consumption_percent", "eDTU_limit", "eDTU_used",
+			"storage_limit", "storage_used", "storage_percent", "allocated_data_storage",
+			"tempdb_data_size", "tempdb_log_size", "tempdb_log_used_percent",
+			"workers_percent", "sessions_percent", "log_write_percent",
+		},
+		Aggregations: []string{"Average", "Maximum"},
+	},
+	// Single servers and flexible servers are published with the same metric
+	// names, so that dashboards keep working across migrations.
+	"postgresql": {
+		ResourceTypes: []string{"Microsoft.DBforPostgreSQL/servers", "Microsoft.DBforPostgreSQL/flexibleServers"},
+		Metrics: []string{
+			"cpu_percent", "memory_percent", "storage_percent", "storage_used",
+			"active_connections", "connections_failed", "network_bytes_egress", "network_bytes_ingress",
+		},
+		Aggregations: []string{"Average", "Maximum", "Total"},
+		TypeMetrics: map[string][]string{
+			"Microsoft.DBforPostgreSQL/servers":         {"io_consumption_percent"},
+			"Microsoft.DBforPostgreSQL/flexibleServers": {"disk_iops_consumed_percentage"},
+		},
+		Renames: map[string]string{"disk_iops_consumed_percentage": "io_consumption_percent"},
+	},
+	"mysql": {
+		ResourceTypes: []string{"Microsoft.DBforMySQL/servers", "Microsoft.DBforMySQL/flexibleServers"},
+		Metrics: []string{
+			"cpu_percent", "memory_percent", "io_consumption_percent", "storage_percent", "storage_used", "storage_limit",
+			"active_connections", "network_bytes_egress", "network_bytes_ingress",
+		},
+		Aggregations: []string{"Average", "Maximum", "Total"},
+		TypeMetrics: map[string][]string{
+			"Microsoft.DBforMySQL/servers":         {"connections_failed"},
+			"Microsoft.DBforMySQL/flexibleServers": {"aborted_connections"},
+		},
+		Renames: map[string]string{"aborted_connections": "connections_failed"},
+	},
+	"sql_managed_instance": {
+		ResourceTypes: []string{"Microsoft.Sql/managedInstances"},
+		Metrics: []string{
+			"avg_cpu_percent", "virtual_core_count", "storage_space_used_mb", "reserved_storage_mb",
+			"io_requests", "io_bytes_read", "io_bytes_written",
+		},
+		Aggregations: []string{"Average", "Maximum"},
+	},
+	// The metrics of the NetApp volumes are only published in the namespace
+	// of the volumes, not in the default namespace of the resource type.
+	"netapp_volume": {
+		ResourceTypes:   []string{"Microsoft.NetApp/netAppAccounts/capacityPools/volumes"},
+		MetricNamespace: "microsoft.netapp/netappaccounts/capacitypools/volumes",
+		Metrics: []string{
+			"AverageReadLatency", "AverageWriteLatency", "ReadIops", "WriteIops",
+			"ReadThroughput", "WriteThroughput", "TotalThroughput",
+			"VolumeLogicalSize", "VolumeConsumedSizePercentage",
+		},
+		Aggregations: []string{"Average"},
+	},
+	"managed_disk": {
+		ResourceTypes: []string{"Microsoft.Compute/disks"},
+		Metrics: []string{
+			"Composite Disk Read Bytes/sec", "Composite Disk Write Bytes/sec",
+			"Composite Disk Read Operations/sec", "Composite Disk Write Operations/sec",
+			"DiskPaidBurstIOPS", "Disk On-demand Burst Operations",
+		},
+		Aggregations: []string{"Average"},
+	},
+	// The burst credits and the consumed IOPS and bandwidth of the disks are
+	// published by the virtual machines, by logical unit of the data disks.
+	"vm_disk_bursting": {
+		ResourceTypes: []string{"Microsoft.Compute/virtualMachines"},
+		Metrics: []string{
+			"Data Disk Used Burst IO Credits Percentage", "Data Disk Used Burst BPS Credits Percentage",
+			"Data Disk IOPS Consumed Percentage", "Data Disk Bandwidth Consumed Percentage",
+		},
+		Aggregations: []string{"Average"},
+		Dimensions:   []Dimension{{Name: "LUN"}},
+	},
+	// The CPU credits are only published by the burstable SKUs: the B-series
+	// virtual machines and the flexible servers of the Burstable tier. The
+	// metrics of the virtual machines are renamed like the ones of the
+	// servers, the credit_model label telling them apart.
+	"cpu_credits": {
+		ResourceTypes: []string{"Microsoft.Compute/virtualMachines", "Microsoft.DBforPostgreSQL/flexibleServers", "Microsoft.DBforMySQL/flexibleServers"},
+		Aggregations:  []string{"Average", "Minimum"},
+		TypeMetrics: map[string][]string{
+			"Microsoft.Compute/virtualMachines":         {"CPU Credits Consumed", "CPU Credits Remaining"},
+			"Microsoft.DBforPostgreSQL/flexibleServers": {"cpu_credits_consumed", "cpu_credits_remaining"},
+			"Microsoft.DBforMySQL/flexibleServers":      {"cpu_credits_consumed", "cpu_credits_remaining"},
+		},
+		Renames: map[string]string{"CPU Credits Consumed": "cpu_credits_consumed", "CPU Credits Remaining": "cpu_credits_remaining"},
+		TypeLabels: map[string]map[string]string{
+			"Microsoft.Compute/virtualMachines":         {"credit_model": "b_series"},
+			"Microsoft.DBforPostgreSQL/flexibleServers": {"credit_model": "burstable_database"},
+			"Microsoft.DBforMySQL/flexibleServers":      {"credit_model": "burstable_database"},
+		},
+	},
+}
+
+// applyPreset sets the settings of the preset which aren't set explicitly.
+func applyPreset(name string, resourceTypes *[]string, metricNamespace *string, metrics *[]Metric, aggregations *[]string, dimensions *[]Dimension) {
+	p, ok := Presets[name]
+	if !ok {
+		return
+	}
+	if resourceTypes != nil && len(*resourceTypes) == 0 {
+		*resourceTypes = p.ResourceTypes
+	}
+	if *metricNamespace == "" {
+		*metricNamespace = p.MetricNamespace
+	}
+	if len(*metrics) == 0 {
+		for _, m := range p.Metrics {
+			*metrics = append(*metrics, Metric{Name: m})
+		}
+	}
+	if len(*aggregations) == 0 {
+		*aggregations = p.Aggregations
+	}
+	if *dimensions == nil {
+		*dimensions = p.Dimensions
+	}
+}
+
+// presetNames returns the sorted names of the presets.
+func presetNames() []string {
+	var names []string
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PresetMetrics returns the names of the metrics requested for a resource of
+// the type by a block with the preset: the metrics of the block, along with
+// the metrics of the resource type when the block uses the metrics of the
+// preset.
+func PresetMetrics(preset string, resourceType string, metrics []Metric) []string {
+	var names []string
+	for _, m := range metrics {
+		names = append(names, m.Name)
+	}
+	p, ok := Presets[preset]
+	if !ok || !reflect.DeepEqual(names, p.Metrics) {
+		return names
+	}
+	for t, typeMetrics := range p.TypeMetrics {
+		if strings.EqualFold(t, resourceType) {
+			names = append(names, typeMetrics...)
+		}
+	}
+	return names
+}
+
+// PresetLabels returns the labels of a resource of the type in a block with
+// the preset: the labels of the preset for the type, overridden by the labels
+// of the block.
+func PresetLabels(preset string, resourceType string, labels map[string]string) map[string]string {
+	var typeLabels map[string]string
+	for t, l := range Presets[preset].TypeLabels {
+		if strings.EqualFold(t, resourceType) {
+			typeLabels = l
+		}
+	}
+	if len(typeLabels) == 0 {
+		return labels
+	}
+	merged := map[string]string{}
+	for name, value := range typeLabels {
+		merged[name] = value
+	}
+	for name, value := range labels {
+		merged[name] = value
+	}
+	return merged
+}
+
+// PresetMetricName returns the name an Azure metric is published under with
+// the preset.
+func PresetMetricName(preset string, metric string) string {
+	if name, ok := Presets[preset].Renames[metric]; ok {
+		return name
+	}
+	return metric
+}
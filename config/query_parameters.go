@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// exporterQueryParameters are the query parameters of the metrics requests
+// set by the exporter, which can't be given as query_parameters.
+var exporterQueryParameters = []string{"metricnames", "metricnamespace", "aggregation", "$filter", "timespan", "interval", "api-version"}
+
+// QueryParameters returns the query_parameters of the metrics of a block.
+// The metrics of a resource are requested together, so the parameters of
+// each metric apply to the request of all of them.
+func QueryParameters(metrics []Metric) map[string]string {
+	var parameters map[string]string
+	for _, m := range metrics {
+		for k, v := range m.QueryParameters {
+			if parameters == nil {
+				parameters = map[string]string{}
+			}
+			parameters[k] = v
+		}
+	}
+	return parameters
+}
+
+// validateQueryParameters checks that the query_parameters of the metrics of
+// a block neither override the parameters of the exporter nor conflict.
+func (c *Config) validateQueryParameters(metrics []Metric) error {
+	seen := map[string]string{}
+	for _, m := range metrics {
+		for k, v := range m.QueryParameters {
+			if k == "" {
+				return fmt.Errorf("Query parameters of metric %s need a name", m.Name)
+			}
+			for _, p := range exporterQueryParameters {
+				if strings.EqualFold(k, p) {
+					return fmt.Errorf("Query parameter %s of metric %s is set by the exporter", k, m.Name)
+				}
+			}
+			if previous, ok := seen[k]; ok && previous != v {
+				return fmt.Errorf("Query parameter %s of metric %s conflicts with another metric of the block: %q and %q", k, m.Name, v, previous)
+			}
+			seen[k] = v
+		}
+	}
+	return nil
+}
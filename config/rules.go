@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Rule renames the metrics whose name matches Metric and derives their
+// labels with Go templates, for the naming schemes the static settings can't
+// express. The templates are evaluated for each sample with a RuleData.
+type Rule struct {
+	// Metric matches the names of the metrics the rule applies to, all the
+	// metrics when empty.
+	Metric Regexp `yaml:"metric"`
+	// Name is the template of the new name of the metrics.
+	Name *Template `yaml:"name"`
+	// Labels are the templates of the labels to set, the labels whose
+	// template evaluates to an empty string are removed.
+	Labels map[string]*Template `yaml:"labels"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// RuleData is the data the templates of the rules are evaluated with.
+type RuleData struct {
+	Name        string
+	Labels      map[string]string
+	Aggregation string
+	ResourceID  string
+}
+
+// Matches tells whether the rule applies to the metric.
+func (r Rule) Matches(metric string) bool {
+	return r.Metric.Regexp == nil || r.Metric.MatchString(metric)
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (r *Rule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Rule
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	if err := checkOverflow(r.XXX, "rules"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateRules checks the rules of the configuration.
+func (c *Config) validateRules() error {
+	for i, r := range c.Rules {
+		if r.Name == nil && len(r.Labels) == 0 {
+			return fmt.Errorf("rules[%d] needs a name or labels", i)
+		}
+		for name := range r.Labels {
+			if !validLabelName.MatchString(name) {
+				return fmt.Errorf("rules[%d] sets the invalid label name %q", i, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Template encapsulates a text/template.Template parsed with the functions
+// of the rules and makes it YAML unmarshalable.
+type Template struct {
+	*template.Template
+}
+
+// ruleFuncs are the functions available to the templates of the rules, in
+// addition to the predefined ones. The string they apply to is the last
+// argument, so that they can be used in pipelines.
+var ruleFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"regexReplace": func(expr, repl, s string) (string, error) {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(s, repl), nil
+	},
+}
+
+// Execute evaluates the template with the data.
+func (t *Template) Execute(data interface{}) (string, error) {
+	var b strings.Builder
+	if err := t.Template.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (t *Template) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	tmpl, err := template.New("rule").Funcs(ruleFuncs).Option("missingkey=zero").Parse(s)
+	if err != nil {
+		return err
+	}
+	t.Template = tmpl
+	return nil
+}
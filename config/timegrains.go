@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimegrainLabel is the label of the time grain of the series of the blocks
+// with timegrains.
+const TimegrainLabel = "timegrain"
+
+// validateTimegrains checks the timegrains of a block, which replace its
+// interval.
+func validateTimegrains(timegrains []time.Duration, interval time.Duration, labels map[string]string) error {
+	if len(timegrains) == 0 {
+		return nil
+	}
+	if interval != 0 {
+		return fmt.Errorf("interval and timegrains can't both be set")
+	}
+	if _, ok := labels[TimegrainLabel]; ok {
+		return fmt.Errorf("label %q is reserved for the timegrains", TimegrainLabel)
+	}
+	seen := map[time.Duration]bool{}
+	for _, timegrain := range timegrains {
+		if err := validateWindow(timegrain, 0); err != nil {
+			return err
+		}
+		if seen[timegrain] {
+			return fmt.Errorf("timegrain %v is listed more than once", timegrain)
+		}
+		seen[timegrain] = true
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// counterStaleness is the delay after which the counter of a series that is
+// no longer updated is dropped, a series reappearing later starts from zero
+// again, which Prometheus detects as a counter reset.
+const counterStaleness = time.Hour
+
+type counterEntry struct {
+	value     float64
+	timestamp time.Time
+	updated   time.Time
+}
+
+// counterAccumulator accumulates the Total aggregation of the successive time
+// grains of metrics into monotonic counters.
+type counterAccumulator struct {
+	sync.Mutex
+	counters map[string]*counterEntry
+}
+
+func newCounterAccumulator() *counterAccumulator {
+	return &counterAccumulator{counters: map[string]*counterEntry{}}
+}
+
+// add adds the total of a time grain to the counter of a series and returns
+// the counter value. Time grains already accumulated are ignored and negative
+// totals are clamped to zero to keep the counter monotonic.
+func (a *counterAccumulator) add(key string, timestamp time.Time, total float64, now time.Time) float64 {
+	a.Lock()
+	defer a.Unlock()
+
+	for k, entry := range a.counters {
+		if now.Sub(entry.updated) > counterStaleness {
+			delete(a.counters, k)
+		}
+	}
+
+	entry, ok := a.counters[key]
+	if !ok {
+		entry = &counterEntry{}
+		a.counters[key] = entry
+	}
+	entry.updated = now
+	if ok && !timestamp.After(entry.timestamp) {
+		return entry.value
+	}
+	if total > 0 {
+		entry.value += total
+	}
+	entry.timestamp = timestamp
+	return entry.value
+}
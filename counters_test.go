@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCounterAccumulatorAdd(t *testing.T) {
+	a := newCounterAccumulator()
+	now := time.Date(2020, 1, 1, 0, 10, 0, 0, time.UTC)
+	grain := func(minute int) time.Time {
+		return time.Date(2020, 1, 1, 0, minute, 0, 0, time.UTC)
+	}
+
+	steps := []struct {
+		timestamp time.Time
+		total     float64
+		now       time.Time
+		want      float64
+	}{
+		{grain(1), 100, now, 100},
+		{grain(1), 100, now.Add(30 * time.Second), 100},
+		{grain(2), 50, now.Add(time.Minute), 150},
+		{grain(3), -10, now.Add(2 * time.Minute), 150},
+		{grain(2), 70, now.Add(3 * time.Minute), 150},
+		{grain(4), 20, now.Add(2 * time.Hour), 20},
+	}
+	for i, s := range steps {
+		if got := a.add("vm|node_network_transmit_bytes_total", s.timestamp, s.total, s.now); got != s.want {
+			t.Errorf("step %d: doesn't accumulate expected value\ngot: %v\nwant: %v", i, got, s.want)
+		}
+	}
+}
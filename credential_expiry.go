@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// credentialExpiryRefreshInterval is the interval between requests of the
+	// application credentials to Microsoft Graph.
+	credentialExpiryRefreshInterval = time.Hour
+	// credentialExpiryRetryDelay is the delay before retrying after a failure.
+	credentialExpiryRetryDelay = 5 * time.Minute
+)
+
+var credentialExpiryDesc = prometheus.NewDesc("azure_exporter_credential_expiry_timestamp_seconds", "Expiry of a credential of the exporter's application registration",
+	[]string{"client_id", "key_id", "type"}, nil)
+
+// MSGraphApplicationListResponse is the response of the applications API of
+// Microsoft Graph.
+type MSGraphApplicationListResponse struct {
+	Value []struct {
+		PasswordCredentials []msGraphCredential `json:"passwordCredentials"`
+		KeyCredentials      []msGraphCredential `json:"keyCredentials"`
+	} `json:"value"`
+}
+
+type msGraphCredential struct {
+	KeyID       string    `json:"keyId"`
+	EndDateTime time.Time `json:"endDateTime"`
+}
+
+type credentialExpiry struct {
+	keyID     string
+	kind      string
+	expiresAt time.Time
+}
+
+// credentialExpiryCache holds the credentials of the application read from
+// Microsoft Graph, the last known credentials are kept on failures.
+type credentialExpiryCache struct {
+	sync.Mutex
+	credentials []credentialExpiry
+	expires     time.Time
+}
+
+func (c *credentialExpiryCache) get(now time.Time) []credentialExpiry {
+	c.Lock()
+	defer c.Unlock()
+
+	if now.Before(c.expires) {
+		return c.credentials
+	}
+	credentials, err := ac.getApplicationCredentials()
+	if err != nil {
+		log.Printf("Failed to get credentials of application %s from Microsoft Graph: %v", sc.Get().Credentials.ClientID, err)
+		c.expires = now.Add(credentialExpiryRetryDelay)
+		return c.credentials
+	}
+	c.credentials = credentials
+	c.expires = now.Add(credentialExpiryRefreshInterval)
+	return c.credentials
+}
+
+// getApplicationCredentials returns the expiry of the client secrets and
+// certificates of the application of the client ID, which requires the
+// Application.Read.All permission of Microsoft Graph.
+func (ac *AzureClient) getApplicationCredentials() ([]credentialExpiry, error) {
+	cfg := sc.Get()
+	graphURL := cfg.CredentialExpiry.GraphURL
+	if err := ac.refreshAccessTokenFor(graphURL); err != nil {
+		return nil, err
+	}
+
+	query := url.Values{
+		"$filter": {fmt.Sprintf("appId eq '%s'", secureString(cfg.Credentials.ClientID))},
+		"$select": {"passwordCredentials,keyCredentials"},
+	}
+	target := fmt.Sprintf("%s/v1.0/applications?%s", strings.TrimSuffix(graphURL, "/"), query.Encode())
+	body, err := azureRequest("GET", target, ac.authorizationFor(graphURL), "")
+	if err != nil {
+		return nil, err
+	}
+
+	var data MSGraphApplicationListResponse
+	if err := decodeLenient("graph", body, &data); err != nil {
+		return nil, err
+	}
+	if len(data.Value) == 0 {
+		return nil, fmt.Errorf("No application found with the client ID")
+	}
+
+	var credentials []credentialExpiry
+	for _, c := range data.Value[0].PasswordCredentials {
+		credentials = append(credentials, credentialExpiry{keyID: c.KeyID, kind: "password", expiresAt: c.EndDateTime})
+	}
+	for _, c := range data.Value[0].KeyCredentials {
+		credentials = append(credentials, credentialExpiry{keyID: c.KeyID, kind: "certificate", expiresAt: c.EndDateTime})
+	}
+	return credentials, nil
+}
+
+// collectCredentialExpiry exposes the configured expiry of the credentials
+// and the expiry of the credentials read from Microsoft Graph.
+func (c *Collector) collectCredentialExpiry(ch chan<- prometheus.Metric) {
+	clientID := c.cfg.Credentials.ClientID
+	if expiresAt := c.cfg.CredentialExpiry.ExpiresAt; !expiresAt.IsZero() {
+		ch <- prometheus.MustNewConstMetric(credentialExpiryDesc, prometheus.GaugeValue, float64(expiresAt.Unix()), clientID, "", "configured")
+	}
+	if !c.cfg.CredentialExpiry.Graph {
+		return
+	}
+	for _, e := range credentialExpiries.get(time.Now()) {
+		ch <- prometheus.MustNewConstMetric(credentialExpiryDesc, prometheus.GaugeValue, float64(e.expiresAt.Unix()), clientID, e.keyID, e.kind)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectCredentialExpiry(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if got, want := r.URL.Query().Get("$filter"), "appId eq 'app'"; got != want {
+			t.Errorf("unexpected filter\ngot: %v\nwant: %v", got, want)
+		}
+		fmt.Fprint(w, `{"value": [{
+			"passwordCredentials": [{"keyId": "k1", "endDateTime": "2030-01-01T00:00:00Z"}],
+			"keyCredentials": [{"keyId": "k2", "endDateTime": "2031-01-01T00:00:00Z"}]
+		}]}`)
+	}))
+	defer server.Close()
+
+	previous, previousClient, previousCache := sc.C, ac, credentialExpiries
+	defer func() { sc.C, ac, credentialExpiries = previous, previousClient, previousCache }()
+	sc.C = &config.Config{
+		Credentials: config.Credentials{ClientID: "app"},
+		CredentialExpiry: config.CredentialExpiry{
+			ExpiresAt: time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC),
+			Graph:     true,
+			GraphURL:  server.URL,
+		},
+	}
+	ac = NewAzureClient()
+	ac.tokens[server.URL] = accessToken{token: "token", expiresOn: time.Now().Add(time.Hour)}
+	credentialExpiries = &credentialExpiryCache{}
+
+	for i := 0; i < 2; i++ {
+		ch := make(chan prometheus.Metric, 10)
+		(&Collector{cfg: sc.C}).collectCredentialExpiry(ch)
+		close(ch)
+
+		got := metricValues(t, ch)
+		want := map[string]float64{
+			`azure_exporter_credential_expiry_timestamp_seconds{app,,configured}`:    1861920000,
+			`azure_exporter_credential_expiry_timestamp_seconds{app,k1,password}`:    1893456000,
+			`azure_exporter_credential_expiry_timestamp_seconds{app,k2,certificate}`: 1924992000,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("doesn't collect credential expiry\ngot: %v\nwant: %v", got, want)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("application credentials not cached\ngot: %d requests\nwant: 1", requests)
+	}
+}
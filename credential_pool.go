@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+// credentialPoolRetryDelay is the delay before retrying to authenticate with
+// a credential of the credential pool after a failure.
+const credentialPoolRetryDelay = 5 * time.Minute
+
+// pooledToken is the Azure Resource Manager access token of a credential of
+// the credential pool. Credentials failing to authenticate are skipped until
+// retryAt.
+type pooledToken struct {
+	token   accessToken
+	retryAt time.Time
+}
+
+// pooledAuthorization returns the name of the credential and the
+// Authorization header value of the next direct GET request to Azure
+// Resource Manager, the credentials and the credential pool being used in
+// turn. The credentials are used in place of a pooled credential which
+// can't authenticate.
+func (ac *AzureClient) pooledAuthorization() (string, string) {
+	pool := sc.Get().CredentialPool
+	if len(pool) == 0 {
+		return config.PrimaryCredential, ac.authorization()
+	}
+
+	ac.credentialPoolMtx.Lock()
+	i := ac.credentialPoolNext % (len(pool) + 1)
+	ac.credentialPoolNext++
+	ac.credentialPoolMtx.Unlock()
+	if i == 0 {
+		return config.PrimaryCredential, ac.authorization()
+	}
+
+	p := pool[i-1]
+	token, ok := ac.pooledToken(p, time.Now())
+	if !ok {
+		return config.PrimaryCredential, ac.authorization()
+	}
+	return p.Name, "Bearer " + token
+}
+
+// pooledToken returns the access token of a credential of the pool, renewed
+// before it expires, and whether it is available. The token is requested
+// without credentialPoolMtx held, so that a slow tenant only delays the
+// requests using its credential.
+func (ac *AzureClient) pooledToken(p config.PoolCredential, now time.Time) (string, bool) {
+	key := p.Name + "|" + p.ClientID
+	entry, fresh := ac.cachedPooledToken(key, now)
+	if !fresh {
+		v, _ := ac.credentialPoolFlights.do(key, func() (interface{}, error) {
+			// The token may have been renewed by a request completed meanwhile.
+			if entry, fresh := ac.cachedPooledToken(key, now); fresh {
+				return entry, nil
+			}
+			token, err := ac.fetchPooledToken(p)
+			entry := pooledToken{token: token}
+			if err != nil {
+				log.Printf("Failed to authenticate with credential %s of the credential pool, retrying in %v: %v", p.Name, credentialPoolRetryDelay, err)
+				entry = pooledToken{retryAt: now.Add(credentialPoolRetryDelay)}
+			}
+			ac.credentialPoolMtx.Lock()
+			ac.credentialPoolTokens[key] = entry
+			ac.credentialPoolMtx.Unlock()
+			return entry, nil
+		})
+		entry = v.(pooledToken)
+	}
+	if entry.token.token == "" {
+		return "", false
+	}
+	return entry.token.token, true
+}
+
+// cachedPooledToken returns the cached token of a credential of the pool, and
+// whether it can be used without requesting a token: it doesn't expire soon,
+// or the credential failed recently.
+func (ac *AzureClient) cachedPooledToken(key string, now time.Time) (pooledToken, bool) {
+	ac.credentialPoolMtx.Lock()
+	defer ac.credentialPoolMtx.Unlock()
+	entry := ac.credentialPoolTokens[key]
+	if now.Before(entry.token.expiresOn.Add(-10 * time.Minute)) {
+		return entry, true
+	}
+	return entry, now.Before(entry.retryAt)
+}
+
+// fetchPooledToken requests an Azure Resource Manager access token with the
+// client secret of a credential of the pool.
+func (ac *AzureClient) fetchPooledToken(p config.PoolCredential) (accessToken, error) {
+	secret := p.ClientSecret
+	if p.ClientSecretFile != "" {
+		data, err := ioutil.ReadFile(p.ClientSecretFile)
+		if err != nil {
+			return accessToken{}, fmt.Errorf("Error reading client secret file: %v", err)
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+	cfg := sc.Get()
+	tenantID := p.TenantID
+	if tenantID == "" {
+		tenantID = cfg.Credentials.TenantID
+	}
+
+	resource := cfg.ResourceManagerAudience()
+	token, err := ac.clientCredentialsToken(tenantID, p.ClientID, secret, resource)
+	if err != nil {
+		return accessToken{}, err
+	}
+	if err := validateToken(token.token, resource, tenantID, time.Now().UTC()); err != nil {
+		return accessToken{}, err
+	}
+	return token, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestPooledAuthorization(t *testing.T) {
+	tokenRequests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		clientID := r.PostForm.Get("client_id")
+		tokenRequests[clientID]++
+		if clientID == "broken" {
+			http.Error(w, `{"error": "invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":"token-%s","expires_on":"%d"}`, clientID, time.Now().Add(time.Hour).Unix())
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{
+		ResourceManagerURL:          "https://management.azure.com",
+		ActiveDirectoryAuthorityURL: server.URL,
+		Credentials:                 config.Credentials{TenantID: "tenant"},
+		CredentialPool: []config.PoolCredential{
+			{Name: "reader-2", ClientID: "reader2", ClientSecret: "secret"},
+			{Name: "reader-3", ClientID: "broken", ClientSecret: "secret"},
+		},
+	}
+	ac = NewAzureClient()
+	ac.tokens[sc.C.ResourceManagerURL] = accessToken{token: "token-primary", expiresOn: time.Now().Add(time.Hour)}
+
+	var got [][2]string
+	for i := 0; i < 6; i++ {
+		credential, authorization := ac.pooledAuthorization()
+		got = append(got, [2]string{credential, authorization})
+	}
+	want := [][2]string{
+		{"primary", "Bearer token-primary"},
+		{"reader-2", "Bearer token-reader2"},
+		{"primary", "Bearer token-primary"},
+		{"primary", "Bearer token-primary"},
+		{"reader-2", "Bearer token-reader2"},
+		{"primary", "Bearer token-primary"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected credentials\ngot: %v\nwant: %v", got, want)
+	}
+	// The tokens are reused and the failing credential isn't retried at once.
+	if want := map[string]int{"reader2": 1, "broken": 1}; !reflect.DeepEqual(tokenRequests, want) {
+		t.Errorf("unexpected token requests\ngot: %v\nwant: %v", tokenRequests, want)
+	}
+}
+
+func TestPooledAuthorizationSlowCredential(t *testing.T) {
+	requested, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		fmt.Fprintf(w, `{"access_token":"token-slow","expires_on":"%d"}`, time.Now().Add(time.Hour).Unix())
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{
+		ResourceManagerURL:          "https://management.azure.com",
+		ActiveDirectoryAuthorityURL: server.URL,
+		Credentials:                 config.Credentials{TenantID: "tenant"},
+		CredentialPool:              []config.PoolCredential{{Name: "slow", ClientID: "slow", ClientSecret: "secret"}},
+	}
+	ac = NewAzureClient()
+	ac.tokens[sc.C.ResourceManagerURL] = accessToken{token: "token-primary", expiresOn: time.Now().Add(time.Hour)}
+	ac.credentialPoolNext = 1
+
+	done := make(chan string)
+	go func() {
+		credential, _ := ac.pooledAuthorization()
+		done <- credential
+	}()
+	<-requested
+
+	// The primary credential is used while the slow credential authenticates.
+	if credential, _ := ac.pooledAuthorization(); credential != config.PrimaryCredential {
+		t.Errorf("unexpected credential %s", credential)
+	}
+	close(release)
+	if credential := <-done; credential != "slow" {
+		t.Errorf("unexpected credential %s", credential)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+// Credential methods of the credentials chain.
+const (
+	clientSecretCredential     = "client_secret"
+	workloadIdentityCredential = "workload_identity"
+	managedIdentityCredential  = "managed_identity"
+	cliCredential              = "cli"
+)
+
+var (
+	// azureCLI is the command of the Azure CLI.
+	azureCLI = "az"
+	// imdsTokenURL is the token endpoint of the Azure Instance Metadata Service.
+	imdsTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+// credentialChain returns the credential methods tried in order to get
+// access tokens. Without a configured auth type or chain, the client secret
+// is used when a client ID is configured and the managed identity otherwise.
+func credentialChain(c config.Credentials) []string {
+	if c.AuthType != "" {
+		return []string{c.AuthType}
+	}
+	if len(c.Chain) > 0 {
+		return c.Chain
+	}
+	if c.ClientID != "" {
+		return []string{clientSecretCredential}
+	}
+	return []string{managedIdentityCredential}
+}
+
+// tokenFrom requests an access token for the resource with a credential
+// method.
+func (ac *AzureClient) tokenFrom(method string, resource string) (accessToken, error) {
+	switch method {
+	case clientSecretCredential:
+		return ac.clientSecretToken(resource)
+	case workloadIdentityCredential:
+		return ac.workloadIdentityToken(resource)
+	case managedIdentityCredential:
+		return ac.managedIdentityToken(resource)
+	case cliCredential:
+		return cliToken(resource)
+	default:
+		return accessToken{}, fmt.Errorf("unknown credential method %q", method)
+	}
+}
+
+func (ac *AzureClient) clientSecretToken(resource string) (accessToken, error) {
+	credentials := sc.Get().Credentials
+	if credentials.ClientID == "" {
+		return accessToken{}, fmt.Errorf("client_id is not configured")
+	}
+	secret, err := ac.clientSecret()
+	if err != nil {
+		return accessToken{}, err
+	}
+	return ac.clientCredentialsToken(credentials.TenantID, credentials.ClientID, secret, resource)
+}
+
+// clientCredentialsToken requests an access token for the resource with the
+// client secret of a service principal.
+func (ac *AzureClient) clientCredentialsToken(tenantID string, clientID string, secret string, resource string) (accessToken, error) {
+	target := fmt.Sprintf("%s/%s/oauth2/token", sc.Get().ActiveDirectoryAuthorityURL, tenantID)
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"resource":      {resource},
+		"client_id":     {clientID},
+		"client_secret": {secret},
+	}
+	resp, err := ac.clientFor(tokenEndpoints).PostForm(target, form)
+	return readTokenResponse(resp, err)
+}
+
+// workloadIdentityToken exchanges the federated token of a workload identity
+// (e.g. Azure Workload Identity on AKS), configured by environment variables,
+// for an access token.
+func (ac *AzureClient) workloadIdentityToken(resource string) (accessToken, error) {
+	tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+	if tokenFile == "" {
+		return accessToken{}, fmt.Errorf("AZURE_FEDERATED_TOKEN_FILE is not set")
+	}
+	assertion, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return accessToken{}, fmt.Errorf("Error reading federated token file: %v", err)
+	}
+
+	cfg := sc.Get()
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	if clientID == "" {
+		clientID = cfg.Credentials.ClientID
+	}
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	if tenantID == "" {
+		tenantID = cfg.Credentials.TenantID
+	}
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = cfg.ActiveDirectoryAuthorityURL
+	}
+
+	target := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authority, "/"), tenantID)
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"scope":                 {strings.TrimSuffix(resource, "/") + "/.default"},
+		"client_id":             {clientID},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+	}
+	resp, err := ac.clientFor(tokenEndpoints).PostForm(target, form)
+	return readTokenResponse(resp, err)
+}
+
+// managedIdentityToken requests an access token from the managed identity
+// endpoint. The client ID selects a user-assigned identity.
+func (ac *AzureClient) managedIdentityToken(resource string) (accessToken, error) {
+	target := fmt.Sprintf("%s?resource=%s&api-version=2018-02-01", imdsTokenURL, url.QueryEscape(resource))
+	if clientID := sc.Get().Credentials.ClientID; clientID != "" {
+		target = fmt.Sprintf("%s&client_id=%s", target, url.QueryEscape(clientID))
+	}
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return accessToken{}, fmt.Errorf("Error getting token against Azure MSI endpoint: %v", err)
+	}
+	req.Header.Add("Metadata", "true")
+	resp, err := ac.clientFor(tokenEndpoints).Do(req)
+	return readTokenResponse(resp, err)
+}
+
+// cliToken gets an access token from the Azure CLI of the logged in user.
+func cliToken(resource string) (accessToken, error) {
+	args := []string{"account", "get-access-token", "--resource", resource, "--output", "json"}
+	if tenantID := sc.Get().Credentials.TenantID; tenantID != "" {
+		args = append(args, "--tenant", tenantID)
+	}
+	ctx := context.Background()
+	if timeout := requestTimeout(tokenEndpoints); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	out, err := exec.CommandContext(ctx, azureCLI, args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return accessToken{}, fmt.Errorf("Error running Azure CLI: %v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return accessToken{}, fmt.Errorf("Error running Azure CLI: %v", err)
+	}
+
+	var data struct {
+		AccessToken string      `json:"accessToken"`
+		ExpiresOn   string      `json:"expiresOn"`
+		ExpiresOnTS json.Number `json:"expires_on"`
+	}
+	if err := json.Unmarshal(out, &data); err != nil {
+		return accessToken{}, fmt.Errorf("Error unmarshalling Azure CLI output: %v", err)
+	}
+
+	token := accessToken{token: data.AccessToken}
+	if ts, err := data.ExpiresOnTS.Int64(); err == nil {
+		token.expiresOn = time.Unix(ts, 0).UTC()
+	} else {
+		// Older versions only give the expiry in local time.
+		expiresOn, err := time.ParseInLocation("2006-01-02 15:04:05.999999", data.ExpiresOn, time.Local)
+		if err != nil {
+			return accessToken{}, fmt.Errorf("Error parsing expiry of Azure CLI token: %v", err)
+		}
+		token.expiresOn = expiresOn.UTC()
+	}
+	return token, nil
+}
+
+// cliSubscription returns the ID of the current subscription of the Azure
+// CLI.
+func cliSubscription() (string, error) {
+	out, err := exec.Command(azureCLI, "account", "show", "--output", "json").Output()
+	if err != nil {
+		return "", fmt.Errorf("Error running Azure CLI: %v", err)
+	}
+	var account struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(out, &account); err != nil {
+		return "", fmt.Errorf("Error unmarshalling Azure CLI output: %v", err)
+	}
+	return account.ID, nil
+}
+
+// tokenClockSkew is the tolerated difference between the local clock and the
+// clock of Azure AD when validating access tokens.
+const tokenClockSkew = 5 * time.Minute
+
+var tenantIDPattern = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
+// validateToken checks the claims of an access token before its first use, so
+// that tokens for another audience or tenant, or rejected because of a clock
+// skew, are reported precisely instead of failing later requests with 401.
+// Tokens that aren't JWTs can't be validated.
+func validateToken(token string, resource string, tenantID string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		debugf("Access token isn't a JWT, skipping its validation")
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return fmt.Errorf("Error decoding access token claims: %v", err)
+	}
+	var claims struct {
+		Audience  string `json:"aud"`
+		TenantID  string `json:"tid"`
+		ExpiresAt int64  `json:"exp"`
+		NotBefore int64  `json:"nbf"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return fmt.Errorf("Error unmarshalling access token claims: %v", err)
+	}
+
+	if claims.Audience != "" && !strings.EqualFold(strings.TrimSuffix(claims.Audience, "/"), strings.TrimSuffix(resource, "/")) {
+		return fmt.Errorf("access token audience %s doesn't match the requested resource %s", claims.Audience, resource)
+	}
+	if tenantIDPattern.MatchString(tenantID) && claims.TenantID != "" && !strings.EqualFold(claims.TenantID, tenantID) {
+		return fmt.Errorf("access token issued for tenant %s instead of the configured tenant %s", claims.TenantID, tenantID)
+	}
+	if claims.ExpiresAt != 0 {
+		if expiresAt := time.Unix(claims.ExpiresAt, 0).UTC(); now.After(expiresAt.Add(tokenClockSkew)) {
+			return fmt.Errorf("access token expired at %s, the local clock (%s) may be ahead", expiresAt.Format(time.RFC3339), now.Format(time.RFC3339))
+		}
+	}
+	if claims.NotBefore != 0 {
+		if notBefore := time.Unix(claims.NotBefore, 0).UTC(); now.Before(notBefore.Add(-tokenClockSkew)) {
+			return fmt.Errorf("access token not valid before %s, the local clock (%s) may be behind", notBefore.Format(time.RFC3339), now.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// readTokenResponse reads an access token response of Azure AD or of the
+// managed identity endpoint, which give the expiry either as a timestamp or
+// as a lifetime in seconds.
+func readTokenResponse(resp *http.Response, err error) (accessToken, error) {
+	if err != nil {
+		return accessToken{}, fmt.Errorf("Error authenticating against Azure API: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		recordThrottling("token", resp.Header.Get("Retry-After"))
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return accessToken{}, fmt.Errorf("Error reading body of response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return accessToken{}, newAPIError(resp.StatusCode, body)
+	}
+
+	var data struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresOn   json.Number `json:"expires_on"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return accessToken{}, fmt.Errorf("Error unmarshalling response body: %v", err)
+	}
+
+	token := accessToken{token: data.AccessToken}
+	if expiresOn, err := data.ExpiresOn.Int64(); err == nil {
+		token.expiresOn = time.Unix(expiresOn, 0).UTC()
+	} else if expiresIn, err := data.ExpiresIn.Int64(); err == nil {
+		token.expiresOn = time.Now().Add(time.Duration(expiresIn) * time.Second).UTC()
+	} else {
+		return accessToken{}, fmt.Errorf("Error ParseInt of expires_on failed: %v", err)
+	}
+	return token, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestFetchAccessTokenChain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/imds":
+			if r.Header.Get("Metadata") != "true" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"access_token":"msi","expires_on":"%d"}`, time.Now().Add(time.Hour).Unix())
+		case "/tenant/oauth2/v2.0/token":
+			if r.FormValue("client_assertion") != "federated" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"workload","expires_in":3600}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	previousURL := imdsTokenURL
+	previous := sc.C
+	defer func() { imdsTokenURL, sc.C = previousURL, previous }()
+	imdsTokenURL = server.URL + "/imds"
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("federated\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		chain     []string
+		tokenFile string
+		want      string
+	}{
+		{[]string{"client_secret", "managed_identity"}, "", "msi"},
+		{[]string{"workload_identity", "managed_identity"}, "", "msi"},
+		{[]string{"workload_identity", "managed_identity"}, tokenFile, "workload"},
+	}
+	for _, c := range cases {
+		os.Setenv("AZURE_FEDERATED_TOKEN_FILE", c.tokenFile)
+		sc.C = &config.Config{
+			ActiveDirectoryAuthorityURL: server.URL,
+			Credentials:                 config.Credentials{TenantID: "tenant", Chain: c.chain},
+		}
+		client := NewAzureClient()
+		if err := client.getAccessToken(); err != nil {
+			t.Errorf("unexpected error with chain %v: %v", c.chain, err)
+			continue
+		}
+		if got := client.tokens[sc.C.ResourceManagerURL].token; got != c.want {
+			t.Errorf("doesn't use expected credentials of chain %v\ngot: %v\nwant: %v", c.chain, got, c.want)
+		}
+	}
+	os.Unsetenv("AZURE_FEDERATED_TOKEN_FILE")
+}
+
+func TestCLIToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "az")
+	content := `#!/bin/sh
+case "$2" in
+get-access-token) echo '{"accessToken": "cli", "expiresOn": "2030-01-01 00:00:00.000000", "expires_on": 1893456000}' ;;
+show) echo '{"id": "00000000-0000-0000-0000-000000000000"}' ;;
+esac
+`
+	if err := ioutil.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	previousCLI := azureCLI
+	previous := sc.C
+	defer func() { azureCLI, sc.C = previousCLI, previous }()
+	azureCLI = script
+	sc.C = &config.Config{Credentials: config.Credentials{AuthType: "cli"}}
+
+	token, err := cliToken("https://management.azure.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.token != "cli" || !token.expiresOn.Equal(time.Unix(1893456000, 0)) {
+		t.Errorf("doesn't read Azure CLI token\ngot: %v", token)
+	}
+
+	subscriptionID, err := cliSubscription()
+	if err != nil || subscriptionID != "00000000-0000-0000-0000-000000000000" {
+		t.Errorf("doesn't read Azure CLI subscription\ngot: %v, %v", subscriptionID, err)
+	}
+}
+
+func TestValidateToken(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	jwt := func(claims string) string {
+		return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+	}
+	tenant := "72f988bf-86f1-41af-91ab-2d7cd011db47"
+
+	cases := []struct {
+		token string
+		want  string
+	}{
+		{"opaque", ""},
+		{jwt(fmt.Sprintf(`{"aud":"https://management.azure.com","tid":"%s","nbf":%d,"exp":%d}`, tenant, now.Unix()-60, now.Unix()+3600)), ""},
+		{jwt(fmt.Sprintf(`{"aud":"https://management.azure.com/","exp":%d}`, now.Unix()-60)), ""},
+		{jwt(`{"aud":"https://graph.microsoft.com"}`), "audience"},
+		{jwt(`{"aud":"https://management.azure.com/","tid":"00000000-0000-0000-0000-000000000000"}`), "tenant"},
+		{jwt(fmt.Sprintf(`{"aud":"https://management.azure.com/","exp":%d}`, now.Unix()-3600)), "local clock"},
+		{jwt(fmt.Sprintf(`{"aud":"https://management.azure.com/","nbf":%d}`, now.Unix()+3600)), "may be behind"},
+	}
+	for _, c := range cases {
+		err := validateToken(c.token, "https://management.azure.com/", tenant, now)
+		if (err == nil) != (c.want == "") || (err != nil && !strings.Contains(err.Error(), c.want)) {
+			t.Errorf("doesn't validate token %s\ngot: %v\nwant: %q", c.token, err, c.want)
+		}
+	}
+}
+
+func TestPrivateEndpointTokenAudience(t *testing.T) {
+	var resources []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resources = append(resources, r.FormValue("resource"))
+		claims := fmt.Sprintf(`{"aud":%q,"exp":%d}`, r.FormValue("resource"), time.Now().Add(time.Hour).Unix())
+		token := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+		fmt.Fprintf(w, `{"access_token":%q,"expires_on":"%d"}`, token, time.Now().Add(time.Hour).Unix())
+	}))
+	defer server.Close()
+
+	previous := sc.C
+	defer func() { sc.C = previous }()
+
+	for _, c := range []struct {
+		config config.Config
+		want   string
+	}{
+		{config.Config{ResourceManagerURL: "https://management.azure.com/"}, "https://management.azure.com/"},
+		{config.Config{ResourceManagerURL: "https://10.0.0.4/", ResourceManagerHost: "management.azure.com"}, "https://management.azure.com/"},
+		{config.Config{ResourceManagerURL: "https://10.0.0.4/", ResourceManagerHost: "10.0.0.4", TokenAudience: "https://management.core.windows.net/"}, "https://management.core.windows.net/"},
+	} {
+		resources = nil
+		cfg := c.config
+		cfg.ActiveDirectoryAuthorityURL = server.URL
+		cfg.Credentials = config.Credentials{TenantID: "tenant", ClientID: "client", ClientSecret: "secret"}
+		sc.C = &cfg
+
+		client := NewAzureClient()
+		if err := client.getAccessToken(); err != nil {
+			t.Errorf("unexpected error with %s: %v", cfg.ResourceManagerURL, err)
+			continue
+		}
+		if len(resources) != 1 || resources[0] != c.want {
+			t.Errorf("unexpected token resource\ngot: %v\nwant: %s", resources, c.want)
+		}
+		if client.authorization() == "Bearer " {
+			t.Errorf("token of %s isn't used for the requests to %s", c.want, cfg.ResourceManagerURL)
+		}
+	}
+}
+
+func TestClientSecretFileRotation(t *testing.T) {
+	var secrets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secrets = append(secrets, r.FormValue("client_secret"))
+		fmt.Fprintf(w, `{"access_token":"token","expires_on":"%d"}`, time.Now().Add(time.Hour).Unix())
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{
+		ActiveDirectoryAuthorityURL: server.URL,
+		Credentials:                 config.Credentials{ClientID: "client", TenantID: "tenant", ClientSecretFile: secretFile},
+	}
+	client := NewAzureClient()
+	resource := sc.C.ResourceManagerAudience()
+
+	for i := 0; i < 2; i++ {
+		if err := client.refreshAccessTokenFor(resource); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// A rotation of the secret renews the token before it expires.
+	if err := ioutil.WriteFile(secretFile, []byte("new\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rotated := time.Now().Add(time.Minute)
+	if err := os.Chtimes(secretFile, rotated, rotated); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := client.refreshAccessTokenFor(resource); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if want := []string{"old", "new"}; !reflect.DeepEqual(secrets, want) {
+		t.Errorf("unexpected client secrets of the token requests\ngot: %q\nwant: %q", secrets, want)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dataPlaneBatchSize is the maximum number of resources of a metrics:getBatch call.
+const dataPlaneBatchSize = 50
+
+// DataPlaneBatchResponse represents a response of the metrics:getBatch API.
+type DataPlaneBatchResponse struct {
+	Values []struct {
+		ResourceID     string `json:"resourceid"`
+		ResourceRegion string `json:"resourceregion"`
+		AzureMetricValueResponse
+	} `json:"values"`
+}
+
+// dataPlaneQuery identifies the resources sharing the query parameters of a
+// metrics:getBatch call.
+type dataPlaneQuery struct {
+	subscription    string
+	metricNamespace string
+	metrics         string
+	aggregations    string
+	filter          string
+	interval        time.Duration
+	timespan        time.Duration
+}
+
+// groupDataPlaneResources groups the resources that can be queried together.
+// Resources without an explicit metric namespace are grouped by resource type,
+// which is their default metric namespace.
+func groupDataPlaneResources(resources []resourceMeta) (map[dataPlaneQuery][]resourceMeta, []dataPlaneQuery) {
+	groups := map[dataPlaneQuery][]resourceMeta{}
+	var queries []dataPlaneQuery
+	for _, rm := range resources {
+		q := dataPlaneQuery{
+			subscription:    subscriptionOf(rm),
+			metricNamespace: metricNamespaceOf(rm),
+			metrics:         rm.metrics,
+			aggregations:    strings.Join(filterAggregations(rm.aggregations), ","),
+			filter:          dimensionFilter(rm.dimensions),
+			interval:        rm.interval,
+			timespan:        rm.timespan,
+		}
+		if _, ok := groups[q]; !ok {
+			queries = append(queries, q)
+		}
+		groups[q] = append(groups[q], rm)
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		return fmt.Sprint(queries[i]) < fmt.Sprint(queries[j])
+	})
+	return groups, queries
+}
+
+// batchCollectDataPlaneMetrics collects the metrics of the resources with the
+// metrics:getBatch API of the regional endpoints of the metrics data plane.
+// Resources without a known region, resources of the fallback types, resources
+// with query parameters and resources rejected by the data plane are collected
+// through ARM instead.
+func (c *Collector) batchCollectDataPlaneMetrics(ch chan<- prometheus.Metric, resources []resourceMeta, publishedResources map[string]bool, apiErrors apiErrorSet) {
+	if err := ac.refreshAccessTokenFor(c.cfg.MetricsDataPlane.Audience); err != nil {
+		c.logf("%v", err)
+		ch <- prometheus.NewInvalidMetric(azureErrorDesc, err)
+		return
+	}
+
+	var armResources []resourceMeta
+	regions := map[string][]resourceMeta{}
+	for _, rm := range resources {
+		region := strings.ToLower(strings.Replace(rm.resource.Location, " ", "", -1))
+		if region == "" || len(rm.queryParameters) > 0 || containsFold(c.cfg.MetricsDataPlane.FallbackResourceTypes, GetResourceType(rm.resourceURL)) {
+			armResources = append(armResources, rm)
+			continue
+		}
+		regions[region] = append(regions[region], rm)
+	}
+
+	var regionNames []string
+	for region := range regions {
+		regionNames = append(regionNames, region)
+	}
+	sort.Strings(regionNames)
+
+	for _, region := range regionNames {
+		endpoint := strings.Replace(c.cfg.MetricsDataPlane.URL, "{region}", region, -1)
+		groups, queries := groupDataPlaneResources(regions[region])
+		for _, q := range queries {
+			group := groups[q]
+			for i := 0; i < len(group); i += dataPlaneBatchSize {
+				j := i + dataPlaneBatchSize
+				if j > len(group) {
+					j = len(group)
+				}
+				rejected := c.collectDataPlaneBatch(ch, endpoint, q, group[i:j], publishedResources, apiErrors)
+				armResources = append(armResources, rejected...)
+			}
+		}
+	}
+
+	if len(armResources) > 0 {
+		c.batchCollectMetrics(ch, armResources, publishedResources, apiErrors)
+	}
+}
+
+// collectDataPlaneBatch collects the metrics of a batch of resources sharing
+// a region and a query. It returns the resources rejected by the data plane.
+func (c *Collector) collectDataPlaneBatch(ch chan<- prometheus.Metric, endpoint string, q dataPlaneQuery, batch []resourceMeta, publishedResources map[string]bool, apiErrors apiErrorSet) []resourceMeta {
+	var resourceIDs []string
+	for _, rm := range batch {
+		resourceIDs = append(resourceIDs, dataPlaneResourceID(rm))
+	}
+
+	start := time.Now()
+	data, err := ac.getDataPlaneBatch(endpoint, q, resourceIDs, c.scrapeID)
+	c.recordTiming("dataplane", batch, start)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest {
+			c.logf("Metrics of namespace %s rejected by %s, falling back to ARM: %v", q.metricNamespace, endpoint, err)
+			return batch
+		}
+		c.logf("Failed to get metrics from %s for %d resources of namespace %s: %v", endpoint, len(batch), q.metricNamespace, err)
+		for _, rm := range batch {
+			apiErrors.add(errorCode(err), rm.resourceID)
+			c.failResource(rm, errorCode(err))
+		}
+		return nil
+	}
+
+	values := map[string]AzureMetricValueResponse{}
+	for _, v := range data.Values {
+		values[strings.ToLower(v.ResourceID)] = v.AzureMetricValueResponse
+	}
+	for k, rm := range batch {
+		value, ok := values[strings.ToLower(resourceIDs[k])]
+		if !ok {
+			c.addEmptyResponse(rm, emptyNotFound, fmt.Sprintf("No metrics returned by %s for resource %s", endpoint, rm.resourceID))
+			emptyResources.record(rm, true, time.Now())
+			continue
+		}
+		c.extractMetrics(ch, rm, http.StatusOK, value, publishedResources, apiErrors)
+	}
+	return nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// dataPlaneResourceID returns the full resource ID expected by the data plane.
+func dataPlaneResourceID(rm resourceMeta) string {
+	return fmt.Sprintf("/subscriptions/%s%s", subscriptionOf(rm), rm.resourceID)
+}
+
+// Returns the metrics of the resources from the metrics:getBatch API
+func (ac *AzureClient) getDataPlaneBatch(endpoint string, q dataPlaneQuery, resourceIDs []string, scrapeID string) (*DataPlaneBatchResponse, error) {
+	apiVersion := "2023-10-01"
+	endTime, startTime := GetTimes(q.timespan)
+
+	values := url.Values{}
+	values.Add("metricnamespace", q.metricNamespace)
+	values.Add("metricnames", q.metrics)
+	values.Add("aggregation", q.aggregations)
+	if q.filter != "" {
+		values.Add("filter", q.filter)
+	}
+	values.Add("starttime", startTime)
+	values.Add("endtime", endTime)
+	if q.interval != 0 {
+		values.Add("interval", isoDuration(q.interval))
+	}
+	values.Add("api-version", apiVersion)
+	target := fmt.Sprintf("%s/subscriptions/%s/metrics:getBatch?%s",
+		strings.TrimSuffix(endpoint, "/"), q.subscription, values.Encode())
+
+	requestBody, err := json.Marshal(map[string][]string{"resourceids": resourceIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", target, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", ac.authorizationFor(sc.Get().MetricsDataPlane.Audience))
+	setCorrelationHeader(req, scrapeID)
+
+	resp, err := ac.clientFor(metricsEndpoints).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		recordThrottling("batch", resp.Header.Get("Retry-After"))
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading body of response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp.StatusCode, body)
+	}
+
+	var data DataPlaneBatchResponse
+	if err := decodeLenient("batch", body, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGroupDataPlaneResources(t *testing.T) {
+	vm := "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm/providers/microsoft.insights/metrics"
+	resources := []resourceMeta{
+		{resourceID: "vm1", resourceURL: vm, metrics: "Percentage CPU"},
+		{resourceID: "vm2", resourceURL: vm, metrics: "Percentage CPU"},
+		{resourceID: "vm3", resourceURL: vm, metrics: "Percentage CPU", aggregations: []string{"Average"}},
+		{resourceID: "vm4", resourceURL: vm, metrics: "Percentage CPU", metricNamespace: "Azure.VM.Windows.GuestMetrics"},
+	}
+
+	groups, queries := groupDataPlaneResources(resources)
+	if len(queries) != 3 {
+		t.Fatalf("doesn't group resources by query\ngot: %v", queries)
+	}
+	q := dataPlaneQuery{
+		metricNamespace: "Microsoft.Compute/virtualMachines",
+		metrics:         "Percentage CPU",
+		aggregations:    "Total,Average,Minimum,Maximum",
+	}
+	if len(groups[q]) != 2 {
+		t.Errorf("doesn't group resources sharing a query\ngot: %v", groups[q])
+	}
+}
+
+func TestDataPlaneBatchResponseUnmarshal(t *testing.T) {
+	body := `{"values":[{"resourceid":"/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1","resourceregion":"eastus","value":[{"name":{"value":"Percentage CPU"},"unit":"Percent","timeseries":[{"data":[{"timestamp":"2023-10-01T00:00:00Z","average":4.2}]}]}]}]}`
+
+	var data DataPlaneBatchResponse
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(data.Values) != 1 || data.Values[0].ResourceRegion != "eastus" || len(data.Values[0].Value) != 1 {
+		t.Fatalf("doesn't unmarshal expected values\ngot: %+v", data)
+	}
+	if got := data.Values[0].Value[0].Timeseries[0].Data[0].Average; got != 4.2 {
+		t.Errorf("doesn't unmarshal expected datapoint\ngot: %v\nwant: 4.2", got)
+	}
+}
+
+func TestBatchCollectDataPlaneMetrics(t *testing.T) {
+	var (
+		mtx       sync.Mutex
+		dataPlane = map[string][]string{}
+		arm       []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `{"value": []}`)
+			return
+		}
+		if r.URL.Path == "/batch" {
+			var batch batchBody
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+				t.Error(err)
+			}
+			for _, req := range batch.Requests {
+				arm = append(arm, strings.SplitN(req.RelativeURL, "/providers/microsoft.insights", 2)[0])
+			}
+			fmt.Fprint(w, `{"responses": []}`)
+			return
+		}
+
+		var body struct {
+			ResourceIDs []string `json:"resourceids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		dataPlane[r.URL.Path] = append(dataPlane[r.URL.Path], body.ResourceIDs...)
+		if strings.HasPrefix(r.URL.Path, "/westeurope/") {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": {"code": "BadRequest", "message": "Metric namespace not supported"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"values": [{"resourceid": "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1", "resourceregion": "eastus",
+			"value": [{"name": {"value": "Percentage CPU"}, "unit": "Percent", "timeseries": [{"data": [{"timeStamp": "2023-10-01T00:00:00Z", "average": 4.2}]}]}]}]}`)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{
+		ResourceManagerURL: server.URL,
+		Credentials:        config.Credentials{SubscriptionID: "abc"},
+		MetricsDataPlane: config.MetricsDataPlane{
+			Enabled:               true,
+			URL:                   server.URL + "/{region}",
+			Audience:              server.URL,
+			FallbackResourceTypes: []string{"Microsoft.Storage/storageAccounts"},
+		},
+	}
+	ac = NewAzureClient()
+	ac.tokens[server.URL] = accessToken{token: "token", expiresOn: time.Now().Add(time.Hour)}
+
+	resource := func(id string, location string) resourceMeta {
+		return resourceMeta{
+			resourceID:   id,
+			resourceURL:  resourceURLFrom("abc", id, "", "Percentage CPU", []string{"Average"}, nil, nil, 0, 0),
+			metrics:      "Percentage CPU",
+			aggregations: []string{"Average"},
+			resource:     AzureResource{ID: id, Location: location},
+			resourceInfo: config.ResourceInfo{Skip: true},
+		}
+	}
+	resources := []resourceMeta{
+		resource("/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1", "East US"),
+		resource("/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm2", "westeurope"),
+		resource("/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm3", ""),
+		resource("/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa1", "eastus"),
+	}
+
+	ch := make(chan prometheus.Metric, 100)
+	(&Collector{cfg: sc.C}).batchCollectDataPlaneMetrics(ch, resources, map[string]bool{}, apiErrorSet{})
+	close(ch)
+	got := metricValues(t, ch)
+
+	wantDataPlane := map[string][]string{
+		"/eastus/subscriptions/abc/metrics:getBatch":     {"/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"},
+		"/westeurope/subscriptions/abc/metrics:getBatch": {"/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm2"},
+	}
+	if !reflect.DeepEqual(dataPlane, wantDataPlane) {
+		t.Errorf("unexpected data plane requests\ngot: %v\nwant: %v", dataPlane, wantDataPlane)
+	}
+	// The resources without region and of the fallback types are requested
+	// from ARM, along with the resources rejected by the data plane.
+	wantARM := []string{
+		"/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm3",
+		"/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa1",
+		"/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm2",
+	}
+	if !reflect.DeepEqual(arm, wantARM) {
+		t.Errorf("unexpected ARM batch requests\ngot: %v\nwant: %v", arm, wantARM)
+	}
+	if want := map[string]float64{"percentage_cpu_percent_average{rg,vm1}": 4.2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected metrics from the data plane\ngot: %v\nwant: %v", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxPayloadSample limits the size of the payload samples logged on decoding
+// warnings.
+const maxPayloadSample = 512
+
+// jsonFloat is a float64 decoded from a JSON number, a numeric string or null.
+type jsonFloat float64
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (f *jsonFloat) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" || s == "" {
+		*f = 0
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: string(data), Type: reflect.TypeOf(f).Elem()}
+	}
+	*f = jsonFloat(v)
+	return nil
+}
+
+// decodeLenient decodes an Azure response from an endpoint class. Unknown
+// fields are ignored and fields of unexpected types are left unset, so that
+// a drift of the response schema doesn't discard the whole response. Such
+// drifts are counted and a sample of the payload is logged in debug mode.
+func decodeLenient(endpoint string, data []byte, v interface{}) error {
+	err := json.Unmarshal(data, v)
+	if err == nil {
+		return nil
+	}
+
+	decodeWarningsTotal.WithLabelValues(endpoint).Inc()
+	debugf("Unexpected %s response payload: %v: %s", endpoint, err, payloadSample(data))
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		log.Printf("Ignoring field of unexpected type in %s response: %v", endpoint, err)
+		return nil
+	}
+	return fmt.Errorf("Error unmarshalling response body: %v", err)
+}
+
+func payloadSample(data []byte) string {
+	if len(data) > maxPayloadSample {
+		return string(data[:maxPayloadSample]) + "..."
+	}
+	return string(data)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDecodeLenient(t *testing.T) {
+	payload := []byte(`{"value": [{"timeseries": [{"data": [
+		{"timeStamp": "2020-01-01T00:00:00Z", "total": "12.5", "average": null, "minimum": 1, "maximum": 3, "count": 2}
+	]}], "name": {"value": "Requests"}, "unit": ["Count"]}]}`)
+
+	var data AzureMetricValueResponse
+	if err := decodeLenient("batch", payload, &data); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	value := data.Value[0]
+	if value.Name.Value != "Requests" {
+		t.Errorf("doesn't decode fields following a type mismatch\ngot: %v\nwant: %v", value.Name.Value, "Requests")
+	}
+	point := value.Timeseries[0].Data[0]
+	if point.Total != 12.5 || point.Average != 0 || point.Maximum != 3 {
+		t.Errorf("doesn't coerce metric values\ngot: %v", point)
+	}
+
+	if err := decodeLenient("batch", []byte(`{"value": [`), &data); err == nil {
+		t.Errorf("doesn't fail on truncated payload")
+	}
+}
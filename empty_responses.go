@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Reasons of the empty responses of Azure, as in
+// azure_exporter_empty_responses_total.
+const (
+	emptyNotFound = "not_found"
+	emptyNoData   = "no_data"
+)
+
+// Logging modes of the empty responses, see --log.empty-responses.
+const (
+	logEmptyEach    = "each"
+	logEmptySummary = "summary"
+	logEmptyNone    = "none"
+)
+
+// emptyResponseSet counts the empty responses of the scrape by reason and
+// block. In large fleets, many resources have metrics which are never
+// emitted, so they are logged as one summary per scrape.
+type emptyResponseSet map[[2]string]int
+
+// addEmptyResponse counts an empty response for the metrics of a resource, logging it with
+// --log.empty-responses=each and with --log.debug.
+func (c *Collector) addEmptyResponse(rm resourceMeta, reason string, message string) {
+	emptyResponsesTotal.WithLabelValues(reason).Inc()
+	if *logEmptyResponses == logEmptyEach {
+		c.logf("%s", message)
+	} else {
+		debugf("scrape=%s %s", c.scrapeID, message)
+	}
+	if c.emptyResponses == nil {
+		c.emptyResponses = emptyResponseSet{}
+	}
+	c.emptyResponses[[2]string{reason, rm.block}]++
+}
+
+// summary returns the counts of the empty responses by reason and block,
+// e.g. not_found: 12 (db-prod: 10, resource_tags[1]: 2).
+func (s emptyResponseSet) summary() string {
+	blocks := map[string][]string{}
+	totals := map[string]int{}
+	for k, n := range s {
+		block := k[1]
+		if block == "" {
+			block = "unknown"
+		}
+		blocks[k[0]] = append(blocks[k[0]], fmt.Sprintf("%s: %d", block, n))
+		totals[k[0]] += n
+	}
+	var reasons []string
+	for reason, counts := range blocks {
+		sort.Strings(counts)
+		reasons = append(reasons, fmt.Sprintf("%s: %d (%s)", reason, totals[reason], strings.Join(counts, ", ")))
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, ", ")
+}
+
+// logEmptyResponses logs the summary of the empty responses of the scrape.
+func (c *Collector) logEmptyResponses() {
+	if len(c.emptyResponses) == 0 || *logEmptyResponses != logEmptySummary {
+		return
+	}
+	total := 0
+	for _, n := range c.emptyResponses {
+		total += n
+	}
+	c.logf("Azure returned no metrics for %d requests, by reason and block: %s", total, c.emptyResponses.summary())
+}
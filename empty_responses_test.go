@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestEmptyResponses(t *testing.T) {
+	previous, previousMode := sc.C, *logEmptyResponses
+	defer func() { sc.C, *logEmptyResponses = previous, previousMode }()
+	sc.C = &config.Config{}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var notFound, noData AzureMetricValueResponse
+	if err := json.Unmarshal([]byte(`{"value": []}`), &notFound); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"value": [{"name": {"value": "Percentage CPU"}, "unit": "Percent", "timeseries": [{"data": []}]}]}`), &noData); err != nil {
+		t.Fatal(err)
+	}
+	rm := func(block string, name string) resourceMeta {
+		return resourceMeta{
+			resourceID:   "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/" + name,
+			resourceURL:  "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/" + name + "/providers/microsoft.insights/metrics",
+			block:        block,
+			metrics:      "Percentage CPU",
+			resourceInfo: config.ResourceInfo{Skip: true},
+		}
+	}
+
+	for _, mode := range []string{logEmptySummary, logEmptyEach, logEmptyNone} {
+		*logEmptyResponses = mode
+		buf.Reset()
+		notFoundBefore := counterValue(t, emptyResponsesTotal.WithLabelValues(emptyNotFound))
+		noDataBefore := counterValue(t, emptyResponsesTotal.WithLabelValues(emptyNoData))
+
+		c := &Collector{scrapeID: "abc", cfg: sc.C}
+		ch := make(chan prometheus.Metric, 10)
+		c.extractMetrics(ch, rm("db-prod", "vm1"), 200, notFound, map[string]bool{}, apiErrorSet{})
+		c.extractMetrics(ch, rm("db-prod", "vm2"), 200, notFound, map[string]bool{}, apiErrorSet{})
+		c.extractMetrics(ch, rm("resource_tags[1]", "vm3"), 200, notFound, map[string]bool{}, apiErrorSet{})
+		c.extractMetrics(ch, rm("db-prod", "vm4"), 200, noData, map[string]bool{}, apiErrorSet{})
+		c.logEmptyResponses()
+
+		if got := counterValue(t, emptyResponsesTotal.WithLabelValues(emptyNotFound)) - notFoundBefore; got != 3 {
+			t.Errorf("unexpected number of responses not found with %s\ngot: %v\nwant: 3", mode, got)
+		}
+		if got := counterValue(t, emptyResponsesTotal.WithLabelValues(emptyNoData)) - noDataBefore; got != 1 {
+			t.Errorf("unexpected number of responses without data with %s\ngot: %v\nwant: 1", mode, got)
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if buf.Len() == 0 {
+			lines = nil
+		}
+		switch mode {
+		case logEmptySummary:
+			want := "scrape=abc Azure returned no metrics for 4 requests, by reason and block: no_data: 1 (db-prod: 1), not_found: 3 (db-prod: 2, resource_tags[1]: 1)"
+			if len(lines) != 1 || !strings.HasSuffix(lines[0], want) {
+				t.Errorf("unexpected summary\ngot: %q\nwant: %q", lines, want)
+			}
+		case logEmptyEach:
+			if len(lines) != 4 || !strings.HasSuffix(lines[0], "scrape=abc Metric Percentage CPU not found at target "+rm("db-prod", "vm1").resourceURL) {
+				t.Errorf("unexpected log lines %q", lines)
+			}
+		case logEmptyNone:
+			if len(lines) != 0 {
+				t.Errorf("unexpected log lines %q", lines)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import "sync"
+
+// flightGroup coalesces concurrent calls with the same key, so that
+// concurrent scrapes share a single Azure request instead of repeating it.
+type flightGroup struct {
+	mtx   sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// do calls fn and returns its results, unless a call with the same key is
+// already in flight, whose results are then returned once it completes.
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mtx.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mtx.Unlock()
+		call.wg.Wait()
+		return call.val, call.err
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mtx.Unlock()
+
+	call.val, call.err = fn()
+	call.wg.Done()
+
+	g.mtx.Lock()
+	delete(g.calls, key)
+	g.mtx.Unlock()
+	return call.val, call.err
+}
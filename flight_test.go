@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupDo(t *testing.T) {
+	var g flightGroup
+	var calls int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := g.do("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "value", nil
+			})
+			if v != "value" || err != nil {
+				t.Errorf("unexpected result: %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("concurrent calls weren't coalesced\ngot: %d calls\nwant: 1", calls)
+	}
+
+	if v, _ := g.do("key", func() (interface{}, error) { return "again", nil }); v != "again" {
+		t.Errorf("completed call was reused\ngot: %v\nwant: again", v)
+	}
+}
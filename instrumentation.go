@@ -0,0 +1,303 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	apiThrottledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_api_throttled_total",
+			Help: "Number of Azure API requests rejected with status 429",
+		},
+		[]string{"endpoint", "subscription"},
+	)
+	apiRetryAfterSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azure_api_retry_after_seconds",
+			Help: "Delay requested by Azure with the Retry-After header of the last throttled request",
+		},
+		[]string{"endpoint", "subscription"},
+	)
+	credentialRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_exporter_credential_requests_total",
+			Help: "Number of direct GET requests to Azure Resource Manager by credential of the credential pool",
+		},
+		[]string{"credential"},
+	)
+	credentialThrottledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_exporter_credential_throttled_total",
+			Help: "Number of direct GET requests to Azure Resource Manager rejected with status 429 by credential of the credential pool",
+		},
+		[]string{"credential"},
+	)
+	decodeWarningsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_exporter_decode_warnings_total",
+			Help: "Number of Azure API responses that didn't match the expected schema",
+		},
+		[]string{"endpoint"},
+	)
+	resourceScrapeDuration = prometheus.NewSummary(
+		prometheus.SummaryOpts{
+			Name:       "azure_resource_scrape_duration_seconds",
+			Help:       "Duration of the Azure requests collecting the metrics of each resource",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		},
+	)
+	apiRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "azure_api_request_duration_seconds",
+			Help:    "Duration of the Azure API requests until their response headers, by endpoint class and HTTP status",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		},
+		[]string{"endpoint", "status"},
+	)
+	apiVersionsRefreshesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_exporter_api_versions_refreshes_total",
+			Help: "Number of listings of the API versions of the resource providers, by result: updated, not_modified or error",
+		},
+		[]string{"result"},
+	)
+	emptyResponsesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_exporter_empty_responses_total",
+			Help: "Number of Azure metrics responses without metrics (not_found) or without datapoints (no_data)",
+		},
+		[]string{"reason"},
+	)
+	clampedSamplesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_exporter_clamped_samples_total",
+			Help: "Number of Azure metric samples overridden by the clamp of their metric, by reason: nan_value, below_min or above_max",
+		},
+		[]string{"reason"},
+	)
+	batchMismatchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_exporter_batch_response_mismatches_total",
+			Help: "Number of Azure batch sub-responses out of order, unknown or missing",
+		},
+		[]string{"reason"},
+	)
+	staleDatapointsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "azure_exporter_stale_datapoints_total",
+			Help: "Number of Azure datapoints rejected as older than max_datapoint_age",
+		},
+	)
+	suspectSamplesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_exporter_suspect_samples_total",
+			Help: "Number of Azure metric samples out of the range of their unit, percent above 100 or negative bytes",
+		},
+		[]string{"reason"},
+	)
+	scheduledEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_scheduled_events_total",
+			Help: "Number of scheduled events of the virtual machine running the exporter, e.g. Spot evictions (Preempt)",
+		},
+		[]string{"event_type"},
+	)
+	scrapeSamplesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "azure_exporter_scrape_samples_total",
+			Help: "Number of samples exposed on /metrics",
+		},
+	)
+	scrapeResponseBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_exporter_scrape_response_bytes_total",
+			Help: "Number of bytes of the /metrics response bodies, after compression",
+		},
+		[]string{"encoding"},
+	)
+	scrapesRejectedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "azure_exporter_scrapes_rejected_total",
+			Help: "Number of scrapes rejected as exceeding --web.max-requests",
+		},
+	)
+	clockOffsetSeconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azure_exporter_clock_offset_seconds",
+			Help: "Estimated offset of the clock of Azure from the clock of the host, from the Date header of the Azure responses",
+		},
+	)
+	configHash = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azure_exporter_config_hash",
+			Help: "Hash of the loaded configuration files",
+		},
+	)
+	configTargets = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azure_exporter_config_targets",
+			Help: "Number of targets entries of the loaded configuration",
+		},
+	)
+	configResourceGroups = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azure_exporter_config_resource_groups",
+			Help: "Number of resource_groups entries of the loaded configuration",
+		},
+	)
+	configResourceTags = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azure_exporter_config_resource_tags",
+			Help: "Number of resource_tags entries of the loaded configuration",
+		},
+	)
+	configLastReloadSuccessful = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azure_exporter_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful (1) or not (0)",
+		},
+	)
+	configLastReloadSuccessTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azure_exporter_config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful configuration reload",
+		},
+	)
+)
+
+// exporterCollectors returns the collectors of the exporter's own metrics.
+func exporterCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		apiThrottledTotal,
+		apiRetryAfterSeconds,
+		credentialRequestsTotal,
+		credentialThrottledTotal,
+		decodeWarningsTotal,
+		resourceScrapeDuration,
+		apiRequestDuration,
+		apiVersionsRefreshesTotal,
+		configHash,
+		configTargets,
+		configResourceGroups,
+		configResourceTags,
+		configLastReloadSuccessful,
+		configLastReloadSuccessTimestamp,
+		staleDatapointsTotal,
+		suspectSamplesTotal,
+		clampedSamplesTotal,
+		emptyResponsesTotal,
+		scheduledEventsTotal,
+		batchMismatchesTotal,
+		scrapeSamplesTotal,
+		scrapeResponseBytesTotal,
+		scrapesRejectedTotal,
+		clockOffsetSeconds,
+	}
+}
+
+// runtimeCollectors returns the collectors of the Go runtime and process
+// metrics of the exporter, when enabled.
+func runtimeCollectors(goRuntime, process bool) []prometheus.Collector {
+	var collectors []prometheus.Collector
+	if goRuntime {
+		collectors = append(collectors, prometheus.NewGoCollector())
+	}
+	if process {
+		collectors = append(collectors, prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	}
+	return collectors
+}
+
+// sampleCount returns the number of samples of the metric families in the
+// exposition format.
+func sampleCount(mfs []*dto.MetricFamily) int {
+	samples := 0
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			switch {
+			case m.Histogram != nil:
+				// The buckets, the +Inf bucket, the sum and the count.
+				samples += len(m.Histogram.Bucket) + 3
+			case m.Summary != nil:
+				samples += len(m.Summary.Quantile) + 2
+			default:
+				samples++
+			}
+		}
+	}
+	return samples
+}
+
+// countingResponseWriter counts the bytes of a response body.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	bytes int
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// record counts the response body by its content encoding.
+func (w *countingResponseWriter) record() {
+	encoding := w.Header().Get("Content-Encoding")
+	if encoding == "" {
+		encoding = "identity"
+	}
+	scrapeResponseBytesTotal.WithLabelValues(encoding).Add(float64(w.bytes))
+}
+
+// recordThrottling counts a throttled request to an Azure endpoint class
+// (batch, resources or token) and the delay requested by Azure.
+func recordThrottling(endpoint string, retryAfter string) {
+	subscription := sc.Get().Credentials.SubscriptionID
+	apiThrottledTotal.WithLabelValues(endpoint, subscription).Inc()
+	if delay, ok := parseRetryAfter(retryAfter, time.Now()); ok {
+		apiRetryAfterSeconds.WithLabelValues(endpoint, subscription).Set(delay.Seconds())
+	}
+}
+
+// durationTransport observes the duration of the requests of an endpoint
+// class in azure_api_request_duration_seconds, the status being "error" for
+// the requests failing without response.
+type durationTransport struct {
+	class string
+	next  http.RoundTripper
+}
+
+func (t durationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	apiRequestDuration.WithLabelValues(t.class, status).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// parseRetryAfter parses a Retry-After header value, given either in seconds
+// or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	var cases = []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"17", 17 * time.Second, true},
+		{"Tue, 01 Oct 2019 12:01:00 GMT", time.Minute, true},
+		{"Tue, 01 Oct 2019 11:59:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+
+	for _, c := range cases {
+		got, ok := parseRetryAfter(c.value, now)
+
+		if got != c.want || ok != c.ok {
+			t.Errorf("doesn't parse Retry-After %q as expected\ngot: %v, %v\nwant: %v, %v", c.value, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestSampleCount(t *testing.T) {
+	mfs := []*dto.MetricFamily{
+		{Metric: []*dto.Metric{{Gauge: &dto.Gauge{}}, {Gauge: &dto.Gauge{}}}},
+		{Metric: []*dto.Metric{{Summary: &dto.Summary{Quantile: make([]*dto.Quantile, 3)}}}},
+		{Metric: []*dto.Metric{{Histogram: &dto.Histogram{Bucket: make([]*dto.Bucket, 2)}}}},
+	}
+	if got := sampleCount(mfs); got != 12 {
+		t.Errorf("unexpected sample count\ngot: %d\nwant: 12", got)
+	}
+}
+
+func TestCountingResponseWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &countingResponseWriter{ResponseWriter: rec}
+	w.Header().Set("Content-Encoding", "gzip")
+	fmt.Fprint(w, "hello")
+	fmt.Fprint(w, "world")
+
+	before := responseBytes(t, "gzip")
+	w.record()
+	if got := responseBytes(t, "gzip") - before; got != 10 {
+		t.Errorf("unexpected response bytes\ngot: %v\nwant: 10", got)
+	}
+}
+
+func responseBytes(t *testing.T, encoding string) float64 {
+	var metric dto.Metric
+	if err := scrapeResponseBytesTotal.WithLabelValues(encoding).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestRuntimeCollectors(t *testing.T) {
+	if got := runtimeCollectors(false, false); len(got) != 0 {
+		t.Errorf("unexpected collectors when disabled: %v", got)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(runtimeCollectors(true, false)...)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if !strings.HasPrefix(mf.GetName(), "go_") {
+			t.Errorf("unexpected metric %s", mf.GetName())
+		}
+	}
+	if len(mfs) == 0 {
+		t.Errorf("Go runtime metrics aren't exposed")
+	}
+}
+
+func TestDurationTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	sampleCount := func(class, status string) uint64 {
+		var metric dto.Metric
+		if err := apiRequestDuration.WithLabelValues(class, status).(prometheus.Histogram).Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		return metric.GetHistogram().GetSampleCount()
+	}
+	throttled, failed := sampleCount("lookup", "429"), sampleCount("lookup", "error")
+
+	client := &http.Client{Transport: durationTransport{class: "lookup", next: http.DefaultTransport}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if _, err := client.Get("http://127.0.0.1:0"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if got := sampleCount("lookup", "429") - throttled; got != 1 {
+		t.Errorf("unexpected requests with status 429\ngot: %d\nwant: 1", got)
+	}
+	if got := sampleCount("lookup", "error") - failed; got != 1 {
+		t.Errorf("unexpected failed requests\ngot: %d\nwant: 1", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+// joinedLabels returns the labels of a resource with the names of the related
+// resources given by its join rules. Joins whose property isn't set on the
+// resource are ignored.
+func joinedLabels(rm resourceMeta) map[string]string {
+	if len(rm.joins) == 0 {
+		return rm.labels
+	}
+
+	labels := map[string]string{}
+	for name, value := range rm.labels {
+		labels[name] = value
+	}
+	for _, j := range rm.joins {
+		id := joinProperty(rm.resource, j.Property)
+		if id == "" {
+			continue
+		}
+		labels[j.Label] = relatedResourceName(id)
+	}
+	return labels
+}
+
+// joinProperty returns the value of a property of a resource, given either as
+// managedBy or as a path below properties or sku, e.g. properties.serverFarmId.
+func joinProperty(resource AzureResource, property string) string {
+	if strings.EqualFold(property, "managedBy") {
+		return resource.ManagedBy
+	}
+	s, _ := resourceProperty(resource, property).(string)
+	return s
+}
+
+// resourceProperty returns the value of a property of a resource given as a
+// path below properties or sku, or nil when it isn't set.
+func resourceProperty(resource AzureResource, property string) interface{} {
+	path := strings.Split(property, ".")
+	if len(path) < 2 {
+		return nil
+	}
+	var value interface{}
+	switch {
+	case strings.EqualFold(path[0], "properties"):
+		value = resource.Properties
+	case strings.EqualFold(path[0], "sku"):
+		value = resource.Sku
+	default:
+		return nil
+	}
+	for _, name := range path[1:] {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = nil
+		for k, v := range object {
+			if strings.EqualFold(k, name) {
+				value = v
+				break
+			}
+		}
+	}
+	return value
+}
+
+// relatedResourceName returns the name of the resource with the given ID.
+func relatedResourceName(id string) string {
+	id = strings.TrimSuffix(id, "/")
+	return id[strings.LastIndex(id, "/")+1:]
+}
+
+// needsLookup reports whether the join rules use properties of the resources
+// that are only returned by a lookup of each resource.
+func needsLookup(joins []config.Join) bool {
+	for _, j := range joins {
+		if !strings.EqualFold(j.Property, "managedBy") {
+			return true
+		}
+	}
+	return false
+}
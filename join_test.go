@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestJoinedLabels(t *testing.T) {
+	resource := AzureResource{
+		ManagedBy: "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1",
+		Properties: map[string]interface{}{
+			"serverFarmId": "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/serverfarms/plan-1",
+			"sku":          map[string]interface{}{"tier": "Premium"},
+		},
+	}
+
+	var tests = []struct {
+		joins []config.Join
+		want  map[string]string
+	}{
+		{
+			joins: []config.Join{{Property: "managedBy", Label: "vm_name"}},
+			want:  map[string]string{"env": "prod", "vm_name": "vm-1"},
+		},
+		{
+			joins: []config.Join{{Property: "properties.serverfarmid", Label: "app_service_plan"}},
+			want:  map[string]string{"env": "prod", "app_service_plan": "plan-1"},
+		},
+		{
+			joins: []config.Join{{Property: "properties.sku.tier", Label: "tier"}},
+			want:  map[string]string{"env": "prod", "tier": "Premium"},
+		},
+		{
+			joins: []config.Join{{Property: "properties.missing", Label: "missing"}, {Property: "properties.sku", Label: "sku"}},
+			want:  map[string]string{"env": "prod"},
+		},
+	}
+
+	for _, test := range tests {
+		rm := resourceMeta{labels: map[string]string{"env": "prod"}, joins: test.joins, resource: resource}
+		got := joinedLabels(rm)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("doesn't join labels for %v\ngot: %v\nwant: %v", test.joins, got, test.want)
+		}
+		if len(rm.labels) != 1 {
+			t.Errorf("modifies the labels of the resource: %v", rm.labels)
+		}
+	}
+}
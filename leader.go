@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// lease is the content of the leader election lock file.
+type lease struct {
+	Holder    string    `json:"holder"`
+	RenewTime time.Time `json:"renew_time"`
+}
+
+// leaderElector elects a single exporter instance polling Azure among the
+// instances sharing a lock file. The leader renews its lease periodically and
+// another instance takes over once the lease expires. The lease is only
+// updated by the instance holding the update lock, a file created exclusively
+// next to the lease file, so that concurrent instances can't both take it.
+type leaderElector struct {
+	sync.RWMutex
+	path          string
+	id            string
+	leaseDuration time.Duration
+	leader        bool
+}
+
+func newLeaderElector(path string, id string, leaseDuration time.Duration) *leaderElector {
+	if id == "" {
+		hostname, _ := os.Hostname()
+		id = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	return &leaderElector{
+		path:          path,
+		id:            id,
+		leaseDuration: leaseDuration,
+	}
+}
+
+// run tries to acquire or renew the lease until the process exits.
+func (le *leaderElector) run() {
+	for {
+		le.tryAcquireOrRenew()
+		time.Sleep(le.leaseDuration / 3)
+	}
+}
+
+func (le *leaderElector) isLeader() bool {
+	le.RLock()
+	defer le.RUnlock()
+	return le.leader
+}
+
+func (le *leaderElector) tryAcquireOrRenew() {
+	leader, err := le.acquire(time.Now().UTC())
+	if err != nil {
+		log.Printf("Error during leader election: %v", err)
+	}
+
+	le.Lock()
+	defer le.Unlock()
+	if leader != le.leader {
+		if leader {
+			log.Printf("Acquired leader lease %s as %s, polling Azure", le.path, le.id)
+		} else {
+			log.Printf("Lost leader lease %s, no longer polling Azure", le.path)
+		}
+	}
+	le.leader = leader
+}
+
+func (le *leaderElector) acquire(now time.Time) (bool, error) {
+	unlock, err := le.lock()
+	if err != nil {
+		return false, err
+	}
+	if unlock == nil {
+		// Another instance is updating the lease, the leadership is
+		// unchanged until the next try.
+		return le.isLeader(), nil
+	}
+	defer unlock()
+
+	current, err := le.read()
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if current != nil && current.Holder != le.id && now.Before(current.RenewTime.Add(le.leaseDuration)) {
+		return false, nil
+	}
+
+	if err := le.write(lease{Holder: le.id, RenewTime: now}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lock creates the update lock of the lease, holding the ID of the instance
+// and its creation time, and returns the function releasing it, or nil when
+// the lock is held by another instance. A lock older than the lease duration
+// was left by an instance which stopped while updating the lease, and is
+// taken over.
+func (le *leaderElector) lock() (func(), error) {
+	path := le.path + ".lock"
+	owner, err := json.Marshal(lease{Holder: le.id, RenewTime: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		if !le.removeStaleLock(path) {
+			return nil, nil
+		}
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error creating lease lock file: %v", err)
+	}
+	_, err = f.Write(owner)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("Error writing lease lock file: %v", err)
+	}
+
+	// The lock is only removed while it's still ours, it may have been
+	// taken over if this instance stalled for longer than the lease.
+	return func() {
+		if data, err := ioutil.ReadFile(path); err == nil && bytes.Equal(data, owner) {
+			os.Remove(path)
+		}
+	}, nil
+}
+
+// removeStaleLock removes the lock when it's older than the lease duration,
+// and reports whether it did. The lock is first renamed to a name of this
+// instance, which only one of the instances finding it stale can do, and is
+// put back when another instance replaced it by its own lock meanwhile.
+func (le *leaderElector) removeStaleLock(path string) bool {
+	stale := func(p string) bool {
+		info, err := os.Stat(p)
+		return err == nil && time.Since(info.ModTime()) > le.leaseDuration
+	}
+	if !stale(path) {
+		return false
+	}
+
+	taken := fmt.Sprintf("%s.%s", path, url.PathEscape(le.id))
+	if err := os.Rename(path, taken); err != nil {
+		return false
+	}
+	defer os.Remove(taken)
+	if !stale(taken) {
+		os.Link(taken, path)
+		return false
+	}
+	log.Printf("Removing stale leader lease lock %s", path)
+	return true
+}
+
+func (le *leaderElector) read() (*lease, error) {
+	data, err := ioutil.ReadFile(le.path)
+	if err != nil {
+		return nil, err
+	}
+	var l lease
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling lease file %s: %v", le.path, err)
+	}
+	return &l, nil
+}
+
+func (le *leaderElector) write(l lease) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(le.path), filepath.Base(le.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error creating lease file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Error writing lease file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Error writing lease file: %v", err)
+	}
+	return os.Rename(tmp.Name(), le.path)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLeaderElectorAcquire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azure_leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lease")
+	a := newLeaderElector(path, "a", time.Minute)
+	b := newLeaderElector(path, "b", time.Minute)
+	now := time.Now().UTC()
+
+	var cases = []struct {
+		elector *leaderElector
+		now     time.Time
+		want    bool
+	}{
+		{a, now, true},
+		{b, now.Add(30 * time.Second), false},
+		{a, now.Add(45 * time.Second), true},
+		{b, now.Add(90 * time.Second), false},
+		{b, now.Add(2 * time.Minute), true},
+		{a, now.Add(2 * time.Minute), false},
+	}
+
+	for i, c := range cases {
+		got, err := c.elector.acquire(c.now)
+		if err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+		if got != c.want {
+			t.Errorf("step %d: %s doesn't get expected leadership\ngot: %v\nwant: %v", i, c.elector.id, got, c.want)
+		}
+		c.elector.leader = got
+	}
+
+	// The lease isn't taken while another instance holds its update lock.
+	if err := ioutil.WriteFile(path+".lock", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := a.acquire(now.Add(5 * time.Minute)); err != nil || got {
+		t.Errorf("a takes the lease while it is locked: %v, %v", got, err)
+	}
+	if got, err := b.acquire(now.Add(5 * time.Minute)); err != nil || !got {
+		t.Errorf("b loses the lease while it is locked: %v, %v", got, err)
+	}
+
+	// A stale lock is taken over.
+	stale := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(path+".lock", stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := a.acquire(now.Add(5 * time.Minute)); err != nil || !got {
+		t.Errorf("a doesn't take the expired lease after the stale lock: %v, %v", got, err)
+	}
+}
+
+func TestLeaderElectorStaleLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azure_leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lease")
+	lockPath := path + ".lock"
+	a := newLeaderElector(path, "a", time.Minute)
+	b := newLeaderElector(path, "b", time.Minute)
+	stale := time.Now().Add(-2 * time.Minute)
+
+	// Only one of the instances finding the stale lock takes it over.
+	for i := 0; i < 50; i++ {
+		if err := ioutil.WriteFile(lockPath, []byte(`{"holder":"c"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(lockPath, stale, stale); err != nil {
+			t.Fatal(err)
+		}
+
+		unlocks := make(chan func(), 2)
+		var wg sync.WaitGroup
+		for _, le := range []*leaderElector{a, b} {
+			wg.Add(1)
+			go func(le *leaderElector) {
+				defer wg.Done()
+				unlock, err := le.lock()
+				if err != nil {
+					t.Error(err)
+				}
+				unlocks <- unlock
+			}(le)
+		}
+		wg.Wait()
+		close(unlocks)
+
+		held := 0
+		for unlock := range unlocks {
+			if unlock != nil {
+				held++
+				unlock()
+			}
+		}
+		if held != 1 {
+			t.Fatalf("round %d: the stale lock is taken by %d instances", i, held)
+		}
+		if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+			t.Fatalf("round %d: the lock isn't released: %v", i, err)
+		}
+	}
+
+	// An instance stalled past the lease doesn't release the lock taken over
+	// by another one.
+	unlockA, err := a.lock()
+	if err != nil || unlockA == nil {
+		t.Fatalf("a doesn't take the lock: %v", err)
+	}
+	if err := os.Chtimes(lockPath, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	unlockB, err := b.lock()
+	if err != nil || unlockB == nil {
+		t.Fatalf("b doesn't take over the stale lock: %v", err)
+	}
+	unlockA()
+	if got, err := b.lock(); err != nil || got != nil {
+		t.Errorf("a released the lock of b: %v", err)
+	}
+	unlockB()
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("b doesn't release its lock: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// blockLimiter limits the metrics batch requests of the resources of a
+// resource_groups or resource_tags block, whose batches are then requested
+// independently of the other resources. It's shared by concurrent scrapes.
+type blockLimiter struct {
+	maxInFlight       int
+	requestsPerSecond float64
+
+	slots chan struct{}
+
+	mtx  sync.Mutex
+	next time.Time
+}
+
+func newBlockLimiter(maxInFlight int, requestsPerSecond float64) *blockLimiter {
+	return &blockLimiter{
+		maxInFlight:       maxInFlight,
+		requestsPerSecond: requestsPerSecond,
+		slots:             make(chan struct{}, maxInFlight),
+	}
+}
+
+// acquire waits for an in-flight slot and for the next request allowed by the
+// rate limit. The slot must be released once the response has been handled.
+func (l *blockLimiter) acquire() {
+	l.slots <- struct{}{}
+	if l.requestsPerSecond <= 0 {
+		return
+	}
+
+	l.mtx.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(time.Second) / l.requestsPerSecond))
+	l.mtx.Unlock()
+
+	time.Sleep(wait)
+}
+
+func (l *blockLimiter) release() {
+	<-l.slots
+}
+
+// blockLimiters holds the limiters of the blocks by block, e.g.
+// resource_groups[2]. A limiter is replaced when its limits change.
+type blockLimiters struct {
+	sync.Mutex
+	limiters map[string]*blockLimiter
+}
+
+func newBlockLimiters() *blockLimiters {
+	return &blockLimiters{limiters: map[string]*blockLimiter{}}
+}
+
+// get returns the limiter of a block, or nil for blocks without limits. A
+// single batch is in flight at once by default.
+func (b *blockLimiters) get(block string, maxInFlight int, requestsPerSecond float64) *blockLimiter {
+	if maxInFlight == 0 && requestsPerSecond == 0 {
+		return nil
+	}
+	if maxInFlight == 0 {
+		maxInFlight = 1
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	l, ok := b.limiters[block]
+	if !ok || l.maxInFlight != maxInFlight || l.requestsPerSecond != requestsPerSecond {
+		l = newBlockLimiter(maxInFlight, requestsPerSecond)
+		b.limiters[block] = l
+	}
+	return l
+}
+
+// scrapeLimiter limits the concurrent collections of /metrics, the scrapes
+// exceeding the limit are rejected rather than queued. A nil scrapeLimiter
+// doesn't limit the scrapes.
+type scrapeLimiter struct {
+	slots chan struct{}
+}
+
+func newScrapeLimiter(maxRequests int) *scrapeLimiter {
+	if maxRequests <= 0 {
+		return nil
+	}
+	return &scrapeLimiter{slots: make(chan struct{}, maxRequests)}
+}
+
+// tryAcquire takes a slot without waiting and reports whether one was free.
+// The slot must be released once the scrape is complete.
+func (l *scrapeLimiter) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *scrapeLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBlockLimiterMaxInFlight(t *testing.T) {
+	l := newBlockLimiter(2, 0)
+
+	var inFlight, max int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.acquire()
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+			l.release()
+		}()
+	}
+	wg.Wait()
+
+	if max > 2 {
+		t.Errorf("doesn't limit the requests in flight, got %d at once, want at most 2", max)
+	}
+}
+
+func TestBlockLimiterRequestsPerSecond(t *testing.T) {
+	l := newBlockLimiter(5, 50)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		l.acquire()
+		l.release()
+	}
+	// The first request isn't delayed, the next four are 20ms apart.
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("doesn't pace the requests, 5 requests took %v", elapsed)
+	}
+}
+
+func TestBlockLimitersGet(t *testing.T) {
+	b := newBlockLimiters()
+
+	if l := b.get("resource_groups[0]", 0, 0); l != nil {
+		t.Errorf("returns a limiter for a block without limits")
+	}
+
+	l := b.get("resource_groups[0]", 0, 2)
+	if l == nil || l.maxInFlight != 1 {
+		t.Fatalf("doesn't default max_in_flight to 1, got %+v", l)
+	}
+	if b.get("resource_groups[0]", 1, 2) != l {
+		t.Errorf("doesn't share the limiter of a block between scrapes")
+	}
+	if b.get("resource_groups[0]", 4, 2) == l {
+		t.Errorf("doesn't replace the limiter of a block when its limits change")
+	}
+	if b.get("resource_tags[0]", 4, 2) == b.get("resource_groups[0]", 4, 2) {
+		t.Errorf("shares a limiter between blocks")
+	}
+}
+
+func TestScrapeLimiter(t *testing.T) {
+	var unlimited *scrapeLimiter
+	if newScrapeLimiter(0) != nil || !unlimited.tryAcquire() {
+		t.Errorf("scrapes are limited without --web.max-requests")
+	}
+	unlimited.release()
+
+	l := newScrapeLimiter(1)
+	if !l.tryAcquire() {
+		t.Fatalf("first scrape is rejected")
+	}
+	if l.tryAcquire() {
+		t.Errorf("concurrent scrape exceeding the limit is accepted")
+	}
+	l.release()
+	if !l.tryAcquire() {
+		t.Errorf("slot isn't released")
+	}
+}
+
+func TestHandlerMaxRequests(t *testing.T) {
+	previous := scrapes
+	defer func() { scrapes = previous }()
+	scrapes = newScrapeLimiter(1)
+	scrapes.tryAcquire()
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("scrape exceeding --web.max-requests isn't rejected\ngot: %d\nwant: 503", rec.Code)
+	}
+}
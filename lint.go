@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// lintConfig writes the effective settings of the targets, resource groups
+// and resource tags of the configuration, once anchors, defaults and targets
+// files are expanded, and returns the warnings about the configuration.
+func lintConfig(c *config.Config, w io.Writer) ([]string, error) {
+	entries, warnings := lintEntries(c)
+	out, err := yaml.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(out)
+	return warnings, err
+}
+
+// lintEntries returns the effective settings of the blocks of the
+// configuration and the sorted warnings about them.
+func lintEntries(c *config.Config) ([]yaml.MapSlice, []string) {
+	var entries []yaml.MapSlice
+	var warnings []string
+
+	// Blocks of the targets by resource and metric namespace.
+	targetBlocks := map[string][]string{}
+	targetAggregations := map[string][]string{}
+	for i, t := range c.Targets {
+		block := config.BlockName(config.TargetsBlock, i, t.Name)
+		for _, e := range expandTargets([]config.Target{t}) {
+			entries = append(entries, effectiveSettings(block, yaml.MapItem{Key: "resource", Value: e.Resource},
+				e.MetricNamespace, e.Metrics, e.Aggregations, e.Interval, e.Timespan, e.Dimensions, e.Labels))
+
+			key := strings.ToLower(e.Resource + "|" + e.MetricNamespace)
+			aggregations := filterAggregations(e.Aggregations)
+			if previous, ok := targetAggregations[key]; ok && !reflect.DeepEqual(previous, aggregations) {
+				warnings = append(warnings, fmt.Sprintf("%s requests the aggregations %v of resource %s, which %s requests with the aggregations %v",
+					block, aggregations, e.Resource, targetBlocks[key][0], previous))
+			}
+			if _, ok := targetAggregations[key]; !ok {
+				targetAggregations[key] = aggregations
+			}
+			targetBlocks[key] = append(targetBlocks[key], block)
+		}
+	}
+	for _, blocks := range targetBlocks {
+		if len(blocks) > 1 {
+			warnings = append(warnings, fmt.Sprintf("Resource is targeted more than once, by %s", strings.Join(blocks, ", ")))
+		}
+	}
+
+	groupBlocks := map[string][]string{}
+	for i, g := range c.ResourceGroups {
+		block := config.BlockName(config.ResourceGroupsBlock, i, g.Name)
+		entries = append(entries, effectiveSettings(block, yaml.MapItem{Key: "resource_group", Value: g.ResourceGroup},
+			g.MetricNamespace, g.Metrics, g.Aggregations, g.Interval, g.Timespan, g.Dimensions, g.Labels))
+		for _, resourceType := range g.ResourceTypes {
+			key := strings.ToLower(g.ResourceGroup + "|" + resourceType + "|" + g.MetricNamespace)
+			groupBlocks[key] = append(groupBlocks[key], block)
+		}
+	}
+	for key, blocks := range groupBlocks {
+		if len(blocks) > 1 {
+			warnings = append(warnings, fmt.Sprintf("Resource type %s of resource group %s is collected more than once, by %s",
+				strings.Split(key, "|")[1], strings.Split(key, "|")[0], strings.Join(blocks, ", ")))
+		}
+	}
+
+	for i, t := range c.ResourceTags {
+		block := config.BlockName(config.ResourceTagsBlock, i, t.Name)
+		entries = append(entries, effectiveSettings(block, yaml.MapItem{Key: "resource_tag", Value: t.ResourceTagName + "=" + t.ResourceTagValue},
+			t.MetricNamespace, t.Metrics, t.Aggregations, t.Interval, t.Timespan, t.Dimensions, t.Labels))
+	}
+
+	sort.Strings(warnings)
+	return entries, warnings
+}
+
+// effectiveSettings returns the settings of a block as used by the scrapes.
+func effectiveSettings(block string, scope yaml.MapItem, metricNamespace string, metrics []config.Metric, aggregations []string, interval time.Duration, timespan time.Duration, dimensions []config.Dimension, labels map[string]string) yaml.MapSlice {
+	var metricNames, dimensionNames []string
+	for _, m := range metrics {
+		metricNames = append(metricNames, m.Name)
+	}
+	for _, d := range dimensions {
+		dimensionNames = append(dimensionNames, d.Name)
+	}
+	if timespan == 0 {
+		timespan = time.Minute
+	}
+
+	settings := yaml.MapSlice{{Key: "block", Value: block}, scope}
+	if metricNamespace != "" {
+		settings = append(settings, yaml.MapItem{Key: "metric_namespace", Value: metricNamespace})
+	}
+	settings = append(settings,
+		yaml.MapItem{Key: "metrics", Value: metricNames},
+		yaml.MapItem{Key: "aggregations", Value: filterAggregations(aggregations)},
+		yaml.MapItem{Key: "timespan", Value: timespan.String()},
+	)
+	if interval != 0 {
+		settings = append(settings, yaml.MapItem{Key: "interval", Value: interval.String()})
+	}
+	if len(dimensionNames) > 0 {
+		settings = append(settings, yaml.MapItem{Key: "dimensions", Value: dimensionNames})
+	}
+	if len(labels) > 0 {
+		settings = append(settings, yaml.MapItem{Key: "labels", Value: labels})
+	}
+	return settings
+}
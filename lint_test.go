@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestLintConfig(t *testing.T) {
+	c, err := config.Parse([]byte(`
+defaults:
+  timespan: 5m
+targets:
+  - &app
+    resource: /resourceGroups/rg/providers/Microsoft.Web/sites/app1
+    metrics: [{name: Requests}]
+  - <<: *app
+    aggregations: [Total]
+resource_groups:
+  - resource_group: rg
+    resource_types: [Microsoft.Web/sites]
+    metrics: [{name: Requests}]
+    labels: {team: web}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ApplyDefaults()
+
+	var out bytes.Buffer
+	warnings, err := lintConfig(c, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `- block: targets[0]
+  resource: /resourceGroups/rg/providers/Microsoft.Web/sites/app1
+  metrics:
+  - Requests
+  aggregations:
+  - Total
+  - Average
+  - Minimum
+  - Maximum
+  timespan: 5m0s
+- block: targets[1]
+  resource: /resourceGroups/rg/providers/Microsoft.Web/sites/app1
+  metrics:
+  - Requests
+  aggregations:
+  - Total
+  timespan: 5m0s
+- block: resource_groups[0]
+  resource_group: rg
+  metrics:
+  - Requests
+  aggregations:
+  - Total
+  - Average
+  - Minimum
+  - Maximum
+  timespan: 5m0s
+  labels:
+    team: web
+`
+	if out.String() != want {
+		t.Errorf("doesn't print the effective settings\ngot:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	wantWarnings := []string{
+		"Resource is targeted more than once, by targets[0], targets[1]",
+		"targets[1] requests the aggregations [Total] of resource /resourceGroups/rg/providers/Microsoft.Web/sites/app1, which targets[0] requests with the aggregations [Total Average Minimum Maximum]",
+	}
+	if !reflect.DeepEqual(warnings, wantWarnings) {
+		t.Errorf("doesn't warn about the duplicate targets\ngot: %s\nwant: %s", strings.Join(warnings, "\n"), strings.Join(wantWarnings, "\n"))
+	}
+}
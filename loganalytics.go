@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// logAnalyticsAPIVersion is the API version of the Log Analytics workspaces.
+const logAnalyticsAPIVersion = "2022-10-01"
+
+// tableUsageQuery sums the billable volume of each table ingested since the
+// start of the day (UTC), in GB as the Quantity of the Usage table is in MB.
+const tableUsageQuery = `Usage | where TimeGenerated > startofday(now()) | where IsBillable == true | summarize BillableGB = sum(Quantity) / 1000 by DataType`
+
+var (
+	logAnalyticsLabels            = []string{"workspace", "resource_group"}
+	logAnalyticsDailyCapDesc      = prometheus.NewDesc("azure_log_analytics_daily_cap_gb", "Daily cap of the data ingestion of a Log Analytics workspace, in GB", logAnalyticsLabels, nil)
+	logAnalyticsIngestionDesc     = prometheus.NewDesc("azure_log_analytics_ingestion_status", "Data ingestion status of a Log Analytics workspace, e.g. OverQuota once the daily cap is reached", append(append([]string{}, logAnalyticsLabels...), "status"), nil)
+	logAnalyticsUsageDesc         = prometheus.NewDesc("azure_log_analytics_usage", "Current usage of a quota of a Log Analytics workspace, e.g. DataAnalyzed for the volume ingested during the quota period", append(append([]string{}, logAnalyticsLabels...), "usage", "unit"), nil)
+	logAnalyticsUsageLimitDesc    = prometheus.NewDesc("azure_log_analytics_usage_limit", "Limit of a quota of a Log Analytics workspace", append(append([]string{}, logAnalyticsLabels...), "usage", "unit"), nil)
+	logAnalyticsTableBillableDesc = prometheus.NewDesc("azure_log_analytics_table_billable_gb", "Billable volume ingested in a table of a Log Analytics workspace since the start of the day (UTC), in GB", append(append([]string{}, logAnalyticsLabels...), "table"), nil)
+)
+
+// AzureLogAnalyticsWorkspaceListResponse is a page of the Log Analytics
+// workspaces API.
+type AzureLogAnalyticsWorkspaceListResponse struct {
+	Value []struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		Properties struct {
+			CustomerID       string `json:"customerId"`
+			WorkspaceCapping struct {
+				DailyQuotaGb        jsonFloat `json:"dailyQuotaGb"`
+				DataIngestionStatus string    `json:"dataIngestionStatus"`
+			} `json:"workspaceCapping"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+// AzureLogAnalyticsUsageResponse is the response of the usages API of a
+// workspace.
+type AzureLogAnalyticsUsageResponse struct {
+	Value []struct {
+		Name struct {
+			Value string `json:"value"`
+		} `json:"name"`
+		Unit         string    `json:"unit"`
+		CurrentValue jsonFloat `json:"currentValue"`
+		Limit        jsonFloat `json:"limit"`
+	} `json:"value"`
+}
+
+// LogAnalyticsQueryResponse is the response of the Log Analytics query API.
+type LogAnalyticsQueryResponse struct {
+	Tables []struct {
+		Columns []struct {
+			Name string `json:"name"`
+		} `json:"columns"`
+		Rows [][]interface{} `json:"rows"`
+	} `json:"tables"`
+}
+
+// collectLogAnalytics exposes the daily cap, the ingestion status and the
+// usages of the Log Analytics workspaces of the subscription, along with the
+// billable volume of their tables with table_usage.
+func (c *Collector) collectLogAnalytics(ch chan<- prometheus.Metric, apiErrors apiErrorSet) {
+	resourceManagerURL := strings.TrimSuffix(c.cfg.ResourceManagerURL, "/")
+	endpoint := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.OperationalInsights/workspaces?api-version=%s",
+		resourceManagerURL, c.cfg.Credentials.SubscriptionID, logAnalyticsAPIVersion)
+
+	var workspaces AzureLogAnalyticsWorkspaceListResponse
+	err := forEachPage(endpoint, c.scrapeID, func(body []byte) (string, error) {
+		var page AzureLogAnalyticsWorkspaceListResponse
+		if err := decodeLenient("log_analytics", body, &page); err != nil {
+			return "", err
+		}
+		workspaces.Value = append(workspaces.Value, page.Value...)
+		return page.NextLink, nil
+	})
+	if err != nil {
+		c.logf("Failed to get Log Analytics workspaces: %v", err)
+		apiErrors.add(errorCode(err), "log_analytics_workspaces")
+		return
+	}
+
+	for _, w := range workspaces.Value {
+		labels := []string{w.Name, resourceGroupOf(w.ID)}
+		capping := w.Properties.WorkspaceCapping
+		// Workspaces without daily cap have a negative quota.
+		if capping.DailyQuotaGb >= 0 {
+			ch <- prometheus.MustNewConstMetric(logAnalyticsDailyCapDesc, prometheus.GaugeValue, float64(capping.DailyQuotaGb), labels...)
+		}
+		if capping.DataIngestionStatus != "" {
+			ch <- prometheus.MustNewConstMetric(logAnalyticsIngestionDesc, prometheus.GaugeValue, 1, append(labels, capping.DataIngestionStatus)...)
+		}
+
+		body, err := getAzureMonitorResponse(fmt.Sprintf("%s%s/usages?api-version=2020-08-01", resourceManagerURL, w.ID), c.scrapeID)
+		if err != nil {
+			c.logf("Failed to get the usages of Log Analytics workspace %s: %v", w.Name, err)
+			apiErrors.add(errorCode(err), w.ID)
+			continue
+		}
+		var usages AzureLogAnalyticsUsageResponse
+		if err := decodeLenient("log_analytics", body, &usages); err != nil {
+			c.logf("Failed to get the usages of Log Analytics workspace %s: %v", w.Name, err)
+			continue
+		}
+		for _, u := range usages.Value {
+			usageLabels := append(append([]string{}, labels...), u.Name.Value, u.Unit)
+			ch <- prometheus.MustNewConstMetric(logAnalyticsUsageDesc, prometheus.GaugeValue, float64(u.CurrentValue), usageLabels...)
+			if u.Limit >= 0 {
+				ch <- prometheus.MustNewConstMetric(logAnalyticsUsageLimitDesc, prometheus.GaugeValue, float64(u.Limit), usageLabels...)
+			}
+		}
+
+		if c.cfg.LogAnalytics.TableUsage && w.Properties.CustomerID != "" {
+			tables, err := ac.queryTableUsage(w.Properties.CustomerID, c.scrapeID)
+			if err != nil {
+				c.logf("Failed to query the table usage of Log Analytics workspace %s: %v", w.Name, err)
+				apiErrors.add(errorCode(err), w.ID)
+				continue
+			}
+			for table, gb := range tables {
+				ch <- prometheus.MustNewConstMetric(logAnalyticsTableBillableDesc, prometheus.GaugeValue, gb, append(labels, table)...)
+			}
+		}
+	}
+}
+
+// queryLogAnalytics runs a query on a workspace with the Log Analytics query
+// API.
+func (ac *AzureClient) queryLogAnalytics(workspaceID string, query string, scrapeID string) (*LogAnalyticsQueryResponse, error) {
+	queryURL := sc.Get().LogAnalytics.QueryURL
+	if err := ac.refreshAccessTokenFor(queryURL); err != nil {
+		return nil, err
+	}
+
+	target := fmt.Sprintf("%s/v1/workspaces/%s/query?%s", strings.TrimSuffix(queryURL, "/"),
+		url.PathEscape(workspaceID), url.Values{"query": {query}}.Encode())
+	body, err := azureRequest("GET", target, ac.authorizationFor(queryURL), scrapeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var data LogAnalyticsQueryResponse
+	if err := decodeLenient("log_analytics_query", body, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// queryTableUsage returns the billable GB ingested in each table of the
+// workspace since the start of the day, which requires read access to the
+// Usage table of the workspace.
+func (ac *AzureClient) queryTableUsage(workspaceID string, scrapeID string) (map[string]float64, error) {
+	data, err := ac.queryLogAnalytics(workspaceID, tableUsageQuery, scrapeID)
+	if err != nil {
+		return nil, err
+	}
+	tables := map[string]float64{}
+	for _, t := range data.Tables {
+		dataType, billable := -1, -1
+		for i, column := range t.Columns {
+			switch column.Name {
+			case "DataType":
+				dataType = i
+			case "BillableGB":
+				billable = i
+			}
+		}
+		if dataType < 0 || billable < 0 {
+			continue
+		}
+		for _, row := range t.Rows {
+			if len(row) <= dataType || len(row) <= billable {
+				continue
+			}
+			table, _ := row[dataType].(string)
+			gb, ok := row[billable].(float64)
+			if table != "" && ok {
+				tables[table] = gb
+			}
+		}
+	}
+	return tables, nil
+}
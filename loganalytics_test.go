@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectLogAnalytics(t *testing.T) {
+	workspace := "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/logs"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/workspaces"):
+			fmt.Fprintf(w, `{"value": [{"id": %q, "name": "logs", "properties": {
+				"customerId": "0000-1111",
+				"workspaceCapping": {"dailyQuotaGb": 5, "dataIngestionStatus": "RespectQuota"}
+			}}]}`, workspace)
+		case r.URL.Path == workspace+"/usages":
+			fmt.Fprint(w, `{"value": [{"name": {"value": "DataAnalyzed"}, "unit": "Bytes", "currentValue": 1024, "limit": -1}]}`)
+		case r.URL.Path == "/v1/workspaces/0000-1111/query":
+			if r.URL.Query().Get("query") != tableUsageQuery {
+				t.Errorf("unexpected query %q", r.URL.Query().Get("query"))
+			}
+			fmt.Fprint(w, `{"tables": [{"columns": [{"name": "DataType"}, {"name": "BillableGB"}], "rows": [["Perf", 1.5], ["Syslog", 0.25]]}]}`)
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{
+		ResourceManagerURL: server.URL,
+		Credentials:        config.Credentials{SubscriptionID: "abc"},
+		LogAnalytics:       config.LogAnalytics{Enabled: true, TableUsage: true, QueryURL: server.URL},
+	}
+	ac = NewAzureClient()
+	ac.tokens[server.URL] = accessToken{token: "token", expiresOn: time.Now().Add(time.Hour)}
+
+	ch := make(chan prometheus.Metric, 20)
+	(&Collector{cfg: sc.C}).collectLogAnalytics(ch, apiErrorSet{})
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`azure_log_analytics_daily_cap_gb{rg,logs}`:                  5,
+		`azure_log_analytics_ingestion_status{rg,RespectQuota,logs}`: 1,
+		`azure_log_analytics_usage{rg,Bytes,DataAnalyzed,logs}`:      1024,
+		`azure_log_analytics_table_billable_gb{rg,Perf,logs}`:        1.5,
+		`azure_log_analytics_table_billable_gb{rg,Syslog,logs}`:      0.25,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't expose the workspace ingestion\ngot: %v\nwant: %v", got, want)
+	}
+}
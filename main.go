@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/percona/azure_metrics_exporter/config"
 
@@ -23,21 +27,93 @@ var (
 		C: &config.Config{},
 	}
 	ac                    = NewAzureClient()
-	configFile            = kingpin.Flag("config.file", "Azure exporter configuration file.").Default("azure.yml").String()
+	configFiles           = kingpin.Flag("config.file", "Azure exporter configuration file, can be repeated (defaults to azure.yml).").Strings()
+	configDir             = kingpin.Flag("config.dir", "Directory of Azure exporter configuration files (*.yml) merged with the configuration files.").String()
+	configCredentialsFile = kingpin.Flag("config.credentials-file", "File holding only the credentials of the configuration, e.g. from a Kubernetes secret.").String()
+	configTargetsFile     = kingpin.Flag("config.targets-file", "File holding only the targets, resource groups and resource tags of the configuration, e.g. from a Kubernetes config map.").String()
 	listenAddress         = kingpin.Flag("web.listen-address", "The address to listen on for HTTP requests.").Default(":9276").String()
 	listMetricDefinitions = kingpin.Flag("list.definitions", "List available metric definitions for the given resources and exit.").Bool()
+	listResourceGroup     = kingpin.Flag("list.resource-group", "List the metric definitions of the resources of this resource group instead of the configured resources.").String()
+	listResources         = kingpin.Flag("list.resource", "List the metric definitions of this resource instead of the configured resources, can be repeated.").Strings()
+	listResourceTypes     = kingpin.Flag("list.resource-type", "Only list the metric definitions of the resources of this type, can be repeated.").Strings()
 	listMetricNamespaces  = kingpin.Flag("list.namespaces", "List available metric namespaces for the given resources and exit.").Bool()
+	startupCheckAccess    = kingpin.Flag("startup.check-access", "Check the permissions of the credentials on the configured scopes on startup.").Default("true").Bool()
+	runCmd                = kingpin.Command("run", "Run the exporter.").Default()
+	checkAccessCmd        = kingpin.Command("check-access", "Check the permissions of the credentials on the configured scopes and exit.")
+	lintConfigCmd         = kingpin.Command("lint-config", "Print the effective settings of the targets, resource groups and resource tags of the configuration and warnings about them, and exit.")
+	output                = kingpin.Flag("output", "Output format of --list.definitions, --list.namespaces, check-access, lint-config and query: text or json.").Default("text").Enum("text", "json")
+	leaderLockFile        = kingpin.Flag("leader-election.lock-file", "Lease file shared by the exporter replicas, only the elected leader polls Azure. Disabled when empty.").String()
+	leaderLeaseDuration   = kingpin.Flag("leader-election.lease-duration", "Duration after which the lease of an unresponsive leader can be taken over.").Default("30s").Duration()
+	enableLifecycle       = kingpin.Flag("web.enable-lifecycle", "Enable the endpoints calling Azure or changing the state of the exporter: /-/reload, /-/reload-api-versions, /api/validate-config and /api/preview.").Bool()
+	logDebug              = kingpin.Flag("log.debug", "Log debug messages, such as samples of unexpected Azure response payloads.").Bool()
+	logScrapeDiff         = kingpin.Flag("log.scrape-diff", "Log the series which appeared and disappeared since the previous scrape.").Bool()
+	logEmptyResponses     = kingpin.Flag("log.empty-responses", "Logging of the Azure responses without metrics or data: summary (one line per scrape), each or none.").Default(logEmptySummary).Enum(logEmptySummary, logEmptyEach, logEmptyNone)
+	goCollector           = kingpin.Flag("collector.go", "Expose the Go runtime metrics (go_*) of the exporter.").Bool()
+	processCollector      = kingpin.Flag("collector.process", "Expose the process metrics (process_*) of the exporter.").Bool()
+	maxRequests           = kingpin.Flag("web.max-requests", "Maximum number of concurrent scrapes, the scrapes exceeding it are rejected with status 503 (0 means no limit).").Default("0").Int()
+	sharedRegistry        = kingpin.Flag("web.shared-registry", "Reuse the registries of the previous scrapes instead of allocating them for each scrape, the scrapes are then serialized.").Bool()
+	tokenTimeout          = kingpin.Flag("azure.timeout.token", "Timeout of the access token requests (overridden by timeouts.token, 0 disables it).").Default("30s").Duration()
+	listingTimeout        = kingpin.Flag("azure.timeout.listing", "Timeout of the Azure Resource Manager listing requests (overridden by timeouts.listing, 0 disables it).").Default("2m").Duration()
+	lookupTimeout         = kingpin.Flag("azure.timeout.lookup", "Timeout of the resource info batch lookup requests (overridden by timeouts.lookup, 0 disables it).").Default("1m").Duration()
+	metricsTimeout        = kingpin.Flag("azure.timeout.metrics", "Timeout of the metrics requests (overridden by timeouts.metrics, 0 disables it).").Default("30s").Duration()
+	simulateLatency       = kingpin.Flag("debug.simulate-latency", "Delay added to each Azure request, to test the alerting on the scrape duration.").Hidden().Duration()
+	simulateErrorRate     = kingpin.Flag("debug.simulate-error-rate", "Ratio (0 to 1) of the Azure requests failing, to test the alerting on azure_error.").Hidden().Float64()
+	leaderID              = kingpin.Flag("leader-election.id", "Identity of this replica in the lease file (defaults to hostname and pid).").String()
 	invalidMetricChars    = regexp.MustCompile("[^a-zA-Z0-9_:]")
 	azureErrorDesc        = prometheus.NewDesc("azure_error", "Error collecting metrics", nil, nil)
+	leaderDesc            = prometheus.NewDesc("azure_exporter_leader", "Whether this exporter replica is the elected leader polling Azure", nil, nil)
+	apiErrorInfoDesc      = prometheus.NewDesc("azure_api_error_info", "Azure API error encountered for a resource during the scrape", []string{"code", "resource"}, nil)
+	resourceDeletedDesc   = prometheus.NewDesc("azure_resource_deleted", "Resource previously discovered that is no longer listed by Azure", []string{"resource"}, nil)
 	batchSize             = 20
+	tracker               = newResourceTracker()
+	emptyResources        = newEmptyResourceTracker()
+	targetsFiles          = newTargetsFileCache()
+	counters              = newCounterAccumulator()
+	lastScrape            = &scrapeProfile{}
+	recentStats           = &statsHistory{}
+	azureTime             = &azureClock{}
+	credentialExpiries    = &credentialExpiryCache{}
+	limiters              = newBlockLimiters()
+	metricNames           = newMetricNameMap()
+	scrapeDiffs           = &scrapeDiffSet{}
+	sharedRegistries      = &scrapeRegistrySet{}
+	scrapes               *scrapeLimiter
+	elector               *leaderElector
 )
 
+// shutdownTimeout is the time given to the running scrapes to complete when
+// the exporter is stopped.
+const shutdownTimeout = 30 * time.Second
+
+// aggregationSuffixes are the metric name suffixes of the aggregations.
+var aggregationSuffixes = map[string]string{"Total": "total", "Average": "average", "Minimum": "min", "Maximum": "max"}
+
 func init() {
 	prometheus.MustRegister(version.NewCollector("azure_exporter"))
 }
 
 // Collector generic collector type
-type Collector struct{}
+type Collector struct {
+	// timings of the batches of the scrape.
+	timings []batchTiming
+	// namespaces of the metrics of the scrape, with group_by_namespace.
+	namespaces namespaceSeries
+	// accessDenied resources discovered by tag during the scrape.
+	accessDenied accessDeniedSet
+	// emptyResponses of the scrape, by reason and block.
+	emptyResponses emptyResponseSet
+	// collect selects the parts of the configuration collected, all when nil.
+	collect collectorSet
+	// scrapeID identifies the scrape in the logs and the Azure requests.
+	scrapeID string
+	// blocks are the error codes of the configured blocks of the scrape.
+	blocks blockStatusSet
+	// stats of the scrape by configured block and subscription.
+	stats scrapeStats
+	// cfg is the configuration of the scrape, copied when it starts so that
+	// configuration reloads don't wait for the scrape.
+	cfg *config.Config
+}
 
 // Describe implemented with dummy data to satisfy interface.
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
@@ -45,143 +121,473 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 type resourceMeta struct {
-	resourceID      string
-	resourceURL     string
-	metricNamespace string
-	metrics         string
-	aggregations    []string
-	resource        AzureResource
+	resourceID        string
+	resourceURL       string
+	block             string
+	metricNamespace   string
+	metrics           string
+	preset            string
+	aggregations      []string
+	interval          time.Duration
+	timegrains        []time.Duration
+	timespan          time.Duration
+	resourceInfo      config.ResourceInfo
+	labels            map[string]string
+	dimensions        []config.Dimension
+	joins             []config.Join
+	emitAbsentAsZero  bool
+	maxDatapointAge   time.Duration
+	suspendEmptyAfter int
+	suspendEmptyFor   time.Duration
+	percentiles       map[string]*config.Percentiles
+	clamps            map[string]*config.Clamp
+	queryParameters   map[string]string
+	propertyMetrics   []config.PropertyMetric
+	deallocatedVMs    string
+	discoveredByTag   bool
+	limiter           *blockLimiter
+	resource          AzureResource
+	// subscription labels the series of the resources discovered in the
+	// subscriptions of management_groups, empty otherwise.
+	subscription string
 }
 
-func (c *Collector) extractMetrics(ch chan<- prometheus.Metric, rm resourceMeta, httpStatusCode int, metricValueData AzureMetricValueResponse, publishedResources map[string]bool) {
-	if httpStatusCode != 200 {
-		log.Printf("Received %d status for resource %s. %s", httpStatusCode, rm.resourceURL, metricValueData.APIError.Message)
-		return
+// apiErrorSet collects the Azure API errors of a scrape by code and resource.
+type apiErrorSet map[[2]string]bool
+
+func (s apiErrorSet) add(code string, resource string) {
+	s[[2]string{code, resource}] = true
+}
+
+func (s apiErrorSet) collect(ch chan<- prometheus.Metric) {
+	for k := range s {
+		ch <- prometheus.MustNewConstMetric(apiErrorInfoDesc, prometheus.GaugeValue, 1, k[0], k[1])
 	}
+}
 
-	if len(metricValueData.Value) == 0 || len(metricValueData.Value[0].Timeseries) == 0 {
-		log.Printf("Metric %v not found at target %v\n", rm.metrics, rm.resourceURL)
+func (c *Collector) extractMetrics(ch chan<- prometheus.Metric, rm resourceMeta, httpStatusCode int, metricValueData AzureMetricValueResponse, publishedResources map[string]bool, apiErrors apiErrorSet) {
+	if c.accessDenied.deny(rm, httpStatusCode) {
 		return
 	}
-	if len(metricValueData.Value[0].Timeseries[0].Data) == 0 {
-		log.Printf("No metric data returned for metric %v at target %v\n", rm.metrics, rm.resourceURL)
+	if httpStatusCode != 200 {
+		c.logf("Received %d status for resource %s. %s", httpStatusCode, rm.resourceURL, metricValueData.APIError.Message)
+		code := metricValueData.APIError.Code
+		if code == "" {
+			code = errorCode(&APIError{StatusCode: httpStatusCode})
+		}
+		apiErrors.add(code, rm.resourceID)
+		c.failResource(rm, code)
 		return
 	}
 
+	if len(metricValueData.Value) == 0 || len(metricValueData.Value[0].Timeseries) == 0 {
+		c.addEmptyResponse(rm, emptyNotFound, fmt.Sprintf("Metric %v not found at target %v", rm.metrics, rm.resourceURL))
+		emptyResources.record(rm, true, time.Now())
+		if !rm.emitAbsentAsZero {
+			return
+		}
+	} else if len(rm.dimensions) == 0 && len(metricValueData.Value[0].Timeseries[0].Data) == 0 {
+		c.addEmptyResponse(rm, emptyNoData, fmt.Sprintf("No metric data returned for metric %v at target %v", rm.metrics, rm.resourceURL))
+		emptyResources.record(rm, true, time.Now())
+		if !rm.emitAbsentAsZero {
+			return
+		}
+	} else {
+		emptyResources.record(rm, false, time.Now())
+	}
+
 	for _, value := range metricValueData.Value {
 		// Ensure Azure metric names conform to Prometheus metric name conventions
-		metricName := strings.Replace(value.Name.Value, " ", "_", -1)
-		metricName = strings.ToLower(metricName + "_" + value.Unit)
+		metricName := strings.Replace(config.PresetMetricName(rm.preset, value.Name.Value), " ", "_", -1)
+		if c.cfg.MetricNaming != labelsNaming {
+			metricName = metricName + "_" + value.Unit
+		}
+		metricName = strings.ToLower(metricName)
 		metricName = strings.Replace(metricName, "/", "_per_", -1)
 		if rm.metricNamespace != "" {
 			metricName = strings.ToLower(rm.metricNamespace + "_" + metricName)
 		}
 		metricName = invalidMetricChars.ReplaceAllString(metricName, "_")
 
-		var val float64
-		if len(value.Timeseries) > 0 {
-			metricValue := value.Timeseries[0].Data[len(value.Timeseries[0].Data)-1]
-			labels := CreateResourceLabels(rm.resourceURL)
-
-			if hasAggregation(rm.aggregations, "Total") {
-				metricName = fmt.Sprintf("%s_total", metricName)
-				val = metricValue.Total
+		description := ac.metricDescription(rm.resourceID, GetResourceType(rm.resourceURL), rm.metricNamespace, value.Name.Value)
+		if p := rm.percentiles[strings.ToLower(value.Name.Value)]; p != nil {
+			c.emitPercentiles(ch, rm, metricName, description, value.Unit, p, apiErrors)
+		}
+		seenSeries := map[string]bool{}
+		for _, timeseries := range value.Timeseries {
+			if len(timeseries.Data) == 0 {
+				continue
+			}
+			metricValue := timeseries.Data[len(timeseries.Data)-1]
+			if isStaleDatapoint(rm, metricValue, azureTime.now()) {
+				staleDatapointsTotal.Inc()
+				debugf("Rejecting datapoint of metric %s at target %s from %s, older than %v", metricName, rm.resourceURL, metricValue.TimeStamp, rm.maxDatapointAge)
+				continue
 			}
-			if hasAggregation(rm.aggregations, "Average") {
-				metricName = fmt.Sprintf("%s_average", metricName)
-				val = metricValue.Average
+			labels := resourceLabels(rm)
+			for name, v := range rm.labels {
+				if _, ok := labels[name]; !ok {
+					labels[name] = v
+				}
 			}
-			if hasAggregation(rm.aggregations, "Minimum") {
-				metricName = fmt.Sprintf("%s_min", metricName)
-				val = metricValue.Minimum
+			c.addNamingLabels(labels, value.Unit)
+			var dimensionValues []string
+			for _, d := range rm.dimensions {
+				for _, m := range timeseries.MetadataValues {
+					if strings.EqualFold(m.Name.Value, d.Name) {
+						v := labelValue(d.Normalize(m.Value))
+						labels[dimensionLabelName(d.Name, labels)] = v
+						dimensionValues = append(dimensionValues, v)
+					}
+				}
 			}
-			if hasAggregation(rm.aggregations, "Minimum") {
-				metricName = fmt.Sprintf("%s_max", metricName)
-				val = metricValue.Maximum
+			seriesKey := strings.Join(dimensionValues, "|")
+			if seenSeries[seriesKey] {
+				c.logf("Skipping series of metric %s at target %s, dimension values %v are already used by another series", metricName, rm.resourceURL, dimensionValues)
+				continue
 			}
+			seenSeries[seriesKey] = true
 
-			alias := getAliasForMetricName(metricName)
-			ch <- prometheus.MustNewConstMetric(
-				prometheus.NewDesc(alias, alias, nil, labels),
-				prometheus.GaugeValue,
-				val,
-			)
+			c.emitAggregations(ch, rm, metricName, description, value.Unit, labels, metricValue, seriesKey, rm.clamps[strings.ToLower(value.Name.Value)])
+		}
+
+		// Metrics returned without any data are published as absent.
+		if len(seenSeries) == 0 && rm.emitAbsentAsZero {
+			labels := resourceLabels(rm)
+			for name, v := range rm.labels {
+				if _, ok := labels[name]; !ok {
+					labels[name] = v
+				}
+			}
+			c.addNamingLabels(labels, value.Unit)
+			labels["absent"] = "true"
+			absent := AzureMetricData{TimeStamp: time.Now().UTC().Format(time.RFC3339)}
+			c.emitAggregations(ch, rm, metricName, description, value.Unit, labels, absent, "absent", nil)
 		}
 	}
 
-	if _, ok := publishedResources[rm.resource.ID]; !ok {
+	if _, ok := publishedResources[resourceKey(rm)]; !ok && !rm.resourceInfo.Skip {
 		infoLabels := CreateAllResourceLabelsFrom(rm)
+		if len(rm.resourceInfo.Labels) > 0 {
+			infoLabels = filterLabels(infoLabels, rm.resourceInfo.Labels)
+		}
 		ch <- prometheus.MustNewConstMetric(
 			prometheus.NewDesc("azure_resource_info", "Azure information available for resource", nil, infoLabels),
 			prometheus.GaugeValue,
 			1,
 		)
-		publishedResources[rm.resource.ID] = true
+		c.stats.add(rm.block, subscriptionOf(rm), entryStats{Series: 1})
+		publishedResources[resourceKey(rm)] = true
 	}
 }
 
-func getAliasForMetricName(metricName string) string {
-	switch metricName {
-	// Our common metrics for nodes.
-	case "cpu_percent_percent_average":
-		return "node_cpu_average"
-	case "network_bytes_egress_bytes_average":
-		return "node_network_transmit_bytes_total"
-	case "network_bytes_ingress_bytes_average":
-		return "node_network_receive_bytes_total"
-	case "storage_limit_bytes_average":
-		return "node_filesystem_size_bytes"
-	// Unique metrics for Azure.
-	case "storage_used_bytes_average":
-		return "azure_storage_used_bytes_average"
-	case "storage_percent_percent_average":
-		return "azure_storage_percent_average"
-	case "memory_percent_percent_average":
-		return "azure_memory_percent_average"
-	default:
-		return metricName
+// emitAggregations publishes the aggregations of a data point of a metric
+// time series, overridden by the clamp of the metric when configured.
+func (c *Collector) emitAggregations(ch chan<- prometheus.Metric, rm resourceMeta, metricName string, description string, unit string, labels map[string]string, metricValue AzureMetricData, seriesKey string, clamp *config.Clamp) {
+	for _, aggregation := range filterAggregations(rm.aggregations) {
+		var val float64
+		switch aggregation {
+		case "Total":
+			val = float64(metricValue.Total)
+		case "Average":
+			val = float64(metricValue.Average)
+		case "Minimum":
+			val = float64(metricValue.Minimum)
+		case "Maximum":
+			val = float64(metricValue.Maximum)
+		}
+		if reason, clamped := checkSample(unit, aggregation, val); reason != "" {
+			suspectSamplesTotal.WithLabelValues(reason).Inc()
+			c.logf("Suspect %s value %v of metric %s at target %s: %s", aggregation, val, metricName, rm.resourceURL, reason)
+			if c.cfg.ClampSuspectSamples {
+				val = clamped
+			}
+		}
+		if clamp != nil {
+			var reason string
+			if val, reason = clamp.Apply(val); reason != "" {
+				clampedSamplesTotal.WithLabelValues(reason).Inc()
+			}
+		}
+		name := fmt.Sprintf("%s_%s", metricName, aggregationSuffixes[aggregation])
+		if c.aggregationLabels() {
+			name = metricName
+			labels["aggregation"] = strings.ToLower(aggregation)
+		}
+		valueType := prometheus.GaugeValue
+
+		alias := c.cfg.MetricPrefix + name
+		seriesLabels := labels
+		if !c.aggregationLabels() {
+			if a, ok := dimensionAliasFor(metricName, aggregation, rm.dimensions, labels); ok {
+				alias = a.Alias
+				seriesLabels = dimensionAliasLabels(a, labels)
+			} else if a, counter := c.aliasFor(metricName, aggregation); a != "" {
+				alias = a
+				if counter {
+					timestamp, err := time.Parse(time.RFC3339, metricValue.TimeStamp)
+					if err != nil {
+						c.logf("Invalid timestamp %q of metric %s at target %s: %v", metricValue.TimeStamp, name, rm.resourceURL, err)
+						continue
+					}
+					valueType = prometheus.CounterValue
+					val = counters.add(rm.resourceID+"|"+alias+"|"+seriesKey, timestamp, val, time.Now())
+				}
+			}
+		}
+		if len(c.cfg.Rules) > 0 {
+			alias, seriesLabels = c.applyRules(c.cfg.Rules, alias, seriesLabels, aggregation, rm.resourceID)
+		}
+		alias = metricNames.shorten(alias, c.cfg.MaxMetricNameLength)
+		help := alias
+		if description != "" {
+			help = fmt.Sprintf("%s (%s)", description, aggregation)
+			if c.aggregationLabels() {
+				help = description
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(alias, help, nil, seriesLabels),
+			valueType,
+			val,
+		)
+		c.stats.add(rm.block, subscriptionOf(rm), entryStats{Series: 1})
+		if c.cfg.GroupByNamespace {
+			c.namespaces.add(alias, providerNamespace(rm))
+		}
 	}
 }
 
-func (c *Collector) batchCollectMetrics(ch chan<- prometheus.Metric, resources []resourceMeta) {
-	var publishedResources = map[string]bool{}
+// labelsNaming is the metric_naming where the unit and the aggregation of the
+// metrics are labels instead of name suffixes.
+const labelsNaming = "labels"
 
-	// collect metrics in batches
-	for i := 0; i < len(resources); i += batchSize {
-		j := i + batchSize
+// aggregationLabelNaming is the metric_naming where the unit is a name suffix
+// and the aggregation a label, so that the aggregations of a metric are a
+// single family.
+const aggregationLabelNaming = "aggregation_label"
 
-		// don't forget to add remainder resources
-		if j > len(resources) {
-			j = len(resources)
+// aggregationLabels tells whether the aggregations of the metrics are labels.
+func (c *Collector) aggregationLabels() bool {
+	return c.cfg.MetricNaming == labelsNaming || c.cfg.MetricNaming == aggregationLabelNaming
+}
+
+// addNamingLabels adds the unit label of the metrics with the labels naming.
+// The aggregation label is reserved, so that dimensions are renamed instead
+// of colliding with it, and set for each aggregation.
+func (c *Collector) addNamingLabels(labels map[string]string, unit string) {
+	if !c.aggregationLabels() {
+		return
+	}
+	if c.cfg.MetricNaming == labelsNaming {
+		labels["unit"] = strings.ToLower(unit)
+	}
+	labels["aggregation"] = ""
+}
+
+// isStaleDatapoint tells whether the datapoint is older than the
+// max_datapoint_age of the resource.
+func isStaleDatapoint(rm resourceMeta, datapoint AzureMetricData, now time.Time) bool {
+	if rm.maxDatapointAge == 0 {
+		return false
+	}
+	timestamp, err := time.Parse(time.RFC3339, datapoint.TimeStamp)
+	if err != nil {
+		return false
+	}
+	return now.Sub(timestamp) > rm.maxDatapointAge
+}
+
+// dimensionLabelName returns the label name of a dimension, prefixed with
+// dimension_ when it collides with the labels of the resource.
+func dimensionLabelName(dimension string, labels map[string]string) string {
+	name := strings.ToLower(invalidLabelChars.ReplaceAllString(dimension, "_"))
+	if _, ok := labels[name]; ok {
+		name = "dimension_" + name
+	}
+	return name
+}
+
+// metricNamespaceOf returns the metric namespace of the resource. Resources
+// without an explicit metric namespace use the one of their resource type.
+func metricNamespaceOf(rm resourceMeta) string {
+	if rm.metricNamespace != "" {
+		return rm.metricNamespace
+	}
+	return GetResourceType(rm.resourceURL)
+}
+
+// skipIngestedResources removes the resources whose metrics are already
+// ingested by Azure Managed Prometheus.
+func (c *Collector) skipIngestedResources(resources []resourceMeta) []resourceMeta {
+	var kept []resourceMeta
+	for _, rm := range resources {
+		if c.cfg.ManagedPrometheus.Ingests(GetResourceType(rm.resourceURL), metricNamespaceOf(rm)) {
+			debugf("Skipping resource %s, its metrics are ingested by Azure Managed Prometheus", rm.resourceID)
+			continue
 		}
+		kept = append(kept, rm)
+	}
+	return kept
+}
 
-		var urls []string
-		for _, r := range resources[i:j] {
-			urls = append(urls, r.resourceURL)
+// aliasFor returns the alias of an aggregation of a metric, named with the
+// suffixes naming, and whether it is a counter.
+func (c *Collector) aliasFor(metricName string, aggregation string) (string, bool) {
+	if c.cfg.AliasCounters {
+		for _, a := range config.CounterAliases {
+			if a.MetricName() == metricName && a.Aggregation == aggregation {
+				return a.Alias, true
+			}
+		}
+	}
+	for _, a := range config.MetricAliases {
+		if a.MetricName() == metricName && a.Aggregation == aggregation && !c.isCounterAlias(a.Alias) {
+			return a.Alias, false
 		}
+	}
+	return "", false
+}
 
-		batchBody, err := ac.getBatchResponseBody(urls)
-		if err != nil {
-			ch <- prometheus.NewInvalidMetric(azureErrorDesc, err)
-			return
+// dimensionAliasFor returns the dimension alias of an aggregation of a metric,
+// named with the suffixes naming, for the series split by its dimension.
+func dimensionAliasFor(metricName string, aggregation string, dimensions []config.Dimension, labels map[string]string) (config.DimensionAlias, bool) {
+	for _, a := range config.DimensionAliases {
+		if a.MetricName() != metricName || a.Aggregation != aggregation || !a.HasDimension(dimensions) {
+			continue
+		}
+		if _, ok := labels[dimensionLabelName(a.Dimension, nil)]; ok {
+			return a, true
 		}
+	}
+	return config.DimensionAlias{}, false
+}
 
-		var batchData AzureBatchMetricResponse
-		err = json.Unmarshal(batchBody, &batchData)
-		if err != nil {
-			ch <- prometheus.NewInvalidMetric(azureErrorDesc, err)
-			return
+// dimensionAliasLabels returns the labels of a series published with a
+// dimension alias, the dimension label being replaced by the label of the
+// alias holding the name of the resource identified by the dimension value,
+// e.g. the name of the database of a DatabaseResourceId.
+func dimensionAliasLabels(a config.DimensionAlias, labels map[string]string) map[string]string {
+	dimension := dimensionLabelName(a.Dimension, nil)
+	aliased := copyLabels(labels)
+	value := aliased[dimension]
+	delete(aliased, dimension)
+	aliased[a.Label] = value[strings.LastIndex(value, "/")+1:]
+	return aliased
+}
+
+// isCounterAlias tells whether the alias is taken by a counter.
+func (c *Collector) isCounterAlias(alias string) bool {
+	if !c.cfg.AliasCounters {
+		return false
+	}
+	for _, a := range config.CounterAliases {
+		if a.Alias == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// metricsBatchResult is the response to the metrics batch request of a batch
+// of resources.
+type metricsBatchResult struct {
+	batch   []resourceMeta
+	start   time.Time
+	body    io.ReadCloser
+	err     error
+	limiter *blockLimiter
+}
+
+func (c *Collector) batchCollectMetrics(ch chan<- prometheus.Metric, resources []resourceMeta, publishedResources map[string]bool, apiErrors apiErrorSet) {
+	// The batches of the blocks with limits are requested independently of
+	// the other resources, whose batches are requested one at a time.
+	defaultLimiter := newBlockLimiter(1, 0)
+	var order []*blockLimiter
+	partitions := map[*blockLimiter][]resourceMeta{}
+	for _, rm := range resources {
+		limiter := rm.limiter
+		if limiter == nil {
+			limiter = defaultLimiter
+		}
+		if _, ok := partitions[limiter]; !ok {
+			order = append(order, limiter)
+		}
+		partitions[limiter] = append(partitions[limiter], rm)
+	}
+
+	total := 0
+	for _, limiter := range order {
+		total += (len(partitions[limiter]) + batchSize - 1) / batchSize
+	}
+	results := make(chan metricsBatchResult, total)
+	for _, limiter := range order {
+		go requestMetricsBatches(partitions[limiter], limiter, c.scrapeID, results)
+	}
+
+	for n := 0; n < total; n++ {
+		r := <-results
+		if r.err != nil {
+			ch <- prometheus.NewInvalidMetric(azureErrorDesc, r.err)
+			for _, rm := range r.batch {
+				c.failResource(rm, errorCode(r.err))
+			}
+			r.limiter.release()
+			continue
 		}
 
-		for k, resp := range batchData.Responses {
-			c.extractMetrics(ch, resources[i+k], resp.HttpStatusCode, resp.Content, publishedResources)
+		batch := r.batch
+		matcher := newBatchMatcher(len(batch))
+		err := decodeBatchResponses(r.body, func(k int, dec *json.Decoder) error {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return fmt.Errorf("Error unmarshalling response body: %v", err)
+			}
+			if k >= len(batch) {
+				return fmt.Errorf("Unexpected batch sub-response %d for %d requests", k, len(batch))
+			}
+			var resp AzureBatchMetricSubResponse
+			if err := decodeLenient("batch", raw, &resp); err != nil {
+				c.logf("Skipping batch sub-response for resource %s: %v", batch[k].resourceID, err)
+				apiErrors.add("InvalidResponse", batch[k].resourceID)
+				c.failResource(batch[k], "InvalidResponse")
+				matcher.match(k, "")
+				return nil
+			}
+			i, err := matcher.match(k, resp.Name)
+			if err != nil {
+				c.logf("Skipping batch sub-response: %v", err)
+				return nil
+			}
+			if resp.HttpStatusCode == http.StatusTooManyRequests {
+				recordThrottling("batch", resp.Headers["Retry-After"])
+			}
+			c.extractMetrics(ch, batch[i], resp.HttpStatusCode, resp.Content, publishedResources, apiErrors)
+			return nil
+		})
+		if err == nil {
+			for _, i := range matcher.missing() {
+				c.logf("Missing batch sub-response for resource %s", batch[i].resourceID)
+				apiErrors.add("MissingResponse", batch[i].resourceID)
+				c.failResource(batch[i], "MissingResponse")
+			}
+		}
+		r.body.Close()
+		r.limiter.release()
+		c.recordTiming("batch", batch, r.start)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(azureErrorDesc, err)
+			for _, rm := range batch {
+				c.failResource(rm, errorCode(err))
+			}
 		}
 	}
 }
 
-func (c *Collector) batchLookupResources(resources []resourceMeta) ([]resourceMeta, error) {
-	var updatedResources = resources
-	// collect resource info in batches
+// requestMetricsBatches requests the metrics of the resources in batches
+// within the limits of the limiter. The in-flight slot of each result is
+// released once it's handled.
+func requestMetricsBatches(resources []resourceMeta, limiter *blockLimiter, scrapeID string, results chan<- metricsBatchResult) {
 	for i := 0; i < len(resources); i += batchSize {
 		j := i + batchSize
 
@@ -192,174 +598,624 @@ func (c *Collector) batchLookupResources(resources []resourceMeta) ([]resourceMe
 
 		var urls []string
 		for _, r := range resources[i:j] {
-			resourceType := GetResourceType(r.resourceURL)
-			if resourceType == "" {
-				return nil, fmt.Errorf("No type found for resource: %s", r.resourceID)
-			}
+			urls = append(urls, r.resourceURL)
+		}
 
-			apiVersion := ac.APIVersions.findBy(resourceType)
-			if apiVersion == "" {
-				return nil, fmt.Errorf("No api version found for type: %s", resourceType)
-			}
+		limiter.acquire()
+		start := time.Now()
+		body, err := ac.getBatchResponse(metricsEndpoints, urls, scrapeID)
+		results <- metricsBatchResult{batch: resources[i:j], start: start, body: body, err: err, limiter: limiter}
+	}
+}
+
+// lookupURL returns the URL of the request of the resource info, with the API
+// version of its type or else lookup_fallback_api_version.
+func (c *Collector) lookupURL(r resourceMeta) (string, error) {
+	resourceType := GetResourceType(r.resourceURL)
+	if resourceType == "" {
+		return "", fmt.Errorf("No type found for resource: %s", r.resourceID)
+	}
+
+	apiVersion := ac.findAPIVersion(resourceType)
+	if apiVersion == "" {
+		if c.cfg.LookupFallbackAPIVersion == "" {
+			return "", fmt.Errorf("No api version found for type: %s", resourceType)
+		}
+		debugf("No api version found for type %s, using %s", resourceType, c.cfg.LookupFallbackAPIVersion)
+		apiVersion = c.cfg.LookupFallbackAPIVersion
+	}
+
+	subscription := fmt.Sprintf("subscriptions/%s", subscriptionOf(r))
+	endpoint := fmt.Sprintf("/%s/%s?api-version=%s", subscription, escapeResourceID(r.resourceID), apiVersion)
+	if needsPowerState(r) {
+		endpoint += "&$expand=instanceView"
+	}
+	return endpoint, nil
+}
+
+// batchLookupResources requests the resource info of the resources in
+// batches. Resources whose info can't be requested are skipped.
+func (c *Collector) batchLookupResources(resources []resourceMeta, apiErrors apiErrorSet) ([]resourceMeta, error) {
+	var updatedResources []resourceMeta
+	var lookupURLs []string
+	for _, r := range resources {
+		u, err := c.lookupURL(r)
+		if err != nil {
+			c.logf("Skipping resource info of resource %s: %v", r.resourceID, err)
+			apiErrors.add("NoAPIVersion", r.resourceID)
+			c.failResource(r, "NoAPIVersion")
+			continue
+		}
+		updatedResources = append(updatedResources, r)
+		lookupURLs = append(lookupURLs, u)
+	}
 
-			subscription := fmt.Sprintf("subscriptions/%s", sc.C.Credentials.SubscriptionID)
-			resourcesEndpoint := fmt.Sprintf("/%s/%s?api-version=%s", subscription, r.resourceID, apiVersion)
+	// collect resource info in batches
+	for i := 0; i < len(updatedResources); i += batchSize {
+		j := i + batchSize
 
-			urls = append(urls, resourcesEndpoint)
+		// don't forget to add remainder resources
+		if j > len(updatedResources) {
+			j = len(updatedResources)
 		}
 
-		batchBody, err := ac.getBatchResponseBody(urls)
+		urls := lookupURLs[i:j]
+		batch := updatedResources[i:j]
+		start := time.Now()
+		batchBody, err := ac.getBatchResponse(lookupEndpoints, urls, c.scrapeID)
 		if err != nil {
 			return nil, err
 		}
 
-		var batchData AzureBatchLookupResponse
-		err = json.Unmarshal(batchBody, &batchData)
+		matcher := newBatchMatcher(len(batch))
+		err = decodeBatchResponses(batchBody, func(k int, dec *json.Decoder) error {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return fmt.Errorf("Error unmarshalling response body: %v", err)
+			}
+			if k >= len(batch) {
+				return fmt.Errorf("Unexpected batch sub-response %d for %d requests", k, len(batch))
+			}
+			var resp AzureBatchLookupSubResponse
+			if err := decodeLenient("batch", raw, &resp); err != nil {
+				c.logf("Skipping batch sub-response for resource %s: %v", batch[k].resourceID, err)
+				matcher.match(k, "")
+				return nil
+			}
+			i, err := matcher.match(k, resp.Name)
+			if err != nil {
+				c.logf("Skipping batch sub-response: %v", err)
+				return nil
+			}
+			if resp.HttpStatusCode == http.StatusTooManyRequests {
+				recordThrottling("batch", resp.Headers["Retry-After"])
+			}
+			if c.accessDenied.deny(batch[i], resp.HttpStatusCode) {
+				return nil
+			}
+			subscription := subscriptionOf(batch[i])
+			batch[i].resource = resp.Content
+			batch[i].resource.Subscription = subscription
+			if subscriptionNamesEnabled() {
+				batch[i].resource.SubscriptionName = ac.subscriptionName(subscription)
+			}
+			return nil
+		})
+		if err == nil {
+			for _, i := range matcher.missing() {
+				c.logf("Missing batch sub-response for resource %s", batch[i].resourceID)
+			}
+		}
+		batchBody.Close()
+		c.recordTiming("lookup", batch, start)
 		if err != nil {
-			return nil, fmt.Errorf("Error unmarshalling response body: %v", err)
+			return nil, err
 		}
+	}
 
-		for k, resp := range batchData.Responses {
-			updatedResources[i+k].resource = resp.Content
-			updatedResources[i+k].resource.Subscription = sc.C.Credentials.SubscriptionID
+	// Resources denied by the lookup aren't collected.
+	completeResources := updatedResources[:0]
+	for _, r := range updatedResources {
+		if !c.accessDenied[r.resourceID] {
+			completeResources = append(completeResources, r)
 		}
 	}
-	return updatedResources, nil
+	return completeResources, nil
 }
 
 // Collect - collect results from Azure Montior API and create Prometheus metrics.
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if elector != nil {
+		leader := elector.isLeader()
+		ch <- prometheus.MustNewConstMetric(leaderDesc, prometheus.GaugeValue, boolToFloat64(leader))
+		if !leader {
+			return
+		}
+	}
+
+	c.scrapeID = newScrapeID()
+	defer func() { lastScrape.update(c.scrapeID, c.timings) }()
+	defer func() { recentStats.record(c.stats) }()
+
+	c.cfg = sc.Get()
+
 	if err := ac.refreshAccessToken(); err != nil {
-		log.Println(err)
+		c.logf("%v", err)
 		ch <- prometheus.NewInvalidMetric(azureErrorDesc, err)
 		return
 	}
 
 	var resources []resourceMeta
 	var incompleteResources []resourceMeta
+	var discoveredResources = map[string]bool{}
+	var discoveryFailed bool
+	var apiErrors = apiErrorSet{}
+	defer apiErrors.collect(ch)
 
-	for _, target := range sc.C.Targets {
-		var rm resourceMeta
-
-		metrics := []string{}
-		for _, metric := range target.Metrics {
-			metrics = append(metrics, metric.Name)
-		}
+	if c.cfg.Budgets.Enabled && c.collect.enabled("budgets") {
+		c.collectBudgets(ch, apiErrors)
+	}
+	if c.cfg.Advisor.Enabled && c.collect.enabled("advisor") {
+		c.collectAdvisorRecommendations(ch, apiErrors)
+	}
+	if c.cfg.SecureScore.Enabled && c.collect.enabled("secure_score") {
+		c.collectSecureScores(ch, apiErrors)
+	}
+	if c.cfg.Backup.Enabled && c.collect.enabled("backup") {
+		c.collectBackups(ch, apiErrors)
+	}
+	if c.cfg.Policy.Enabled && c.collect.enabled("policy") {
+		c.collectPolicyCompliance(ch, apiErrors)
+	}
+	if c.cfg.Autoscale.Enabled && c.collect.enabled("autoscale") {
+		c.collectAutoscale(ch, apiErrors)
+	}
+	if c.cfg.LogAnalytics.Enabled && c.collect.enabled("log_analytics") {
+		c.collectLogAnalytics(ch, apiErrors)
+	}
+	if c.collect.enabled("credential_expiry") {
+		c.collectCredentialExpiry(ch)
+	}
+	if c.cfg.ScheduledEvents.Enabled && c.collect.enabled("scheduled_events") {
+		scheduledEvents.collect(ch)
+	}
+	if c.cfg.GroupByNamespace {
+		defer c.namespaces.collect(ch)
+	}
+	defer func() { c.accessDenied.collect(ch) }()
+	defer c.logEmptyResponses()
+	defer func() { c.blocks.collect(ch) }()
 
-		rm.resourceID = target.Resource
-		rm.metricNamespace = target.MetricNamespace
-		rm.metrics = strings.Join(metrics, ",")
-		rm.aggregations = filterAggregations(target.Aggregations)
-		rm.resourceURL = resourceURLFrom(target.Resource, rm.metricNamespace, rm.metrics, rm.aggregations)
-		incompleteResources = append(incompleteResources, rm)
+	targets, resourceGroups, resourceTags := c.cfg.Targets, c.cfg.ResourceGroups, c.cfg.ResourceTags
+	if !c.collect.enabled("targets") {
+		targets = nil
+	}
+	if !c.collect.enabled("resource_groups") {
+		resourceGroups = nil
 	}
+	if !c.collect.enabled("resource_tags") {
+		resourceTags = nil
+	}
+
+	for i, configured := range targets {
+		block := config.BlockName(config.TargetsBlock, i, configured.Name)
+		c.blocks.register(block)
+		for _, target := range expandTargets([]config.Target{configured}) {
+			var rm resourceMeta
 
-	for _, resourceGroup := range sc.C.ResourceGroups {
-		metrics := []string{}
-		for _, metric := range resourceGroup.Metrics {
-			metrics = append(metrics, metric.Name)
+			rm.resourceID = target.Resource
+			rm.block = block
+			rm.metricNamespace = target.MetricNamespace
+			rm.metrics = strings.Join(config.PresetMetrics(target.Preset, resourceTypeOf(target.Resource), target.Metrics), ",")
+			rm.preset = target.Preset
+			rm.aggregations = filterAggregations(target.Aggregations)
+			rm.interval = target.Interval
+			rm.timegrains = target.Timegrains
+			rm.timespan = target.Timespan
+			rm.resourceInfo = target.ResourceInfo
+			rm.labels = config.PresetLabels(target.Preset, resourceTypeOf(target.Resource), target.Labels)
+			rm.dimensions = target.Dimensions
+			rm.joins = target.Join
+			rm.propertyMetrics = target.PropertyMetrics
+			rm.emitAbsentAsZero = target.EmitAbsentAsZero
+			rm.maxDatapointAge = target.MaxDatapointAge
+			rm.deallocatedVMs = target.DeallocatedVMs
+			rm.percentiles = percentilesOf(target.Metrics)
+			rm.clamps = clampsOf(target.Metrics)
+			rm.queryParameters = config.QueryParameters(target.Metrics)
+			rm.resourceURL = resourceURLFrom(c.cfg.Credentials.SubscriptionID, target.Resource, rm.metricNamespace, rm.metrics, rm.aggregations, rm.dimensions, rm.queryParameters, rm.interval, rm.timespan)
+			if target.SkipResourceLookup {
+				rm.resourceInfo.Skip = true
+				resources = append(resources, rm)
+				continue
+			}
+			incompleteResources = append(incompleteResources, rm)
 		}
-		metricsStr := strings.Join(metrics, ",")
+	}
 
-		filteredResources, err := ac.filteredListFromResourceGroup(resourceGroup)
-		if err != nil {
-			log.Printf("Failed to get resources for resource group %s and resource types %s: %v",
-				resourceGroup.ResourceGroup, resourceGroup.ResourceTypes, err)
-			ch <- prometheus.NewInvalidMetric(azureErrorDesc, err)
-			return
+	// The resource groups and the resource tags are discovered in each
+	// subscription of the management groups.
+	var subscriptions []string
+	var subscriptionsErr error
+	if len(resourceGroups) > 0 || len(resourceTags) > 0 {
+		subscriptions, subscriptionsErr = ac.discoverySubscriptions(c.scrapeID)
+		if subscriptionsErr != nil {
+			c.logf("Failed to get the subscriptions of management groups %s: %v", strings.Join(c.cfg.ManagementGroups, ", "), subscriptionsErr)
+			apiErrors.add(errorCode(subscriptionsErr), strings.Join(c.cfg.ManagementGroups, ","))
+			discoveryFailed = true
 		}
+	}
 
-		for _, f := range filteredResources {
-			var rm resourceMeta
-			rm.resourceID = f.ID
-			rm.metricNamespace = resourceGroup.MetricNamespace
-			rm.metrics = metricsStr
-			rm.aggregations = filterAggregations(resourceGroup.Aggregations)
-			rm.resourceURL = resourceURLFrom(f.ID, rm.metricNamespace, rm.metrics, rm.aggregations)
-			rm.resource = f
-			resources = append(resources, rm)
+	for i, resourceGroup := range resourceGroups {
+		block := config.BlockName(config.ResourceGroupsBlock, i, resourceGroup.Name)
+		c.blocks.register(block)
+		if subscriptionsErr != nil {
+			c.blocks.fail(block, errorCode(subscriptionsErr))
+			continue
+		}
+		limiter := limiters.get(block, resourceGroup.MaxInFlight, resourceGroup.RequestsPerSecond)
+		for _, subscription := range subscriptions {
+			start := time.Now()
+			filteredResources, err := ac.filteredListFromResourceGroup(subscription, resourceGroup, c.scrapeID)
+			c.stats.add(block, subscription, entryStats{APICalls: 1, DurationSeconds: time.Since(start).Seconds()})
+			if err != nil && len(c.cfg.ManagementGroups) > 0 && errorCode(err) == "ResourceGroupNotFound" {
+				// The resource group only exists in some of the subscriptions.
+				continue
+			}
+			if err != nil {
+				c.logf("Failed to get resources of %s for resource group %s of subscription %s and resource types %s: %v",
+					block, resourceGroup.ResourceGroup, subscription, resourceGroup.ResourceTypes, err)
+				apiErrors.add(errorCode(err), resourceGroup.ResourceGroup)
+				c.blocks.fail(block, errorCode(err))
+				c.stats.add(block, subscription, entryStats{Errors: 1})
+				discoveryFailed = true
+				continue
+			}
+			for _, f := range filteredResources {
+				var rm resourceMeta
+				rm.resourceID = f.ID
+				rm.block = block
+				rm.metricNamespace = resourceGroup.MetricNamespace
+				rm.metrics = strings.Join(config.PresetMetrics(resourceGroup.Preset, f.Type, resourceGroup.Metrics), ",")
+				rm.preset = resourceGroup.Preset
+				rm.aggregations = filterAggregations(resourceGroup.Aggregations)
+				rm.interval = resourceGroup.Interval
+				rm.timegrains = resourceGroup.Timegrains
+				rm.timespan = resourceGroup.Timespan
+				rm.resourceInfo = resourceGroup.ResourceInfo
+				rm.labels = config.PresetLabels(resourceGroup.Preset, f.Type, resourceGroup.Labels)
+				rm.dimensions = resourceGroup.Dimensions
+				rm.joins = resourceGroup.Join
+				rm.propertyMetrics = resourceGroup.PropertyMetrics
+				rm.emitAbsentAsZero = resourceGroup.EmitAbsentAsZero
+				rm.maxDatapointAge = resourceGroup.MaxDatapointAge
+				rm.deallocatedVMs = resourceGroup.DeallocatedVMs
+				rm.percentiles = percentilesOf(resourceGroup.Metrics)
+				rm.clamps = clampsOf(resourceGroup.Metrics)
+				rm.queryParameters = config.QueryParameters(resourceGroup.Metrics)
+				rm.suspendEmptyAfter = resourceGroup.SuspendEmptyAfter
+				rm.suspendEmptyFor = resourceGroup.SuspendEmptyFor
+				rm.limiter = limiter
+				rm.resourceURL = resourceURLFrom(subscription, f.ID, rm.metricNamespace, rm.metrics, rm.aggregations, rm.dimensions, rm.queryParameters, rm.interval, rm.timespan)
+				rm.resource = f
+				if len(c.cfg.ManagementGroups) > 0 {
+					rm.subscription = subscription
+				}
+				if needsLookup(rm.joins) || needsPropertiesLookup(rm.propertyMetrics) || needsPowerState(rm) {
+					incompleteResources = append(incompleteResources, rm)
+				} else {
+					resources = append(resources, rm)
+				}
+				discoveredResources[resourceKey(rm)] = true
+			}
 		}
 	}
 
 	resourcesCache := make(map[string][]byte)
-	for _, resourceTag := range sc.C.ResourceTags {
-		metrics := []string{}
-		for _, metric := range resourceTag.Metrics {
-			metrics = append(metrics, metric.Name)
+	for i, resourceTag := range resourceTags {
+		block := config.BlockName(config.ResourceTagsBlock, i, resourceTag.Name)
+		c.blocks.register(block)
+		if subscriptionsErr != nil {
+			c.blocks.fail(block, errorCode(subscriptionsErr))
+			continue
 		}
-		metricsStr := strings.Join(metrics, ",")
-
-		filteredResources, err := ac.filteredListByTag(resourceTag, resourcesCache)
-		if err != nil {
-			log.Printf("Failed to get resources for tag name %s, tag value %s: %v",
-				resourceTag.ResourceTagName, resourceTag.ResourceTagValue, err)
-			ch <- prometheus.NewInvalidMetric(azureErrorDesc, err)
-			return
+		limiter := limiters.get(block, resourceTag.MaxInFlight, resourceTag.RequestsPerSecond)
+		for _, subscription := range subscriptions {
+			start := time.Now()
+			filteredResources, err := ac.filteredListByTag(subscription, resourceTag, resourcesCache, c.scrapeID)
+			c.stats.add(block, subscription, entryStats{APICalls: 1, DurationSeconds: time.Since(start).Seconds()})
+			if err != nil {
+				c.logf("Failed to get resources of %s for tag name %s, tag value %s of subscription %s: %v",
+					block, resourceTag.ResourceTagName, resourceTag.ResourceTagValue, subscription, err)
+				apiErrors.add(errorCode(err), fmt.Sprintf("%s=%s", resourceTag.ResourceTagName, resourceTag.ResourceTagValue))
+				c.blocks.fail(block, errorCode(err))
+				c.stats.add(block, subscription, entryStats{Errors: 1})
+				discoveryFailed = true
+				continue
+			}
+			for _, f := range filteredResources {
+				var rm resourceMeta
+				rm.resourceID = f.ID
+				rm.block = block
+				rm.metricNamespace = resourceTag.MetricNamespace
+				rm.metrics = strings.Join(config.PresetMetrics(resourceTag.Preset, f.Type, resourceTag.Metrics), ",")
+				rm.preset = resourceTag.Preset
+				rm.aggregations = filterAggregations(resourceTag.Aggregations)
+				rm.interval = resourceTag.Interval
+				rm.timegrains = resourceTag.Timegrains
+				rm.timespan = resourceTag.Timespan
+				rm.resourceInfo = resourceTag.ResourceInfo
+				rm.labels = config.PresetLabels(resourceTag.Preset, f.Type, resourceTag.Labels)
+				rm.dimensions = resourceTag.Dimensions
+				rm.joins = resourceTag.Join
+				rm.propertyMetrics = resourceTag.PropertyMetrics
+				rm.emitAbsentAsZero = resourceTag.EmitAbsentAsZero
+				rm.maxDatapointAge = resourceTag.MaxDatapointAge
+				rm.deallocatedVMs = resourceTag.DeallocatedVMs
+				rm.percentiles = percentilesOf(resourceTag.Metrics)
+				rm.clamps = clampsOf(resourceTag.Metrics)
+				rm.queryParameters = config.QueryParameters(resourceTag.Metrics)
+				rm.suspendEmptyAfter = resourceTag.SuspendEmptyAfter
+				rm.suspendEmptyFor = resourceTag.SuspendEmptyFor
+				rm.discoveredByTag = true
+				rm.resource = f
+				rm.limiter = limiter
+				rm.resourceURL = resourceURLFrom(subscription, f.ID, rm.metricNamespace, rm.metrics, rm.aggregations, rm.dimensions, rm.queryParameters, rm.interval, rm.timespan)
+				if len(c.cfg.ManagementGroups) > 0 {
+					rm.subscription = subscription
+				}
+				incompleteResources = append(incompleteResources, rm)
+				discoveredResources[resourceKey(rm)] = true
+			}
 		}
+	}
 
-		for _, f := range filteredResources {
-			var rm resourceMeta
-			rm.resourceID = f.ID
-			rm.metricNamespace = resourceTag.MetricNamespace
-			rm.metrics = metricsStr
-			rm.aggregations = filterAggregations(resourceTag.Aggregations)
-			rm.resourceURL = resourceURLFrom(f.ID, rm.metricNamespace, rm.metrics, rm.aggregations)
-			incompleteResources = append(incompleteResources, rm)
+	for _, rm := range append(append([]resourceMeta{}, resources...), incompleteResources...) {
+		c.stats.add(rm.block, subscriptionOf(rm), entryStats{Resources: 1})
+	}
+
+	// Resources of a failed discovery can't be told apart from deleted ones,
+	// nor can the resources of the blocks which aren't collected.
+	if !discoveryFailed && c.collect.enabled("resource_groups") && c.collect.enabled("resource_tags") {
+		for _, id := range tracker.update(discoveredResources, c.cfg.DeletedResourceScrapes) {
+			ch <- prometheus.MustNewConstMetric(resourceDeletedDesc, prometheus.GaugeValue, 1, id)
 		}
 	}
 
-	completeResources, err := c.batchLookupResources(incompleteResources)
+	resources = c.skipIngestedResources(resources)
+	incompleteResources = c.skipIngestedResources(incompleteResources)
+
+	completeResources, err := c.batchLookupResources(incompleteResources, apiErrors)
 	if err != nil {
-		log.Printf("Failed to get resource info: %s", err)
+		c.logf("Failed to get resource info: %s", err)
 		ch <- prometheus.NewInvalidMetric(azureErrorDesc, err)
+		for _, rm := range incompleteResources {
+			c.failResource(rm, errorCode(err))
+		}
 		return
 	}
 
 	resources = append(resources, completeResources...)
-	c.batchCollectMetrics(ch, resources)
+	for i := range resources {
+		resources[i].labels = joinedLabels(resources[i])
+	}
+	resources = applyPowerStates(resources)
+	c.collectPropertyMetrics(ch, resources)
+	resources = expandTimegrains(resources)
+	resources = emptyResources.filter(ch, resources, time.Now())
+	var publishedResources = map[string]bool{}
+	if c.cfg.MetricsDataPlane.Enabled {
+		c.batchCollectDataPlaneMetrics(ch, resources, publishedResources, apiErrors)
+	} else {
+		c.batchCollectMetrics(ch, resources, publishedResources, apiErrors)
+	}
+	if c.cfg.Baselines.Enabled {
+		c.collectBaselines(ch, resources, apiErrors)
+	}
+}
+
+// identityLabels returns the labels added to all Azure metrics from the
+// identity of the exporter, as configured by global_labels_from_identity.
+func identityLabels() prometheus.Labels {
+	cfg := sc.Get()
+	labels := prometheus.Labels{}
+	for _, label := range cfg.GlobalLabelsFromIdentity {
+		switch label {
+		case "subscription_id":
+			labels[label] = cfg.Credentials.SubscriptionID
+		case "subscription_name":
+			if err := ac.refreshAccessToken(); err != nil {
+				log.Println(err)
+			}
+			labels[label] = ac.subscriptionName(cfg.Credentials.SubscriptionID)
+		case "tenant_id":
+			labels[label] = cfg.Credentials.TenantID
+		}
+	}
+	return labels
+}
+
+// lifecycleHandler only serves the endpoints calling Azure or changing the
+// state of the exporter with --web.enable-lifecycle, as they are
+// unauthenticated like the metrics.
+func lifecycleHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !*enableLifecycle {
+			http.Error(w, "Lifecycle endpoints are disabled, see --web.enable-lifecycle", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
-	registry := prometheus.NewRegistry()
-	collector := &Collector{}
-	registry.MustRegister(collector)
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+	collect, err := parseCollectorSet(r.URL.Query()["collect[]"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !scrapes.tryAcquire() {
+		scrapesRejectedTotal.Inc()
+		http.Error(w, "Too many concurrent scrapes", http.StatusServiceUnavailable)
+		return
+	}
+	defer scrapes.release()
+
+	var s *scrapeRegistry
+	if *sharedRegistry {
+		s = sharedRegistries.get(collect, identityLabels())
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		s.collector.reset()
+	} else {
+		s = newScrapeRegistry(collect, identityLabels())
+	}
+
+	sc.RLock()
+	disableCompression := sc.C.DisableCompression
+	sc.RUnlock()
+	h := promhttp.HandlerFor(s.gatherer, promhttp.HandlerOpts{DisableCompression: disableCompression})
+	cw := &countingResponseWriter{ResponseWriter: w}
+	h.ServeHTTP(cw, r)
+	cw.record()
+}
+
+// logAccessChecks logs the scopes lacking permissions and reports whether
+// all scopes passed the check.
+func logAccessChecks(checks []accessCheck) bool {
+	ok := true
+	for _, check := range checks {
+		if check.Err != nil {
+			log.Printf("Failed to check permissions on scope %s: %v", check.Scope, check.Err)
+			ok = false
+		} else if len(check.Missing) > 0 {
+			log.Printf("Missing permissions on scope %s: %s", check.Scope, strings.Join(check.Missing, ", "))
+			ok = false
+		}
+	}
+	return ok
 }
 
 func main() {
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
-	if err := sc.ReloadConfig(*configFile); err != nil {
-		log.Fatalf("Error loading config: %v", err)
+	command := kingpin.Parse()
+	if handled, err := runServiceCommand(command); handled {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	run(command, nil)
+}
+
+// run runs the exporter command, the HTTP server is shut down when stop is
+// closed.
+func run(command string, stop <-chan struct{}) {
+	if *simulateErrorRate < 0 || *simulateErrorRate > 1 {
+		log.Fatalf("Invalid --debug.simulate-error-rate %v, must be between 0 and 1", *simulateErrorRate)
+	}
+	if *simulateLatency > 0 || *simulateErrorRate > 0 {
+		log.Printf("Simulating Azure request latency of %v and error rate of %v", *simulateLatency, *simulateErrorRate)
+	}
+
+	// Without configuration files, the exporter can be configured by
+	// environment variables.
+	if len(*configFiles) == 0 && *configDir == "" && *configTargetsFile == "" && !config.EnvConfigured() {
+		*configFiles = []string{"azure.yml"}
+	}
+	if err := reloadConfig("", ""); err != nil {
+		cliExit(exitConfigInvalid, fmt.Errorf("Error loading config: %v", err), nil, nil)
+	}
+
+	if command == lintConfigCmd.FullCommand() {
+		if *output == "json" {
+			sc.RLock()
+			entries, warnings := lintEntries(sc.C)
+			sc.RUnlock()
+			cliExit(exitOK, nil, warnings, jsonSettings(entries))
+		}
+		sc.RLock()
+		warnings, err := lintConfig(sc.C, os.Stdout)
+		sc.RUnlock()
+		if err != nil {
+			log.Fatalf("Error printing config: %v", err)
+		}
+		for _, w := range warnings {
+			log.Printf("Warning: %s", w)
+		}
+		os.Exit(exitOK)
 	}
 
 	err := ac.getAccessToken()
 	if err != nil {
-		log.Fatalf("Failed to get token: %v", err)
+		cliExit(exitAuthFailed, fmt.Errorf("Failed to get token: %v", err), nil, nil)
+	}
+
+	if command == checkAccessCmd.FullCommand() {
+		checks := ac.checkAccess()
+		if *output == "json" {
+			results, code := accessChecksOutcome(checks)
+			cliExit(code, nil, nil, results)
+		}
+		if !logAccessChecks(checks) {
+			_, code := accessChecksOutcome(checks)
+			os.Exit(code)
+		}
+		log.Printf("All configured scopes are accessible")
+		os.Exit(exitOK)
+	}
+
+	if command == queryCmd.FullCommand() {
+		series, err := queryResourceMetrics(*queryResource, *queryNamespace, *queryMetrics, *queryAggregations, *queryDimensions, *queryTimespan, *queryInterval)
+		if err != nil {
+			cliExit(exitAPIError, err, nil, nil)
+		}
+		if *output == "json" {
+			cliExit(exitOK, nil, nil, series)
+		}
+		writeQuerySeries(os.Stdout, series, *queryAggregations)
+		os.Exit(exitOK)
 	}
 
 	// Print list of available metric definitions for each resource to console if specified.
 	if *listMetricDefinitions {
-		results, err := ac.getMetricDefinitions()
+		results, err := ac.getMetricDefinitions(definitionFilter{
+			resourceGroup: *listResourceGroup,
+			resources:     *listResources,
+			resourceTypes: *listResourceTypes,
+		})
 		if err != nil {
-			log.Fatalf("Failed to fetch metric definitions: %v", err)
+			cliExit(exitAPIError, fmt.Errorf("Failed to fetch metric definitions: %v", err), nil, nil)
+		}
+		if *output == "json" {
+			cliExit(exitOK, nil, nil, results)
 		}
 
-		for k, v := range results {
+		var keys []string
+		for k := range results {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
 			log.Printf("Resource: %s\n\nAvailable Metrics:\n", k)
-			for _, r := range v.MetricDefinitionResponses {
-				log.Printf("- %s\n", r.Name.Value)
+			for _, r := range results[k].MetricDefinitionResponses {
+				for _, line := range formatMetricDefinition(r) {
+					log.Println(line)
+				}
 			}
 		}
-		os.Exit(0)
+		os.Exit(exitOK)
 	}
 
 	// Print list of available metric namespace for each resource to console if specified.
 	if *listMetricNamespaces {
 		results, err := ac.getMetricNamespaces()
 		if err != nil {
-			log.Fatalf("Failed to fetch metric namespaces: %v", err)
+			cliExit(exitAPIError, fmt.Errorf("Failed to fetch metric namespaces: %v", err), nil, nil)
+		}
+		if *output == "json" {
+			cliExit(exitOK, nil, nil, results)
 		}
 
 		for k, v := range results {
@@ -368,14 +1224,26 @@ func main() {
 				log.Printf("- %s\n", namespace.Properties.MetricNamespaceName)
 			}
 		}
-		os.Exit(0)
+		os.Exit(exitOK)
+	}
+
+	if *startupCheckAccess {
+		logAccessChecks(ac.checkAccess())
 	}
 
-	err = ac.listAPIVersions()
+	err = ac.listAPIVersions(sc.Get())
 	if err != nil {
-		log.Fatal(err)
+		cliExit(exitAPIError, err, nil, nil)
 	}
 
+	if *leaderLockFile != "" {
+		elector = newLeaderElector(*leaderLockFile, *leaderID, *leaderLeaseDuration)
+		elector.tryAcquireOrRenew()
+		go elector.run()
+	}
+	go scheduledEvents.run()
+	go refreshAPIVersions()
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
             <head>
@@ -388,9 +1256,31 @@ func main() {
             </html>`))
 	})
 
+	scrapes = newScrapeLimiter(*maxRequests)
 	http.HandleFunc("/metrics", handler)
+	http.HandleFunc("/api/validate-config", lifecycleHandler(validateConfigHandler))
+	http.HandleFunc("/debug/slow", slowHandler)
+	http.HandleFunc("/debug/scrape", lastScrapeHandler)
+	http.HandleFunc("/-/reload", lifecycleHandler(reloadHandler))
+	http.HandleFunc("/-/reload-api-versions", lifecycleHandler(apiVersionsReloadHandler))
+	http.HandleFunc("/api/config", configHandler)
+	http.HandleFunc("/api/metric-names", metricNamesHandler)
+	http.HandleFunc("/api/stats", statsHandler)
+	http.HandleFunc("/api/preview", lifecycleHandler(previewHandler))
+	http.HandleFunc("/pmm/metadata", pmmMetadataHandler)
+	server := &http.Server{Addr: *listenAddress}
+	if stop != nil {
+		go func() {
+			<-stop
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("Error shutting down HTTP server: %v", err)
+			}
+		}()
+	}
 	log.Printf("azure_metrics_exporter listening on port %v", *listenAddress)
-	if err := http.ListenAndServe(*listenAddress, nil); err != nil {
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Error starting HTTP server: %v", err)
 	}
 }
@@ -0,0 +1,651 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestExtractMetricsDimensions(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{}
+
+	var data AzureMetricValueResponse
+	payload := `{"value": [{"name": {"value": "Requests"}, "unit": "Count", "timeseries": [
+		{"metadatavalues": [{"name": {"value": "instance"}, "value": "Web01.cloudapp.net"}], "data": [{"timeStamp": "2020-01-01T00:00:00Z", "total": 3}]},
+		{"metadatavalues": [{"name": {"value": "instance"}, "value": "web01"}], "data": [{"timeStamp": "2020-01-01T00:00:00Z", "total": 4}]},
+		{"metadatavalues": [{"name": {"value": "instance"}, "value": "Web02"}], "data": [{"timeStamp": "2020-01-01T00:00:00Z", "total": 5}]},
+		{"metadatavalues": [{"name": {"value": "instance"}, "value": "web03"}], "data": []}
+	]}]}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	rm := resourceMeta{
+		resourceID:   "/resourceGroups/rg/providers/Microsoft.Web/sites/app",
+		resourceURL:  "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app/providers/microsoft.insights/metrics",
+		aggregations: []string{"Total"},
+		resourceInfo: config.ResourceInfo{Skip: true},
+		dimensions: []config.Dimension{{Name: "Instance", Transforms: []config.DimensionTransform{
+			{Type: "strip_domain"}, {Type: "lowercase"},
+		}}},
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).extractMetrics(ch, rm, 200, data, map[string]bool{}, apiErrorSet{})
+	close(ch)
+
+	got := map[string]float64{}
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range metric.Label {
+			if l.GetName() == "instance" {
+				got[l.GetValue()] = metric.GetGauge().GetValue()
+			}
+		}
+	}
+	want := map[string]float64{"web01": 3, "web02": 5}
+	if len(got) != len(want) || got["web01"] != want["web01"] || got["web02"] != want["web02"] {
+		t.Errorf("doesn't split metrics by normalized dimension values\ngot: %v\nwant: %v", got, want)
+	}
+}
+
+func TestExtractMetricsAbsentAsZero(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{}
+
+	var data AzureMetricValueResponse
+	payload := `{"value": [
+		{"name": {"value": "Requests"}, "unit": "Count", "timeseries": [{"data": [{"timeStamp": "2020-01-01T00:00:00Z", "total": 3}]}]},
+		{"name": {"value": "Errors"}, "unit": "Count", "timeseries": []}
+	]}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, emitAbsentAsZero := range []bool{false, true} {
+		rm := resourceMeta{
+			resourceID:       "/resourceGroups/rg/providers/Microsoft.Web/sites/app",
+			resourceURL:      "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app/providers/microsoft.insights/metrics",
+			aggregations:     []string{"Total"},
+			resourceInfo:     config.ResourceInfo{Skip: true},
+			emitAbsentAsZero: emitAbsentAsZero,
+		}
+
+		ch := make(chan prometheus.Metric, 10)
+		(&Collector{cfg: sc.C}).extractMetrics(ch, rm, 200, data, map[string]bool{}, apiErrorSet{})
+		close(ch)
+
+		got := metricValues(t, ch)
+		want := map[string]float64{`requests_count_total{rg,app}`: 3}
+		if emitAbsentAsZero {
+			want[`errors_count_total{true,rg,app}`] = 0
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("doesn't publish absent metrics with emit_absent_as_zero %v\ngot: %v\nwant: %v", emitAbsentAsZero, got, want)
+		}
+	}
+}
+
+func TestExtractMetricsMaxDatapointAge(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{}
+
+	var data AzureMetricValueResponse
+	payload := fmt.Sprintf(`{"value": [
+		{"name": {"value": "Requests"}, "unit": "Count", "timeseries": [{"data": [{"timeStamp": %q, "total": 3}]}]},
+		{"name": {"value": "Errors"}, "unit": "Count", "timeseries": [{"data": [{"timeStamp": "2020-01-01T00:00:00Z", "total": 1}]}]}
+	]}`, time.Now().UTC().Add(-time.Minute).Format(time.RFC3339))
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	rm := resourceMeta{
+		resourceID:      "/resourceGroups/rg/providers/Microsoft.Web/sites/app",
+		resourceURL:     "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app/providers/microsoft.insights/metrics",
+		aggregations:    []string{"Total"},
+		resourceInfo:    config.ResourceInfo{Skip: true},
+		maxDatapointAge: 10 * time.Minute,
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).extractMetrics(ch, rm, 200, data, map[string]bool{}, apiErrorSet{})
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{`requests_count_total{rg,app}`: 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't reject datapoints older than max_datapoint_age\ngot: %v\nwant: %v", got, want)
+	}
+}
+
+func TestExtractMetricsLabelsNaming(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{MetricNaming: "labels"}
+
+	var data AzureMetricValueResponse
+	payload := `{"value": [{"name": {"value": "Requests"}, "unit": "Count", "timeseries": [
+		{"metadatavalues": [{"name": {"value": "unit"}, "value": "web01"}], "data": [{"timeStamp": "2020-01-01T00:00:00Z", "total": 3, "average": 1.5}]}
+	]}]}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	rm := resourceMeta{
+		resourceID:   "/resourceGroups/rg/providers/Microsoft.Web/sites/app",
+		resourceURL:  "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app/providers/microsoft.insights/metrics",
+		aggregations: []string{"Total", "Average"},
+		resourceInfo: config.ResourceInfo{Skip: true},
+		dimensions:   []config.Dimension{{Name: "unit"}},
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).extractMetrics(ch, rm, 200, data, map[string]bool{}, apiErrorSet{})
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`requests{total,web01,rg,app,count}`:   3,
+		`requests{average,web01,rg,app,count}`: 1.5,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't label metrics with their unit and aggregation\ngot: %v\nwant: %v", got, want)
+	}
+}
+
+func TestExtractMetricsAggregationLabelNaming(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{MetricNaming: "aggregation_label"}
+
+	var data AzureMetricValueResponse
+	payload := `{"value": [{"name": {"value": "Requests"}, "unit": "Count", "timeseries": [
+		{"data": [{"timeStamp": "2020-01-01T00:00:00Z", "total": 3, "maximum": 2}]}
+	]}]}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	rm := resourceMeta{
+		resourceID:   "/resourceGroups/rg/providers/Microsoft.Web/sites/app",
+		resourceURL:  "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app/providers/microsoft.insights/metrics",
+		aggregations: []string{"Total", "Maximum"},
+		resourceInfo: config.ResourceInfo{Skip: true},
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).extractMetrics(ch, rm, 200, data, map[string]bool{}, apiErrorSet{})
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`requests_count{total,rg,app}`:   3,
+		`requests_count{maximum,rg,app}`: 2,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't label metrics with their aggregation only\ngot: %v\nwant: %v", got, want)
+	}
+}
+
+var fqNamePattern = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// metricValues returns the values of the metrics sent to a closed channel by
+// name and label values sorted by label name, e.g.
+// azure_budget_limit{monthly,USD,Monthly}.
+func metricValues(t *testing.T, ch <-chan prometheus.Metric) map[string]float64 {
+	values := map[string]float64{}
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		var labels []string
+		for _, l := range metric.Label {
+			labels = append(labels, l.GetValue())
+		}
+		name := fqNamePattern.FindStringSubmatch(m.Desc().String())[1]
+		values[fmt.Sprintf("%s{%s}", name, strings.Join(labels, ","))] = metric.GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestBatchLookupResourcesFallbackAPIVersion(t *testing.T) {
+	var requests []batchRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			fmt.Fprint(w, `{"displayName": "sub"}`)
+			return
+		}
+		var batch batchBody
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Error(err)
+		}
+		requests = append(requests, batch.Requests...)
+		var responses []string
+		for range batch.Requests {
+			responses = append(responses, `{"httpStatusCode": 200, "content": {"name": "vm"}}`)
+		}
+		fmt.Fprintf(w, `{"responses": [%s]}`, strings.Join(responses, ","))
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	ac = NewAzureClient()
+	ac.APIVersions = APIVersionMap{"Microsoft.Compute/virtualMachines": "2021-03-01"}
+
+	resources := []resourceMeta{
+		{resourceID: "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"},
+		{resourceID: "/resourceGroups/rg/providers/Microsoft.Unknown/things/thing1"},
+	}
+	for i, r := range resources {
+		resources[i].resourceURL = resourceURLFrom("abc", r.resourceID, "", "Percentage CPU", []string{"Average"}, nil, nil, 0, 0)
+	}
+
+	for _, fallback := range []string{"", "2020-01-01"} {
+		sc.C = &config.Config{
+			ResourceManagerURL:       server.URL,
+			Credentials:              config.Credentials{SubscriptionID: "abc"},
+			LookupFallbackAPIVersion: fallback,
+		}
+		requests = nil
+		apiErrors := apiErrorSet{}
+
+		got, err := (&Collector{cfg: sc.C}).batchLookupResources(resources, apiErrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var urls []string
+		for _, r := range requests {
+			urls = append(urls, r.RelativeURL)
+		}
+		want := []string{"/subscriptions/abc//resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1?api-version=2021-03-01"}
+		if fallback != "" {
+			want = append(want, "/subscriptions/abc//resourceGroups/rg/providers/Microsoft.Unknown/things/thing1?api-version=2020-01-01")
+		}
+		if !reflect.DeepEqual(urls, want) || len(got) != len(want) {
+			t.Errorf("doesn't isolate resources without api version with fallback %q\ngot: %v\nwant: %v", fallback, urls, want)
+		}
+		if fallback == "" && len(apiErrors) != 1 {
+			t.Errorf("doesn't record the resource without api version\ngot: %v", apiErrors)
+		}
+	}
+}
+
+func TestAliasFor(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+
+	tests := []struct {
+		aliasCounters bool
+		metricName    string
+		aggregation   string
+		alias         string
+		counter       bool
+	}{
+		{false, "cpu_percent_percent", "Average", "node_cpu_average", false},
+		{false, "cpu_percent_percent", "Total", "", false},
+		{false, "network_bytes_egress_bytes", "Average", "node_network_transmit_bytes_total", false},
+		{false, "network_bytes_egress_bytes", "Total", "", false},
+		{true, "network_bytes_egress_bytes", "Average", "", false},
+		{true, "network_bytes_egress_bytes", "Total", "node_network_transmit_bytes_total", true},
+	}
+	for _, test := range tests {
+		sc.C = &config.Config{AliasCounters: test.aliasCounters}
+		if alias, counter := (&Collector{cfg: sc.C}).aliasFor(test.metricName, test.aggregation); alias != test.alias || counter != test.counter {
+			t.Errorf("alias_counters %v, %s %s\ngot: %v, %v\nwant: %v, %v", test.aliasCounters, test.metricName, test.aggregation, alias, counter, test.alias, test.counter)
+		}
+	}
+}
+
+func TestExtractMetricsDimensionAliases(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{}
+
+	var data AzureMetricValueResponse
+	payload := `{"value": [{"name": {"value": "cpu_percent"}, "unit": "Percent", "timeseries": [
+		{"metadatavalues": [{"name": {"value": "DatabaseResourceId"}, "value": "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Sql/servers/srv/databases/db1"}], "data": [{"timeStamp": "2020-01-01T00:00:00Z", "average": 12, "maximum": 30}]},
+		{"metadatavalues": [{"name": {"value": "DatabaseResourceId"}, "value": "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Sql/servers/srv/databases/db2"}], "data": [{"timeStamp": "2020-01-01T00:00:00Z", "average": 40, "maximum": 50}]}
+	]}]}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	rm := resourceMeta{
+		resourceID:   "/resourceGroups/rg/providers/Microsoft.Sql/servers/srv/elasticPools/pool",
+		resourceURL:  "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Sql/servers/srv/elasticPools/pool/providers/microsoft.insights/metrics",
+		aggregations: []string{"Average", "Maximum"},
+		resourceInfo: config.ResourceInfo{Skip: true},
+		dimensions:   []config.Dimension{{Name: "DatabaseResourceId"}},
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).extractMetrics(ch, rm, 200, data, map[string]bool{}, apiErrorSet{})
+	close(ch)
+
+	got := map[string]float64{}
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		name := regexp.MustCompile(`fqName: "([^"]+)"`).FindStringSubmatch(m.Desc().String())[1]
+		var labels []string
+		for _, l := range metric.Label {
+			if l.GetName() == "service_name" || l.GetName() == "databaseresourceid" {
+				labels = append(labels, l.GetName()+"="+l.GetValue())
+			}
+		}
+		got[name+"{"+strings.Join(labels, ",")+"}"] = metric.GetGauge().GetValue()
+	}
+	want := map[string]float64{
+		"node_cpu_average{service_name=db1}": 12,
+		"node_cpu_average{service_name=db2}": 40,
+		"cpu_percent_percent_max{databaseresourceid=/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Sql/servers/srv/databases/db1}": 30,
+		"cpu_percent_percent_max{databaseresourceid=/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Sql/servers/srv/databases/db2}": 50,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't alias the series split by DatabaseResourceId\ngot: %v\nwant: %v", got, want)
+	}
+}
+
+func TestLifecycleHandler(t *testing.T) {
+	previous := *enableLifecycle
+	defer func() { *enableLifecycle = previous }()
+
+	var called bool
+	h := lifecycleHandler(func(w http.ResponseWriter, r *http.Request) { called = true })
+	for _, enabled := range []bool{false, true} {
+		*enableLifecycle, called = enabled, false
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest("POST", "/-/reload", nil))
+		if called != enabled {
+			t.Errorf("handler called %v with --web.enable-lifecycle=%v", called, enabled)
+		}
+		if !enabled && rec.Code != http.StatusForbidden {
+			t.Errorf("unexpected status of a disabled endpoint\ngot: %d\nwant: %d", rec.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestCollectReleasesConfigLock(t *testing.T) {
+	requested, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		fmt.Fprint(w, `{"value": []}`)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL, Budgets: config.Budgets{Enabled: true}}
+	ac = NewAzureClient()
+	ac.tokens[server.URL] = accessToken{token: "token", expiresOn: time.Now().Add(time.Hour)}
+
+	ch := make(chan prometheus.Metric, 10)
+	done := make(chan struct{})
+	go func() {
+		(&Collector{collect: collectorSet{"budgets": true}}).Collect(ch)
+		close(done)
+	}()
+	<-requested
+
+	// A configuration reload doesn't wait for the running scrape.
+	locked := make(chan struct{})
+	go func() {
+		sc.Lock()
+		sc.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Errorf("configuration is locked during the scrape")
+	}
+	close(release)
+	<-done
+}
+
+func TestCollectDuringReload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/batch"):
+			fmt.Fprint(w, `{"responses": []}`)
+		case strings.Contains(r.URL.Path, "/budgets"):
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			fmt.Fprint(w, `{"value": []}`)
+		}
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{
+		ResourceManagerURL: server.URL,
+		Credentials:        config.Credentials{SubscriptionID: "abc"},
+		Budgets:            config.Budgets{Enabled: true},
+		Targets: []config.Target{{
+			Resource: "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+			Metrics:  []config.Metric{{Name: "Percentage CPU"}},
+		}},
+	}
+	ac = NewAzureClient()
+	ac.tokens[server.URL] = accessToken{token: "token", expiresOn: time.Now().Add(time.Hour)}
+
+	// Reloads replace the configuration while the scrapes run, which the
+	// race detector reports when the scrapes read it without the lock.
+	done := make(chan struct{})
+	reloaded := make(chan struct{})
+	go func() {
+		defer close(reloaded)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			sc.Lock()
+			c := *sc.C
+			c.QueryDelay += time.Second
+			sc.C = &c
+			sc.Unlock()
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		ch := make(chan prometheus.Metric, 100)
+		go func() {
+			for range ch {
+			}
+		}()
+		(&Collector{}).Collect(ch)
+		close(ch)
+	}
+	close(done)
+	<-reloaded
+}
+
+func TestHandlerIdentityLabels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/budgets") {
+			fmt.Fprint(w, `{"value": [{"name": "monthly", "properties": {"amount": 1000, "timeGrain": "Monthly"}}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"displayName": "Production"}`)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{
+		ResourceManagerURL:       server.URL,
+		Credentials:              config.Credentials{SubscriptionID: "abc", TenantID: "tenant"},
+		GlobalLabelsFromIdentity: []string{"subscription_id", "subscription_name", "tenant_id"},
+		Budgets:                  config.Budgets{Enabled: true},
+	}
+	ac = NewAzureClient()
+	ac.tokens[server.URL] = accessToken{token: "token", expiresOn: time.Now().Add(time.Hour)}
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/metrics?collect[]=budgets", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status\ngot: %d\nwant: %d\n%s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mf, ok := families["azure_budget_limit"]
+	if !ok || len(mf.Metric) != 1 {
+		t.Fatalf("budget isn't collected: %v", mf)
+	}
+	got := map[string]string{}
+	for _, l := range mf.Metric[0].Label {
+		got[l.GetName()] = l.GetValue()
+	}
+	want := map[string]string{
+		"budget":            "monthly",
+		"currency":          "",
+		"time_grain":        "Monthly",
+		"subscription_id":   "abc",
+		"subscription_name": "Production",
+		"tenant_id":         "tenant",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected labels of the sample\ngot: %v\nwant: %v", got, want)
+	}
+}
+
+func TestCollectSkipResourceLookup(t *testing.T) {
+	var lookups []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			fmt.Fprint(w, `{"value": []}`)
+			return
+		}
+		var batch batchBody
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Error(err)
+		}
+		var responses []string
+		for _, req := range batch.Requests {
+			if !strings.Contains(req.RelativeURL, "/providers/microsoft.insights/metrics") {
+				lookups = append(lookups, req.RelativeURL)
+				continue
+			}
+			responses = append(responses, fmt.Sprintf(`{"name": %q, "httpStatusCode": 200, "content": {"value": [{"name": {"value": "Percentage CPU"}, "unit": "Percent",
+				"timeseries": [{"data": [{"timeStamp": "2020-01-01T00:00:00Z", "average": 12}]}]}]}}`, req.Name))
+		}
+		fmt.Fprintf(w, `{"responses": [%s]}`, strings.Join(responses, ","))
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{
+		ResourceManagerURL: server.URL,
+		Credentials:        config.Credentials{SubscriptionID: "abc"},
+		Targets: []config.Target{{
+			Resource:           "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+			Metrics:            []config.Metric{{Name: "Percentage CPU"}},
+			Aggregations:       []string{"Average"},
+			SkipResourceLookup: true,
+		}},
+	}
+	ac = NewAzureClient()
+	ac.tokens[server.URL] = accessToken{token: "token", expiresOn: time.Now().Add(time.Hour)}
+
+	ch := make(chan prometheus.Metric, 100)
+	go func() {
+		(&Collector{collect: collectorSet{"targets": true}}).Collect(ch)
+		close(ch)
+	}()
+	got := metricValues(t, ch)
+
+	if len(lookups) > 0 {
+		t.Errorf("resource looked up despite skip_resource_lookup: %v", lookups)
+	}
+	if v, ok := got["percentage_cpu_percent_average{rg,vm1}"]; !ok || v != 12 {
+		t.Errorf("target isn't collected\ngot: %v", got)
+	}
+	for k := range got {
+		if strings.HasPrefix(k, "azure_resource_info{") {
+			t.Errorf("azure_resource_info exposed despite skip_resource_lookup: %s", k)
+		}
+	}
+}
+
+func TestExtractMetricsMetricPrefix(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+
+	var data AzureMetricValueResponse
+	payload := `{"value": [
+		{"name": {"value": "cpu_percent"}, "unit": "Percent", "timeseries": [{"data": [{"timeStamp": "2020-01-01T00:00:00Z", "average": 12, "total": 24}]}]},
+		{"name": {"value": "network_bytes_egress"}, "unit": "Bytes", "timeseries": [{"data": [{"timeStamp": "2020-01-01T00:00:00Z", "average": 512, "total": 2048}]}]}
+	]}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+	rm := resourceMeta{
+		resourceID:   "/resourceGroups/rg/providers/Microsoft.Sql/servers/srv/databases/db1",
+		resourceURL:  "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Sql/servers/srv/databases/db1/providers/microsoft.insights/metrics",
+		aggregations: []string{"Average", "Total"},
+		resourceInfo: config.ResourceInfo{Skip: true},
+	}
+
+	// The prefix applies to the generated names, the aliases keep their
+	// well-known names, including the counters of alias_counters.
+	tests := []struct {
+		aliasCounters bool
+		want          map[string]float64
+	}{
+		{false, map[string]float64{
+			"node_cpu_average{rg,srv,db1}":                       12,
+			"azure_cpu_percent_percent_total{rg,srv,db1}":        24,
+			"node_network_transmit_bytes_total{rg,srv,db1}":      512,
+			"azure_network_bytes_egress_bytes_total{rg,srv,db1}": 2048,
+		}},
+		{true, map[string]float64{
+			"node_cpu_average{rg,srv,db1}":                         12,
+			"azure_cpu_percent_percent_total{rg,srv,db1}":          24,
+			"azure_network_bytes_egress_bytes_average{rg,srv,db1}": 512,
+			// A counter, of which metricValues has no gauge value.
+			"node_network_transmit_bytes_total{rg,srv,db1}": 0,
+		}},
+	}
+	for _, test := range tests {
+		sc.C = &config.Config{MetricPrefix: "azure_", AliasCounters: test.aliasCounters}
+		ch := make(chan prometheus.Metric, 10)
+		(&Collector{cfg: sc.C}).extractMetrics(ch, rm, 200, data, map[string]bool{}, apiErrorSet{})
+		close(ch)
+		if got := metricValues(t, ch); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("alias_counters %v: unexpected metrics\ngot: %v\nwant: %v", test.aliasCounters, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// managementGroupsAPIVersion is the API version of the management groups.
+const managementGroupsAPIVersion = "2020-05-01"
+
+// managementGroupSubscriptionType is the type of the subscriptions among the
+// descendants of a management group.
+const managementGroupSubscriptionType = "Microsoft.Management/managementGroups/subscriptions"
+
+// ManagementGroupDescendantsResponse is a page of the descendants of a
+// management group, its subscriptions and management groups at any depth.
+type ManagementGroupDescendantsResponse struct {
+	Value []struct {
+		Type       string `json:"type"`
+		Name       string `json:"name"`
+		Properties struct {
+			DisplayName string `json:"displayName"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+// managementGroupsEntry caches the subscriptions of management groups.
+type managementGroupsEntry struct {
+	groups        []string
+	subscriptions []string
+	expires       time.Time
+}
+
+// discoverySubscriptions returns the subscriptions in which the resource
+// groups and the resource tags are discovered: the subscriptions of the
+// management groups when configured, else the subscription of the
+// credentials. The subscriptions are cached like the subscription names, the
+// last known ones being used when they can't be listed.
+func (ac *AzureClient) discoverySubscriptions(scrapeID string) ([]string, error) {
+	cfg := sc.Get()
+	groups := cfg.ManagementGroups
+	if len(groups) == 0 {
+		return []string{cfg.Credentials.SubscriptionID}, nil
+	}
+
+	ac.managementGroupsMtx.Lock()
+	defer ac.managementGroupsMtx.Unlock()
+
+	now := time.Now()
+	entry := ac.managementGroups
+	known := reflect.DeepEqual(entry.groups, groups)
+	if known && now.Before(entry.expires) {
+		return entry.subscriptions, nil
+	}
+
+	subscriptions, err := ac.listManagementGroupSubscriptions(groups, scrapeID)
+	if err != nil {
+		if !known {
+			return nil, err
+		}
+		log.Printf("Failed to list the subscriptions of management groups %s, using the last known ones: %v", strings.Join(groups, ", "), err)
+		entry.expires = now.Add(subscriptionNameRetryDelay)
+	} else {
+		entry = managementGroupsEntry{groups: groups, subscriptions: subscriptions, expires: now.Add(cfg.SubscriptionNameRefreshInterval)}
+	}
+	ac.managementGroups = entry
+	return entry.subscriptions, nil
+}
+
+// listManagementGroupSubscriptions returns the sorted subscriptions of the
+// management groups and of their nested management groups. Their display
+// names are cached as the subscription names.
+func (ac *AzureClient) listManagementGroupSubscriptions(groups []string, scrapeID string) ([]string, error) {
+	names := map[string]string{}
+	for _, group := range groups {
+		endpoint := fmt.Sprintf("%s/providers/Microsoft.Management/managementGroups/%s/descendants?api-version=%s",
+			strings.TrimSuffix(sc.Get().ResourceManagerURL, "/"), url.PathEscape(group), managementGroupsAPIVersion)
+		err := forEachPage(endpoint, scrapeID, func(body []byte) (string, error) {
+			var data ManagementGroupDescendantsResponse
+			if err := json.Unmarshal(body, &data); err != nil {
+				return "", fmt.Errorf("Error unmarshalling response body: %v", err)
+			}
+			for _, d := range data.Value {
+				if strings.EqualFold(d.Type, managementGroupSubscriptionType) {
+					names[d.Name] = d.Properties.DisplayName
+				}
+			}
+			return data.NextLink, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error listing the descendants of management group %s: %w", group, err)
+		}
+	}
+
+	subscriptions := make([]string, 0, len(names))
+	for subscription := range names {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Strings(subscriptions)
+
+	ac.subscriptionNamesMtx.Lock()
+	defer ac.subscriptionNamesMtx.Unlock()
+	for subscription, name := range names {
+		if name != "" {
+			ac.subscriptionNames[subscription] = subscriptionNameEntry{name: name, expires: time.Now().Add(sc.Get().SubscriptionNameRefreshInterval)}
+		}
+	}
+	return subscriptions, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDiscoverySubscriptions(t *testing.T) {
+	var requests int
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if failing {
+			http.Error(w, `{"error": {"code": "AuthorizationFailed"}}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/providers/Microsoft.Management/managementGroups/contoso/descendants":
+			if r.URL.Query().Get("page") == "" {
+				fmt.Fprintf(w, `{"value": [
+					{"type": "Microsoft.Management/managementGroups/subscriptions", "name": "def", "properties": {"displayName": "Production"}},
+					{"type": "Microsoft.Management/managementGroups", "name": "nested"}
+				], "nextLink": "http://%s%s?page=2"}`, r.Host, r.URL.Path)
+				return
+			}
+			fmt.Fprint(w, `{"value": [{"type": "Microsoft.Management/managementGroups/subscriptions", "name": "abc", "properties": {"displayName": "Staging"}}]}`)
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{
+		ResourceManagerURL:              server.URL,
+		Credentials:                     config.Credentials{SubscriptionID: "xyz"},
+		SubscriptionNameRefreshInterval: time.Hour,
+	}
+	ac = NewAzureClient()
+
+	got, err := ac.discoverySubscriptions("")
+	if err != nil || !reflect.DeepEqual(got, []string{"xyz"}) {
+		t.Errorf("unexpected subscriptions without management groups\ngot: %v, %v\nwant: [xyz]", got, err)
+	}
+
+	sc.C.ManagementGroups = []string{"contoso"}
+	got, err = ac.discoverySubscriptions("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"abc", "def"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected subscriptions\ngot: %v\nwant: %v", got, want)
+	}
+	if name := ac.subscriptionName("def"); name != "Production" {
+		t.Errorf("unexpected subscription name\ngot: %s\nwant: Production", name)
+	}
+
+	// The subscriptions are cached, and the last known ones are used when
+	// they can't be listed.
+	requests = 0
+	if _, err := ac.discoverySubscriptions(""); err != nil || requests != 0 {
+		t.Errorf("subscriptions weren't cached: %d requests, %v", requests, err)
+	}
+	failing = true
+	ac.managementGroups.expires = time.Now()
+	if got, err := ac.discoverySubscriptions(""); err != nil || len(got) != 2 {
+		t.Errorf("unexpected subscriptions after a failure\ngot: %v, %v\nwant: [abc def]", got, err)
+	}
+
+	ac = NewAzureClient()
+	if _, err := ac.discoverySubscriptions(""); errorCode(err) != "AuthorizationFailed" {
+		t.Errorf("unexpected error without known subscriptions\ngot: %v\nwant: AuthorizationFailed", err)
+	}
+}
+
+func TestExtractMetricsSubscriptions(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{ManagementGroups: []string{"contoso"}}
+
+	var data AzureMetricValueResponse
+	payload := `{"value": [{"name": {"value": "Requests"}, "unit": "Count", "timeseries": [
+		{"data": [{"timeStamp": "2020-01-01T00:00:00Z", "total": 3}]}
+	]}]}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Per-environment subscriptions hold resources of the same names.
+	c := &Collector{cfg: sc.C}
+	ch := make(chan prometheus.Metric, 10)
+	published := map[string]bool{}
+	for _, subscription := range []string{"abc", "def"} {
+		id := "/resourceGroups/rg/providers/Microsoft.Web/sites/app"
+		rm := resourceMeta{
+			resourceID:   id,
+			resourceURL:  "/subscriptions/" + subscription + id + "/providers/microsoft.insights/metrics",
+			aggregations: []string{"Total"},
+			resource:     AzureResource{ID: id, Type: "Microsoft.Web/sites", Subscription: subscription},
+			subscription: subscription,
+		}
+		c.extractMetrics(ch, rm, 200, data, published, apiErrorSet{})
+	}
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`requests_count_total{abc,rg,app}`: 3,
+		`requests_count_total{def,rg,app}`: 3,
+		`azure_resource_info{,abc,/resourceGroups/rg/providers/Microsoft.Web/sites/app,,,rg,app,Microsoft.Web/sites}`: 1,
+		`azure_resource_info{,def,/resourceGroups/rg/providers/Microsoft.Web/sites/app,,,rg,app,Microsoft.Web/sites}`: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't tell the resources of the subscriptions apart\ngot: %v\nwant: %v", got, want)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// metricNameHashLengths are the lengths of the hashes suffixed to truncated
+// metric names, a longer hash is used when a shorter one collides.
+var metricNameHashLengths = []int{8, 16}
+
+// metricNameMap truncates the metric names longer than
+// max_metric_name_length and remembers the full names of the truncated ones.
+type metricNameMap struct {
+	sync.Mutex
+	full map[string]string
+}
+
+func newMetricNameMap() *metricNameMap {
+	return &metricNameMap{full: map[string]string{}}
+}
+
+// shorten returns the name truncated to maxLength, with a hash of the full
+// name so that truncated names stay unique. Names are kept when maxLength is
+// 0.
+func (m *metricNameMap) shorten(name string, maxLength int) string {
+	if maxLength <= 0 || len(name) <= maxLength {
+		return name
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	var short string
+	for _, hashLength := range metricNameHashLengths {
+		short = truncateMetricName(name, maxLength, hashLength)
+		if full, ok := m.full[short]; !ok || full == name {
+			m.full[short] = name
+			return short
+		}
+	}
+	log.Printf("Truncated metric name %s of %s collides with the one of %s", short, name, m.full[short])
+	return short
+}
+
+// names returns the full names of the truncated metric names.
+func (m *metricNameMap) names() map[string]string {
+	m.Lock()
+	defer m.Unlock()
+
+	names := make(map[string]string, len(m.full))
+	for short, full := range m.full {
+		names[short] = full
+	}
+	return names
+}
+
+// truncateMetricName truncates the name so that it ends with the first
+// hexadecimal characters of its hash, e.g. name_prefix_1a2b3c4d.
+func truncateMetricName(name string, maxLength int, hashLength int) string {
+	sum := sha256.Sum256([]byte(name))
+	return name[:maxLength-hashLength-1] + "_" + hex.EncodeToString(sum[:])[:hashLength]
+}
+
+// metricNamesHandler lists the full names of the truncated metric names.
+func metricNamesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, metricNames.names())
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetricNameMapShorten(t *testing.T) {
+	m := newMetricNameMap()
+
+	if got := m.shorten("requests_count_total", 32); got != "requests_count_total" {
+		t.Errorf("truncates a short name\ngot: %v", got)
+	}
+	if got := m.shorten(strings.Repeat("a", 100), 0); len(got) != 100 {
+		t.Errorf("truncates without max length\ngot: %v", got)
+	}
+
+	long := "microsoft_insights_components_availabilityresults_availabilitypercentage_percent_average"
+	short := m.shorten(long, 40)
+	if len(short) != 40 || !strings.HasPrefix(short, long[:31]) {
+		t.Errorf("doesn't truncate the name to the max length\ngot: %v", short)
+	}
+	if got := m.shorten(long, 40); got != short {
+		t.Errorf("truncation isn't deterministic\ngot: %v\nwant: %v", got, short)
+	}
+	if other := m.shorten(long[:len(long)-7]+"maximum", 40); other == short {
+		t.Errorf("truncated names of different metrics collide: %v", other)
+	}
+
+	// A colliding hash is replaced by a longer one.
+	m.full[truncateMetricName("colliding_"+long, 40, 8)] = "another"
+	if got := m.shorten("colliding_"+long, 40); got != truncateMetricName("colliding_"+long, 40, 16) {
+		t.Errorf("doesn't resolve the collision of truncated names\ngot: %v", got)
+	}
+
+	if got := m.names()[short]; got != long {
+		t.Errorf("doesn't map the truncated name to the full name\ngot: %v\nwant: %v", got, long)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var namespaceSeriesCountDesc = prometheus.NewDesc("azure_namespace_series_count", "Number of series of the Azure metrics of the scrape by resource provider namespace", []string{"namespace"}, nil)
+
+// namespaceSeries tracks the resource provider namespaces of the metrics of a
+// scrape, as configured by group_by_namespace.
+type namespaceSeries struct {
+	// families maps the metric names to the namespace of their first series.
+	families map[string]string
+	counts   map[string]int
+}
+
+// add records a series of a metric of the resource provider namespace.
+func (n *namespaceSeries) add(name string, namespace string) {
+	if n.families == nil {
+		n.families = map[string]string{}
+		n.counts = map[string]int{}
+	}
+	if _, ok := n.families[name]; !ok {
+		n.families[name] = namespace
+	}
+	n.counts[namespace]++
+}
+
+// collect sends the number of series of each namespace.
+func (n *namespaceSeries) collect(ch chan<- prometheus.Metric) {
+	for namespace, count := range n.counts {
+		ch <- prometheus.MustNewConstMetric(namespaceSeriesCountDesc, prometheus.GaugeValue, float64(count), namespace)
+	}
+}
+
+// sort sorts the metric families by namespace and then by name. The families
+// of other metrics come last.
+func (n *namespaceSeries) sort(mfs []*dto.MetricFamily) {
+	sort.SliceStable(mfs, func(i, j int) bool {
+		a, aOK := n.families[mfs[i].GetName()]
+		b, bOK := n.families[mfs[j].GetName()]
+		if aOK != bOK {
+			return aOK
+		}
+		return a < b
+	})
+}
+
+// providerNamespace returns the resource provider namespace of the resource,
+// e.g. Microsoft.Compute.
+func providerNamespace(rm resourceMeta) string {
+	return strings.SplitN(GetResourceType(rm.resourceURL), "/", 2)[0]
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNamespaceSeries(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{GroupByNamespace: true}
+
+	var data AzureMetricValueResponse
+	payload := `{"value": [{"name": {"value": "Requests"}, "unit": "Count", "timeseries": [{"data": [{"timeStamp": "2020-01-01T00:00:00Z", "total": 3}]}]}]}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Collector{cfg: sc.C}
+	ch := make(chan prometheus.Metric, 10)
+	for url, name := range map[string]string{
+		"/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app1/providers/microsoft.insights/metrics":    "Errors",
+		"/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app2/providers/microsoft.insights/metrics":    "Errors",
+		"/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Cache/Redis/cache/providers/microsoft.insights/metrics": "Hits",
+	} {
+		rm := resourceMeta{
+			resourceID:   url,
+			resourceURL:  url,
+			aggregations: []string{"Total"},
+			resourceInfo: config.ResourceInfo{Skip: true},
+		}
+		data.Value[0].Name.Value = name
+		c.extractMetrics(ch, rm, 200, data, map[string]bool{}, apiErrorSet{})
+	}
+	for len(ch) > 0 {
+		<-ch
+	}
+	c.namespaces.collect(ch)
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`azure_namespace_series_count{Microsoft.Web}`:   2,
+		`azure_namespace_series_count{Microsoft.Cache}`: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't count the series by namespace\ngot: %v\nwant: %v", got, want)
+	}
+
+	var mfs []*dto.MetricFamily
+	for _, name := range []string{"azure_resource_info", "errors_count_total", "hits_count_total"} {
+		name := name
+		mfs = append(mfs, &dto.MetricFamily{Name: &name})
+	}
+	c.namespaces.sort(mfs)
+	var names []string
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+	}
+	wantNames := []string{"hits_count_total", "errors_count_total", "azure_resource_info"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("doesn't sort the families by namespace\ngot: %v\nwant: %v", names, wantNames)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// percentilesOf returns the percentiles of the metrics of a block, by
+// lowercase metric name.
+func percentilesOf(metrics []config.Metric) map[string]*config.Percentiles {
+	var percentiles map[string]*config.Percentiles
+	for _, m := range metrics {
+		if m.Percentiles == nil {
+			continue
+		}
+		if percentiles == nil {
+			percentiles = map[string]*config.Percentiles{}
+		}
+		percentiles[strings.ToLower(m.Name)] = m.Percentiles
+	}
+	return percentiles
+}
+
+// percentileQuery returns the query of the percentiles of the logs of the
+// resource over the timespan of the metrics.
+func percentileQuery(p *config.Percentiles, resourceID string, endTime string, startTime string) string {
+	var values []string
+	for _, v := range p.Values {
+		values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return fmt.Sprintf("%s | where TimeGenerated between (datetime(%s) .. datetime(%s)) | where _ResourceId =~ '%s' | summarize percentiles(%s, %s)",
+		p.Table, startTime, endTime, strings.Replace(resourceID, "'", "", -1), p.Column, strings.Join(values, ", "))
+}
+
+// percentileSuffix returns the metric name suffix of a percentile, e.g. p99_9.
+func percentileSuffix(v float64) string {
+	return "p" + strings.Replace(strconv.FormatFloat(v, 'f', -1, 64), ".", "_", -1)
+}
+
+// emitPercentiles publishes the percentiles of a metric of the resource,
+// computed from its logs in Log Analytics, along with its aggregations.
+func (c *Collector) emitPercentiles(ch chan<- prometheus.Metric, rm resourceMeta, metricName string, description string, unit string, p *config.Percentiles, apiErrors apiErrorSet) {
+	endTime, startTime := GetTimes(rm.timespan)
+	data, err := ac.queryLogAnalytics(p.WorkspaceID, percentileQuery(p, rm.resourceID, endTime, startTime), c.scrapeID)
+	if err != nil {
+		c.logf("Failed to query the percentiles of metric %s at target %s: %v", metricName, rm.resourceURL, err)
+		apiErrors.add(errorCode(err), rm.resourceID)
+		return
+	}
+	// The percentiles are the columns of the single row, in the order of
+	// the query, and null without logs.
+	if len(data.Tables) == 0 || len(data.Tables[0].Rows) == 0 {
+		return
+	}
+	row := data.Tables[0].Rows[0]
+	for i, v := range p.Values {
+		if i >= len(row) {
+			break
+		}
+		val, ok := row[i].(float64)
+		if !ok {
+			continue
+		}
+		if p.Scale != 0 {
+			val *= p.Scale
+		}
+		labels := resourceLabels(rm)
+		for name, v := range rm.labels {
+			if _, ok := labels[name]; !ok {
+				labels[name] = v
+			}
+		}
+		c.addNamingLabels(labels, unit)
+		name := metricName + "_" + percentileSuffix(v)
+		if c.aggregationLabels() {
+			name = metricName
+			labels["aggregation"] = percentileSuffix(v)
+		}
+		name = metricNames.shorten(c.cfg.MetricPrefix+name, c.cfg.MaxMetricNameLength)
+		help := name
+		if description != "" {
+			help = fmt.Sprintf("%s (%s percentile)", description, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(name, help, nil, labels), prometheus.GaugeValue, val)
+		c.stats.add(rm.block, subscriptionOf(rm), entryStats{Series: 1})
+		if c.cfg.GroupByNamespace {
+			c.namespaces.add(name, providerNamespace(rm))
+		}
+	}
+}
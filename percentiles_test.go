@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPercentileQuery(t *testing.T) {
+	p := &config.Percentiles{Table: "AppServiceHTTPLogs", Column: "TimeTaken", Values: []float64{95, 99.9}}
+	got := percentileQuery(p, "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app", "2020-01-01T00:05:00Z", "2020-01-01T00:00:00Z")
+	want := "AppServiceHTTPLogs | where TimeGenerated between (datetime(2020-01-01T00:00:00Z) .. datetime(2020-01-01T00:05:00Z)) | where _ResourceId =~ '/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app' | summarize percentiles(TimeTaken, 95, 99.9)"
+	if got != want {
+		t.Errorf("unexpected query\ngot: %s\nwant: %s", got, want)
+	}
+
+	if got := percentileSuffix(99.9); got != "p99_9" {
+		t.Errorf("unexpected suffix\ngot: %s\nwant: p99_9", got)
+	}
+}
+
+func TestEmitPercentiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/workspaces/0000-1111/query" || !strings.Contains(r.URL.Query().Get("query"), "percentiles(TimeTaken, 95, 99)") {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		fmt.Fprint(w, `{"tables": [{"columns": [{"name": "percentile_TimeTaken_95"}, {"name": "percentile_TimeTaken_99"}], "rows": [[120.5, null]]}]}`)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{LogAnalytics: config.LogAnalytics{QueryURL: server.URL}}
+	ac = NewAzureClient()
+	ac.tokens[server.URL] = accessToken{token: "token", expiresOn: time.Now().Add(time.Hour)}
+
+	rm := resourceMeta{
+		resourceID:  "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app",
+		resourceURL: "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app/providers/microsoft.insights/metrics",
+	}
+	p := &config.Percentiles{WorkspaceID: "0000-1111", Table: "AppServiceHTTPLogs", Column: "TimeTaken", Values: []float64{95, 99}, Scale: 0.001}
+
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).emitPercentiles(ch, rm, "httpresponsetime_seconds", "", "Seconds", p, apiErrorSet{})
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{`httpresponsetime_seconds_p95{rg,app}`: 0.1205}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't publish the percentiles\ngot: %v\nwant: %v", got, want)
+	}
+}
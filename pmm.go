@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/common/version"
+)
+
+// pmmExporterType is the type of the exporter in the PMM inventory.
+const pmmExporterType = "azure_database_exporter"
+
+// pmmServiceTypes are the PMM service types of the resource types monitored
+// as databases, the other resource types being remote nodes.
+var pmmServiceTypes = map[string]string{
+	"microsoft.dbformysql/servers":              "mysql",
+	"microsoft.dbformysql/flexibleservers":      "mysql",
+	"microsoft.dbforpostgresql/servers":         "postgresql",
+	"microsoft.dbforpostgresql/flexibleservers": "postgresql",
+}
+
+// pmmServiceLabels are the labels of the PMM services and nodes, with the
+// labels of the Azure metrics holding their value.
+var pmmServiceLabels = map[string]string{
+	"node_name":            "resource_name",
+	"service_name":         "resource_name",
+	"azure_resource_group": "resource_group",
+}
+
+// pmmMetadata describes the exporter to pmm-managed, to register the Azure
+// nodes and services in the PMM inventory.
+type pmmMetadata struct {
+	ExporterType  string            `json:"exporter_type"`
+	Version       string            `json:"version"`
+	MetricsPath   string            `json:"metrics_path"`
+	Collectors    []string          `json:"collectors"`
+	ResourceTypes []pmmResourceType `json:"resource_types"`
+	Labels        map[string]string `json:"labels"`
+}
+
+// pmmResourceType is a resource type supported by the presets.
+type pmmResourceType struct {
+	Type        string   `json:"type"`
+	NodeType    string   `json:"node_type"`
+	ServiceType string   `json:"service_type,omitempty"`
+	Presets     []string `json:"presets"`
+}
+
+// newPMMMetadata returns the metadata of the exporter, with the resource
+// types of the presets sorted by type.
+func newPMMMetadata() pmmMetadata {
+	presets := map[string][]string{}
+	for name, p := range config.Presets {
+		types := append([]string{}, p.ResourceTypes...)
+		for t := range p.TypeMetrics {
+			types = append(types, t)
+		}
+		for _, t := range types {
+			if !containsFold(presets[t], name) {
+				presets[t] = append(presets[t], name)
+			}
+		}
+	}
+
+	resourceTypes := []pmmResourceType{}
+	for t, names := range presets {
+		sort.Strings(names)
+		r := pmmResourceType{Type: t, NodeType: "remote", Presets: names}
+		if serviceType, ok := pmmServiceTypes[strings.ToLower(t)]; ok {
+			r.NodeType, r.ServiceType = "remote_azure_database", serviceType
+		}
+		resourceTypes = append(resourceTypes, r)
+	}
+	sort.Slice(resourceTypes, func(i, j int) bool {
+		return resourceTypes[i].Type < resourceTypes[j].Type
+	})
+
+	return pmmMetadata{
+		ExporterType:  pmmExporterType,
+		Version:       version.Version,
+		MetricsPath:   "/metrics",
+		Collectors:    collectorNames,
+		ResourceTypes: resourceTypes,
+		Labels:        pmmServiceLabels,
+	}
+}
+
+// pmmMetadataHandler returns the metadata of the exporter in the format
+// expected by pmm-managed for external exporters.
+func pmmMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, newPMMMetadata())
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPMMMetadataHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	pmmMetadataHandler(rec, httptest.NewRequest("GET", "/pmm/metadata", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status\ngot: %d\nwant: %d", rec.Code, http.StatusOK)
+	}
+	var got pmmMetadata
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if got.ExporterType != pmmExporterType || got.MetricsPath != "/metrics" {
+		t.Errorf("unexpected metadata %+v", got)
+	}
+
+	types := map[string]pmmResourceType{}
+	for i, r := range got.ResourceTypes {
+		if i > 0 && got.ResourceTypes[i-1].Type >= r.Type {
+			t.Errorf("resource types aren't sorted: %s before %s", got.ResourceTypes[i-1].Type, r.Type)
+		}
+		types[r.Type] = r
+	}
+	want := pmmResourceType{
+		Type:        "Microsoft.DBforMySQL/flexibleServers",
+		NodeType:    "remote_azure_database",
+		ServiceType: "mysql",
+		Presets:     []string{"cpu_credits", "mysql"},
+	}
+	if got := types[want.Type]; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected resource type\ngot: %+v\nwant: %+v", got, want)
+	}
+	if got := types["Microsoft.Compute/virtualMachines"]; got.NodeType != "remote" || got.ServiceType != "" {
+		t.Errorf("unexpected virtual machines %+v", got)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var policyNonCompliantResourcesDesc = prometheus.NewDesc("azure_policy_noncompliant_resources", "Number of resources non-compliant with an Azure Policy assignment",
+	[]string{"policy_assignment", "resource_type"}, nil)
+
+// policyNonCompliantApply groups the latest non-compliant policy states by
+// resource first, so that resources evaluated against several definitions of
+// an initiative are counted once.
+const policyNonCompliantApply = "groupby((PolicyAssignmentName,ResourceType,ResourceId))/groupby((PolicyAssignmentName,ResourceType),aggregate($count as NumNonCompliantResources))"
+
+// AzurePolicyStatesQueryResponse is a page of the results of a query of the
+// Policy Insights policy states.
+type AzurePolicyStatesQueryResponse struct {
+	Value []struct {
+		PolicyAssignmentName     string    `json:"policyAssignmentName"`
+		ResourceType             string    `json:"resourceType"`
+		NumNonCompliantResources jsonFloat `json:"numNonCompliantResources"`
+	} `json:"value"`
+	NextLink string `json:"@odata.nextLink"`
+}
+
+// collectPolicyCompliance exposes the number of non-compliant resources of
+// the subscription by policy assignment and resource type, as summarized by
+// Policy Insights from the latest policy states.
+func (c *Collector) collectPolicyCompliance(ch chan<- prometheus.Metric, apiErrors apiErrorSet) {
+	apiVersion := "2019-10-01"
+	query := url.Values{
+		"api-version": {apiVersion},
+		"$filter":     {"ComplianceState eq 'NonCompliant'"},
+		"$apply":      {policyNonCompliantApply},
+	}
+	endpoint := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.PolicyInsights/policyStates/latest/queryResults?%s",
+		strings.TrimSuffix(c.cfg.ResourceManagerURL, "/"), c.cfg.Credentials.SubscriptionID, query.Encode())
+
+	// The pages of query results are requested with POST as well.
+	for endpoint != "" {
+		body, err := postAzureMonitorRequest(endpoint, c.scrapeID)
+		if err != nil {
+			c.logf("Failed to get policy states: %v", err)
+			apiErrors.add(errorCode(err), "policy_states")
+			return
+		}
+		var page AzurePolicyStatesQueryResponse
+		if err := decodeLenient("policy", body, &page); err != nil {
+			c.logf("Failed to get policy states: %v", err)
+			apiErrors.add(errorCode(err), "policy_states")
+			return
+		}
+		for _, r := range page.Value {
+			ch <- prometheus.MustNewConstMetric(policyNonCompliantResourcesDesc, prometheus.GaugeValue, float64(r.NumNonCompliantResources),
+				r.PolicyAssignmentName, strings.ToLower(r.ResourceType))
+		}
+		endpoint = page.NextLink
+	}
+}
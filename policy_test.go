@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectPolicyCompliance(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("unexpected method %s", r.Method)
+		}
+		if r.URL.Query().Get("$skiptoken") == "" {
+			if got := r.URL.Query().Get("$filter"); got != "ComplianceState eq 'NonCompliant'" {
+				t.Errorf("unexpected filter %q", got)
+			}
+			fmt.Fprintf(w, `{"value": [{"policyAssignmentName": "require-tags", "resourceType": "Microsoft.Storage/storageAccounts", "numNonCompliantResources": 4}],
+				"@odata.nextLink": "%s/next?$skiptoken=abc"}`, server.URL)
+			return
+		}
+		fmt.Fprint(w, `{"value": [{"policyAssignmentName": "require-tags", "resourceType": "Microsoft.Compute/virtualMachines", "numNonCompliantResources": 2}]}`)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL}
+	ac = NewAzureClient()
+
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).collectPolicyCompliance(ch, apiErrorSet{})
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`azure_policy_noncompliant_resources{require-tags,microsoft.storage/storageaccounts}`: 4,
+		`azure_policy_noncompliant_resources{require-tags,microsoft.compute/virtualmachines}`: 2,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't collect policy compliance\ngot: %v\nwant: %v", got, want)
+	}
+}
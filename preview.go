@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+// previewResource is a resource the metrics of which would be scraped.
+type previewResource struct {
+	ID           string   `json:"id"`
+	Subscription string   `json:"subscription,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	Type         string   `json:"type"`
+	Location     string   `json:"location,omitempty"`
+	Metrics      []string `json:"metrics"`
+}
+
+// previewResult are the resources discovered for a configured block.
+type previewResult struct {
+	Block     string            `json:"block"`
+	Resources []previewResource `json:"resources"`
+}
+
+// errUnknownBlock is returned for a block which isn't in the configuration.
+type errUnknownBlock struct {
+	block string
+}
+
+func (e errUnknownBlock) Error() string {
+	return fmt.Sprintf("Unknown block %q", e.block)
+}
+
+// previewBlock runs the discovery of a configured block, without requesting
+// the metrics of the discovered resources.
+func previewBlock(block string) (previewResult, error) {
+	result := previewResult{Block: block, Resources: []previewResource{}}
+
+	cfg := sc.Get()
+	kind, i, ok := cfg.FindBlock(block)
+	if !ok {
+		return result, errUnknownBlock{block}
+	}
+	var (
+		preset  string
+		metrics []config.Metric
+		list    func(subscription string) ([]AzureResource, error)
+	)
+	switch kind {
+	case config.TargetsBlock:
+		for _, t := range expandTargets(cfg.Targets[i : i+1]) {
+			r := AzureResource{ID: t.Resource, Type: resourceTypeOf(t.Resource)}
+			result.Resources = append(result.Resources, newPreviewResource(r, t.Preset, t.Metrics))
+		}
+		return result, nil
+	case config.ResourceGroupsBlock:
+		rg := cfg.ResourceGroups[i]
+		preset, metrics = rg.Preset, rg.Metrics
+		list = func(subscription string) ([]AzureResource, error) {
+			resources, err := ac.filteredListFromResourceGroup(subscription, rg, "")
+			if err != nil && len(cfg.ManagementGroups) > 0 && errorCode(err) == "ResourceGroupNotFound" {
+				return nil, nil
+			}
+			return resources, err
+		}
+	case config.ResourceTagsBlock:
+		tag := cfg.ResourceTags[i]
+		preset, metrics = tag.Preset, tag.Metrics
+		list = func(subscription string) ([]AzureResource, error) {
+			return ac.filteredListByTag(subscription, tag, map[string][]byte{}, "")
+		}
+	}
+
+	if err := ac.refreshAccessToken(); err != nil {
+		return result, err
+	}
+	subscriptions, err := ac.discoverySubscriptions("")
+	if err != nil {
+		return result, err
+	}
+	for _, subscription := range subscriptions {
+		resources, err := list(subscription)
+		if err != nil {
+			return result, err
+		}
+		for _, r := range resources {
+			result.Resources = append(result.Resources, newPreviewResource(r, preset, metrics))
+		}
+	}
+	return result, nil
+}
+
+func newPreviewResource(r AzureResource, preset string, metrics []config.Metric) previewResource {
+	return previewResource{
+		ID:           r.ID,
+		Subscription: r.Subscription,
+		Name:         r.Name,
+		Type:         r.Type,
+		Location:     r.Location,
+		Metrics:      config.PresetMetrics(preset, r.Type, metrics),
+	}
+}
+
+// previewHandler returns the resources which would be scraped for the block
+// given by the block parameter, e.g. db-prod or resource_tags[3], to debug
+// one block of a large configuration.
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	block := r.URL.Query().Get("block")
+	if block == "" {
+		http.Error(w, "Missing block parameter", http.StatusBadRequest)
+		return
+	}
+	result, err := previewBlock(block)
+	if _, ok := err.(errUnknownBlock); ok {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Error discovering the resources of %s: %v", block, err), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestPreviewHandler(t *testing.T) {
+	var metricsRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/oauth2/token"):
+			fmt.Fprintf(w, `{"access_token":"token","expires_on":"%d"}`, time.Now().Add(time.Hour).Unix())
+		case r.URL.Path == "/subscriptions/abc/resources":
+			if !strings.Contains(r.URL.Query().Get("$filter"), "tagName eq 'team'") {
+				t.Errorf("unexpected filter %s", r.URL.Query().Get("$filter"))
+			}
+			fmt.Fprint(w, `{"value": [
+				{"id": "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1", "name": "vm1", "type": "Microsoft.Compute/virtualMachines", "location": "westeurope"},
+				{"id": "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Web/sites/app", "name": "app", "type": "Microsoft.Web/sites", "location": "westeurope"}
+			]}`)
+		default:
+			if strings.Contains(r.URL.Path, "metrics") {
+				metricsRequests++
+			}
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{
+		ResourceManagerURL:          server.URL,
+		ActiveDirectoryAuthorityURL: server.URL,
+		Credentials:                 config.Credentials{SubscriptionID: "abc", ClientID: "client", TenantID: "tenant"},
+		Targets: []config.Target{{
+			Resource: "/resourceGroups/rg/providers/Microsoft.Sql/servers/srv/databases/db",
+			Metrics:  []config.Metric{{Name: "cpu_percent"}},
+		}},
+		ResourceTags: []config.ResourceTag{{
+			ResourceTagName:  "team",
+			ResourceTagValue: "db",
+			ResourceTypes:    []string{"Microsoft.Compute/virtualMachines"},
+			Metrics:          []config.Metric{{Name: "Percentage CPU"}},
+		}},
+	}
+	ac = NewAzureClient()
+
+	rec := httptest.NewRecorder()
+	previewHandler(rec, httptest.NewRequest("GET", "/api/preview?block=resource_tags[0]", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status\ngot: %d\nwant: %d\n%s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var got previewResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	want := previewResult{
+		Block: "resource_tags[0]",
+		Resources: []previewResource{{
+			ID:           "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+			Subscription: "abc",
+			Name:         "vm1",
+			Type:         "Microsoft.Compute/virtualMachines",
+			Location:     "westeurope",
+			Metrics:      []string{"Percentage CPU"},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected preview\ngot: %+v\nwant: %+v", got, want)
+	}
+	if metricsRequests != 0 {
+		t.Errorf("unexpected requests of the metrics: %d", metricsRequests)
+	}
+
+	got, err := previewBlock("targets[0]")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.Resources) != 1 || got.Resources[0].Type != "Microsoft.Sql/servers/databases" {
+		t.Errorf("unexpected preview of targets[0]: %+v", got)
+	}
+
+	for block, status := range map[string]int{
+		"":                   http.StatusBadRequest,
+		"targets[1]":         http.StatusNotFound,
+		"resource_groups[0]": http.StatusNotFound,
+		"targets":            http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		previewHandler(rec, httptest.NewRequest("GET", "/api/preview?block="+block, nil))
+		if rec.Code != status {
+			t.Errorf("unexpected status for block %q\ngot: %d\nwant: %d", block, rec.Code, status)
+		}
+	}
+}
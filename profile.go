@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultSlowBatches is the default number of batches listed by /debug/slow.
+const defaultSlowBatches = 10
+
+// batchTiming is the duration of the collection of a batch of resources.
+type batchTiming struct {
+	Endpoint        string   `json:"endpoint"`
+	Resources       []string `json:"resources"`
+	DurationSeconds float64  `json:"duration_seconds"`
+}
+
+// scrapeProfile holds the ID and the batch timings of the last scrape.
+type scrapeProfile struct {
+	sync.RWMutex
+	scrapeID string
+	timings  []batchTiming
+}
+
+func (p *scrapeProfile) update(scrapeID string, timings []batchTiming) {
+	p.Lock()
+	defer p.Unlock()
+	p.scrapeID = scrapeID
+	p.timings = timings
+}
+
+// id returns the ID of the last scrape.
+func (p *scrapeProfile) id() string {
+	p.RLock()
+	defer p.RUnlock()
+	return p.scrapeID
+}
+
+// slowest returns the n slowest batches of the last scrape.
+func (p *scrapeProfile) slowest(n int) []batchTiming {
+	p.RLock()
+	timings := append([]batchTiming{}, p.timings...)
+	p.RUnlock()
+
+	sort.SliceStable(timings, func(i, j int) bool {
+		return timings[i].DurationSeconds > timings[j].DurationSeconds
+	})
+	if len(timings) > n {
+		timings = timings[:n]
+	}
+	return timings
+}
+
+// recordTiming records the duration of the collection of a batch of
+// resources since start. The durations of the metrics batches are observed
+// for each of their resources.
+func (c *Collector) recordTiming(endpoint string, batch []resourceMeta, start time.Time) {
+	duration := time.Since(start).Seconds()
+	timing := batchTiming{Endpoint: endpoint, DurationSeconds: duration}
+	blocks, subscriptions := map[string]bool{}, map[string]bool{}
+	for _, rm := range batch {
+		timing.Resources = append(timing.Resources, rm.resourceID)
+		if endpoint != "lookup" {
+			resourceScrapeDuration.Observe(duration)
+		}
+
+		// The duration of the batch is counted once for each block and
+		// subscription of its resources.
+		subscription := subscriptionOf(rm)
+		c.stats.add(rm.block, subscription, entryStats{APICalls: 1})
+		if !blocks[rm.block] {
+			c.stats.add(rm.block, "", entryStats{DurationSeconds: duration})
+			blocks[rm.block] = true
+		}
+		if !subscriptions[subscription] {
+			c.stats.add("", subscription, entryStats{DurationSeconds: duration})
+			subscriptions[subscription] = true
+		}
+	}
+	c.timings = append(c.timings, timing)
+}
+
+// slowHandler lists the slowest batches of the last scrape, the number of
+// batches is given by the n parameter.
+func slowHandler(w http.ResponseWriter, r *http.Request) {
+	n := defaultSlowBatches
+	if value := r.URL.Query().Get("n"); value != "" {
+		var err error
+		n, err = strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid n parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, lastScrape.slowest(n))
+}
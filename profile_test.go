@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSlowHandler(t *testing.T) {
+	previous := lastScrape
+	defer func() { lastScrape = previous }()
+	lastScrape = &scrapeProfile{}
+	lastScrape.update("", []batchTiming{
+		{Endpoint: "lookup", Resources: []string{"/a"}, DurationSeconds: 0.5},
+		{Endpoint: "batch", Resources: []string{"/a", "/b"}, DurationSeconds: 2},
+		{Endpoint: "batch", Resources: []string{"/c"}, DurationSeconds: 1},
+	})
+
+	rec := httptest.NewRecorder()
+	slowHandler(rec, httptest.NewRequest("GET", "/debug/slow?n=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Code)
+	}
+	var got []batchTiming
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []batchTiming{
+		{Endpoint: "batch", Resources: []string{"/a", "/b"}, DurationSeconds: 2},
+		{Endpoint: "batch", Resources: []string{"/c"}, DurationSeconds: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't list slowest batches\ngot: %v\nwant: %v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	slowHandler(rec, httptest.NewRequest("GET", "/debug/slow?n=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status for invalid n: %d", rec.Code)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectPropertyMetrics publishes the numeric properties of the resources
+// given by the property_metrics of their blocks, e.g. the size of the disks.
+// Booleans are published as 0 or 1, other values are skipped.
+func (c *Collector) collectPropertyMetrics(ch chan<- prometheus.Metric, resources []resourceMeta) {
+	published := map[string]bool{}
+	for _, rm := range resources {
+		for _, m := range rm.propertyMetrics {
+			var val float64
+			switch v := resourceProperty(rm.resource, m.Property).(type) {
+			case float64:
+				val = v
+			case bool:
+				val = boolToFloat64(v)
+			default:
+				continue
+			}
+
+			name := c.cfg.MetricPrefix + m.Name
+			// A resource can be selected by several blocks.
+			key := resourceKey(rm) + "|" + name
+			if published[key] {
+				continue
+			}
+			published[key] = true
+
+			labels := resourceLabels(rm)
+			for name, v := range rm.labels {
+				if _, ok := labels[name]; !ok {
+					labels[name] = v
+				}
+			}
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(name, "Property "+m.Property+" of the resource", nil, labels),
+				prometheus.GaugeValue,
+				val,
+			)
+			c.stats.add(rm.block, subscriptionOf(rm), entryStats{Series: 1})
+		}
+	}
+}
+
+// needsPropertiesLookup reports whether the property metrics use properties
+// of the resources that are only returned by a lookup of each resource, the
+// sku being returned by the listings.
+func needsPropertiesLookup(metrics []config.PropertyMetric) bool {
+	for _, m := range metrics {
+		if !strings.HasPrefix(strings.ToLower(m.Property), "sku.") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectPropertyMetrics(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{}
+
+	disk := resourceMeta{
+		resourceURL: resourceURLFrom("abc", "/resourceGroups/rg/providers/Microsoft.Compute/disks/disk1", "", "Composite Disk Read Bytes/sec", []string{"Average"}, nil, nil, 0, 0),
+		labels:      map[string]string{"env": "prod"},
+		propertyMetrics: []config.PropertyMetric{
+			{Property: "properties.diskSizeGB", Name: "disk_size_gigabytes"},
+			{Property: "properties.burstingEnabled", Name: "disk_bursting_enabled"},
+			{Property: "properties.diskState", Name: "disk_state"},
+			{Property: "properties.missing", Name: "disk_missing"},
+			{Property: "sku.capacity", Name: "disk_capacity"},
+		},
+		resource: AzureResource{
+			ID:         "/resourceGroups/rg/providers/Microsoft.Compute/disks/disk1",
+			Properties: map[string]interface{}{"diskSizeGB": float64(128), "burstingEnabled": true, "diskState": "Attached"},
+		},
+	}
+	// The same disk selected by another block is published once.
+	duplicate := disk
+
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).collectPropertyMetrics(ch, []resourceMeta{disk, duplicate})
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`disk_size_gigabytes{prod,rg,disk1}`:   128,
+		`disk_bursting_enabled{prod,rg,disk1}`: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected property metrics\ngot: %v\nwant: %v", got, want)
+	}
+}
+
+func TestNeedsPropertiesLookup(t *testing.T) {
+	if needsPropertiesLookup([]config.PropertyMetric{{Property: "sku.capacity", Name: "plan_capacity"}}) {
+		t.Error("looks up the resources for their sku")
+	}
+	if !needsPropertiesLookup([]config.PropertyMetric{{Property: "sku.capacity", Name: "plan_capacity"}, {Property: "properties.numberOfWorkers", Name: "plan_workers"}}) {
+		t.Error("doesn't look up the resources for their properties")
+	}
+}
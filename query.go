@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	queryCmd          = kingpin.Command("query", "Fetch the metrics of a resource once, print their datapoints and exit.")
+	queryResource     = queryCmd.Flag("resource", "ID of the resource, with or without the /subscriptions/<id> prefix.").Required().String()
+	queryMetrics      = queryCmd.Flag("metrics", "Comma-separated names of the metrics, as in the Azure metric definitions.").Required().String()
+	queryAggregations = queryCmd.Flag("aggregation", "Aggregation of the metrics, can be repeated.").Default("Average").Enums("Total", "Average", "Minimum", "Maximum")
+	queryNamespace    = queryCmd.Flag("namespace", "Metric namespace of the metrics.").String()
+	queryDimensions   = queryCmd.Flag("dimension", "Dimension splitting the metrics, can be repeated.").Strings()
+	queryTimespan     = queryCmd.Flag("timespan", "Timespan of the datapoints.").Default("5m").Duration()
+	queryInterval     = queryCmd.Flag("interval", "Time grain of the datapoints, in whole minutes (defaults to the time grain chosen by Azure).").Duration()
+)
+
+// queryDatapoint is a datapoint of a queried time series.
+type queryDatapoint struct {
+	TimeStamp string             `json:"timestamp"`
+	Values    map[string]float64 `json:"values"`
+}
+
+// querySeries is a time series returned by Azure for the query command.
+type querySeries struct {
+	Metric     string            `json:"metric"`
+	Unit       string            `json:"unit"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+	Datapoints []queryDatapoint  `json:"datapoints"`
+}
+
+// splitResourceID returns the subscription and the resource ID without the
+// /subscriptions/<id> prefix, the subscription of the credentials being used
+// when the ID has no prefix.
+func splitResourceID(id string) (string, string) {
+	parts := strings.SplitN(id, "/", 4)
+	if len(parts) == 4 && parts[0] == "" && strings.EqualFold(parts[1], "subscriptions") {
+		return parts[2], "/" + parts[3]
+	}
+	return sc.Get().Credentials.SubscriptionID, id
+}
+
+// queryResourceMetrics requests the metrics of a resource once, bypassing the
+// collector, to tell whether missing or unexpected values come from Azure.
+func queryResourceMetrics(id string, namespace string, metrics string, aggregations []string, dimensions []string, timespan time.Duration, interval time.Duration) ([]querySeries, error) {
+	var dims []config.Dimension
+	for _, d := range dimensions {
+		dims = append(dims, config.Dimension{Name: d})
+	}
+	subscription, resource := splitResourceID(id)
+	endpoint := strings.TrimSuffix(sc.Get().ResourceManagerURL, "/") + resourceURLFrom(subscription, resource, namespace, metrics, aggregations, dims, nil, interval, timespan)
+	body, err := getAzureMonitorResponse(endpoint, "")
+	if err != nil {
+		return nil, fmt.Errorf("Error requesting the metrics of %s: %v", id, err)
+	}
+	var data AzureMetricValueResponse
+	if err := decodeLenient("metrics", body, &data); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling response body: %v", err)
+	}
+
+	series := []querySeries{}
+	for _, value := range data.Value {
+		for _, ts := range value.Timeseries {
+			s := querySeries{Metric: value.Name.Value, Unit: value.Unit, Datapoints: []queryDatapoint{}}
+			if len(ts.MetadataValues) > 0 {
+				s.Dimensions = map[string]string{}
+				for _, m := range ts.MetadataValues {
+					s.Dimensions[m.Name.Value] = m.Value
+				}
+			}
+			for _, d := range ts.Data {
+				s.Datapoints = append(s.Datapoints, queryDatapoint{TimeStamp: d.TimeStamp, Values: aggregationValues(d, aggregations)})
+			}
+			series = append(series, s)
+		}
+	}
+	return series, nil
+}
+
+// aggregationValues returns the values of the aggregations of a datapoint.
+func aggregationValues(d AzureMetricData, aggregations []string) map[string]float64 {
+	values := map[string]float64{}
+	for _, aggregation := range filterAggregations(aggregations) {
+		switch aggregation {
+		case "Total":
+			values[aggregation] = float64(d.Total)
+		case "Average":
+			values[aggregation] = float64(d.Average)
+		case "Minimum":
+			values[aggregation] = float64(d.Minimum)
+		case "Maximum":
+			values[aggregation] = float64(d.Maximum)
+		}
+	}
+	return values
+}
+
+// writeQuerySeries prints the time series of the query command, one datapoint
+// per line.
+func writeQuerySeries(w io.Writer, series []querySeries, aggregations []string) {
+	if len(series) == 0 {
+		fmt.Fprintln(w, "No time series returned")
+		return
+	}
+	for _, s := range series {
+		var dims []string
+		for name, v := range s.Dimensions {
+			dims = append(dims, fmt.Sprintf("%s=%q", name, v))
+		}
+		sort.Strings(dims)
+		header := fmt.Sprintf("%s (%s)", s.Metric, s.Unit)
+		if len(dims) > 0 {
+			header += " {" + strings.Join(dims, ", ") + "}"
+		}
+		fmt.Fprintln(w, header)
+		if len(s.Datapoints) == 0 {
+			fmt.Fprintln(w, "  No datapoints")
+		}
+		for _, d := range s.Datapoints {
+			line := "  " + d.TimeStamp
+			for _, aggregation := range filterAggregations(aggregations) {
+				line += fmt.Sprintf("  %s=%v", aggregation, d.Values[aggregation])
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestQueryResourceMetrics(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if got := r.URL.Query().Get("aggregation"); got != "Average,Maximum" {
+			t.Errorf("unexpected aggregation %q", got)
+		}
+		if got := r.URL.Query().Get("metricnames"); got != "Percentage CPU" {
+			t.Errorf("unexpected metric names %q", got)
+		}
+		fmt.Fprint(w, `{"value": [{"name": {"value": "Percentage CPU"}, "unit": "Percent", "timeseries": [{"data": [
+			{"timeStamp": "2026-10-15T10:00:00Z", "average": 12.5, "maximum": 40},
+			{"timeStamp": "2026-10-15T10:01:00Z"}
+		]}]}]}`)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{
+		ResourceManagerURL: server.URL,
+		Credentials:        config.Credentials{SubscriptionID: "abc"},
+	}
+	ac = NewAzureClient()
+	ac.tokens[server.URL] = accessToken{token: "token", expiresOn: time.Now().Add(time.Hour)}
+
+	aggregations := []string{"Average", "Maximum"}
+	for _, id := range []string{
+		"/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+		"/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+	} {
+		series, err := queryResourceMetrics(id, "", "Percentage CPU", aggregations, nil, 5*time.Minute, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := []querySeries{{
+			Metric: "Percentage CPU",
+			Unit:   "Percent",
+			Datapoints: []queryDatapoint{
+				{TimeStamp: "2026-10-15T10:00:00Z", Values: map[string]float64{"Average": 12.5, "Maximum": 40}},
+				{TimeStamp: "2026-10-15T10:01:00Z", Values: map[string]float64{"Average": 0, "Maximum": 0}},
+			},
+		}}
+		if !reflect.DeepEqual(series, want) {
+			t.Errorf("unexpected series of %s\ngot: %+v\nwant: %+v", id, series, want)
+		}
+
+		var buf bytes.Buffer
+		writeQuerySeries(&buf, series, aggregations)
+		wantOutput := "Percentage CPU (Percent)\n  2026-10-15T10:00:00Z  Average=12.5  Maximum=40\n  2026-10-15T10:01:00Z  Average=0  Maximum=0\n"
+		if buf.String() != wantOutput {
+			t.Errorf("unexpected output\ngot: %q\nwant: %q", buf.String(), wantOutput)
+		}
+	}
+
+	for _, path := range paths {
+		if path != "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1/providers/microsoft.insights/metrics" {
+			t.Errorf("unexpected path %s", path)
+		}
+	}
+	if len(paths) != 2 {
+		t.Errorf("unexpected number of requests: %d", len(paths))
+	}
+
+	var buf bytes.Buffer
+	writeQuerySeries(&buf, []querySeries{}, aggregations)
+	if !strings.Contains(buf.String(), "No time series returned") {
+		t.Errorf("unexpected output of an empty response: %q", buf.String())
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// scrapeRegistry holds the collector and the registries serving a scrape.
+// Shared between scrapes, it must be locked while serving one.
+type scrapeRegistry struct {
+	mtx       sync.Mutex
+	collector *Collector
+	gatherer  prometheus.Gatherer
+	labels    prometheus.Labels
+}
+
+// newScrapeRegistry registers a collector of the selected parts of the
+// configuration, with the given identity labels, and the exporter's own
+// metrics.
+func newScrapeRegistry(collect collectorSet, labels prometheus.Labels) *scrapeRegistry {
+	registry := prometheus.NewRegistry()
+	collector := &Collector{collect: collect}
+	prometheus.WrapRegistererWith(labels, registry).MustRegister(collector)
+	exporterRegistry := prometheus.NewRegistry()
+	exporterRegistry.MustRegister(exporterCollectors()...)
+	exporterRegistry.MustRegister(runtimeCollectors(*goCollector, *processCollector)...)
+
+	// Only the series of the Azure metrics are compared between scrapes.
+	azureGatherer := prometheus.Gatherer(registry)
+	if *logScrapeDiff {
+		azureGatherer = prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			mfs, err := registry.Gather()
+			scrapeDiffs.get(collect.key()).log(mfs)
+			return mfs, err
+		})
+	}
+	gatherers := prometheus.Gatherers{azureGatherer, exporterRegistry}
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := gatherers.Gather()
+		collector.namespaces.sort(mfs)
+		scrapeSamplesTotal.Add(float64(sampleCount(mfs)))
+		return mfs, err
+	})
+	return &scrapeRegistry{collector: collector, gatherer: gatherer, labels: labels}
+}
+
+// reset clears the state of the previous scrape of the collector.
+func (c *Collector) reset() {
+	*c = Collector{collect: c.collect}
+}
+
+// scrapeRegistrySet keeps a scrapeRegistry for each selection of collectors,
+// with --web.shared-registry.
+type scrapeRegistrySet struct {
+	mtx        sync.Mutex
+	registries map[string]*scrapeRegistry
+}
+
+// get returns the scrapeRegistry of the selection of collectors, replaced
+// when the identity labels changed on a configuration reload.
+func (s *scrapeRegistrySet) get(collect collectorSet, labels prometheus.Labels) *scrapeRegistry {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.registries == nil {
+		s.registries = map[string]*scrapeRegistry{}
+	}
+	key := collect.key()
+	r, ok := s.registries[key]
+	if !ok || !reflect.DeepEqual(r.labels, labels) {
+		r = newScrapeRegistry(collect, labels)
+		s.registries[key] = r
+	}
+	return r
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestScrapeRegistrySet(t *testing.T) {
+	var s scrapeRegistrySet
+	all, _ := parseCollectorSet(nil)
+	targets, _ := parseCollectorSet([]string{"targets"})
+	labels := prometheus.Labels{"subscription_id": "a"}
+
+	r := s.get(all, labels)
+	if s.get(all, prometheus.Labels{"subscription_id": "a"}) != r {
+		t.Errorf("registry isn't reused between scrapes")
+	}
+	if s.get(targets, labels) == r {
+		t.Errorf("registry is shared between selections of collectors")
+	}
+	if s.get(all, prometheus.Labels{"subscription_id": "b"}) == r {
+		t.Errorf("registry isn't replaced when the identity labels change")
+	}
+}
+
+func TestCollectorReset(t *testing.T) {
+	collect, _ := parseCollectorSet([]string{"targets"})
+	c := &Collector{collect: collect, accessDenied: accessDeniedSet{"/a": true}}
+	c.namespaces.add("a", "Microsoft.Compute")
+	c.timings = append(c.timings, batchTiming{})
+
+	c.reset()
+	if c.accessDenied != nil || c.namespaces.families != nil || c.timings != nil {
+		t.Errorf("state of the previous scrape isn't cleared: %+v", c)
+	}
+	if !c.collect.enabled("targets") || c.collect.enabled("resource_groups") {
+		t.Errorf("selection of collectors isn't kept: %v", c.collect)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+type configStatus struct {
+	Hash string `json:"hash"`
+}
+
+// reloadConfig reloads the configuration files when they have the expected
+// hash, see config.SafeConfig.Load. With a block, only the named block is
+// reloaded, see config.SafeConfig.LoadBlock.
+func reloadConfig(expectedHash string, block string) (err error) {
+	defer func() { configLastReloadSuccessful.Set(boolToFloat64(err == nil)) }()
+
+	sc.RLock()
+	previous := sc.C.Credentials
+	sc.RUnlock()
+
+	sources := config.Sources{
+		Files:           *configFiles,
+		Dir:             *configDir,
+		CredentialsFile: *configCredentialsFile,
+		TargetsFile:     *configTargetsFile,
+	}
+	if block != "" {
+		if err := sc.LoadBlock(sources, block); err != nil {
+			return err
+		}
+		configLastReloadSuccessTimestamp.SetToCurrentTime()
+		log.Printf("Loaded block %s of the configuration", block)
+		return nil
+	}
+	if err := sc.LoadPrepared(sources, expectedHash, resolveCLISubscription); err != nil {
+		return err
+	}
+
+	sc.RLock()
+	defer sc.RUnlock()
+	if !reflect.DeepEqual(previous, sc.C.Credentials) {
+		ac.resetTokens()
+	}
+	setConfigHash(sc.Hash)
+	configTargets.Set(float64(len(sc.C.Targets)))
+	configResourceGroups.Set(float64(len(sc.C.ResourceGroups)))
+	configResourceTags.Set(float64(len(sc.C.ResourceTags)))
+	configLastReloadSuccessTimestamp.SetToCurrentTime()
+	log.Printf("Loaded configuration with hash %s", sc.Hash)
+	return nil
+}
+
+// resolveCLISubscription sets the subscription of the Azure CLI on a loaded
+// configuration authenticating with the CLI without a subscription, before
+// the configuration is published.
+func resolveCLISubscription(c *config.Config) error {
+	if c.Credentials.AuthType != cliCredential || c.Credentials.SubscriptionID != "" {
+		return nil
+	}
+	subscriptionID, err := cliSubscription()
+	if err != nil {
+		return fmt.Errorf("Failed to get subscription of the Azure CLI: %v", err)
+	}
+	log.Printf("Using subscription %s of the Azure CLI", subscriptionID)
+	c.Credentials.SubscriptionID = subscriptionID
+	return nil
+}
+
+// setConfigHash exposes the hash of the configuration as the value of
+// azure_exporter_config_hash, from its first 48 bits so that it's exactly
+// represented by a float64.
+func setConfigHash(hash string) {
+	b, err := hex.DecodeString(hash)
+	if err != nil || len(b) < 6 {
+		return
+	}
+	var v uint64
+	for _, c := range b[:6] {
+		v = v<<8 | uint64(c)
+	}
+	configHash.Set(float64(v))
+}
+
+// ifMatch returns the hash of the If-Match header of the request, an empty
+// string matches any hash.
+func ifMatch(r *http.Request) string {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "*" {
+		return ""
+	}
+	return strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+}
+
+// reloadHandler reloads the configuration files. With an If-Match header,
+// the configuration is only applied when the files have the given hash, so
+// that automation can tell whether its intended version is live. With a
+// block parameter, only the named block is reloaded.
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "Only POST or PUT requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	block := r.URL.Query().Get("block")
+	if block != "" && ifMatch(r) != "" {
+		http.Error(w, "The block parameter can't be used with If-Match", http.StatusBadRequest)
+		return
+	}
+	err := reloadConfig(ifMatch(r), block)
+	if errors.Is(err, config.ErrHashMismatch) {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		log.Printf("Error reloading config: %v", err)
+		http.Error(w, fmt.Sprintf("Error reloading config: %v", err), http.StatusInternalServerError)
+		return
+	}
+	configHandler(w, r)
+}
+
+// configHandler returns the hash of the running configuration, also given as
+// ETag. Requests with an If-Match header of another hash fail.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	sc.RLock()
+	hash := sc.Hash
+	sc.RUnlock()
+
+	w.Header().Set("ETag", `"`+hash+`"`)
+	if expected := ifMatch(r); expected != "" && expected != hash {
+		http.Error(w, fmt.Sprintf("Running config has hash %s", hash), http.StatusPreconditionFailed)
+		return
+	}
+	writeJSON(w, http.StatusOK, configStatus{Hash: hash})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestReloadHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azure_reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := []byte("credentials:\n  subscription_id: abc\n  client_id: id\n  client_secret: secret\n  tenant_id: tenant\n")
+	path := filepath.Join(dir, "azure.yml")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	previousFiles, previousDir := *configFiles, *configDir
+	previous, previousHash := sc.C, sc.Hash
+	defer func() {
+		*configFiles, *configDir = previousFiles, previousDir
+		sc.C, sc.Hash = previous, previousHash
+	}()
+	*configFiles, *configDir = []string{path}, ""
+	sc.C, sc.Hash = &config.Config{}, ""
+
+	var tests = []struct {
+		method  string
+		ifMatch string
+		status  int
+		hash    string
+	}{
+		{"GET", "", http.StatusMethodNotAllowed, ""},
+		{"POST", `"0123"`, http.StatusPreconditionFailed, ""},
+		{"POST", `"` + hash + `"`, http.StatusOK, hash},
+		{"POST", "*", http.StatusOK, hash},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/-/reload", nil)
+		if test.ifMatch != "" {
+			req.Header.Set("If-Match", test.ifMatch)
+		}
+		w := httptest.NewRecorder()
+		reloadHandler(w, req)
+		if w.Code != test.status {
+			t.Errorf("unexpected status of %s with If-Match %s\ngot: %d\nwant: %d", test.method, test.ifMatch, w.Code, test.status)
+		}
+		if sc.Hash != test.hash {
+			t.Errorf("unexpected running config hash after %s with If-Match %s\ngot: %s\nwant: %s", test.method, test.ifMatch, sc.Hash, test.hash)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/-/reload?block=db-prod", nil)
+	req.Header.Set("If-Match", `"`+hash+`"`)
+	w := httptest.NewRecorder()
+	reloadHandler(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status of a block reload with If-Match\ngot: %d\nwant: %d", w.Code, http.StatusBadRequest)
+	}
+
+	req = httptest.NewRequest("GET", "/api/config", nil)
+	w = httptest.NewRecorder()
+	configHandler(w, req)
+	var status configStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Hash != hash || w.Header().Get("ETag") != `"`+hash+`"` {
+		t.Errorf("doesn't return running config hash\ngot: %s (ETag %s)\nwant: %s", status.Hash, w.Header().Get("ETag"), hash)
+	}
+
+	req = httptest.NewRequest("GET", "/api/config", nil)
+	req.Header.Set("If-Match", `"0123"`)
+	w = httptest.NewRecorder()
+	configHandler(w, req)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("unexpected status with another hash\ngot: %d\nwant: %d", w.Code, http.StatusPreconditionFailed)
+	}
+}
+
+func TestReloadConfigMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azure_reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "azure.yml")
+	content := `credentials:
+  subscription_id: abc
+  client_id: id
+  client_secret: secret
+  tenant_id: tenant
+targets:
+  - resource: /resourceGroups/rg/providers/Microsoft.Web/sites/app1
+    metrics:
+    - name: Requests
+  - resource: /resourceGroups/rg/providers/Microsoft.Web/sites/app2
+    metrics:
+    - name: Requests
+resource_groups:
+  - resource_group: rg
+    resource_types:
+    - Microsoft.Sql/servers/databases
+    metrics:
+    - name: cpu_percent
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	previousFiles, previousDir := *configFiles, *configDir
+	previous, previousHash := sc.C, sc.Hash
+	defer func() {
+		*configFiles, *configDir = previousFiles, previousDir
+		sc.C, sc.Hash = previous, previousHash
+	}()
+	*configFiles, *configDir = []string{path}, ""
+
+	if err := reloadConfig("", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, test := range []struct {
+		gauge prometheus.Gauge
+		value float64
+	}{
+		{configTargets, 2},
+		{configResourceGroups, 1},
+		{configResourceTags, 0},
+		{configLastReloadSuccessful, 1},
+	} {
+		if got := gaugeValue(t, test.gauge); got != test.value {
+			t.Errorf("unexpected value of %s\ngot: %v\nwant: %v", test.gauge.Desc(), got, test.value)
+		}
+	}
+	succeeded := gaugeValue(t, configLastReloadSuccessTimestamp)
+	if time.Since(time.Unix(int64(succeeded), 0)) > time.Minute {
+		t.Errorf("unexpected last reload success timestamp %v", succeeded)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("targets: ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig("", ""); err == nil {
+		t.Fatalf("expected an error for an invalid configuration")
+	}
+	if got := gaugeValue(t, configLastReloadSuccessful); got != 0 {
+		t.Errorf("failed reload reported as successful")
+	}
+	if got := gaugeValue(t, configLastReloadSuccessTimestamp); got != succeeded {
+		t.Errorf("failed reload changed the last success timestamp\ngot: %v\nwant: %v", got, succeeded)
+	}
+}
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	var metric dto.Metric
+	if err := g.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetGauge().GetValue()
+}
+
+func TestReloadConfigCLISubscription(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azure_reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "azure.yml")
+	if err := ioutil.WriteFile(path, []byte("credentials:\n  auth_type: cli\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "az")
+
+	previousFiles, previousDir, previousCLI := *configFiles, *configDir, azureCLI
+	previous, previousHash := sc.C, sc.Hash
+	defer func() {
+		*configFiles, *configDir, azureCLI = previousFiles, previousDir, previousCLI
+		sc.C, sc.Hash = previous, previousHash
+	}()
+	*configFiles, *configDir, azureCLI = []string{path}, "", script
+	running := &config.Config{Credentials: config.Credentials{SubscriptionID: "abc"}}
+	sc.C, sc.Hash = running, "running"
+
+	// The running configuration is kept when the CLI fails.
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig("", ""); err == nil {
+		t.Fatalf("expected an error when the Azure CLI fails")
+	}
+	if sc.C != running || sc.Hash != "running" {
+		t.Errorf("configuration without subscription published: %+v", sc.C.Credentials)
+	}
+
+	// The subscription is set before the configuration is published.
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho '{\"id\": \"cli-subscription\"}'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig("", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := sc.C.Credentials.SubscriptionID; got != "cli-subscription" {
+		t.Errorf("unexpected subscription\ngot: %s\nwant: cli-subscription", got)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/common/model"
+)
+
+// applyRules applies the rules of the configuration to the name and the
+// labels of a sample, in order, each rule seeing the result of the previous
+// ones. The labels are copied when a rule applies, as they are shared by the
+// aggregations of a series. A rule failing to evaluate is logged and skipped.
+func (c *Collector) applyRules(rules []config.Rule, name string, labels map[string]string, aggregation string, resourceID string) (string, map[string]string) {
+	copied := false
+	for i, r := range rules {
+		if !r.Matches(name) {
+			continue
+		}
+		data := config.RuleData{Name: name, Labels: labels, Aggregation: aggregation, ResourceID: resourceID}
+
+		newName := name
+		if r.Name != nil {
+			s, err := r.Name.Execute(data)
+			if err != nil {
+				c.logf("Error evaluating the name of rules[%d] for metric %s: %v", i, name, err)
+				continue
+			}
+			newName = invalidMetricChars.ReplaceAllString(s, "_")
+			if newName == "" {
+				c.logf("Error evaluating the name of rules[%d] for metric %s: empty name", i, name)
+				continue
+			}
+			if !model.IsValidMetricName(model.LabelValue(newName)) {
+				c.logf("Error evaluating the name of rules[%d] for metric %s: invalid name %q", i, name, newName)
+				continue
+			}
+		}
+
+		newLabels := make(map[string]string, len(r.Labels))
+		failed := false
+		for label, t := range r.Labels {
+			v, err := t.Execute(data)
+			if err != nil {
+				c.logf("Error evaluating label %s of rules[%d] for metric %s: %v", label, i, name, err)
+				failed = true
+				break
+			}
+			newLabels[label] = v
+		}
+		if failed {
+			continue
+		}
+
+		if !copied {
+			labels = copyLabels(labels)
+			copied = true
+		}
+		for label, v := range newLabels {
+			if v == "" {
+				delete(labels, label)
+			} else {
+				labels[label] = v
+			}
+		}
+		name = newName
+	}
+	return name, labels
+}
+
+// copyLabels returns a copy of the labels.
+func copyLabels(labels map[string]string) map[string]string {
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestApplyRules(t *testing.T) {
+	var c config.Config
+	err := yaml.Unmarshal([]byte(`
+rules:
+- metric: azure_sql_.*
+  name: '{{ .Name | trimPrefix "azure_sql_" | printf "mysql_%s" }}'
+  labels:
+    service_name: '{{ index .Labels "resource_name" }}'
+    resource_name: ''
+- labels:
+    aggregation: '{{ .Aggregation | lower }}'
+`), &c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	labels := map[string]string{"resource_name": "db1", "resource_group": "rg"}
+	name, got := (&Collector{cfg: sc.C}).applyRules(c.Rules, "azure_sql_cpu_percent_percent_average", labels, "Average", "/resourceGroups/rg/providers/Microsoft.Sql/servers/s/databases/db1")
+	if name != "mysql_cpu_percent_percent_average" {
+		t.Errorf("unexpected name\ngot: %s\nwant: mysql_cpu_percent_percent_average", name)
+	}
+	want := map[string]string{"service_name": "db1", "resource_group": "rg", "aggregation": "average"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected labels\ngot: %v\nwant: %v", got, want)
+	}
+	if labels["resource_name"] != "db1" || len(labels) != 2 {
+		t.Errorf("labels of the series were modified: %v", labels)
+	}
+
+	name, got = (&Collector{cfg: sc.C}).applyRules(c.Rules, "node_cpu_average", labels, "Average", "")
+	if name != "node_cpu_average" || got["aggregation"] != "average" || got["resource_name"] != "db1" {
+		t.Errorf("unexpected result for a metric matched by the second rule only: %s %v", name, got)
+	}
+}
+
+func TestApplyRulesInvalidName(t *testing.T) {
+	var c config.Config
+	err := yaml.Unmarshal([]byte(`
+rules:
+- name: '{{ index .Labels "resource_name" }}_cpu'
+  labels:
+    service_name: '{{ index .Labels "resource_name" }}'
+`), &c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	labels := map[string]string{"resource_name": "01-db"}
+	name, got := (&Collector{cfg: sc.C}).applyRules(c.Rules, "cpu_percent_percent_average", labels, "Average", "")
+	if name != "cpu_percent_percent_average" {
+		t.Errorf("unexpected name\ngot: %s\nwant: cpu_percent_percent_average", name)
+	}
+	if !reflect.DeepEqual(got, labels) {
+		t.Errorf("rule with an invalid name was applied to the labels\ngot: %v\nwant: %v", got, labels)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+// Reasons of the suspect samples.
+const (
+	percentOutOfRange = "percent_out_of_range"
+	negativeBytes     = "negative_bytes"
+)
+
+// clampsOf returns the clamps of the metrics of a block, by lowercase metric
+// name.
+func clampsOf(metrics []config.Metric) map[string]*config.Clamp {
+	var clamps map[string]*config.Clamp
+	for _, m := range metrics {
+		if m.Clamp == nil {
+			continue
+		}
+		if clamps == nil {
+			clamps = map[string]*config.Clamp{}
+		}
+		clamps[strings.ToLower(m.Name)] = m.Clamp
+	}
+	return clamps
+}
+
+// checkSample tells why a value of an aggregation of a metric is out of the
+// range of its unit, e.g. a sentinel value returned by Azure, and returns the
+// value clamped to that range. The totals of the percent metrics sum the
+// datapoints of the time grain and can exceed 100.
+func checkSample(unit string, aggregation string, val float64) (string, float64) {
+	unit = strings.ToLower(unit)
+	switch {
+	case unit == "percent" && val < 0:
+		return percentOutOfRange, 0
+	case unit == "percent" && val > 100 && aggregation != "Total":
+		return percentOutOfRange, 100
+	case strings.HasPrefix(unit, "bytes") && val < 0:
+		return negativeBytes, 0
+	}
+	return "", val
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCheckSample(t *testing.T) {
+	tests := []struct {
+		unit        string
+		aggregation string
+		val         float64
+		reason      string
+		clamped     float64
+	}{
+		{"Percent", "Average", 42, "", 42},
+		{"Percent", "Maximum", 1e9, percentOutOfRange, 100},
+		{"Percent", "Minimum", -1, percentOutOfRange, 0},
+		{"Percent", "Total", 500, "", 500},
+		{"Bytes", "Average", -4096, negativeBytes, 0},
+		{"BytesPerSecond", "Total", -1, negativeBytes, 0},
+		{"Count", "Total", -1, "", -1},
+	}
+	for _, test := range tests {
+		reason, clamped := checkSample(test.unit, test.aggregation, test.val)
+		if reason != test.reason || clamped != test.clamped {
+			t.Errorf("%s %s %v\ngot: %q, %v\nwant: %q, %v", test.unit, test.aggregation, test.val, reason, clamped, test.reason, test.clamped)
+		}
+	}
+}
+
+func TestExtractMetricsClampSuspectSamples(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+
+	var data AzureMetricValueResponse
+	payload := `{"value": [{"name": {"value": "Percentage CPU"}, "unit": "Percent", "timeseries": [
+		{"data": [{"timeStamp": "2020-01-01T00:00:00Z", "maximum": 2147483647}]}
+	]}]}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+	rm := resourceMeta{
+		resourceID:   "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+		resourceURL:  "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1/providers/microsoft.insights/metrics",
+		aggregations: []string{"Maximum"},
+		resourceInfo: config.ResourceInfo{Skip: true},
+	}
+
+	for _, clamp := range []bool{false, true} {
+		sc.C = &config.Config{ClampSuspectSamples: clamp}
+		before := counterValue(t, suspectSamplesTotal.WithLabelValues(percentOutOfRange))
+
+		ch := make(chan prometheus.Metric, 1)
+		(&Collector{cfg: sc.C}).extractMetrics(ch, rm, 200, data, map[string]bool{}, apiErrorSet{})
+		var metric dto.Metric
+		if err := (<-ch).Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+
+		want := 2147483647.0
+		if clamp {
+			want = 100
+		}
+		if got := metric.GetGauge().GetValue(); got != want {
+			t.Errorf("clamp_suspect_samples %v\ngot: %v\nwant: %v", clamp, got, want)
+		}
+		if got := counterValue(t, suspectSamplesTotal.WithLabelValues(percentOutOfRange)) - before; got != 1 {
+			t.Errorf("unexpected number of suspect samples counted\ngot: %v\nwant: 1", got)
+		}
+	}
+}
+
+func TestExtractMetricsClamp(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{}
+
+	var data AzureMetricValueResponse
+	payload := `{"value": [{"name": {"value": "ActiveMessages"}, "unit": "Count", "timeseries": [
+		{"metadatavalues": [{"name": {"value": "EntityName"}, "value": "orders"}], "data": [{"timeStamp": "2020-01-01T00:00:00Z", "average": -1}]},
+		{"metadatavalues": [{"name": {"value": "EntityName"}, "value": "invoices"}], "data": [{"timeStamp": "2020-01-01T00:00:00Z", "average": 12}]},
+		{"metadatavalues": [{"name": {"value": "EntityName"}, "value": "refunds"}], "data": [{"timeStamp": "2020-01-01T00:00:00Z", "average": -3}]}
+	]}]}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+	min := 0.0
+	rm := resourceMeta{
+		resourceID:   "/resourceGroups/rg/providers/Microsoft.ServiceBus/namespaces/ns",
+		resourceURL:  "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.ServiceBus/namespaces/ns/providers/microsoft.insights/metrics",
+		aggregations: []string{"Average"},
+		resourceInfo: config.ResourceInfo{Skip: true},
+		dimensions:   []config.Dimension{{Name: "EntityName"}},
+		clamps:       clampsOf([]config.Metric{{Name: "activemessages", Clamp: &config.Clamp{Min: &min, NaNValues: []float64{-1}}}}),
+	}
+	nanBefore := counterValue(t, clampedSamplesTotal.WithLabelValues(config.ClampNaNValue))
+	minBefore := counterValue(t, clampedSamplesTotal.WithLabelValues(config.ClampBelowMin))
+
+	ch := make(chan prometheus.Metric, 3)
+	(&Collector{cfg: sc.C}).extractMetrics(ch, rm, 200, data, map[string]bool{}, apiErrorSet{})
+	close(ch)
+
+	got := metricValues(t, ch)
+	if v := got["activemessages_count_average{orders,rg,ns}"]; !math.IsNaN(v) {
+		t.Errorf("doesn't replace the sentinel value by NaN, got %v", v)
+	}
+	if v := got["activemessages_count_average{invoices,rg,ns}"]; v != 12 {
+		t.Errorf("unexpected value\ngot: %v\nwant: 12", v)
+	}
+	if v := got["activemessages_count_average{refunds,rg,ns}"]; v != 0 {
+		t.Errorf("doesn't clamp the value to the min\ngot: %v\nwant: 0", v)
+	}
+	if got := counterValue(t, clampedSamplesTotal.WithLabelValues(config.ClampNaNValue)) - nanBefore; got != 1 {
+		t.Errorf("unexpected number of NaN samples counted\ngot: %v\nwant: 1", got)
+	}
+	if got := counterValue(t, clampedSamplesTotal.WithLabelValues(config.ClampBelowMin)) - minBefore; got != 1 {
+		t.Errorf("unexpected number of clamped samples counted\ngot: %v\nwant: 1", got)
+	}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var metric dto.Metric
+	if err := c.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scheduledEventsAPIVersion is the API version of the scheduled events.
+const scheduledEventsAPIVersion = "2020-07-01"
+
+var (
+	scheduledEventsUpDesc = prometheus.NewDesc("azure_scheduled_events_up", "Whether the last poll of the scheduled events of the Instance Metadata Service succeeded",
+		nil, nil)
+	scheduledEventInfoDesc = prometheus.NewDesc("azure_scheduled_event_info", "Scheduled event of a virtual machine, e.g. a Spot eviction (Preempt)",
+		[]string{"event_id", "event_type", "event_status", "event_source", "resource"}, nil)
+	scheduledEventNotBeforeDesc = prometheus.NewDesc("azure_scheduled_event_not_before_timestamp_seconds", "Time after which a scheduled event may start",
+		[]string{"event_id", "event_type", "resource"}, nil)
+)
+
+// ScheduledEventsResponse is the response of the scheduled events of the
+// Instance Metadata Service.
+type ScheduledEventsResponse struct {
+	DocumentIncarnation int              `json:"DocumentIncarnation"`
+	Events              []ScheduledEvent `json:"Events"`
+}
+
+// ScheduledEvent is an event scheduled on virtual machines.
+type ScheduledEvent struct {
+	EventID     string   `json:"EventId"`
+	EventType   string   `json:"EventType"`
+	EventStatus string   `json:"EventStatus"`
+	EventSource string   `json:"EventSource"`
+	Resources   []string `json:"Resources"`
+	NotBefore   string   `json:"NotBefore"`
+}
+
+// scheduledEventsPoller polls the scheduled events in the background, as the
+// notice of a Spot eviction can be shorter than the scrape interval.
+type scheduledEventsPoller struct {
+	sync.Mutex
+	polled bool
+	up     bool
+	events []ScheduledEvent
+	// counted are the IDs of the events counted by azure_scheduled_events_total.
+	counted map[string]bool
+}
+
+var scheduledEvents = &scheduledEventsPoller{counted: map[string]bool{}}
+
+// run polls the scheduled events every poll_interval while they are enabled.
+func (p *scheduledEventsPoller) run() {
+	for {
+		sc.RLock()
+		c := sc.C.ScheduledEvents
+		sc.RUnlock()
+		if c.Enabled {
+			p.poll(c.URL)
+		}
+		time.Sleep(c.PollInterval)
+	}
+}
+
+// poll requests the scheduled events and counts the new ones. Failures are
+// logged when the events were previously available.
+func (p *scheduledEventsPoller) poll(url string) {
+	events, err := getScheduledEvents(url)
+
+	p.Lock()
+	defer p.Unlock()
+	if err != nil {
+		if p.up || !p.polled {
+			log.Printf("Failed to get the scheduled events: %v", err)
+		}
+		p.polled, p.up, p.events = true, false, nil
+		return
+	}
+
+	counted := map[string]bool{}
+	for _, e := range events {
+		if !p.counted[e.EventID] {
+			scheduledEventsTotal.WithLabelValues(e.EventType).Inc()
+		}
+		counted[e.EventID] = true
+	}
+	p.polled, p.up, p.events, p.counted = true, true, events, counted
+}
+
+// collect exposes the scheduled events of the last poll.
+func (p *scheduledEventsPoller) collect(ch chan<- prometheus.Metric) {
+	p.Lock()
+	defer p.Unlock()
+	if !p.polled {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(scheduledEventsUpDesc, prometheus.GaugeValue, boolToFloat64(p.up))
+	for _, e := range p.events {
+		notBefore, err := http.ParseTime(e.NotBefore)
+		for _, resource := range e.Resources {
+			ch <- prometheus.MustNewConstMetric(scheduledEventInfoDesc, prometheus.GaugeValue, 1, e.EventID, e.EventType, e.EventStatus, e.EventSource, resource)
+			if err == nil {
+				ch <- prometheus.MustNewConstMetric(scheduledEventNotBeforeDesc, prometheus.GaugeValue, float64(notBefore.Unix()), e.EventID, e.EventType, resource)
+			}
+		}
+	}
+}
+
+// getScheduledEvents requests the scheduled events of the virtual machine
+// from the Instance Metadata Service.
+func getScheduledEvents(url string) ([]ScheduledEvent, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s?api-version=%s", url, scheduledEventsAPIVersion), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating HTTP request: %v", err)
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := ac.clientFor(tokenEndpoints).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading body of response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp.StatusCode, body)
+	}
+
+	var data ScheduledEventsResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling response body: %v", err)
+	}
+	return data.Events, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestScheduledEvents(t *testing.T) {
+	events := `{"DocumentIncarnation": 1, "Events": [{
+		"EventId": "A123BC45-1234-5678-AB90-ABCDEF123456",
+		"EventStatus": "Scheduled",
+		"EventType": "Preempt",
+		"ResourceType": "VirtualMachine",
+		"Resources": ["vm1"],
+		"NotBefore": "Mon, 19 Sep 2016 18:29:47 GMT",
+		"EventSource": "Platform"
+	}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("api-version") != scheduledEventsAPIVersion {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if events == "" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, events)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{}
+	ac = NewAzureClient()
+
+	preempted := counterValue(t, scheduledEventsTotal.WithLabelValues("Preempt"))
+	p := &scheduledEventsPoller{counted: map[string]bool{}}
+	p.poll(server.URL)
+	p.poll(server.URL)
+	if got := counterValue(t, scheduledEventsTotal.WithLabelValues("Preempt")) - preempted; got != 1 {
+		t.Errorf("unexpected number of counted events\ngot: %v\nwant: 1", got)
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	p.collect(ch)
+	close(ch)
+	want := map[string]float64{
+		`azure_scheduled_events_up{}`: 1,
+		`azure_scheduled_event_info{A123BC45-1234-5678-AB90-ABCDEF123456,Platform,Scheduled,Preempt,vm1}`:      1,
+		`azure_scheduled_event_not_before_timestamp_seconds{A123BC45-1234-5678-AB90-ABCDEF123456,Preempt,vm1}`: 1474309787,
+	}
+	if got := metricValues(t, ch); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected metrics\ngot: %v\nwant: %v", got, want)
+	}
+
+	events = ""
+	p.poll(server.URL)
+	ch = make(chan prometheus.Metric, 10)
+	p.collect(ch)
+	close(ch)
+	if got := metricValues(t, ch); !reflect.DeepEqual(got, map[string]float64{`azure_scheduled_events_up{}`: 0}) {
+		t.Errorf("unexpected metrics after a failure: %v", got)
+	}
+}
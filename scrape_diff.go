@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxLoggedSeriesChanges limits the number of series logged per scrape.
+const maxLoggedSeriesChanges = 100
+
+// scrapeDiff compares the series of each scrape with the previous scrape, so
+// that Azure-side changes and data gaps can be spotted in the logs.
+type scrapeDiff struct {
+	mtx      sync.Mutex
+	previous map[string]bool
+}
+
+// scrapeDiffSet keeps a scrapeDiff for each selection of collectors, so that
+// the scrapes of different parts of the configuration aren't compared.
+type scrapeDiffSet struct {
+	mtx   sync.Mutex
+	diffs map[string]*scrapeDiff
+}
+
+// get returns the scrapeDiff of the selection of collectors.
+func (s *scrapeDiffSet) get(key string) *scrapeDiff {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.diffs == nil {
+		s.diffs = map[string]*scrapeDiff{}
+	}
+	d, ok := s.diffs[key]
+	if !ok {
+		d = &scrapeDiff{}
+		s.diffs[key] = d
+	}
+	return d
+}
+
+// update returns the series of the metric families which appeared and
+// disappeared since the previous scrape, none for the first scrape.
+func (d *scrapeDiff) update(mfs []*dto.MetricFamily) (appeared []string, disappeared []string) {
+	series := map[string]bool{}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			var labels []string
+			for _, l := range m.Label {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			series[fmt.Sprintf("%s{%s}", mf.GetName(), strings.Join(labels, ","))] = true
+		}
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.previous != nil {
+		for s := range series {
+			if !d.previous[s] {
+				appeared = append(appeared, s)
+			}
+		}
+		for s := range d.previous {
+			if !series[s] {
+				disappeared = append(disappeared, s)
+			}
+		}
+	}
+	d.previous = series
+	sort.Strings(appeared)
+	sort.Strings(disappeared)
+	return appeared, disappeared
+}
+
+// log logs the series which appeared and disappeared since the previous
+// scrape.
+func (d *scrapeDiff) log(mfs []*dto.MetricFamily) {
+	appeared, disappeared := d.update(mfs)
+	if len(appeared) == 0 && len(disappeared) == 0 {
+		return
+	}
+
+	log.Printf("Scrape diff: %d series appeared, %d series disappeared", len(appeared), len(disappeared))
+	logged := 0
+	for _, changes := range []struct {
+		sign   string
+		series []string
+	}{{"+", appeared}, {"-", disappeared}} {
+		for _, s := range changes.series {
+			if logged == maxLoggedSeriesChanges {
+				log.Printf("Scrape diff: %d more changes not logged", len(appeared)+len(disappeared)-logged)
+				return
+			}
+			log.Printf("Scrape diff: %s %s", changes.sign, s)
+			logged++
+		}
+	}
+}
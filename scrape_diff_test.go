@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestScrapeDiffUpdate(t *testing.T) {
+	gather := func(resources ...string) []*dto.MetricFamily {
+		registry := prometheus.NewRegistry()
+		gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "requests_count_total", Help: "Requests"}, []string{"resource_name"})
+		registry.MustRegister(gauge)
+		for _, r := range resources {
+			gauge.WithLabelValues(r).Set(1)
+		}
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mfs
+	}
+
+	d := &scrapeDiff{}
+	if appeared, disappeared := d.update(gather("app1", "app2")); appeared != nil || disappeared != nil {
+		t.Errorf("compares the first scrape\ngot: %v, %v", appeared, disappeared)
+	}
+
+	appeared, disappeared := d.update(gather("app2", "app3"))
+	if want := []string{`requests_count_total{resource_name="app3"}`}; !reflect.DeepEqual(appeared, want) {
+		t.Errorf("unexpected appeared series\ngot: %v\nwant: %v", appeared, want)
+	}
+	if want := []string{`requests_count_total{resource_name="app1"}`}; !reflect.DeepEqual(disappeared, want) {
+		t.Errorf("unexpected disappeared series\ngot: %v\nwant: %v", disappeared, want)
+	}
+}
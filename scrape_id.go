@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// correlationHeader carries the scrape ID in the Azure requests, Azure
+// reports it in its activity logs and support requests.
+const correlationHeader = "x-ms-correlation-request-id"
+
+// newScrapeID returns a random UUID identifying a scrape.
+func newScrapeID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Printf("Failed to generate scrape ID: %v", err)
+		return ""
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// setCorrelationHeader sets the scrape ID of an Azure request, if any.
+func setCorrelationHeader(req *http.Request, scrapeID string) {
+	if scrapeID != "" {
+		req.Header.Set(correlationHeader, scrapeID)
+	}
+}
+
+// logf logs a message of the scrape, prefixed with its ID.
+func (c *Collector) logf(format string, v ...interface{}) {
+	log.Printf("scrape=%s "+format, append([]interface{}{c.scrapeID}, v...)...)
+}
+
+// lastScrapeHandler returns the ID of the last scrape, to find its log lines
+// and Azure requests.
+func lastScrapeHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"scrape_id": lastScrape.id()})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestNewScrapeID(t *testing.T) {
+	id := newScrapeID()
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id) {
+		t.Errorf("scrape ID isn't a UUID: %q", id)
+	}
+	if newScrapeID() == id {
+		t.Errorf("scrape IDs aren't unique")
+	}
+}
+
+func TestCollectorLogf(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	(&Collector{scrapeID: "abc"}).logf("Failed to get %s: %v", "budgets", "denied")
+	if !strings.HasSuffix(buf.String(), "scrape=abc Failed to get budgets: denied\n") {
+		t.Errorf("log line isn't prefixed with the scrape ID: %q", buf.String())
+	}
+}
+
+func TestBatchCorrelationHeader(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(correlationHeader)
+		w.Write([]byte(`{"responses": []}`))
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL}
+	ac = NewAzureClient()
+
+	body, err := ac.getBatchResponse(metricsEndpoints, []string{"/a"}, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	if got != "abc" {
+		t.Errorf("batch request doesn't carry the scrape ID\ngot: %q\nwant: abc", got)
+	}
+}
+
+func TestListingCorrelationHeader(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(correlationHeader))
+		w.Write([]byte(`{"value": []}`))
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL}
+	ac = NewAzureClient()
+	ac.tokens[server.URL] = accessToken{token: "token", expiresOn: time.Now().Add(time.Hour)}
+
+	if _, err := ac.listBudgets("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getAzureMonitorResponse(server.URL, ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"abc", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected correlation headers\ngot: %q\nwant: %q", got, want)
+	}
+}
+
+func TestLastScrapeHandler(t *testing.T) {
+	previous := lastScrape
+	defer func() { lastScrape = previous }()
+	lastScrape = &scrapeProfile{}
+	lastScrape.update("abc", nil)
+
+	rec := httptest.NewRecorder()
+	lastScrapeHandler(rec, httptest.NewRequest("GET", "/debug/scrape", nil))
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"scrape_id":"abc"}` {
+		t.Errorf("unexpected last scrape\ngot: %s", body)
+	}
+}
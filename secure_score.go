@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	secureScorePercentageDesc = prometheus.NewDesc("azure_secure_score_percentage", "Percentage of the Defender for Cloud secure score",
+		[]string{"secure_score"}, nil)
+	secureScoreControlPercentageDesc = prometheus.NewDesc("azure_secure_score_control_percentage", "Percentage of the score of a Defender for Cloud security control",
+		[]string{"control"}, nil)
+	secureScoreControlUnhealthyDesc = prometheus.NewDesc("azure_secure_score_control_unhealthy_resources", "Number of unhealthy resources of a Defender for Cloud security control",
+		[]string{"control"}, nil)
+)
+
+// AzureSecureScoreListResponse is a page of the secure scores or secure score
+// controls API of Microsoft.Security.
+type AzureSecureScoreListResponse struct {
+	Value []struct {
+		Name       string `json:"name"`
+		Properties struct {
+			DisplayName string `json:"displayName"`
+			Score       struct {
+				Percentage jsonFloat `json:"percentage"`
+			} `json:"score"`
+			UnhealthyResourceCount jsonFloat `json:"unhealthyResourceCount"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+// collectSecureScores exposes the secure scores of the subscription and the
+// scores of their security controls. Azure gives the percentages as ratios.
+func (c *Collector) collectSecureScores(ch chan<- prometheus.Metric, apiErrors apiErrorSet) {
+	apiVersion := "2020-01-01"
+	subscription := fmt.Sprintf("%s/subscriptions/%s", strings.TrimSuffix(c.cfg.ResourceManagerURL, "/"), c.cfg.Credentials.SubscriptionID)
+
+	scoresEndpoint := fmt.Sprintf("%s/providers/Microsoft.Security/secureScores?api-version=%s", subscription, apiVersion)
+	err := forEachPage(scoresEndpoint, c.scrapeID, func(body []byte) (string, error) {
+		var page AzureSecureScoreListResponse
+		if err := decodeLenient("security", body, &page); err != nil {
+			return "", err
+		}
+		for _, s := range page.Value {
+			ch <- prometheus.MustNewConstMetric(secureScorePercentageDesc, prometheus.GaugeValue, 100*float64(s.Properties.Score.Percentage), s.Name)
+		}
+		return page.NextLink, nil
+	})
+	if err != nil {
+		c.logf("Failed to get secure scores: %v", err)
+		apiErrors.add(errorCode(err), "secure_scores")
+		return
+	}
+
+	controlsEndpoint := fmt.Sprintf("%s/providers/Microsoft.Security/secureScoreControls?api-version=%s", subscription, apiVersion)
+	err = forEachPage(controlsEndpoint, c.scrapeID, func(body []byte) (string, error) {
+		var page AzureSecureScoreListResponse
+		if err := decodeLenient("security", body, &page); err != nil {
+			return "", err
+		}
+		for _, s := range page.Value {
+			control := s.Properties.DisplayName
+			if control == "" {
+				control = s.Name
+			}
+			ch <- prometheus.MustNewConstMetric(secureScoreControlPercentageDesc, prometheus.GaugeValue, 100*float64(s.Properties.Score.Percentage), control)
+			ch <- prometheus.MustNewConstMetric(secureScoreControlUnhealthyDesc, prometheus.GaugeValue, float64(s.Properties.UnhealthyResourceCount), control)
+		}
+		return page.NextLink, nil
+	})
+	if err != nil {
+		c.logf("Failed to get secure score controls: %v", err)
+		apiErrors.add(errorCode(err), "secure_score_controls")
+	}
+}
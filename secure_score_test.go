@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectSecureScores(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/secureScores") {
+			fmt.Fprint(w, `{"value": [{"name": "ascScore", "properties": {"score": {"max": 58, "current": 36.2, "percentage": 0.625}}}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"value": [{"name": "1195afff", "properties": {"displayName": "Enable MFA", "score": {"percentage": 0.5}, "unhealthyResourceCount": 3}}]}`)
+	}))
+	defer server.Close()
+
+	previous, previousClient := sc.C, ac
+	defer func() { sc.C, ac = previous, previousClient }()
+	sc.C = &config.Config{ResourceManagerURL: server.URL}
+	ac = NewAzureClient()
+
+	ch := make(chan prometheus.Metric, 10)
+	(&Collector{cfg: sc.C}).collectSecureScores(ch, apiErrorSet{})
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`azure_secure_score_percentage{ascScore}`:                    62.5,
+		`azure_secure_score_control_percentage{Enable MFA}`:          50,
+		`azure_secure_score_control_unhealthy_resources{Enable MFA}`: 3,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't collect secure scores\ngot: %v\nwant: %v", got, want)
+	}
+}
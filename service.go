@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// serviceArgs returns the arguments of the Windows service running the
+// exporter with the flags of the command line args, which installs the
+// service. The flags are given in their --name=value form, so that the
+// command is the only positional argument left out whatever the values of
+// the flags.
+func serviceArgs(app *kingpin.Application, args []string) ([]string, error) {
+	ctx, err := app.ParseContext(args)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceArgs := []string{"service", "run"}
+	for _, e := range ctx.Elements {
+		flag, ok := e.Clause.(*kingpin.FlagClause)
+		if !ok || e.Value == nil {
+			continue
+		}
+		name := flag.Model().Name
+		switch {
+		case !flag.Model().IsBoolFlag():
+			serviceArgs = append(serviceArgs, fmt.Sprintf("--%s=%s", name, *e.Value))
+		case *e.Value == "false":
+			serviceArgs = append(serviceArgs, "--no-"+name)
+		default:
+			serviceArgs = append(serviceArgs, "--"+name)
+		}
+	}
+	return serviceArgs, nil
+}
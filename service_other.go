@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// runServiceCommand runs the commands managing the exporter as a Windows
+// service, which only exist on Windows.
+func runServiceCommand(command string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestServiceArgs(t *testing.T) {
+	app := kingpin.New("azure_metrics_exporter", "")
+	app.Flag("config.file", "").Strings()
+	app.Flag("service.name", "").String()
+	app.Flag("log.eventlog", "").Bool()
+	app.Flag("log.debug", "").Bool()
+	service := app.Command("service", "")
+	service.Command("install", "")
+	service.Command("run", "")
+
+	args := []string{
+		"--service.name", "service",
+		"service", "install",
+		"--config.file=install", "--config.file", "azure.yml",
+		"--log.eventlog", "--no-log.debug",
+	}
+	got, err := serviceArgs(app, args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"service", "run",
+		"--service.name=service",
+		"--config.file=install", "--config.file=azure.yml",
+		"--log.eventlog", "--no-log.debug",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected service arguments\ngot: %q\nwant: %q", got, want)
+	}
+
+	if _, err := serviceArgs(app, []string{"service", "install", "--unknown"}); err == nil {
+		t.Errorf("no error for an unknown flag")
+	}
+}
@@ -0,0 +1,180 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	serviceCmd          = kingpin.Command("service", "Manage the exporter as a Windows service.")
+	serviceInstallCmd   = serviceCmd.Command("install", "Install the Windows service, which runs the exporter with the other given flags.")
+	serviceUninstallCmd = serviceCmd.Command("uninstall", "Uninstall the Windows service.")
+	serviceRunCmd       = serviceCmd.Command("run", "Run the exporter as a Windows service, as started by the service control manager.")
+	serviceName         = kingpin.Flag("service.name", "Name of the Windows service.").Default("azure_metrics_exporter").String()
+	logEventLog         = kingpin.Flag("log.eventlog", "Log to the Windows event log, with the service name as event source.").Bool()
+)
+
+// runServiceCommand runs the commands managing the exporter as a Windows
+// service.
+func runServiceCommand(command string) (bool, error) {
+	if *logEventLog {
+		if err := logToEventLog(*serviceName); err != nil {
+			return true, err
+		}
+	}
+
+	switch command {
+	case serviceInstallCmd.FullCommand():
+		return true, installService(*serviceName)
+	case serviceUninstallCmd.FullCommand():
+		return true, uninstallService(*serviceName)
+	case serviceRunCmd.FullCommand():
+		return true, runService(*serviceName)
+	}
+	return false, nil
+}
+
+// installService installs the service running the exporter with the flags of
+// the command line and registers its event source.
+func installService(name string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("Error getting path of the exporter: %v", err)
+	}
+	args, err := serviceArgs(kingpin.CommandLine, os.Args[1:])
+	if err != nil {
+		return fmt.Errorf("Error parsing the command line: %v", err)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("Error connecting to the service control manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.CreateService(name, exe, mgr.Config{DisplayName: "Azure Metrics Exporter", StartType: mgr.StartAutomatic}, args...)
+	if err != nil {
+		return fmt.Errorf("Error creating service %s: %v", name, err)
+	}
+	s.Close()
+
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		return fmt.Errorf("Error registering event source %s: %v", name, err)
+	}
+	log.Printf("Installed service %s", name)
+	return nil
+}
+
+// uninstallService uninstalls the service and its event source.
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("Error connecting to the service control manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("Error opening service %s: %v", name, err)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("Error deleting service %s: %v", name, err)
+	}
+
+	if err := eventlog.Remove(name); err != nil {
+		log.Printf("Error removing event source %s: %v", name, err)
+	}
+	log.Printf("Uninstalled service %s", name)
+	return nil
+}
+
+// exporterService runs the exporter until the service is stopped.
+type exporterService struct {
+	name string
+}
+
+// Execute implements the svc.Handler interface.
+func (s *exporterService) Execute(args []string, r <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(runCmd.FullCommand(), stop)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				log.Printf("Stopping service %s", s.name)
+				status <- svc.Status{State: svc.StopPending}
+				close(stop)
+				<-done
+				return false, 0
+			}
+		case <-done:
+			return false, 0
+		}
+	}
+}
+
+// runService runs the exporter as the service until it's stopped by the
+// service control manager. The configuration files are relative to the
+// directory of the exporter.
+func runService(name string) error {
+	if exe, err := os.Executable(); err == nil {
+		if err := os.Chdir(filepath.Dir(exe)); err != nil {
+			log.Printf("Error changing to the directory of the exporter: %v", err)
+		}
+	}
+
+	if err := svc.Run(name, &exporterService{name: name}); err != nil {
+		return fmt.Errorf("Error running service %s: %v", name, err)
+	}
+	return nil
+}
+
+// eventLogWriter writes the log messages to the Application event log.
+type eventLogWriter struct {
+	log *eventlog.Log
+}
+
+// logToEventLog writes the log messages to the Application event log, with
+// the service name as event source.
+func logToEventLog(name string) error {
+	l, err := eventlog.Open(name)
+	if err != nil {
+		return fmt.Errorf("Error opening event log: %v", err)
+	}
+	log.SetFlags(0)
+	log.SetOutput(&eventLogWriter{log: l})
+	return nil
+}
+
+// Write implements the io.Writer interface. The NUL characters, which can't
+// be logged, are dropped.
+func (w *eventLogWriter) Write(p []byte) (int, error) {
+	message := strings.Replace(strings.TrimSpace(string(p)), "\x00", "", -1)
+	if err := w.log.Info(1, message); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	// defaultStatsScrapes is the default number of scrapes summed by
+	// /api/stats.
+	defaultStatsScrapes = 10
+	// maxStatsScrapes is the number of scrapes whose statistics are kept.
+	maxStatsScrapes = 100
+)
+
+// entryStats are the statistics of a configured block or of a subscription.
+// The API calls count the listing requests and the batch sub-requests, and
+// the duration sums the durations of the requests involving the entry.
+type entryStats struct {
+	Resources       int     `json:"resources"`
+	APICalls        int     `json:"api_calls"`
+	Series          int     `json:"series"`
+	Errors          int     `json:"errors"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+func (s *entryStats) add(delta entryStats) {
+	s.Resources += delta.Resources
+	s.APICalls += delta.APICalls
+	s.Series += delta.Series
+	s.Errors += delta.Errors
+	s.DurationSeconds += delta.DurationSeconds
+}
+
+// scrapeStats are the statistics of a scrape by configured block, e.g.
+// resource_groups[2], and by subscription.
+type scrapeStats struct {
+	blocks        map[string]*entryStats
+	subscriptions map[string]*entryStats
+}
+
+// add adds delta to the statistics of the block and of the subscription,
+// an empty block or subscription is skipped.
+func (s *scrapeStats) add(block string, subscription string, delta entryStats) {
+	if s.blocks == nil {
+		s.blocks = map[string]*entryStats{}
+		s.subscriptions = map[string]*entryStats{}
+	}
+	for _, e := range []struct {
+		m   map[string]*entryStats
+		key string
+	}{{s.blocks, block}, {s.subscriptions, subscription}} {
+		if e.key == "" {
+			continue
+		}
+		if e.m[e.key] == nil {
+			e.m[e.key] = &entryStats{}
+		}
+		e.m[e.key].add(delta)
+	}
+}
+
+// statsSummary is the sum of the statistics of the last scrapes.
+type statsSummary struct {
+	Scrapes       int                   `json:"scrapes"`
+	Blocks        map[string]entryStats `json:"blocks"`
+	Subscriptions map[string]entryStats `json:"subscriptions"`
+}
+
+// statsHistory holds the statistics of the last scrapes.
+type statsHistory struct {
+	sync.Mutex
+	scrapes []scrapeStats
+}
+
+func (h *statsHistory) record(s scrapeStats) {
+	h.Lock()
+	defer h.Unlock()
+	h.scrapes = append(h.scrapes, s)
+	if len(h.scrapes) > maxStatsScrapes {
+		h.scrapes = h.scrapes[len(h.scrapes)-maxStatsScrapes:]
+	}
+}
+
+// summary sums the statistics of the last n scrapes.
+func (h *statsHistory) summary(n int) statsSummary {
+	h.Lock()
+	defer h.Unlock()
+	scrapes := h.scrapes
+	if len(scrapes) > n {
+		scrapes = scrapes[len(scrapes)-n:]
+	}
+
+	summary := statsSummary{
+		Scrapes:       len(scrapes),
+		Blocks:        map[string]entryStats{},
+		Subscriptions: map[string]entryStats{},
+	}
+	for _, s := range scrapes {
+		for _, e := range []struct {
+			from map[string]*entryStats
+			to   map[string]entryStats
+		}{{s.blocks, summary.Blocks}, {s.subscriptions, summary.Subscriptions}} {
+			for key, stats := range e.from {
+				sum := e.to[key]
+				sum.add(*stats)
+				e.to[key] = sum
+			}
+		}
+	}
+	return summary
+}
+
+// subscriptionOf returns the subscription of a resource.
+func subscriptionOf(rm resourceMeta) string {
+	if rm.resource.Subscription != "" {
+		return rm.resource.Subscription
+	}
+	return sc.Get().Credentials.SubscriptionID
+}
+
+// failResource records an error of the metrics of a resource.
+func (c *Collector) failResource(rm resourceMeta, code string) {
+	c.blocks.fail(rm.block, code)
+	c.stats.add(rm.block, subscriptionOf(rm), entryStats{Errors: 1})
+}
+
+// statsHandler sums the statistics of the last scrapes by configured block
+// and by subscription, the number of scrapes is given by the n parameter.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	n := defaultStatsScrapes
+	if value := r.URL.Query().Get("n"); value != "" {
+		var err error
+		n, err = strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxStatsScrapes {
+			http.Error(w, "Invalid n parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, recentStats.summary(n))
+}
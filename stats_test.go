@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestScrapeStats(t *testing.T) {
+	defer func(c *config.Config) { sc.C = c }(sc.C)
+	sc.C = &config.Config{Credentials: config.Credentials{SubscriptionID: "abc"}}
+
+	c := &Collector{cfg: sc.C}
+	a := resourceMeta{resourceID: "/a", block: "resource_groups[0]"}
+	b := resourceMeta{resourceID: "/b", block: "resource_groups[0]", resource: AzureResource{Subscription: "def"}}
+	c.recordTiming("batch", []resourceMeta{a, b}, time.Now().Add(-time.Second))
+	c.extractMetrics(make(chan prometheus.Metric, 1), a, 404, AzureMetricValueResponse{}, map[string]bool{}, apiErrorSet{})
+
+	blocks := c.stats.blocks["resource_groups[0]"]
+	if blocks.APICalls != 2 || blocks.Errors != 1 || blocks.DurationSeconds < 1 || blocks.DurationSeconds >= 2 {
+		t.Errorf("unexpected stats of the block: %+v", *blocks)
+	}
+	if s := c.stats.subscriptions["abc"]; s.APICalls != 1 || s.Errors != 1 {
+		t.Errorf("unexpected stats of subscription abc: %+v", *s)
+	}
+	if s := c.stats.subscriptions["def"]; s.APICalls != 1 || s.Errors != 0 {
+		t.Errorf("unexpected stats of subscription def: %+v", *s)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	defer func(h *statsHistory) { recentStats = h }(recentStats)
+	recentStats = &statsHistory{}
+	for i := 0; i < maxStatsScrapes+5; i++ {
+		var s scrapeStats
+		s.add("targets[0]", "abc", entryStats{Resources: 2, Series: 10})
+		if i%2 == 0 {
+			s.add("resource_tags[0]", "abc", entryStats{Errors: 1})
+		}
+		recentStats.record(s)
+	}
+	if len(recentStats.scrapes) != maxStatsScrapes {
+		t.Errorf("unexpected number of scrapes kept\ngot: %d\nwant: %d", len(recentStats.scrapes), maxStatsScrapes)
+	}
+
+	rec := httptest.NewRecorder()
+	statsHandler(rec, httptest.NewRequest("GET", "/api/stats?n=4", nil))
+	var got statsSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	want := statsSummary{
+		Scrapes: 4,
+		Blocks: map[string]entryStats{
+			"targets[0]":       {Resources: 8, Series: 40},
+			"resource_tags[0]": {Errors: 2},
+		},
+		Subscriptions: map[string]entryStats{"abc": {Resources: 8, Series: 40, Errors: 2}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected stats\ngot: %+v\nwant: %+v", got, want)
+	}
+
+	for _, n := range []string{"0", "x", "101"} {
+		rec := httptest.NewRecorder()
+		statsHandler(rec, httptest.NewRequest("GET", "/api/stats?n="+n, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("unexpected status for n=%s\ngot: %d\nwant: %d", n, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
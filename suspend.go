@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultSuspendEmptyFor is the suspension of the resources without metrics
+// when suspend_empty_for isn't set.
+const defaultSuspendEmptyFor = time.Hour
+
+var resourceSuspendedDesc = prometheus.NewDesc("azure_resource_suspended", "Resource whose metrics aren't requested as Azure returned none of them for several scrapes", []string{"resource"}, nil)
+
+// emptyResourceTracker counts the consecutive scrapes for which Azure
+// returned no metrics for a resource, and suspends the resources reaching
+// the suspend_empty_after of their block for suspend_empty_for. It's shared
+// by the scrapes.
+type emptyResourceTracker struct {
+	sync.Mutex
+	empty     map[string]int
+	suspended map[string]time.Time
+}
+
+func newEmptyResourceTracker() *emptyResourceTracker {
+	return &emptyResourceTracker{
+		empty:     map[string]int{},
+		suspended: map[string]time.Time{},
+	}
+}
+
+// suspendKey identifies the metrics of a resource, which can be requested
+// with different metrics by several blocks, or at several time grains.
+func suspendKey(rm resourceMeta) string {
+	return resourceKey(rm) + "|" + rm.metricNamespace + "|" + rm.metrics + "|" + rm.interval.String()
+}
+
+// record records whether Azure returned metrics for the resource.
+func (t *emptyResourceTracker) record(rm resourceMeta, empty bool, now time.Time) {
+	if rm.suspendEmptyAfter <= 0 {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	key := suspendKey(rm)
+	if !empty {
+		delete(t.empty, key)
+		return
+	}
+	t.empty[key]++
+	if t.empty[key] < rm.suspendEmptyAfter {
+		return
+	}
+	delete(t.empty, key)
+	duration := rm.suspendEmptyFor
+	if duration == 0 {
+		duration = defaultSuspendEmptyFor
+	}
+	log.Printf("No metrics %s returned for resource %s for %d scrapes, suspending it for %v", rm.metrics, rm.resourceID, rm.suspendEmptyAfter, duration)
+	t.suspended[key] = now.Add(duration)
+}
+
+// filter returns the resources which aren't suspended, and exposes the
+// suspended ones, once per resource.
+func (t *emptyResourceTracker) filter(ch chan<- prometheus.Metric, resources []resourceMeta, now time.Time) []resourceMeta {
+	t.Lock()
+	defer t.Unlock()
+
+	var active []resourceMeta
+	exposed := map[string]bool{}
+	for _, rm := range resources {
+		key := suspendKey(rm)
+		if until, ok := t.suspended[key]; ok {
+			if now.Before(until) {
+				if id := resourceKey(rm); !exposed[id] {
+					ch <- prometheus.MustNewConstMetric(resourceSuspendedDesc, prometheus.GaugeValue, 1, id)
+					exposed[id] = true
+				}
+				continue
+			}
+			delete(t.suspended, key)
+		}
+		active = append(active, rm)
+	}
+	return active
+}
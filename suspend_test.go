@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestEmptyResourceTracker(t *testing.T) {
+	tr := newEmptyResourceTracker()
+	now := time.Now()
+	rm := resourceMeta{resourceID: "/a", metrics: "Percentage CPU", suspendEmptyAfter: 2, suspendEmptyFor: time.Minute}
+	other := resourceMeta{resourceID: "/b", metrics: "Percentage CPU"}
+
+	filter := func(now time.Time) ([]resourceMeta, map[string]float64) {
+		ch := make(chan prometheus.Metric, 10)
+		active := tr.filter(ch, []resourceMeta{rm, other}, now)
+		close(ch)
+		return active, metricValues(t, ch)
+	}
+
+	// Metrics returned in between reset the count.
+	tr.record(rm, true, now)
+	tr.record(rm, false, now)
+	tr.record(rm, true, now)
+	if active, _ := filter(now); len(active) != 2 {
+		t.Errorf("resource is suspended before suspend_empty_after scrapes")
+	}
+
+	tr.record(rm, true, now)
+	// Resources without suspend_empty_after are never suspended.
+	for i := 0; i < 5; i++ {
+		tr.record(other, true, now)
+	}
+	active, got := filter(now.Add(30 * time.Second))
+	if !reflect.DeepEqual(active, []resourceMeta{other}) {
+		t.Errorf("unexpected active resources\ngot: %v", active)
+	}
+	if want := map[string]float64{`azure_resource_suspended{/a}`: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected suspended resources\ngot: %v\nwant: %v", got, want)
+	}
+
+	if active, _ := filter(now.Add(time.Minute)); len(active) != 2 {
+		t.Errorf("resource is still suspended after suspend_empty_for")
+	}
+
+	// The time grains of a resource are suspended separately, and the
+	// resource is exposed once.
+	fine, coarse := rm, rm
+	fine.interval, coarse.interval = time.Minute, time.Hour
+	for i := 0; i < 2; i++ {
+		tr.record(fine, true, now)
+		tr.record(coarse, true, now)
+	}
+	tr.record(rm, true, now)
+	ch := make(chan prometheus.Metric, 10)
+	active = tr.filter(ch, []resourceMeta{rm, fine, coarse}, now)
+	close(ch)
+	if !reflect.DeepEqual(active, []resourceMeta{rm}) {
+		t.Errorf("unexpected active time grains\ngot: %v", active)
+	}
+	if got, want := metricValues(t, ch), map[string]float64{`azure_resource_suspended{/a}`: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected suspended time grains\ngot: %v\nwant: %v", got, want)
+	}
+}
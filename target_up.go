@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	targetUpDesc        = prometheus.NewDesc("azure_target_up", "Whether the resources of a configured targets, resource_groups or resource_tags entry were discovered and their metrics fetched without error (1) or not (0)", []string{"target"}, nil)
+	targetLastErrorDesc = prometheus.NewDesc("azure_target_last_error_info", "Last Azure error code of a configured targets, resource_groups or resource_tags entry during the scrape", []string{"target", "code"}, nil)
+)
+
+// blockStatusSet holds the last error code of each configured block during
+// the scrape, e.g. resource_groups[2], empty for the blocks without error.
+type blockStatusSet map[string]string
+
+// register adds a block collected by the scrape.
+func (s *blockStatusSet) register(block string) {
+	if *s == nil {
+		*s = blockStatusSet{}
+	}
+	if _, ok := (*s)[block]; !ok {
+		(*s)[block] = ""
+	}
+}
+
+// fail records an error of the discovery or of the metrics of a block.
+func (s *blockStatusSet) fail(block string, code string) {
+	if block == "" {
+		return
+	}
+	if *s == nil {
+		*s = blockStatusSet{}
+	}
+	(*s)[block] = code
+}
+
+func (s blockStatusSet) collect(ch chan<- prometheus.Metric) {
+	for block, code := range s {
+		ch <- prometheus.MustNewConstMetric(targetUpDesc, prometheus.GaugeValue, boolToFloat64(code == ""), block)
+		if code != "" {
+			ch <- prometheus.MustNewConstMetric(targetLastErrorDesc, prometheus.GaugeValue, 1, block, code)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBlockStatusSet(t *testing.T) {
+	c := &Collector{cfg: sc.C}
+	c.blocks.register("targets[0]")
+	c.blocks.register("resource_groups[0]")
+	c.blocks.register("resource_tags[0]")
+	c.blocks.fail("resource_tags[0]", "AuthorizationFailed")
+
+	// Metrics errors of a resource fail its block.
+	rm := resourceMeta{resourceID: "/a", block: "resource_groups[0]"}
+	c.extractMetrics(make(chan prometheus.Metric, 1), rm, 404, AzureMetricValueResponse{}, map[string]bool{}, apiErrorSet{})
+	// Registering a block again keeps its error.
+	c.blocks.register("resource_groups[0]")
+
+	ch := make(chan prometheus.Metric, 10)
+	c.blocks.collect(ch)
+	close(ch)
+
+	got := metricValues(t, ch)
+	want := map[string]float64{
+		`azure_target_up{targets[0]}`:                                        1,
+		`azure_target_up{resource_groups[0]}`:                                0,
+		`azure_target_up{resource_tags[0]}`:                                  0,
+		`azure_target_last_error_info{NotFound,resource_groups[0]}`:          1,
+		`azure_target_last_error_info{AuthorizationFailed,resource_tags[0]}`: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected target status\ngot: %v\nwant: %v", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// targetGroup is a group of resources of a targets file, which uses the
+// file_sd format of Prometheus.
+type targetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+type targetsFile struct {
+	modTime time.Time
+	groups  []targetGroup
+}
+
+// targetsFileCache holds the target groups of the targets files. A file is
+// read again when it is modified, so that external tools can update the
+// targets without reloading the configuration.
+type targetsFileCache struct {
+	sync.Mutex
+	files map[string]targetsFile
+}
+
+func newTargetsFileCache() *targetsFileCache {
+	return &targetsFileCache{files: map[string]targetsFile{}}
+}
+
+// groups returns the target groups of the files matching pattern. The last
+// valid content of a file is used when it can't be read.
+func (c *targetsFileCache) groups(pattern string) ([]targetGroup, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading targets files %s: %v", pattern, err)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	var groups []targetGroup
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("Error reading targets file %s: %v", path, err)
+			continue
+		}
+		cached, ok := c.files[path]
+		if !ok || !info.ModTime().Equal(cached.modTime) {
+			fileGroups, err := readTargetsFile(path)
+			if err != nil {
+				log.Printf("Error reading targets file %s: %v", path, err)
+			} else {
+				cached = targetsFile{modTime: info.ModTime(), groups: fileGroups}
+				c.files[path] = cached
+				log.Printf("Loaded %d target groups from %s", len(fileGroups), path)
+			}
+		}
+		groups = append(groups, cached.groups...)
+	}
+	return groups, nil
+}
+
+func readTargetsFile(path string) ([]targetGroup, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []targetGroup
+	switch ext := filepath.Ext(path); strings.ToLower(ext) {
+	case ".json":
+		err = json.Unmarshal(data, &groups)
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(data, &groups)
+	default:
+		return nil, fmt.Errorf("unsupported file extension %q", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// expandTargets replaces the targets read from targets files with a target
+// per resource of the files. The labels of the target groups are added to
+// the labels of the target.
+func expandTargets(targets []config.Target) []config.Target {
+	var expanded []config.Target
+	for _, t := range targets {
+		if t.TargetsFile == "" {
+			expanded = append(expanded, t)
+			continue
+		}
+
+		groups, err := targetsFiles.groups(t.TargetsFile)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, g := range groups {
+			labels := map[string]string{}
+			for name, value := range t.Labels {
+				labels[name] = value
+			}
+			for name, value := range g.Labels {
+				// Labels starting with __ are reserved by Prometheus.
+				if strings.HasPrefix(name, "__") || invalidLabelChars.MatchString(name) {
+					continue
+				}
+				labels[name] = value
+			}
+
+			for _, resource := range g.Targets {
+				if !strings.HasPrefix(resource, "/") {
+					log.Printf("Ignoring resource %q of targets file %s, resource paths must start with a /", resource, t.TargetsFile)
+					continue
+				}
+				target := t
+				target.TargetsFile = ""
+				target.Resource = resource
+				target.Labels = labels
+				expanded = append(expanded, target)
+			}
+		}
+	}
+	return expanded
+}
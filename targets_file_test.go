@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestExpandTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vms.json")
+	content := `[{"targets": ["/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1", "vm2"], "labels": {"env": "prod", "__meta": "x"}}]`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	targets := []config.Target{
+		{Resource: "/resourceGroups/rg/providers/Microsoft.Web/sites/app"},
+		{TargetsFile: filepath.Join(dir, "*.json"), Labels: map[string]string{"team": "db"}},
+	}
+	got := expandTargets(targets)
+	want := []config.Target{
+		{Resource: "/resourceGroups/rg/providers/Microsoft.Web/sites/app"},
+		{Resource: "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1", Labels: map[string]string{"team": "db", "env": "prod"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't expand targets files\ngot: %v\nwant: %v", got, want)
+	}
+
+	content = `[{"targets": ["/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm3"]}]`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	got = expandTargets(targets[1:])
+	if len(got) != 1 || got[0].Resource != "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm3" {
+		t.Errorf("doesn't reload modified targets file\ngot: %v", got)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("[{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime = modTime.Add(time.Minute)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	got = expandTargets(targets[1:])
+	if len(got) != 1 || got[0].Resource != "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm3" {
+		t.Errorf("doesn't keep last valid targets on invalid file\ngot: %v", got)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "github.com/percona/azure_metrics_exporter/config"
+
+// expandTimegrains returns the resources of the blocks with timegrains once
+// per time grain, requested with the time grain as interval and labelled with
+// it. The timespan is widened to the time grain, so that the coarse time
+// grains have a datapoint.
+func expandTimegrains(resources []resourceMeta) []resourceMeta {
+	var expanded []resourceMeta
+	for _, rm := range resources {
+		if len(rm.timegrains) == 0 {
+			expanded = append(expanded, rm)
+			continue
+		}
+		for _, timegrain := range rm.timegrains {
+			g := rm
+			g.interval = timegrain
+			if g.timespan < timegrain {
+				g.timespan = timegrain
+			}
+			g.labels = map[string]string{}
+			for name, v := range rm.labels {
+				g.labels[name] = v
+			}
+			g.labels[config.TimegrainLabel] = isoDuration(timegrain)
+			g.resourceURL = resourceURLFrom(subscriptionOf(rm), rm.resourceID, g.metricNamespace, g.metrics, g.aggregations, g.dimensions, g.queryParameters, g.interval, g.timespan)
+			expanded = append(expanded, g)
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestExpandTimegrains(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{Credentials: config.Credentials{SubscriptionID: "abc"}}
+
+	labels := map[string]string{"env": "prod"}
+	rm := resourceMeta{
+		resourceID:   "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+		metrics:      "Percentage CPU",
+		aggregations: []string{"Average"},
+		timespan:     5 * time.Minute,
+		timegrains:   []time.Duration{time.Minute, time.Hour},
+		labels:       labels,
+		resourceInfo: config.ResourceInfo{Skip: true},
+	}
+	other := resourceMeta{resourceID: "/resourceGroups/rg/providers/Microsoft.Web/sites/app"}
+	expanded := expandTimegrains([]resourceMeta{rm, other})
+	if len(expanded) != 3 || expanded[2].resourceID != other.resourceID {
+		t.Fatalf("unexpected resources %+v", expanded)
+	}
+	if !reflect.DeepEqual(labels, map[string]string{"env": "prod"}) {
+		t.Errorf("labels of the resource modified: %v", labels)
+	}
+
+	for i, want := range []struct {
+		interval  string
+		timespan  time.Duration
+		timegrain string
+	}{{"PT1M", 5 * time.Minute, "PT1M"}, {"PT1H", time.Hour, "PT1H"}} {
+		g := expanded[i]
+		if g.timespan != want.timespan {
+			t.Errorf("unexpected timespan of time grain %s\ngot: %v\nwant: %v", want.timegrain, g.timespan, want.timespan)
+		}
+		if got := g.labels[config.TimegrainLabel]; got != want.timegrain || g.labels["env"] != "prod" {
+			t.Errorf("unexpected labels of time grain %s: %v", want.timegrain, g.labels)
+		}
+		u, err := url.Parse(g.resourceURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(u.Path, "/subscriptions/abc/resourceGroups/rg/") || u.Query().Get("interval") != want.interval {
+			t.Errorf("unexpected URL of time grain %s: %s", want.timegrain, g.resourceURL)
+		}
+	}
+
+	var data AzureMetricValueResponse
+	payload := `{"value": [{"name": {"value": "Percentage CPU"}, "unit": "Percent", "timeseries": [
+		{"data": [{"timeStamp": "2020-01-01T00:00:00Z", "average": 12}]}
+	]}]}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan prometheus.Metric, 2)
+	for _, g := range expanded[:2] {
+		(&Collector{cfg: sc.C}).extractMetrics(ch, g, 200, data, map[string]bool{}, apiErrorSet{})
+	}
+	close(ch)
+	want := map[string]float64{
+		"percentage_cpu_percent_average{prod,rg,vm1,PT1M}": 12,
+		"percentage_cpu_percent_average{prod,rg,vm1,PT1H}": 12,
+	}
+	if got := metricValues(t, ch); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected metrics\ngot: %v\nwant: %v", got, want)
+	}
+}
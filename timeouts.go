@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Azure endpoint classes, whose requests have separate timeouts.
+const (
+	tokenEndpoints   = "token"
+	listingEndpoints = "listing"
+	lookupEndpoints  = "lookup"
+	metricsEndpoints = "metrics"
+)
+
+// requestTimeout returns the timeout of the requests of an endpoint class,
+// from the configuration or else from the flags. Zero means no timeout.
+func requestTimeout(class string) time.Duration {
+	timeouts := sc.Get().Timeouts
+	switch class {
+	case tokenEndpoints:
+		return timeoutOr(timeouts.Token, *tokenTimeout)
+	case listingEndpoints:
+		return timeoutOr(timeouts.Listing, *listingTimeout)
+	case lookupEndpoints:
+		return timeoutOr(timeouts.Lookup, *lookupTimeout)
+	case metricsEndpoints:
+		return timeoutOr(timeouts.Metrics, *metricsTimeout)
+	}
+	return 0
+}
+
+func timeoutOr(configured time.Duration, flag time.Duration) time.Duration {
+	if configured != 0 {
+		return configured
+	}
+	return flag
+}
+
+// clientFor returns a client for the requests of an endpoint class. It shares
+// the transport, and so the connections, of the Azure client.
+func (ac *AzureClient) clientFor(class string) *http.Client {
+	return &http.Client{
+		Transport: durationTransport{class: class, next: ac.client.Transport},
+		Timeout:   requestTimeout(class),
+	}
+}
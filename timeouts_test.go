@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	previous, previousToken, previousMetrics := sc.C, *tokenTimeout, *metricsTimeout
+	defer func() { sc.C, *tokenTimeout, *metricsTimeout = previous, previousToken, previousMetrics }()
+	sc.C = &config.Config{Timeouts: config.Timeouts{Metrics: 5 * time.Second}}
+	*tokenTimeout = 30 * time.Second
+	*metricsTimeout = 30 * time.Second
+
+	if got := requestTimeout(tokenEndpoints); got != 30*time.Second {
+		t.Errorf("doesn't default to the flag\ngot: %v\nwant: 30s", got)
+	}
+	if got := requestTimeout(metricsEndpoints); got != 5*time.Second {
+		t.Errorf("doesn't use the configured timeout\ngot: %v\nwant: 5s", got)
+	}
+}
+
+func TestClientForTimeout(t *testing.T) {
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{Timeouts: config.Timeouts{Metrics: 20 * time.Millisecond}}
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewAzureClient()
+	if _, err := client.clientFor(metricsEndpoints).Get(server.URL); err == nil {
+		t.Errorf("request didn't time out")
+	}
+}
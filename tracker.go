@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// resourceTracker remembers the resources discovered through ARM listings
+// between scrapes, so that resources which disappear can be reported.
+type resourceTracker struct {
+	sync.Mutex
+	known   map[string]bool
+	deleted map[string]int
+}
+
+func newResourceTracker() *resourceTracker {
+	return &resourceTracker{
+		known:   map[string]bool{},
+		deleted: map[string]int{},
+	}
+}
+
+// update records the resources listed during the current scrape and returns
+// the resources to be reported as deleted for this scrape. A resource is
+// reported for the given number of scrapes after it was last listed.
+func (t *resourceTracker) update(seen map[string]bool, scrapes int) []string {
+	t.Lock()
+	defer t.Unlock()
+
+	for id := range t.known {
+		if !seen[id] {
+			log.Printf("Resource %s is no longer listed by Azure, reporting it as deleted", id)
+			if scrapes > 0 {
+				t.deleted[id] = scrapes
+			}
+		}
+	}
+	for id := range seen {
+		delete(t.deleted, id)
+	}
+	t.known = seen
+
+	deleted := []string{}
+	for id, remaining := range t.deleted {
+		deleted = append(deleted, id)
+		if remaining <= 1 {
+			delete(t.deleted, id)
+		} else {
+			t.deleted[id] = remaining - 1
+		}
+	}
+	sort.Strings(deleted)
+	return deleted
+}
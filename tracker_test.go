@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResourceTrackerUpdate(t *testing.T) {
+	tracker := newResourceTracker()
+
+	var cases = []struct {
+		seen map[string]bool
+		want []string
+	}{
+		{map[string]bool{"a": true, "b": true}, []string{}},
+		{map[string]bool{"a": true}, []string{"b"}},
+		{map[string]bool{"a": true}, []string{"b"}},
+		{map[string]bool{}, []string{"a"}},
+		{map[string]bool{"a": true}, []string{}},
+	}
+
+	for i, c := range cases {
+		got := tracker.update(c.seen, 2)
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("scrape %d: doesn't report expected deleted resources\ngot: %v\nwant: %v", i, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// failoverRetryDelay is the delay before requests are sent to the primary
+// Azure Resource Manager endpoint again after failing over to the secondary.
+const failoverRetryDelay = time.Minute
+
+// armTransport sends the requests to the Azure Resource Manager with the Host
+// header and the TLS server name of the configuration, so that ARM can be
+// reached through a private endpoint (e.g. management.privatelink.azure.com)
+// by address when its name doesn't resolve to it.
+//
+// When a request fails, the idle connections to ARM are closed so that the
+// next requests resolve its name again, and the request is sent to the
+// secondary endpoint of the configuration, if any, which is then used until
+// failoverRetryDelay has passed.
+type armTransport struct {
+	mtx           sync.Mutex
+	transports    map[string]*http.Transport
+	secondary     *http.Transport
+	failedOverAt  time.Time
+	failedOverURL string
+}
+
+func newARMTransport() *armTransport {
+	return &armTransport{
+		transports: map[string]*http.Transport{},
+		secondary:  http.DefaultTransport.(*http.Transport).Clone(),
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *armTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isResourceManagerURL(req.URL) {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+
+	cfg := sc.Get()
+	secondaryURL := cfg.ResourceManagerSecondaryURL
+	if secondaryURL != "" && t.failedOver(secondaryURL) {
+		return t.roundTripSecondary(req, secondaryURL)
+	}
+
+	host := cfg.ResourceManagerHost
+	serverName := cfg.ResourceManagerTLSServerName
+	if serverName == "" {
+		serverName = host
+	}
+	primaryReq := req
+	if host != "" {
+		primaryReq = req.Clone(req.Context())
+		primaryReq.Host = host
+	}
+	transport := t.transport(serverName)
+	resp, err := transport.RoundTrip(primaryReq)
+	if err == nil || req.Context().Err() != nil {
+		return resp, err
+	}
+
+	transport.CloseIdleConnections()
+	if secondaryURL == "" || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+	log.Printf("Request to Azure Resource Manager failed, failing over to %s for %v: %v", secondaryURL, failoverRetryDelay, err)
+	t.mtx.Lock()
+	t.failedOverAt = time.Now()
+	t.failedOverURL = secondaryURL
+	t.mtx.Unlock()
+	return t.roundTripSecondary(req, secondaryURL)
+}
+
+// failedOver tells whether the requests are sent to the secondary endpoint.
+func (t *armTransport) failedOver(secondaryURL string) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.failedOverURL == secondaryURL && time.Since(t.failedOverAt) < failoverRetryDelay
+}
+
+// roundTripSecondary sends the request to the secondary endpoint, with its
+// own Host header and TLS server name.
+func (t *armTransport) roundTripSecondary(req *http.Request, secondaryURL string) (*http.Response, error) {
+	secondary, err := url.Parse(secondaryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	secondaryReq := req.Clone(req.Context())
+	secondaryReq.URL.Scheme = secondary.Scheme
+	secondaryReq.URL.Host = secondary.Host
+	secondaryReq.Host = ""
+	if req.Body != nil && req.GetBody != nil {
+		if secondaryReq.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.secondary.RoundTrip(secondaryReq)
+	if err != nil && req.Context().Err() == nil {
+		t.secondary.CloseIdleConnections()
+	}
+	return resp, err
+}
+
+// transport returns the transport verifying the certificates for the TLS
+// server name, the host of the URL when empty.
+func (t *armTransport) transport(serverName string) *http.Transport {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	transport, ok := t.transports[serverName]
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+		if serverName != "" {
+			transport.TLSClientConfig = &tls.Config{ServerName: serverName}
+		}
+		t.transports[serverName] = transport
+	}
+	return transport
+}
+
+func isResourceManagerURL(u *url.URL) bool {
+	rm, err := url.Parse(sc.Get().ResourceManagerURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, rm.Host)
+}
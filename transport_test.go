@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/percona/azure_metrics_exporter/config"
+)
+
+func TestARMTransport(t *testing.T) {
+	var hosts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+	}))
+	defer server.Close()
+
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{
+		ResourceManagerURL:  server.URL + "/",
+		ResourceManagerHost: "management.azure.com",
+	}
+
+	client := &http.Client{Transport: newARMTransport()}
+	resp, err := client.Get(server.URL + "/subscriptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(hosts) != 1 || hosts[0] != "management.azure.com" {
+		t.Errorf("doesn't override Host header\ngot: %v\nwant: %v", hosts, []string{"management.azure.com"})
+	}
+
+	transport := newARMTransport().transport("management.azure.com")
+	if got := transport.TLSClientConfig.ServerName; got != "management.azure.com" {
+		t.Errorf("doesn't set TLS server name\ngot: %v\nwant: %v", got, "management.azure.com")
+	}
+}
+
+func TestARMTransportFailover(t *testing.T) {
+	var bodies []string
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, r.URL.Path+" "+string(body))
+	}))
+	defer secondary.Close()
+
+	// The primary endpoint is closed, so that its requests fail.
+	primary := httptest.NewServer(http.NotFoundHandler())
+	primary.Close()
+
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{
+		ResourceManagerURL:          primary.URL + "/",
+		ResourceManagerSecondaryURL: secondary.URL + "/",
+	}
+
+	transport := newARMTransport()
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(primary.URL+"/batch", "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	want := []string{"/batch {}", "/batch {}"}
+	if !reflect.DeepEqual(bodies, want) {
+		t.Errorf("doesn't fail over to the secondary endpoint\ngot: %v\nwant: %v", bodies, want)
+	}
+	if !transport.failedOver(sc.C.ResourceManagerSecondaryURL) {
+		t.Errorf("doesn't keep using the secondary endpoint")
+	}
+}
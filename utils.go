@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
 	"reflect"
 	"regexp"
 	"strings"
@@ -21,6 +22,13 @@ var (
 	invalidLabelChars          = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
 )
 
+// debugf logs a message when debug logging is enabled.
+func debugf(format string, v ...interface{}) {
+	if *logDebug {
+		log.Printf(format, v...)
+	}
+}
+
 // PrintPrettyJSON - Prints structs nicely for debugging.
 func PrintPrettyJSON(input map[string]interface{}) {
 	out, err := json.MarshalIndent(input, "", "\t")
@@ -31,29 +39,125 @@ func PrintPrettyJSON(input map[string]interface{}) {
 }
 
 // GetTimes - Returns the endTime and startTime used for querying Azure Metrics API
-func GetTimes() (string, string) {
-	// Make sure we are using UTC
-	now := time.Now().UTC()
+// over the timespan, defaulting to 1 minute.
+func GetTimes(timespan time.Duration) (string, string) {
+	if timespan == 0 {
+		timespan = time.Minute
+	}
+
+	// The window follows the clock of Azure, in UTC, so that it neither
+	// depends on the time zone of the host nor on the drift of its clock.
+	now := azureTime.now().UTC()
 
-	// Use query delay of 3 minutes when querying for latest metric data
-	endTime := now.Add(time.Minute * time.Duration(-3)).Format(time.RFC3339)
-	startTime := now.Add(time.Minute * time.Duration(-4)).Format(time.RFC3339)
+	// Leave query_delay for the ingestion of the latest metric data, and
+	// widen the window by clock_skew_allowance for the remaining skew.
+	cfg := sc.Get()
+	end := now.Add(-cfg.QueryDelay)
+	endTime := end.Format(time.RFC3339)
+	startTime := end.Add(-timespan - cfg.ClockSkewAllowance).Format(time.RFC3339)
 	return endTime, startTime
 }
 
+// isoDuration formats a duration of whole minutes in ISO 8601, e.g. PT5M.
+func isoDuration(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("P%dD", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("PT%dH", d/time.Hour)
+	default:
+		return fmt.Sprintf("PT%dM", d/time.Minute)
+	}
+}
+
 // CreateResourceLabels - Returns resource labels for a given resource URL.
+// The names are unescaped, see escapeResourceID.
 func CreateResourceLabels(resourceURL string) map[string]string {
 	labels := make(map[string]string)
 	resource := strings.Split(resourceURL, "/")
 
-	labels["resource_group"] = resource[resourceGroupPosition]
-	labels["resource_name"] = resource[resourceNamePosition]
+	labels["resource_group"] = unescapeLabelValue(resource[resourceGroupPosition])
+	labels["resource_name"] = unescapeLabelValue(resource[resourceNamePosition])
 	if len(resource) > 13 {
-		labels["sub_resource_name"] = resource[subResourceNamePosition]
+		labels["sub_resource_name"] = unescapeLabelValue(resource[subResourceNamePosition])
 	}
 	return labels
 }
 
+// resourceLabels returns the labels of the series of a resource, including
+// its subscription when discovered in the subscriptions of management_groups,
+// as resources of different subscriptions may have the same names.
+func resourceLabels(rm resourceMeta) map[string]string {
+	labels := CreateResourceLabels(rm.resourceURL)
+	if rm.subscription != "" {
+		labels["azure_subscription"] = rm.subscription
+	}
+	return labels
+}
+
+// resourceKey identifies a resource in a scrape, by its ID qualified with its
+// subscription when discovered in the subscriptions of management_groups.
+func resourceKey(rm resourceMeta) string {
+	if rm.subscription != "" {
+		return "/subscriptions/" + rm.subscription + rm.resourceID
+	}
+	return rm.resourceID
+}
+
+// escapeResourceID escapes the segments of a resource ID for a URL path, as
+// the names of the resources may hold spaces, non-ASCII characters or
+// characters reserved in URLs such as '#' and '?'.
+func escapeResourceID(resourceID string) string {
+	segments := strings.Split(resourceID, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// unescapeLabelValue returns the label value of an escaped segment of a
+// resource URL.
+func unescapeLabelValue(segment string) string {
+	if s, err := url.PathUnescape(segment); err == nil {
+		segment = s
+	}
+	return labelValue(segment)
+}
+
+// labelValue replaces the invalid UTF-8 sequences of a label value, which
+// would otherwise fail the exposition of the metric.
+func labelValue(value string) string {
+	return strings.ToValidUTF8(value, "\uFFFD")
+}
+
+// resourceGroupOf returns the resource group of a resource ID, or an empty
+// string for IDs outside of resource groups.
+func resourceGroupOf(resourceID string) string {
+	parts := strings.Split(resourceID, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.EqualFold(parts[i], "resourceGroups") {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// resourceTypeOf returns the resource type of a resource ID, e.g.
+// Microsoft.Sql/servers/databases.
+func resourceTypeOf(resourceID string) string {
+	parts := strings.Split(strings.Trim(resourceID, "/"), "/")
+	for i := 0; i+2 < len(parts); i++ {
+		if strings.EqualFold(parts[i], "providers") {
+			types := []string{parts[i+1]}
+			for j := i + 2; j < len(parts); j += 2 {
+				types = append(types, parts[j])
+			}
+			return strings.Join(types, "/")
+		}
+	}
+	return ""
+}
+
 // GetResourceType returns the resource type with the namespace
 func GetResourceType(resourceURL string) string {
 	resource := strings.Split(resourceURL, "/")
@@ -76,7 +180,7 @@ func CreateAllResourceLabelsFrom(rm resourceMeta) map[string]string {
 		k = strings.ToLower(k)
 		k = "tag_" + k
 		k = invalidLabelChars.ReplaceAllString(k, "_")
-		labels[k] = v
+		labels[k] = labelValue(v)
 	}
 
 	// create a label for each field of the resource
@@ -85,9 +189,25 @@ func CreateAllResourceLabelsFrom(rm resourceMeta) map[string]string {
 		field := val.Field(i)
 		tag := reflect.TypeOf(rm.resource).Field(i).Tag.Get(formatTag)
 		if field.Kind() == reflect.String {
-			labels[tag] = field.String()
+			labels[tag] = labelValue(field.String())
 		}
 	}
+	// The subscription name is only resolved with the subscription_name
+	// identity label.
+	if !subscriptionNamesEnabled() {
+		delete(labels, "azure_subscription_name")
+	}
+
+	if labels["provisioning_state"] == "" {
+		if state, ok := rm.resource.Properties["provisioningState"].(string); ok {
+			labels["provisioning_state"] = state
+		}
+	}
+	// The power state is only known for the virtual machines looked up with
+	// their instance view, as configured by deallocated_vms.
+	if needsPowerState(rm) {
+		labels["power_state"] = powerState(rm.resource)
+	}
 
 	// Most labels are handled by iterating over the fields of resourceMeta.AzureResource.
 	// Their tag values are used as label keys.
@@ -100,17 +220,15 @@ func CreateAllResourceLabelsFrom(rm resourceMeta) map[string]string {
 	return labels
 }
 
-func hasAggregation(aggregations []string, aggregation string) bool {
-	if len(aggregations) == 0 {
-		return true
-	}
-
-	for _, aggr := range aggregations {
-		if aggr == aggregation {
-			return true
+// filterLabels returns the labels whose name is in names.
+func filterLabels(labels map[string]string, names []string) map[string]string {
+	filtered := make(map[string]string)
+	for _, name := range names {
+		if v, ok := labels[name]; ok {
+			filtered[name] = v
 		}
 	}
-	return false
+	return filtered
 }
 
 func filterAggregations(aggregations []string) []string {
@@ -120,3 +238,10 @@ func filterAggregations(aggregations []string) []string {
 	}
 	return base
 }
+
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
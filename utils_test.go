@@ -1,8 +1,12 @@
 package main
 
 import (
+	"net/url"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/percona/azure_metrics_exporter/config"
 )
 
 func TestCreateResourceLabels(t *testing.T) {
@@ -29,6 +33,46 @@ func TestCreateResourceLabels(t *testing.T) {
 	}
 }
 
+func TestUnicodeResourceNames(t *testing.T) {
+	var cases = []struct {
+		id   string
+		want map[string]string
+	}{
+		{
+			"/resourceGroups/rg-café/providers/Microsoft.Web/sites/アプリ",
+			map[string]string{"resource_group": "rg-café", "resource_name": "アプリ"},
+		},
+		{
+			"/resourceGroups/rg 1/providers/Microsoft.Sql/servers/srv/databases/db#1?x=50%",
+			map[string]string{"resource_group": "rg 1", "resource_name": "srv", "sub_resource_name": "db#1?x=50%"},
+		},
+	}
+
+	for _, c := range cases {
+		resourceURL := resourceURLFrom("abc", c.id, "", "Requests", []string{"Total"}, nil, nil, 0, 0)
+		u, err := url.Parse(resourceURL)
+		if err != nil {
+			t.Fatalf("Error parsing %s: %v", resourceURL, err)
+		}
+		if want := "/subscriptions/abc" + c.id + "/providers/microsoft.insights/metrics"; u.Path != want {
+			t.Errorf("doesn't escape the resource ID\ngot: %s\nwant: %s", u.Path, want)
+		}
+		if u.Query().Get("metricnames") != "Requests" {
+			t.Errorf("doesn't keep the query of %s", resourceURL)
+		}
+		if got := GetResourceType(resourceURL); got != resourceTypeOf(c.id) {
+			t.Errorf("unexpected resource type\ngot: %s\nwant: %s", got, resourceTypeOf(c.id))
+		}
+		if got := CreateResourceLabels(resourceURL); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("doesn't unescape the resource labels\ngot: %v\nwant: %v", got, c.want)
+		}
+	}
+
+	if got, want := labelValue("vm-\xff01"), "vm-\uFFFD01"; got != want {
+		t.Errorf("doesn't replace invalid UTF-8\ngot: %q\nwant: %q", got, want)
+	}
+}
+
 func TestCreateAllResourceLabelsFrom(t *testing.T) {
 	var cases = []struct {
 		rm   resourceMeta
@@ -51,6 +95,7 @@ func TestCreateAllResourceLabelsFrom(t *testing.T) {
 				"azure_subscription": "abc123d4-e5f6-g7h8-i9j10-a1b2c3d4e5f6",
 				"id":                 "/resourceGroups/prod-rg-001/providers/Microsoft.Compute/virtualMachines/prod-vm-01",
 				"managed_by":         "",
+				"provisioning_state": "",
 				"resource_group":     "prod-rg-001",
 				"resource_name":      "prod-vm-01",
 				"resource_type":      "Microsoft.Compute/virtualMachines",
@@ -58,6 +103,37 @@ func TestCreateAllResourceLabelsFrom(t *testing.T) {
 				"tag_monitoring":     "enabled",
 			},
 		},
+		{
+			resourceMeta{
+				resourceURL:    "/subscriptions/abc123d4-e5f6-g7h8-i9j10-a1b2c3d4e5f6/resourceGroups/prod-rg-001/providers/Microsoft.Compute/virtualMachines/prod-vm-02/providers/microsoft.insights/metrics",
+				deallocatedVMs: labelDeallocatedVMs,
+				resource: AzureResource{
+					ID:   "/resourceGroups/prod-rg-001/providers/Microsoft.Compute/virtualMachines/prod-vm-02",
+					Name: "prod-vm-02",
+					Type: "Microsoft.Compute/virtualMachines",
+					Properties: map[string]interface{}{
+						"provisioningState": "Updating",
+						"instanceView": map[string]interface{}{
+							"statuses": []interface{}{
+								map[string]interface{}{"code": "ProvisioningState/updating"},
+								map[string]interface{}{"code": "PowerState/running"},
+							},
+						},
+					},
+				},
+			},
+			map[string]string{
+				"azure_location":     "",
+				"azure_subscription": "",
+				"id":                 "/resourceGroups/prod-rg-001/providers/Microsoft.Compute/virtualMachines/prod-vm-02",
+				"managed_by":         "",
+				"power_state":        "running",
+				"provisioning_state": "Updating",
+				"resource_group":     "prod-rg-001",
+				"resource_name":      "prod-vm-02",
+				"resource_type":      "Microsoft.Compute/virtualMachines",
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -67,6 +143,15 @@ func TestCreateAllResourceLabelsFrom(t *testing.T) {
 			t.Errorf("doesn't create expected resource labels\ngot: %v\nwant: %v", got, c.want)
 		}
 	}
+
+	previous := sc.C
+	defer func() { sc.C = previous }()
+	sc.C = &config.Config{GlobalLabelsFromIdentity: []string{"subscription_name"}}
+	rm := cases[0].rm
+	rm.resource.SubscriptionName = "Production"
+	if got := CreateAllResourceLabelsFrom(rm)["azure_subscription_name"]; got != "Production" {
+		t.Errorf("unexpected azure_subscription_name label %q", got)
+	}
 }
 
 func TestCetResourceType(t *testing.T) {
@@ -92,3 +177,38 @@ func TestCetResourceType(t *testing.T) {
 		}
 	}
 }
+
+func TestFilterLabels(t *testing.T) {
+	labels := map[string]string{"resource_group": "rg", "resource_name": "vm", "tag_owner": "team"}
+
+	got := filterLabels(labels, []string{"resource_name", "tag_owner", "tag_missing"})
+	want := map[string]string{"resource_name": "vm", "tag_owner": "team"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't filter expected labels\ngot: %v\nwant: %v", got, want)
+	}
+}
+
+func TestIsoDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		time.Minute:      "PT1M",
+		15 * time.Minute: "PT15M",
+		6 * time.Hour:    "PT6H",
+		24 * time.Hour:   "P1D",
+	} {
+		if got := isoDuration(d); got != want {
+			t.Errorf("isoDuration(%v)\ngot: %v\nwant: %v", d, got, want)
+		}
+	}
+}
+
+func TestResourceTypeOf(t *testing.T) {
+	for id, want := range map[string]string{
+		"/resourceGroups/rg/providers/Microsoft.DBforPostgreSQL/flexibleServers/pg": "Microsoft.DBforPostgreSQL/flexibleServers",
+		"/resourceGroups/rg/providers/Microsoft.Sql/servers/srv/elasticPools/pool":  "Microsoft.Sql/servers/elasticPools",
+		"/resourceGroups/rg": "",
+	} {
+		if got := resourceTypeOf(id); got != want {
+			t.Errorf("resourceTypeOf(%q)\ngot: %v\nwant: %v", id, got, want)
+		}
+	}
+}
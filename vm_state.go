@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+)
+
+// Handling of the deallocated virtual machines, as configured by
+// deallocated_vms.
+const (
+	skipDeallocatedVMs  = "skip"
+	labelDeallocatedVMs = "label"
+)
+
+const virtualMachineType = "Microsoft.Compute/virtualMachines"
+
+// needsPowerState reports whether the power state of the resource, only
+// returned by a lookup of its instance view, is needed.
+func needsPowerState(rm resourceMeta) bool {
+	return rm.deallocatedVMs != "" && strings.EqualFold(GetResourceType(rm.resourceURL), virtualMachineType)
+}
+
+// powerState returns the power state of a virtual machine from its instance
+// view, e.g. running or deallocated, or an empty string when it's unknown.
+func powerState(resource AzureResource) string {
+	var instanceView map[string]interface{}
+	for k, v := range resource.Properties {
+		if strings.EqualFold(k, "instanceView") {
+			instanceView, _ = v.(map[string]interface{})
+		}
+	}
+	statuses, _ := instanceView["statuses"].([]interface{})
+	for _, s := range statuses {
+		status, _ := s.(map[string]interface{})
+		code, _ := status["code"].(string)
+		if strings.HasPrefix(code, "PowerState/") {
+			return strings.TrimPrefix(code, "PowerState/")
+		}
+	}
+	return ""
+}
+
+// applyPowerStates skips the deallocated virtual machines or labels the
+// virtual machines with their power state, as configured by deallocated_vms.
+func applyPowerStates(resources []resourceMeta) []resourceMeta {
+	var kept []resourceMeta
+	for _, rm := range resources {
+		if !needsPowerState(rm) {
+			kept = append(kept, rm)
+			continue
+		}
+
+		state := powerState(rm.resource)
+		switch rm.deallocatedVMs {
+		case skipDeallocatedVMs:
+			if state == "deallocated" {
+				debugf("Skipping metrics of deallocated virtual machine %s", rm.resourceID)
+				continue
+			}
+		case labelDeallocatedVMs:
+			if state != "" {
+				labels := map[string]string{}
+				for name, value := range rm.labels {
+					labels[name] = value
+				}
+				labels["state"] = state
+				rm.labels = labels
+			}
+		}
+		kept = append(kept, rm)
+	}
+	return kept
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestApplyPowerStates(t *testing.T) {
+	vm := func(name string, state string, deallocatedVMs string) resourceMeta {
+		id := "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/" + name
+		rm := resourceMeta{
+			resourceID:     id,
+			resourceURL:    resourceURLFrom("abc", id, "", "Percentage CPU", []string{"Average"}, nil, nil, 0, 0),
+			labels:         map[string]string{"team": "a"},
+			deallocatedVMs: deallocatedVMs,
+		}
+		payload := `{"properties": {"instanceView": {"statuses": [{"code": "ProvisioningState/succeeded"}, {"code": "PowerState/` + state + `"}]}}}`
+		if err := json.Unmarshal([]byte(payload), &rm.resource); err != nil {
+			t.Fatal(err)
+		}
+		return rm
+	}
+
+	resources := applyPowerStates([]resourceMeta{
+		vm("vm1", "running", "skip"),
+		vm("vm2", "deallocated", "skip"),
+		vm("vm3", "deallocated", "label"),
+		vm("vm4", "deallocated", ""),
+	})
+
+	var got []string
+	for _, rm := range resources {
+		got = append(got, relatedResourceName(rm.resourceID)+":"+rm.labels["state"])
+	}
+	want := []string{"vm1:", "vm3:deallocated", "vm4:"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doesn't handle deallocated virtual machines\ngot: %v\nwant: %v", got, want)
+	}
+}